@@ -361,7 +361,8 @@ func (a *Alphapoint) GetDepositAddresses() ([]AlphapointDepositAddresses, error)
 	return response.Addresses, nil
 }
 
-func (a *Alphapoint) WithdrawCoins(symbol, product string, amount float64, address string) error {
+// withdrawCoins sends coins off the exchange.
+func (a *Alphapoint) withdrawCoins(symbol, product string, amount float64, address string) error {
 	request := make(map[string]interface{})
 	request["ins"] = symbol
 	request["product"] = product
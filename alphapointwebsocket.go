@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"github.com/gorilla/websocket"
 	"log"
 	"net/http"
@@ -28,8 +29,10 @@ type AlphapointWebsocketTicker struct {
 	SellOrderCount          int     `json:"sellOrderCount"`
 }
 
-func (a *Alphapoint) WebsocketClient() {
-	for a.ExchangeEnanbled && a.WebsocketEnabled {
+func (a *Alphapoint) WebsocketClient(ctx context.Context) {
+	defer SetWebsocketConnected(a.ExchangeName, false)
+
+	for a.ExchangeEnanbled && a.WebsocketEnabled && ctx.Err() == nil {
 		var Dialer websocket.Dialer
 		var err error
 		a.WebsocketConn, _, err = Dialer.Dial(a.WebsocketURL, http.Header{})
@@ -39,6 +42,10 @@ func (a *Alphapoint) WebsocketClient() {
 			continue
 		}
 
+		stopClose := CloseOnDone(ctx, a.WebsocketConn)
+
+		SetWebsocketConnected(a.ExchangeName, true)
+
 		if a.Verbose {
 			log.Printf("%s Connected to Websocket.\n", a.ExchangeName)
 		}
@@ -81,7 +88,9 @@ func (a *Alphapoint) WebsocketClient() {
 				}
 			}
 		}
+		stopClose()
 		a.WebsocketConn.Close()
 		log.Printf("%s Websocket client disconnected.", a.ExchangeName)
+		SetWebsocketConnected(a.ExchangeName, false)
 	}
 }
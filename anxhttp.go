@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -97,7 +98,7 @@ func (a *ANX) SetDefaults() {
 	a.MakerFee = 0.3
 	a.Verbose = false
 	a.Websocket = false
-	a.RESTPollingDelay = 10
+	a.RESTPollingDelay = 10 * time.Second
 }
 
 func (a *ANX) GetName() string {
@@ -112,6 +113,26 @@ func (a *ANX) IsEnabled() bool {
 	return a.Enabled
 }
 
+func (a *ANX) Setup(exch Exchanges) {
+	if !exch.Enabled {
+		a.SetEnabled(false)
+	} else {
+		a.SetEnabled(true)
+		a.AuthenticatedAPISupport = exch.AuthenticatedAPISupport
+		a.SetAPIKeys(exch.APIKey, exch.APISecret)
+		a.RESTPollingDelay = exch.RESTPollingDelay.Duration
+		a.Verbose = exch.Verbose
+		a.Websocket = exch.Websocket
+		a.BaseCurrencies = exch.BaseCurrencies
+		a.AvailablePairs = CurrencyPairsToStrings(exch.AvailablePairs)
+		a.EnabledPairs = CurrencyPairsToStrings(exch.EnabledPairs)
+	}
+}
+
+func (a *ANX) GetRequiredCredentials() []string {
+	return []string{CREDENTIAL_API_KEY, CREDENTIAL_API_SECRET}
+}
+
 func (a *ANX) SetAPIKeys(apiKey, apiSecret string) {
 	if !a.AuthenticatedAPISupport {
 		return
@@ -137,13 +158,13 @@ func (a *ANX) GetFee(maker bool) float64 {
 	}
 }
 
-func (a *ANX) Run() {
+func (a *ANX) Run(ctx context.Context) {
 	if a.Verbose {
-		log.Printf("%s polling delay: %ds.\n", a.GetName(), a.RESTPollingDelay)
+		log.Printf("%s polling delay: %s.\n", a.GetName(), a.RESTPollingDelay)
 		log.Printf("%s %d currencies enabled: %s.\n", a.GetName(), len(a.EnabledPairs), a.EnabledPairs)
 	}
 
-	for a.Enabled {
+	for a.Enabled && ctx.Err() == nil {
 		for _, x := range a.EnabledPairs {
 			currency := x
 			go func() {
@@ -152,7 +173,7 @@ func (a *ANX) Run() {
 				AddExchangeInfo(a.GetName(), currency[0:3], currency[3:], ticker.Data.Last.Value, ticker.Data.Vol.Value)
 			}()
 		}
-		time.Sleep(time.Second * a.RESTPollingDelay)
+		SleepContext(ctx, a.RESTPollingDelay)
 	}
 }
 
@@ -352,6 +373,13 @@ func (a *ANX) CreateNewSubAccount(currency, name string) (string, error) {
 	return response.SubAccount, nil
 }
 
+// CreateNewWallet opens a sub-account holding a single currency. ANX can't
+// list sub-accounts or move funds between them, so its sub-accounts are
+// only reachable through their deposit addresses.
+func (a *ANX) CreateNewWallet(name, currency string) (string, error) {
+	return a.CreateNewSubAccount(currency, name)
+}
+
 func (a *ANX) GetDepositAddress(currency, name string, new bool) (string, error) {
 	request := make(map[string]interface{})
 	request["ccy"] = currency
@@ -387,6 +415,11 @@ func (a *ANX) GetDepositAddress(currency, name string, new bool) (string, error)
 	return response.Address, nil
 }
 
+// GetCryptoDepositAddress returns the main account's current address.
+func (a *ANX) GetCryptoDepositAddress(currency string) (string, error) {
+	return a.GetDepositAddress(currency, "", false)
+}
+
 func (a *ANX) SendAuthenticatedHTTPRequest(path string, params map[string]interface{}, result interface{}) (err error) {
 	request := make(map[string]interface{})
 	request["nonce"] = strconv.FormatInt(time.Now().UnixNano(), 10)[0:13]
@@ -0,0 +1,608 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	API_PATH                 = "/api/v1/"
+	API_MAX_BODY_SIZE        = 1 << 20
+	ErrAPINotFound           = "Not found."
+	ErrAPIMethodNotAllowed   = "Method %s not allowed."
+	ErrAPIInvalidBody        = "Invalid request body. Error: %s"
+	ErrAPIPairRequired       = "The pair query parameter is required."
+	ErrAPIInvalidDepth       = "Invalid depth %s."
+	ErrAPIInvalidEventID     = "Invalid event ID %s."
+	ErrAPIEventNotFound      = "Event %d not found."
+	ErrAPIInvalidOrderAmount = "Amount and price must be above zero."
+	ErrAPIForbidden          = "Approvers may only list, approve and reject withdrawals."
+	ErrAPIContentType        = "Content-Type must be application/json."
+	ErrAPICrossOrigin        = "Cross-origin request from %s refused."
+)
+
+// APIExchange is an exchange's state as returned by the API.
+type APIExchange struct {
+	DashboardExchange
+	EnabledPairs []CurrencyPair
+}
+
+type APIEvent struct {
+	Exchange       string
+	Item           string
+	Condition      string
+	CryptoCurrency string
+	FiatCurrency   string
+	Action         string
+}
+
+type APIOrder struct {
+	Pair   string
+	Side   string
+	Amount float64
+	Price  float64
+}
+
+type APIOrderID struct {
+	ID string
+}
+
+type APIWithdrawal struct {
+	Exchange string
+	Currency string
+	Address  string
+	Amount   float64
+	DryRun   bool
+}
+
+type APIWithdrawalRejection struct {
+	Reason string
+}
+
+type APIError struct {
+	Error string
+}
+
+// apiError is an error along with the HTTP status it is returned with.
+type apiError struct {
+	status int
+	err    error
+}
+
+func (e apiError) Error() string {
+	return e.err.Error()
+}
+
+func newAPIError(status int, format string, args ...interface{}) error {
+	return apiError{status, fmt.Errorf(format, args...)}
+}
+
+// apiHandler returns a value to encode as the response body, or an error.
+// Errors which aren't an apiError are the exchange's and returned as 502.
+type apiHandler func(r *http.Request, args []string) (interface{}, error)
+
+type apiRoute struct {
+	method  string
+	path    []string
+	handler apiHandler
+}
+
+// apiRoutes are matched against the path below API_PATH. A "*" segment
+// matches any value, which is passed to the handler in order.
+var apiRoutes = []apiRoute{
+	{"GET", []string{"exchanges"}, handleAPIGetExchanges},
+	{"GET", []string{"exchanges", "*"}, handleAPIGetExchange},
+	{"POST", []string{"exchanges", "*", "enable"}, handleAPIEnableExchange},
+	{"POST", []string{"exchanges", "*", "disable"}, handleAPIDisableExchange},
+	{"GET", []string{"exchanges", "*", "ticker"}, handleAPIGetTicker},
+	{"GET", []string{"exchanges", "*", "orderbook"}, handleAPIGetOrderbook},
+	{"GET", []string{"exchanges", "*", "balances"}, handleAPIGetBalances},
+	{"GET", []string{"exchanges", "*", "orders"}, handleAPIGetOrders},
+	{"POST", []string{"exchanges", "*", "orders"}, handleAPIPlaceOrder},
+	{"DELETE", []string{"exchanges", "*", "orders", "*"}, handleAPICancelOrder},
+	{"GET", []string{"events"}, handleAPIGetEvents},
+	{"POST", []string{"events"}, handleAPIAddEvent},
+	{"DELETE", []string{"events", "*"}, handleAPIRemoveEvent},
+	{"GET", []string{"withdrawals"}, handleAPIGetWithdrawals},
+	{"POST", []string{"withdrawals"}, handleAPIRequestWithdrawal},
+	{"POST", []string{"withdrawals", "*", "approve"}, handleAPIApproveWithdrawal},
+	{"POST", []string{"withdrawals", "*", "reject"}, handleAPIRejectWithdrawal},
+}
+
+// apiApproverRoutes are the only routes withdrawal approvers may call.
+var apiApproverRoutes = []apiRoute{
+	{"GET", []string{"withdrawals"}, nil},
+	{"POST", []string{"withdrawals", "*", "approve"}, nil},
+	{"POST", []string{"withdrawals", "*", "reject"}, nil},
+}
+
+// HandleAPI serves the JSON API. It is registered on the webserver under
+// API_PATH, behind the same login as the dashboard.
+func HandleAPI(w http.ResponseWriter, r *http.Request) {
+	path := SplitStrings(strings.Trim(strings.TrimPrefix(r.URL.Path, API_PATH), "/"), "/")
+
+	if username, _, _ := r.BasicAuth(); username != GetConfig().Webserver.AdminUsername && !isAPIApproverRoute(r.Method, path) {
+		writeAPIError(w, newAPIError(http.StatusForbidden, ErrAPIForbidden))
+		return
+	}
+
+	found := false
+	for _, route := range apiRoutes {
+		args, ok := matchAPIRoute(route.path, path)
+		if !ok {
+			continue
+		}
+
+		found = true
+		if route.method == r.Method {
+			if r.Method != "GET" {
+				err := checkAPIRequest(r)
+				if err != nil {
+					writeAPIError(w, err)
+					return
+				}
+			}
+
+			result, err := route.handler(r, args)
+			if err != nil {
+				writeAPIError(w, err)
+				return
+			}
+			writeAPIResponse(w, http.StatusOK, result)
+			return
+		}
+	}
+
+	if found {
+		writeAPIError(w, newAPIError(http.StatusMethodNotAllowed, ErrAPIMethodNotAllowed, r.Method))
+		return
+	}
+	writeAPIError(w, newAPIError(http.StatusNotFound, ErrAPINotFound))
+}
+
+func isAPIApproverRoute(method string, path []string) bool {
+	for _, route := range apiApproverRoutes {
+		if _, ok := matchAPIRoute(route.path, path); ok && route.method == method {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAPIRoute(route, path []string) ([]string, bool) {
+	if len(route) != len(path) {
+		return nil, false
+	}
+
+	args := []string{}
+	for i := range route {
+		if route[i] == "*" {
+			args = append(args, path[i])
+		} else if route[i] != path[i] {
+			return nil, false
+		}
+	}
+	return args, true
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("API: Unable to write response. Error: %s\n", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, err error) {
+	status := http.StatusBadGateway
+	if e, ok := err.(apiError); ok {
+		status = e.status
+	}
+	writeAPIResponse(w, status, APIError{err.Error()})
+}
+
+// checkAPIRequest guards requests which change state against cross-site
+// request forgery. Browsers can't send a JSON Content-Type to another site
+// without its consent, and the Origin or Referer they send must match the
+// host the request was made to. Requests without either, such as from curl,
+// are let through.
+func checkAPIRequest(r *http.Request) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		return newAPIError(http.StatusUnsupportedMediaType, ErrAPIContentType)
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+
+	if origin == "" {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host != r.Host {
+		return newAPIError(http.StatusForbidden, ErrAPICrossOrigin, origin)
+	}
+	return nil
+}
+
+func readAPIRequest(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, API_MAX_BODY_SIZE))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err != nil {
+		return newAPIError(http.StatusBadRequest, ErrAPIInvalidBody, err)
+	}
+	return nil
+}
+
+func getAPIExchange(name string) (IBotExchange, Exchanges, error) {
+	exch, err := GetExchangeConfig(name)
+	if err != nil {
+		return nil, Exchanges{}, apiError{http.StatusNotFound, err}
+	}
+
+	exchange := bot.exchange.GetExchangeByName(exch.Name)
+	if exchange == nil {
+		return nil, Exchanges{}, newAPIError(http.StatusNotFound, ErrExchangeNotFound, name)
+	}
+	return exchange, exch, nil
+}
+
+func getAPIPair(r *http.Request, exch Exchanges) (CurrencyPair, error) {
+	pair := r.URL.Query().Get("pair")
+	if pair == "" {
+		return CurrencyPair{}, newAPIError(http.StatusBadRequest, ErrAPIPairRequired)
+	}
+	return ParseCLICurrencyPair(exch, pair), nil
+}
+
+// newAPIFeatureError is returned for exchanges without the unified API a
+// request needs.
+func newAPIFeatureError(err error) error {
+	return apiError{http.StatusNotImplemented, err}
+}
+
+func getAPIExchangeState(name string) (APIExchange, error) {
+	for _, x := range GetDashboard().Exchanges {
+		if x.Name != name {
+			continue
+		}
+
+		exch, err := GetExchangeConfig(name)
+		if err != nil {
+			return APIExchange{}, apiError{http.StatusNotFound, err}
+		}
+		return APIExchange{x, exch.EnabledPairs}, nil
+	}
+	return APIExchange{}, newAPIError(http.StatusNotFound, ErrExchangeNotFound, name)
+}
+
+func handleAPIGetExchanges(r *http.Request, args []string) (interface{}, error) {
+	exchanges := []APIExchange{}
+	for _, x := range GetDashboard().Exchanges {
+		exch, err := GetExchangeConfig(x.Name)
+		if err != nil {
+			continue
+		}
+		exchanges = append(exchanges, APIExchange{x, exch.EnabledPairs})
+	}
+	return exchanges, nil
+}
+
+func handleAPIGetExchange(r *http.Request, args []string) (interface{}, error) {
+	return getAPIExchangeState(args[0])
+}
+
+func handleAPIEnableExchange(r *http.Request, args []string) (interface{}, error) {
+	return setAPIExchangeEnabled(args[0], true)
+}
+
+func handleAPIDisableExchange(r *http.Request, args []string) (interface{}, error) {
+	return setAPIExchangeEnabled(args[0], false)
+}
+
+func setAPIExchangeEnabled(name string, enabled bool) (interface{}, error) {
+	_, _, err := getAPIExchange(name)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = SetExchangeEnabled(name, enabled)
+	if err != nil {
+		return nil, apiError{http.StatusBadRequest, err}
+	}
+	return getAPIExchangeState(name)
+}
+
+func handleAPIGetTicker(r *http.Request, args []string) (interface{}, error) {
+	exchange, exch, err := getAPIExchange(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	fetcher, ok := exchange.(ITickerFetcher)
+	if !ok {
+		return nil, newAPIFeatureError(NewExchangeFeatureError(exchange, "tickers"))
+	}
+
+	pair, err := getAPIPair(r, exch)
+	if err != nil {
+		return nil, err
+	}
+	return fetcher.GetTickerPrice(pair)
+}
+
+func handleAPIGetOrderbook(r *http.Request, args []string) (interface{}, error) {
+	exchange, exch, err := getAPIExchange(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	fetcher, ok := exchange.(IOrderbookFetcher)
+	if !ok {
+		return nil, newAPIFeatureError(NewExchangeFeatureError(exchange, "orderbooks"))
+	}
+
+	pair, err := getAPIPair(r, exch)
+	if err != nil {
+		return nil, err
+	}
+
+	depth := CLI_DEFAULT_ORDERBOOK_SIZE
+	if value := r.URL.Query().Get("depth"); value != "" {
+		depth, err = strconv.Atoi(value)
+		if err != nil || depth <= 0 {
+			return nil, newAPIError(http.StatusBadRequest, ErrAPIInvalidDepth, value)
+		}
+	}
+
+	orderbook, err := fetcher.GetOrderbookDepth(pair)
+	if err != nil {
+		return nil, err
+	}
+
+	return TrimOrderbook(orderbook, depth), nil
+}
+
+func handleAPIGetBalances(r *http.Request, args []string) (interface{}, error) {
+	exchange, _, err := getAPIExchange(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	fetcher, err := GetBalanceFetcher(exchange)
+	if err != nil {
+		return nil, newAPIFeatureError(err)
+	}
+	return fetcher.GetAccountBalances()
+}
+
+func getAPIOrderManager(name string) (IOrderManager, Exchanges, error) {
+	exchange, exch, err := getAPIExchange(name)
+	if err != nil {
+		return nil, Exchanges{}, err
+	}
+
+	manager, err := GetOrderManager(exchange)
+	if err != nil {
+		return nil, Exchanges{}, newAPIFeatureError(err)
+	}
+	return manager, exch, nil
+}
+
+func handleAPIGetOrders(r *http.Request, args []string) (interface{}, error) {
+	manager, _, err := getAPIOrderManager(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	orders, err := manager.GetOpenOrderDetails()
+	if err != nil {
+		return nil, err
+	}
+
+	if orders == nil {
+		orders = []OrderDetail{}
+	}
+	return orders, nil
+}
+
+func handleAPIPlaceOrder(r *http.Request, args []string) (interface{}, error) {
+	manager, exch, err := getAPIOrderManager(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	order := APIOrder{}
+	err = readAPIRequest(r, &order)
+	if err != nil {
+		return nil, err
+	}
+
+	if order.Pair == "" {
+		return nil, newAPIError(http.StatusBadRequest, ErrAPIPairRequired)
+	}
+
+	side := StringToUpper(order.Side)
+	err = IsValidOrderSide(side)
+	if err != nil {
+		return nil, apiError{http.StatusBadRequest, err}
+	}
+
+	if order.Amount <= 0 || order.Price <= 0 {
+		return nil, newAPIError(http.StatusBadRequest, ErrAPIInvalidOrderAmount)
+	}
+
+	orderID, err := manager.SubmitOrder(ParseCLICurrencyPair(exch, order.Pair), side, order.Amount, order.Price)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("API: Order %s placed on %s.\n", orderID, exch.Name)
+	return APIOrderID{orderID}, nil
+}
+
+func handleAPICancelOrder(r *http.Request, args []string) (interface{}, error) {
+	manager, exch, err := getAPIOrderManager(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	pair, err := getAPIPair(r, exch)
+	if err != nil {
+		return nil, err
+	}
+
+	err = manager.CancelOrderByID(pair, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("API: Order %s cancelled on %s.\n", args[1], exch.Name)
+	return APIOrderID{args[1]}, nil
+}
+
+func handleAPIGetEvents(r *http.Request, args []string) (interface{}, error) {
+	return GetEvents(), nil
+}
+
+func handleAPIAddEvent(r *http.Request, args []string) (interface{}, error) {
+	event := APIEvent{}
+	err := readAPIRequest(r, &event)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := AddEvent(event.Exchange, event.Item, event.Condition, StringToUpper(event.CryptoCurrency), StringToUpper(event.FiatCurrency), event.Action)
+	if err != nil {
+		return nil, apiError{http.StatusBadRequest, err}
+	}
+
+	err = SaveEvents()
+	if err != nil {
+		log.Printf("API: Unable to save events to %s. Error: %s\n", EventsFile, err)
+	}
+
+	for _, x := range GetEvents() {
+		if x.ID == id {
+			return x, nil
+		}
+	}
+	return nil, newAPIError(http.StatusNotFound, ErrAPIEventNotFound, id)
+}
+
+func handleAPIRemoveEvent(r *http.Request, args []string) (interface{}, error) {
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, ErrAPIInvalidEventID, args[0])
+	}
+
+	if !RemoveEvent(id) {
+		return nil, newAPIError(http.StatusNotFound, ErrAPIEventNotFound, id)
+	}
+
+	err = SaveEvents()
+	if err != nil {
+		log.Printf("API: Unable to save events to %s. Error: %s\n", EventsFile, err)
+	}
+	return GetEvents(), nil
+}
+
+func handleAPIGetWithdrawals(r *http.Request, args []string) (interface{}, error) {
+	return GetWithdrawals()
+}
+
+// newAPIWithdrawalError returns exchange failures as they are, and other
+// errors as the withdrawal being refused.
+func newAPIWithdrawalError(withdrawal Withdrawal, err error) error {
+	if withdrawal.Status == WITHDRAWAL_STATUS_FAILED {
+		return err
+	}
+	return apiError{http.StatusForbidden, err}
+}
+
+func checkAPIWithdrawalExists(id string) error {
+	withdrawals, err := GetWithdrawals()
+	if err != nil {
+		return apiError{http.StatusForbidden, err}
+	}
+
+	for _, x := range withdrawals {
+		if x.ID == id {
+			return nil
+		}
+	}
+	return newAPIError(http.StatusNotFound, ErrWithdrawalNotFound, id)
+}
+
+func handleAPIRequestWithdrawal(r *http.Request, args []string) (interface{}, error) {
+	request := APIWithdrawal{}
+	err := readAPIRequest(r, &request)
+	if err != nil {
+		return nil, err
+	}
+
+	_, exch, err := getAPIExchange(request.Exchange)
+	if err != nil {
+		return nil, err
+	}
+
+	username, _, _ := r.BasicAuth()
+	withdrawal, err := RequestWithdrawal(exch.Name, request.Currency, request.Address, request.Amount, username, request.DryRun)
+	if err != nil {
+		return nil, newAPIWithdrawalError(withdrawal, err)
+	}
+	return withdrawal, nil
+}
+
+// handleAPIApproveWithdrawal requires an empty JSON object as the body.
+func handleAPIApproveWithdrawal(r *http.Request, args []string) (interface{}, error) {
+	err := readAPIRequest(r, &struct{}{})
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkAPIWithdrawalExists(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	username, _, _ := r.BasicAuth()
+	withdrawal, err := ApproveWithdrawal(args[0], username)
+	if err != nil {
+		return nil, newAPIWithdrawalError(withdrawal, err)
+	}
+	return withdrawal, nil
+}
+
+// handleAPIRejectWithdrawal takes a JSON object with an optional Reason as the
+// body.
+func handleAPIRejectWithdrawal(r *http.Request, args []string) (interface{}, error) {
+	request := APIWithdrawalRejection{}
+	err := readAPIRequest(r, &request)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkAPIWithdrawalExists(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	username, _, _ := r.BasicAuth()
+	withdrawal, err := RejectWithdrawal(args[0], username, request.Reason)
+	if err != nil {
+		return nil, newAPIWithdrawalError(withdrawal, err)
+	}
+	return withdrawal, nil
+}
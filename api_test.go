@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupTestBot configures the bot with paper trading Bitstamp and Bitfinex
+// accounts, a disabled Kraken, storage and withdrawals needing approval.
+// Exchanges are never started, so nothing is sent to them.
+func setupTestBot(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	ConfigFile = filepath.Join(dir, "config.json")
+	EventsFile = filepath.Join(dir, "events.json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	bot.ctx, bot.cancel = ctx, cancel
+	bot.exchange = Exchange{}
+	bot.exchange.SetDefaults()
+
+	storage, err := OpenStorage(filepath.Join(dir, "storage.db"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot.storage = storage
+
+	pairs := []CurrencyPair{{Base: "BTC", Quote: "USD"}}
+	SetConfig(Config{
+		Name:             "Test",
+		Cryptocurrencies: "BTC,LTC",
+		Webserver:        WebserverConfig{AdminUsername: "admin", AdminPassword: "adminpass"},
+		Exchanges: []Exchanges{
+			{
+				Name:             "Bitstamp",
+				Enabled:          true,
+				PaperTrading:     true,
+				RESTPollingDelay: ConfigDuration{time.Second * 10},
+				BaseCurrencies:   []string{"USD"},
+				AvailablePairs:   pairs,
+				EnabledPairs:     pairs,
+				PaperBalances:    map[string]float64{"USD": 1000, "BTC": 10},
+			},
+			{
+				Name:             "Bitfinex",
+				Enabled:          true,
+				PaperTrading:     true,
+				RESTPollingDelay: ConfigDuration{time.Second * 10},
+				BaseCurrencies:   []string{"USD"},
+				AvailablePairs:   pairs,
+				EnabledPairs:     pairs,
+			},
+			{Name: "Kraken"},
+		},
+		Withdrawals: WithdrawalConfig{
+			Enabled:         true,
+			RequireApproval: true,
+			ApprovalTimeout: ConfigDuration{WITHDRAWAL_DEFAULT_APPROVAL_TIMEOUT},
+			Approvers:       []WithdrawalApprover{{Username: "approver", Password: "approverpass"}},
+			Whitelist:       map[string][]string{"BTC": {"1Whitelisted"}},
+			DailyLimits:     map[string]float64{"BTC": 1},
+		},
+	})
+
+	SetConfigBaseCurrencies(GetConfig())
+	for _, x := range GetConfig().Exchanges {
+		bot.exchange.GetExchangeByName(x.Name).Setup(x)
+	}
+
+	t.Cleanup(func() {
+		bot.routines.Wait()
+		storage.Close()
+		bot.storage = nil
+		paperExchanges.exchanges = make(map[string]*PaperExchange)
+		Events = nil
+	})
+}
+
+type apiTestRequest struct {
+	user   string
+	method string
+	path   string
+	body   string
+	header map[string]string
+}
+
+// do sends the request as JSON unless a Content-Type is given, and decodes
+// the response into v if it isn't nil.
+func (x apiTestRequest) do(t *testing.T, server *httptest.Server, v interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(x.method, server.URL+API_PATH+x.path, strings.NewReader(x.body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch x.user {
+	case "admin":
+		req.SetBasicAuth("admin", "adminpass")
+	case "approver":
+		req.SetBasicAuth("approver", "approverpass")
+	case "wrong":
+		req.SetBasicAuth("admin", "wrongpass")
+	}
+
+	if x.method != "GET" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range x.header {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != nil {
+		err = json.Unmarshal(body, v)
+		if err != nil {
+			t.Fatalf("%s %s: unable to decode %q. Error: %s", x.method, x.path, body, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestHandleAPIStatus(t *testing.T) {
+	setupTestBot(t)
+	server := httptest.NewServer(NewWebserverHandler())
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		request apiTestRequest
+		status  int
+	}{
+		{"no credentials", apiTestRequest{"", "GET", "exchanges", "", nil}, http.StatusUnauthorized},
+		{"wrong password", apiTestRequest{"wrong", "GET", "exchanges", "", nil}, http.StatusUnauthorized},
+		{"admin", apiTestRequest{"admin", "GET", "exchanges", "", nil}, http.StatusOK},
+		{"approver listing withdrawals", apiTestRequest{"approver", "GET", "withdrawals", "", nil}, http.StatusOK},
+		{"approver listing exchanges", apiTestRequest{"approver", "GET", "exchanges", "", nil}, http.StatusForbidden},
+		{"approver placing an order", apiTestRequest{"approver", "POST", "exchanges/Bitstamp/orders", "{}", nil}, http.StatusForbidden},
+		{"unknown route", apiTestRequest{"admin", "GET", "unknown", "", nil}, http.StatusNotFound},
+		{"wrong method", apiTestRequest{"admin", "PUT", "exchanges", "", nil}, http.StatusMethodNotAllowed},
+		{"get exchange", apiTestRequest{"admin", "GET", "exchanges/Bitstamp", "", nil}, http.StatusOK},
+		{"unknown exchange", apiTestRequest{"admin", "GET", "exchanges/Unknown", "", nil}, http.StatusNotFound},
+		{"enable unknown exchange", apiTestRequest{"admin", "POST", "exchanges/Unknown/enable", "", nil}, http.StatusNotFound},
+		{"enable exchange failing validation", apiTestRequest{"admin", "POST", "exchanges/Kraken/enable", "", nil}, http.StatusBadRequest},
+		{"ticker without pair", apiTestRequest{"admin", "GET", "exchanges/Bitstamp/ticker", "", nil}, http.StatusBadRequest},
+		{"orderbook with invalid depth", apiTestRequest{"admin", "GET", "exchanges/Bitstamp/orderbook?pair=BTCUSD&depth=0", "", nil}, http.StatusBadRequest},
+		{"balances", apiTestRequest{"admin", "GET", "exchanges/Bitstamp/balances", "", nil}, http.StatusOK},
+		{"orders", apiTestRequest{"admin", "GET", "exchanges/Bitstamp/orders", "", nil}, http.StatusOK},
+		{"order with bad JSON", apiTestRequest{"admin", "POST", "exchanges/Bitstamp/orders", "{", nil}, http.StatusBadRequest},
+		{"order with unknown field", apiTestRequest{"admin", "POST", "exchanges/Bitstamp/orders", `{"Pair":"BTCUSD","Size":1}`, nil}, http.StatusBadRequest},
+		{"order with invalid side", apiTestRequest{"admin", "POST", "exchanges/Bitstamp/orders", `{"Pair":"BTCUSD","Side":"hold","Amount":1,"Price":1}`, nil}, http.StatusBadRequest},
+		{"order with zero amount", apiTestRequest{"admin", "POST", "exchanges/Bitstamp/orders", `{"Pair":"BTCUSD","Side":"buy","Price":1}`, nil}, http.StatusBadRequest},
+		{"order rejected by the exchange", apiTestRequest{"admin", "POST", "exchanges/Bitstamp/orders", `{"Pair":"BTCUSD","Side":"buy","Amount":100,"Price":500}`, nil}, http.StatusBadGateway},
+		{"cancel unknown order", apiTestRequest{"admin", "DELETE", "exchanges/Bitstamp/orders/99?pair=BTCUSD", "", nil}, http.StatusBadGateway},
+		{"cancel without pair", apiTestRequest{"admin", "DELETE", "exchanges/Bitstamp/orders/99", "", nil}, http.StatusBadRequest},
+		{"events", apiTestRequest{"admin", "GET", "events", "", nil}, http.StatusOK},
+		{"invalid event", apiTestRequest{"admin", "POST", "events", `{"Exchange":"Bitstamp","Item":"PRICE"}`, nil}, http.StatusBadRequest},
+		{"remove invalid event ID", apiTestRequest{"admin", "DELETE", "events/x", "", nil}, http.StatusBadRequest},
+		{"remove unknown event", apiTestRequest{"admin", "DELETE", "events/99", "", nil}, http.StatusNotFound},
+		{"withdrawal with bad JSON", apiTestRequest{"admin", "POST", "withdrawals", "[]", nil}, http.StatusBadRequest},
+		{"withdrawal from unknown exchange", apiTestRequest{"admin", "POST", "withdrawals", `{"Exchange":"Unknown"}`, nil}, http.StatusNotFound},
+		{"approve unknown withdrawal", apiTestRequest{"approver", "POST", "withdrawals/99/approve", "{}", nil}, http.StatusNotFound},
+		{"approve without body", apiTestRequest{"approver", "POST", "withdrawals/99/approve", "", nil}, http.StatusBadRequest},
+		{"reject unknown withdrawal", apiTestRequest{"approver", "POST", "withdrawals/99/reject", "{}", nil}, http.StatusNotFound},
+		{"reject with a query reason", apiTestRequest{"approver", "POST", "withdrawals/1/reject?reason=No", "", nil}, http.StatusBadRequest},
+		{"form content type", apiTestRequest{"admin", "POST", "events", "Exchange=Bitstamp", map[string]string{"Content-Type": "application/x-www-form-urlencoded"}}, http.StatusUnsupportedMediaType},
+		{"no content type", apiTestRequest{"admin", "DELETE", "events/0", "", map[string]string{"Content-Type": ""}}, http.StatusUnsupportedMediaType},
+		{"cross-origin", apiTestRequest{"admin", "POST", "exchanges/Bitstamp/disable", "", map[string]string{"Origin": "http://example.com"}}, http.StatusForbidden},
+		{"cross-origin referer", apiTestRequest{"admin", "POST", "exchanges/Bitstamp/disable", "", map[string]string{"Referer": "http://example.com/page"}}, http.StatusForbidden},
+	}
+
+	for _, x := range tests {
+		if status := x.request.do(t, server, nil); status != x.status {
+			t.Errorf("%s: %s %s returned %d, want %d", x.name, x.request.method, x.request.path, status, x.status)
+		}
+	}
+}
+
+func TestHandleAPIExchanges(t *testing.T) {
+	setupTestBot(t)
+	server := httptest.NewServer(NewWebserverHandler())
+	defer server.Close()
+
+	exchanges := []APIExchange{}
+	apiTestRequest{"admin", "GET", "exchanges", "", nil}.do(t, server, &exchanges)
+	if len(exchanges) != 3 || exchanges[0].Name != "Bitstamp" || len(exchanges[0].EnabledPairs) != 1 {
+		t.Fatalf("exchanges = %+v, want Bitstamp, Bitfinex and Kraken", exchanges)
+	}
+
+	exchange := APIExchange{}
+	origin := map[string]string{"Origin": server.URL}
+	status := apiTestRequest{"admin", "POST", "exchanges/Bitstamp/disable", "", origin}.do(t, server, &exchange)
+	if status != http.StatusOK || exchange.Enabled {
+		t.Errorf("disable returned %d %+v, want Bitstamp disabled", status, exchange)
+	}
+
+	status = apiTestRequest{"admin", "POST", "exchanges/Bitfinex/disable", "", nil}.do(t, server, nil)
+	if status != http.StatusBadRequest {
+		t.Errorf("disabling the last enabled exchange returned %d, want 400", status)
+	}
+
+	status = apiTestRequest{"admin", "POST", "exchanges/Bitstamp/enable", "", nil}.do(t, server, &exchange)
+	if status != http.StatusOK || !exchange.Enabled {
+		t.Errorf("enable returned %d %+v, want Bitstamp enabled", status, exchange)
+	}
+
+	saved, err := ReadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exch, _ := saved.GetExchangeConfig("Bitstamp"); !exch.Enabled {
+		t.Error("enabling Bitstamp wasn't saved")
+	}
+}
+
+func TestHandleAPIOrders(t *testing.T) {
+	setupTestBot(t)
+	server := httptest.NewServer(NewWebserverHandler())
+	defer server.Close()
+
+	orderID := APIOrderID{}
+	status := apiTestRequest{"admin", "POST", "exchanges/Bitstamp/orders", `{"Pair":"BTCUSD","Side":"buy","Amount":1,"Price":500}`, nil}.do(t, server, &orderID)
+	if status != http.StatusOK || orderID.ID == "" {
+		t.Fatalf("placing an order returned %d %+v", status, orderID)
+	}
+
+	orders := []OrderDetail{}
+	apiTestRequest{"admin", "GET", "exchanges/Bitstamp/orders", "", nil}.do(t, server, &orders)
+	if len(orders) != 1 || orders[0].ID != orderID.ID || orders[0].Side != ORDER_SIDE_BUY {
+		t.Errorf("orders = %+v, want the buy order", orders)
+	}
+
+	balances := []AccountBalance{}
+	apiTestRequest{"admin", "GET", "exchanges/Bitstamp/balances", "", nil}.do(t, server, &balances)
+	for _, x := range balances {
+		if x.Currency == "USD" && x.Available >= 1000 {
+			t.Errorf("USD balance %+v, want funds held for the order", x)
+		}
+	}
+
+	status = apiTestRequest{"admin", "DELETE", "exchanges/Bitstamp/orders/" + orderID.ID + "?pair=BTCUSD", "", nil}.do(t, server, nil)
+	if status != http.StatusOK {
+		t.Errorf("cancelling the order returned %d", status)
+	}
+
+	apiTestRequest{"admin", "GET", "exchanges/Bitstamp/orders", "", nil}.do(t, server, &orders)
+	if len(orders) != 0 {
+		t.Errorf("orders = %+v, want none", orders)
+	}
+}
+
+func TestHandleAPIEvents(t *testing.T) {
+	setupTestBot(t)
+	server := httptest.NewServer(NewWebserverHandler())
+	defer server.Close()
+
+	event := Event{}
+	body := `{"Exchange":"Bitstamp","Item":"PRICE","Condition":">,1000","CryptoCurrency":"btc","FiatCurrency":"usd","Action":"CONSOLE_PRINT"}`
+	status := apiTestRequest{"admin", "POST", "events", body, nil}.do(t, server, &event)
+	if status != http.StatusOK || event.Exchange != "Bitstamp" || event.CryptoCurrency != "BTC" {
+		t.Fatalf("adding an event returned %d %+v", status, event)
+	}
+
+	events := []Event{}
+	apiTestRequest{"admin", "GET", "events", "", nil}.do(t, server, &events)
+	if len(events) != 1 {
+		t.Errorf("events = %+v, want the added event", events)
+	}
+
+	status = apiTestRequest{"admin", "DELETE", "events/0", "", nil}.do(t, server, &events)
+	if status != http.StatusOK || len(events) != 0 {
+		t.Errorf("removing the event returned %d %+v", status, events)
+	}
+}
+
+func TestHandleAPIWithdrawals(t *testing.T) {
+	setupTestBot(t)
+	server := httptest.NewServer(NewWebserverHandler())
+	defer server.Close()
+
+	withdrawal := Withdrawal{}
+	status := apiTestRequest{"admin", "POST", "withdrawals", `{"Exchange":"Bitstamp","Currency":"BTC","Address":"1Unknown","Amount":0.5}`, nil}.do(t, server, nil)
+	if status != http.StatusForbidden {
+		t.Errorf("withdrawing to an address not whitelisted returned %d, want 403", status)
+	}
+
+	status = apiTestRequest{"admin", "POST", "withdrawals", `{"Exchange":"Bitstamp","Currency":"BTC","Address":"1Whitelisted","Amount":0.5}`, nil}.do(t, server, &withdrawal)
+	if status != http.StatusOK || withdrawal.Status != WITHDRAWAL_STATUS_PENDING_APPROVAL || withdrawal.Requester != "admin" {
+		t.Fatalf("requesting a withdrawal returned %d %+v", status, withdrawal)
+	}
+
+	status = apiTestRequest{"approver", "POST", "withdrawals/" + withdrawal.ID + "/approve", "{}", nil}.do(t, server, &withdrawal)
+	if status != http.StatusOK || withdrawal.Status != WITHDRAWAL_STATUS_DRY_RUN || withdrawal.Approver != "approver" {
+		t.Errorf("approving the withdrawal returned %d %+v, want a dry run as Bitstamp is paper trading", status, withdrawal)
+	}
+
+	status = apiTestRequest{"approver", "POST", "withdrawals/" + withdrawal.ID + "/reject", `{"Reason":"Too late"}`, nil}.do(t, server, nil)
+	if status != http.StatusForbidden {
+		t.Errorf("rejecting an approved withdrawal returned %d, want 403", status)
+	}
+
+	withdrawals := []Withdrawal{}
+	apiTestRequest{"approver", "GET", "withdrawals", "", nil}.do(t, server, &withdrawals)
+	if len(withdrawals) != 2 || withdrawals[0].Status != WITHDRAWAL_STATUS_REJECTED || withdrawals[1].Status != WITHDRAWAL_STATUS_DRY_RUN {
+		t.Errorf("withdrawals = %+v, want one rejected and one dry run", withdrawals)
+	}
+}
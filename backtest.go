@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BACKTEST_DEFAULT_BALANCE = 10000
+	BACKTEST_YEAR            = time.Hour * 24 * 365
+	ErrBacktestNoData        = "No stored %s for %s %s between %s and %s."
+	ErrBacktestPanic         = "Strategy %s panicked: %v"
+	ErrBacktestInvalidPeriod = "Backtest start must be before end."
+)
+
+// Backtest replays stored candles, or stored trades, through a strategy
+// trading on a SimulatedExchange.
+type Backtest struct {
+	Exchange string
+	Pair     CurrencyPair
+	Strategy string
+	Params   map[string]string
+	Interval time.Duration
+	// Trades replays stored trades instead of candles. Candles are then built
+	// from the trades at Interval for indicators and OnTicker.
+	Trades   bool
+	Start    time.Time
+	End      time.Time
+	MakerFee float64 // percent
+	TakerFee float64 // percent
+	Slippage float64 // percent
+	Latency  time.Duration
+	// Balances defaults to BACKTEST_DEFAULT_BALANCE of the quote currency.
+	Balances map[string]float64
+}
+
+type EquityPoint struct {
+	Timestamp time.Time
+	Equity    float64
+}
+
+type BacktestReport struct {
+	Exchange           string
+	Pair               CurrencyPair
+	Strategy           string
+	Params             map[string]string
+	Start              time.Time
+	End                time.Time
+	InitialEquity      float64
+	FinalEquity        float64
+	PnL                float64
+	Return             float64 // percent
+	MaxDrawdown        float64
+	MaxDrawdownPercent float64
+	Sharpe             float64
+	Trades             int
+	Fees               float64
+	Fills              []SimulatedFill
+	Equity             []EquityPoint
+}
+
+// backtestIndicators feeds indicators from the replayed candles. Indicators at
+// an interval other than the backtest's are never ready.
+type backtestIndicators struct {
+	interval time.Duration
+	candles  []Candle
+	feeds    map[string]*indicatorFeed
+}
+
+func (b *backtestIndicators) update(candle Candle) {
+	b.candles = append(b.candles, candle)
+	for _, x := range b.feeds {
+		x.update(candle)
+	}
+}
+
+func (b *backtestIndicators) GetIndicator(exchange string, pair CurrencyPair, spec IndicatorSpec) (float64, bool) {
+	if spec.Interval != b.interval {
+		return 0, false
+	}
+
+	key := spec.String()
+	feed, ok := b.feeds[key]
+	if !ok {
+		indicator, err := spec.NewIndicator()
+		if err != nil {
+			return 0, false
+		}
+
+		// Indicators first used part way through catch up on earlier candles.
+		feed = &indicatorFeed{exchange: exchange, pair: pair, spec: spec, indicator: indicator}
+		for _, x := range b.candles {
+			feed.update(x)
+		}
+		b.feeds[key] = feed
+	}
+
+	if !feed.indicator.Ready() {
+		return 0, false
+	}
+	return feed.spec.GetIndicatorOutput(feed.indicator), true
+}
+
+// Run replays the stored data between Start and End and reports how the
+// strategy performed.
+func (b Backtest) Run(storage *Storage) (report BacktestReport, err error) {
+	if !b.Start.Before(b.End) {
+		return report, errors.New(ErrBacktestInvalidPeriod)
+	}
+
+	strategy, err := NewStrategy(b.Strategy)
+	if err != nil {
+		return report, err
+	}
+
+	var candles []Candle
+	var trades []TradeRecord
+	if b.Trades {
+		trades, err = storage.GetTrades(b.Exchange, b.Pair, b.Start, b.End)
+		if err == nil && len(trades) == 0 {
+			err = fmt.Errorf(ErrBacktestNoData, "trades", b.Exchange, b.Pair, b.Start.Format(time.RFC3339), b.End.Format(time.RFC3339))
+		}
+	} else {
+		candles, err = storage.GetCandles(b.Exchange, b.Pair, b.Interval, b.Start, b.End)
+		if err == nil && len(candles) == 0 {
+			err = fmt.Errorf(ErrBacktestNoData, "candles", b.Exchange, b.Pair, b.Start.Format(time.RFC3339), b.End.Format(time.RFC3339))
+		}
+	}
+	if err != nil {
+		return report, err
+	}
+
+	balances := b.Balances
+	if len(balances) == 0 {
+		balances = map[string]float64{b.Pair.Quote: BACKTEST_DEFAULT_BALANCE}
+	}
+
+	now := b.Start
+	clock := func() time.Time { return now }
+
+	exchange := NewSimulatedExchange(b.Exchange, balances)
+	exchange.MakerFee = b.MakerFee
+	exchange.TakerFee = b.TakerFee
+	exchange.Slippage = b.Slippage
+	exchange.Latency = b.Latency
+	exchange.Clock = clock
+
+	indicators := &backtestIndicators{interval: b.Interval, feeds: make(map[string]*indicatorFeed)}
+	s := &StrategyContext{
+		Name:       b.Strategy,
+		Exchange:   b.Exchange,
+		Pair:       b.Pair,
+		Params:     b.Params,
+		Orders:     exchange,
+		Balances:   exchange,
+		Indicators: indicators,
+		Clock:      clock,
+	}
+	exchange.OnOrderUpdate = func(order OrderDetail) {
+		strategy.OnOrderUpdate(s, order)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf(ErrBacktestPanic, b.Strategy, r)
+		}
+	}()
+
+	equity := []EquityPoint{}
+	price := 0.0
+	sample := func() {
+		equity = append(equity, EquityPoint{now, exchange.GetEquity(b.Pair.Quote, map[string]float64{StringToUpper(b.Pair.Base): price})})
+	}
+
+	// closeCandle hands a finished candle to the indicators and the strategy.
+	closeCandle := func(candle Candle) {
+		now = candle.Timestamp.Add(b.Interval)
+		price = candle.Close
+		indicators.update(candle)
+		strategy.OnTicker(s, TickerPrice{
+			CryptoCurrency: b.Pair.Base,
+			FiatCurrency:   b.Pair.Quote,
+			Last:           candle.Close,
+			High:           candle.High,
+			Low:            candle.Low,
+			Bid:            candle.Close,
+			Ask:            candle.Close,
+			Volume:         candle.Volume,
+		})
+		strategy.OnTimer(s, now)
+		sample()
+	}
+
+	if b.Trades {
+		now, price = trades[0].Timestamp, trades[0].Price
+	} else {
+		now, price = candles[0].Timestamp, candles[0].Open
+	}
+	sample()
+
+	err = strategy.OnStart(s)
+	if err != nil {
+		return report, err
+	}
+
+	if b.Trades {
+		var candle *Candle
+		for _, x := range trades {
+			start := x.Timestamp.Truncate(b.Interval)
+			if candle != nil && !start.Equal(candle.Timestamp) {
+				closeCandle(*candle)
+				candle = nil
+			}
+
+			now = x.Timestamp
+			exchange.ProcessTrade(b.Pair, x)
+			strategy.OnTrade(s, x)
+
+			if candle == nil {
+				c := NewCandle(start, x.Price, x.Amount)
+				candle = &c
+			} else {
+				candle.AddTrade(x.Price, x.Amount)
+			}
+		}
+		if candle != nil {
+			closeCandle(*candle)
+		}
+	} else {
+		for _, x := range candles {
+			now = x.Timestamp
+			exchange.ProcessCandle(b.Pair, x, b.Interval)
+			closeCandle(x)
+		}
+	}
+
+	strategy.OnStop(s)
+	sample()
+
+	return b.report(exchange, equity), nil
+}
+
+func (b Backtest) report(exchange *SimulatedExchange, equity []EquityPoint) BacktestReport {
+	report := BacktestReport{
+		Exchange: b.Exchange,
+		Pair:     b.Pair,
+		Strategy: b.Strategy,
+		Params:   b.Params,
+		Start:    b.Start,
+		End:      b.End,
+		Fills:    exchange.GetFills(),
+		Equity:   equity,
+	}
+
+	report.InitialEquity = equity[0].Equity
+	report.FinalEquity = equity[len(equity)-1].Equity
+	report.PnL = report.FinalEquity - report.InitialEquity
+	if report.InitialEquity > 0 {
+		report.Return = report.PnL / report.InitialEquity * 100
+	}
+
+	peak := 0.0
+	for _, x := range equity {
+		peak = math.Max(peak, x.Equity)
+		if peak-x.Equity > report.MaxDrawdown {
+			report.MaxDrawdown = peak - x.Equity
+			report.MaxDrawdownPercent = report.MaxDrawdown / peak * 100
+		}
+	}
+
+	report.Trades = len(report.Fills)
+	for _, x := range report.Fills {
+		report.Fees += x.Fee
+	}
+
+	report.Sharpe = GetSharpeRatio(equity, b.Interval)
+	return report
+}
+
+// GetSharpeRatio returns the annualised Sharpe ratio of an equity curve
+// sampled every interval, taking the risk free rate as zero.
+func GetSharpeRatio(equity []EquityPoint, interval time.Duration) float64 {
+	returns := []float64{}
+	for i := 1; i < len(equity); i++ {
+		if equity[i-1].Equity > 0 && equity[i].Timestamp.After(equity[i-1].Timestamp) {
+			returns = append(returns, equity[i].Equity/equity[i-1].Equity-1)
+		}
+	}
+
+	if len(returns) < 2 {
+		return 0
+	}
+
+	mean := 0.0
+	for _, x := range returns {
+		mean += x
+	}
+	mean /= float64(len(returns))
+
+	variance := 0.0
+	for _, x := range returns {
+		variance += (x - mean) * (x - mean)
+	}
+	deviation := math.Sqrt(variance / float64(len(returns)-1))
+	if deviation == 0 {
+		return 0
+	}
+	return mean / deviation * math.Sqrt(float64(BACKTEST_YEAR)/float64(interval))
+}
+
+// Export writes the report to path as JSON, or as CSV when path ends in .csv.
+// CSV output is the fill list, with the equity curve written alongside it to
+// a file ending in _equity.csv.
+func (r BacktestReport) Export(path string) error {
+	if strings.ToLower(filepath.Ext(path)) != ".csv" {
+		data, err := json.MarshalIndent(r, "", " ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, data, 0644)
+	}
+
+	rows := [][]string{{"Timestamp", "OrderID", "Pair", "Side", "Price", "Amount", "Fee", "Maker"}}
+	for _, x := range r.Fills {
+		rows = append(rows, []string{
+			x.Timestamp.Format(time.RFC3339),
+			x.OrderID,
+			x.Pair.String(),
+			x.Side,
+			strconv.FormatFloat(x.Price, 'f', -1, 64),
+			strconv.FormatFloat(x.Amount, 'f', -1, 64),
+			strconv.FormatFloat(x.Fee, 'f', -1, 64),
+			strconv.FormatBool(x.Maker),
+		})
+	}
+
+	err := WriteCSVFile(path, rows)
+	if err != nil {
+		return err
+	}
+
+	rows = [][]string{{"Timestamp", "Equity"}}
+	for _, x := range r.Equity {
+		rows = append(rows, []string{x.Timestamp.Format(time.RFC3339), strconv.FormatFloat(x.Equity, 'f', -1, 64)})
+	}
+	return WriteCSVFile(strings.TrimSuffix(path, filepath.Ext(path))+"_equity.csv", rows)
+}
+
+func WriteCSVFile(path string, rows [][]string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(file)
+	err = writer.WriteAll(rows)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"log"
+	"math"
 	"net/url"
 	"strconv"
 	"strings"
@@ -48,8 +50,20 @@ const (
 	BITFINEX_MARGIN_INFO          = "margin_infos"
 	BITFINEX_TRANSFER             = "transfer"
 	BITFINEX_WITHDRAWAL           = "withdrawal"
+	BITFINEX_TRADE_HISTORY_LIMIT  = 500
+	BITFINEX_MOVEMENTS_LIMIT      = 500
+	BITFINEX_WALLET_EXCHANGE      = "exchange"
+	BITFINEX_WALLET_TRADING       = "trading"
+	BITFINEX_WALLET_DEPOSIT       = "deposit"
 )
 
+// BITFINEX_WITHDRAWAL_TYPES maps currencies to their withdrawal_type.
+var BITFINEX_WITHDRAWAL_TYPES = map[string]string{
+	"BTC": "bitcoin",
+	"LTC": "litecoin",
+	"ETH": "ethereum",
+}
+
 type BitfinexStats struct {
 	Period int64
 	Volume float64 `json:",string"`
@@ -187,7 +201,7 @@ func (b *Bitfinex) SetDefaults() {
 	b.Enabled = true
 	b.Verbose = false
 	b.Websocket = false
-	b.RESTPollingDelay = 10
+	b.RESTPollingDelay = 10 * time.Second
 	b.WebsocketSubdChannels = make(map[int]BitfinexWebsocketChanInfo)
 }
 
@@ -203,20 +217,40 @@ func (b *Bitfinex) IsEnabled() bool {
 	return b.Enabled
 }
 
+func (b *Bitfinex) Setup(exch Exchanges) {
+	if !exch.Enabled {
+		b.SetEnabled(false)
+	} else {
+		b.SetEnabled(true)
+		b.AuthenticatedAPISupport = exch.AuthenticatedAPISupport
+		b.SetAPIKeys(exch.APIKey, exch.APISecret)
+		b.RESTPollingDelay = exch.RESTPollingDelay.Duration
+		b.Verbose = exch.Verbose
+		b.Websocket = exch.Websocket
+		b.BaseCurrencies = exch.BaseCurrencies
+		b.AvailablePairs = CurrencyPairsToStrings(exch.AvailablePairs)
+		b.EnabledPairs = CurrencyPairsToStrings(exch.EnabledPairs)
+	}
+}
+
+func (b *Bitfinex) GetRequiredCredentials() []string {
+	return []string{CREDENTIAL_API_KEY, CREDENTIAL_API_SECRET}
+}
+
 func (b *Bitfinex) SetAPIKeys(apiKey, apiSecret string) {
 	b.APIKey = apiKey
 	b.APISecret = apiSecret
 }
 
-func (b *Bitfinex) Run() {
+func (b *Bitfinex) Run(ctx context.Context) {
 	if b.Verbose {
 		log.Printf("%s Websocket: %s.", b.GetName(), IsEnabled(b.Websocket))
-		log.Printf("%s polling delay: %ds.\n", b.GetName(), b.RESTPollingDelay)
+		log.Printf("%s polling delay: %s.\n", b.GetName(), b.RESTPollingDelay)
 		log.Printf("%s %d currencies enabled: %s.\n", b.GetName(), len(b.EnabledPairs), b.EnabledPairs)
 	}
 
 	if b.Websocket {
-		go b.WebsocketClient()
+		StartRoutine(func() { b.WebsocketClient(ctx) })
 	}
 
 	exchangeProducts, err := b.GetSymbols()
@@ -231,13 +265,13 @@ func (b *Bitfinex) Run() {
 				log.Println(err)
 			} else {
 				log.Printf("%s Updating available pairs. Difference: %s.\n", b.Name, diff)
-				exch.AvailablePairs = JoinStrings(exchangeProducts, ",")
+				exch.AvailablePairs = ParseCurrencyPairs(exchangeProducts, exch.BaseCurrencies)
 				UpdateExchangeConfig(exch)
 			}
 		}
 	}
 
-	for b.Enabled {
+	for b.Enabled && ctx.Err() == nil {
 		for _, x := range b.EnabledPairs {
 			currency := x
 			go func() {
@@ -250,7 +284,7 @@ func (b *Bitfinex) Run() {
 				AddExchangeInfo(b.GetName(), currency[0:3], currency[3:], ticker.Last, ticker.Volume)
 			}()
 		}
-		time.Sleep(time.Second * b.RESTPollingDelay)
+		SleepContext(ctx, b.RESTPollingDelay)
 	}
 }
 
@@ -485,6 +519,120 @@ func (b *Bitfinex) CancelAllOrders() (string, error) {
 	return response.Result, nil
 }
 
+func (b *Bitfinex) CancelAllOpenOrders() error {
+	_, err := b.CancelAllOrders()
+	return err
+}
+
+func (b *Bitfinex) GetTickerPrice(pair CurrencyPair) (TickerPrice, error) {
+	ticker, err := b.GetTicker(StringToLower(pair.String()), nil)
+	if err != nil {
+		return TickerPrice{}, err
+	}
+	return TickerPrice{pair.Base, pair.Quote, ticker.Last, ticker.High, ticker.Low, ticker.Bid, ticker.Ask, ticker.Volume}, nil
+}
+
+func (b *Bitfinex) GetOrderbookDepth(pair CurrencyPair) (Orderbook, error) {
+	orderbook, err := b.GetOrderbook(StringToLower(pair.String()), nil)
+	if err != nil {
+		return Orderbook{}, err
+	}
+
+	result := Orderbook{Pair: pair}
+	result.Bids = b.convertBookStructures(orderbook.Bids)
+	result.Asks = b.convertBookStructures(orderbook.Asks)
+	return result, nil
+}
+
+func (b *Bitfinex) convertBookStructures(entries []BookStructure) []OrderbookItem {
+	items := []OrderbookItem{}
+	for _, x := range entries {
+		price, err := strconv.ParseFloat(x.Price, 64)
+		if err != nil {
+			continue
+		}
+		amount, err := strconv.ParseFloat(x.Amount, 64)
+		if err != nil {
+			continue
+		}
+		items = append(items, OrderbookItem{price, amount})
+	}
+	return items
+}
+
+// GetAccountBalances returns the balances of the exchange wallet, which is the
+// wallet used for trading.
+func (b *Bitfinex) GetAccountBalances() ([]AccountBalance, error) {
+	balances, err := b.GetAccountBalance()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []AccountBalance{}
+	for _, x := range balances {
+		if x.Type != "exchange" {
+			continue
+		}
+		result = append(result, AccountBalance{StringToUpper(x.Currency), x.Amount, x.Available, x.Amount - x.Available})
+	}
+	return result, nil
+}
+
+func (b *Bitfinex) GetOpenOrderDetails() ([]OrderDetail, error) {
+	orders, err := b.GetActiveOrders()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []OrderDetail{}
+	for _, x := range orders {
+		result = append(result, OrderDetail{
+			ID:     strconv.FormatInt(x.ID, 10),
+			Pair:   ParseCurrencyPair(StringToUpper(x.Symbol), b.BaseCurrencies),
+			Side:   StringToUpper(x.Side),
+			Price:  x.Price,
+			Amount: x.OriginalAmount,
+			Filled: x.ExecutedAmount,
+			Status: "open",
+		})
+	}
+	return result, nil
+}
+
+func (b *Bitfinex) SubmitOrder(pair CurrencyPair, side string, amount, price float64) (string, error) {
+	order, err := b.NewOrder(StringToLower(pair.String()), amount, price, side == ORDER_SIDE_BUY, "exchange limit", false)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(order.OrderID, 10), nil
+}
+
+func (b *Bitfinex) CancelOrderByID(pair CurrencyPair, orderID string) error {
+	id, err := strconv.ParseInt(orderID, 10, 64)
+	if err != nil {
+		return err
+	}
+	_, err = b.CancelOrder(id)
+	return err
+}
+
+func (b *Bitfinex) ReplaceOrderByID(pair CurrencyPair, orderID, side string, amount, price float64) (string, error) {
+	id, err := strconv.ParseInt(orderID, 10, 64)
+	if err != nil {
+		return orderID, err
+	}
+
+	order, err := b.ReplaceOrder(id, StringToLower(pair.String()), amount, price, side == ORDER_SIDE_BUY, "exchange limit", false)
+	if err != nil {
+		return orderID, err
+	}
+
+	if order.OrderID == 0 {
+		order.OrderID = order.ID
+	}
+	return strconv.FormatInt(order.OrderID, 10), nil
+}
+
 func (b *Bitfinex) ReplaceOrder(OrderID int64, Symbol string, Amount float64, Price float64, Buy bool, Type string, Hidden bool) (BitfinexOrder, error) {
 	request := make(map[string]interface{})
 	request["order_id"] = OrderID
@@ -587,11 +735,11 @@ func (b *Bitfinex) GetBalanceHistory(symbol string, timeSince time.Time, timeUnt
 	request["currency"] = symbol
 
 	if !timeSince.IsZero() {
-		request["since"] = timeSince
+		request["since"] = strconv.FormatInt(timeSince.Unix(), 10)
 	}
 
 	if !timeUntil.IsZero() {
-		request["until"] = timeUntil
+		request["until"] = strconv.FormatInt(timeUntil.Unix(), 10)
 	}
 
 	if limit > 0 {
@@ -613,14 +761,17 @@ func (b *Bitfinex) GetBalanceHistory(symbol string, timeSince time.Time, timeUnt
 }
 
 type BitfinexMovementHistory struct {
-	ID          int64   `json:"id"`
-	Currency    string  `json:"currency"`
-	Method      string  `json:"method"`
-	Type        string  `json:"withdrawal"`
-	Amount      float64 `json:"amount,string"`
-	Description string  `json:"description"`
-	Status      string  `json:"status"`
-	Timestamp   string  `json:"timestamp"`
+	ID          int64       `json:"id"`
+	TxID        interface{} `json:"txid"`
+	Currency    string      `json:"currency"`
+	Method      string      `json:"method"`
+	Type        string      `json:"type"`
+	Amount      float64     `json:"amount,string"`
+	Description string      `json:"description"`
+	Address     string      `json:"address"`
+	Status      string      `json:"status"`
+	Timestamp   string      `json:"timestamp"`
+	Fee         interface{} `json:"fee"`
 }
 
 func (b *Bitfinex) GetMovementHistory(symbol, method string, timeSince, timeUntil time.Time, limit int) ([]BitfinexMovementHistory, error) {
@@ -653,6 +804,71 @@ func (b *Bitfinex) GetMovementHistory(symbol, method string, timeSince, timeUnti
 	return response, nil
 }
 
+// GetFundingHistory returns the deposits and withdrawals of every currency held
+// or traded. Movement history is requested one currency at a time.
+func (b *Bitfinex) GetFundingHistory(start, end time.Time) ([]FundingMovement, error) {
+	balances, err := b.GetAccountBalance()
+	if err != nil {
+		return nil, err
+	}
+
+	currencies := []string{}
+	for _, x := range balances {
+		currencies = append(currencies, StringToUpper(x.Currency))
+	}
+	for _, x := range ParseCurrencyPairs(b.EnabledPairs, b.BaseCurrencies) {
+		currencies = append(currencies, x.Base, x.Quote)
+	}
+
+	result := []FundingMovement{}
+	seen := make(map[string]bool)
+	for _, currency := range currencies {
+		if seen[currency] {
+			continue
+		}
+		seen[currency] = true
+
+		movements, err := b.GetMovementHistory(currency, "", start, end, BITFINEX_MOVEMENTS_LIMIT)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, x := range movements {
+			timestamp, err := strconv.ParseFloat(x.Timestamp, 64)
+			if err != nil {
+				continue
+			}
+
+			status := FUNDING_STATUS_PENDING
+			switch StringToUpper(x.Status) {
+			case "COMPLETED":
+				status = FUNDING_STATUS_COMPLETE
+			case "CANCELED", "CANCELLED", "UNCONFIRMED":
+				status = FUNDING_STATUS_CANCELLED
+			}
+
+			txID := ""
+			if x.TxID != nil {
+				txID = fmt.Sprint(x.TxID)
+			}
+			fee, _ := strconv.ParseFloat(fmt.Sprint(x.Fee), 64)
+			result = append(result, FundingMovement{
+				Exchange:  b.GetName(),
+				ID:        strconv.FormatInt(x.ID, 10),
+				Type:      StringToUpper(x.Type),
+				Status:    status,
+				Timestamp: time.Unix(0, int64(timestamp*float64(time.Second))),
+				Currency:  StringToUpper(x.Currency),
+				Amount:    math.Abs(x.Amount),
+				Fee:       math.Abs(fee),
+				Address:   x.Address,
+				TxID:      txID,
+			})
+		}
+	}
+	return result, nil
+}
+
 type BitfinexTradeHistory struct {
 	Price       float64 `json:"price,string"`
 	Amount      float64 `json:"amount,string"`
@@ -667,11 +883,11 @@ type BitfinexTradeHistory struct {
 
 func (b *Bitfinex) GetTradeHistory(symbol string, timestamp, until time.Time, limit, reverse int) ([]BitfinexTradeHistory, error) {
 	request := make(map[string]interface{})
-	request["currency"] = symbol
-	request["timestamp"] = timestamp
+	request["symbol"] = symbol
+	request["timestamp"] = strconv.FormatInt(timestamp.Unix(), 10)
 
 	if !until.IsZero() {
-		request["until"] = until
+		request["until"] = strconv.FormatInt(until.Unix(), 10)
 	}
 
 	if limit > 0 {
@@ -692,6 +908,36 @@ func (b *Bitfinex) GetTradeHistory(symbol string, timestamp, until time.Time, li
 	return response, nil
 }
 
+// GetAccountFills returns the oldest fills of pair within [start, end).
+// Bitfinex reports fees as negative amounts.
+func (b *Bitfinex) GetAccountFills(pair CurrencyPair, start, end time.Time) ([]AccountFill, error) {
+	trades, err := b.GetTradeHistory(StringToLower(pair.String()), start, end, BITFINEX_TRADE_HISTORY_LIMIT, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []AccountFill{}
+	for _, x := range trades {
+		timestamp, err := strconv.ParseFloat(x.Timestamp, 64)
+		if err != nil {
+			continue
+		}
+		result = append(result, AccountFill{
+			Exchange:    b.GetName(),
+			ID:          strconv.FormatInt(x.TID, 10),
+			OrderID:     strconv.FormatInt(x.OrderID, 10),
+			Timestamp:   time.Unix(0, int64(timestamp*float64(time.Second))),
+			Pair:        pair,
+			Side:        StringToUpper(x.Type),
+			Price:       x.Price,
+			Amount:      x.Amount,
+			Fee:         math.Abs(x.FeeAmount),
+			FeeCurrency: StringToUpper(x.FeeCurrency),
+		})
+	}
+	return result, nil
+}
+
 func (b *Bitfinex) NewOffer(symbol string, amount, rate float64, period int64, direction string) int64 {
 	request := make(map[string]interface{})
 	request["currency"] = symbol
@@ -833,10 +1079,10 @@ type BitfinexWalletTransfer struct {
 
 func (b *Bitfinex) WalletTransfer(amount float64, currency, walletFrom, walletTo string) ([]BitfinexWalletTransfer, error) {
 	request := make(map[string]interface{})
-	request["amount"] = amount
+	request["amount"] = strconv.FormatFloat(amount, 'f', -1, 64)
 	request["currency"] = currency
 	request["walletfrom"] = walletFrom
-	request["walletTo"] = walletTo
+	request["walletto"] = walletTo
 
 	response := []BitfinexWalletTransfer{}
 	err := b.SendAuthenticatedHTTPRequest("POST", BITFINEX_TRANSFER, request, &response)
@@ -848,13 +1094,57 @@ func (b *Bitfinex) WalletTransfer(amount float64, currency, walletFrom, walletTo
 	return response, nil
 }
 
+// ListWallets returns the exchange, trading (margin) and deposit (funding)
+// wallets.
+func (b *Bitfinex) ListWallets() ([]Wallet, error) {
+	balances, err := b.GetAccountBalance()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Wallet{}
+	for _, wallet := range []string{BITFINEX_WALLET_EXCHANGE, BITFINEX_WALLET_TRADING, BITFINEX_WALLET_DEPOSIT} {
+		x := Wallet{Exchange: b.GetName(), ID: wallet, Name: wallet, Balances: []AccountBalance{}}
+		for _, y := range balances {
+			if y.Type == wallet {
+				x.Balances = append(x.Balances, AccountBalance{StringToUpper(y.Currency), y.Amount, y.Available, y.Amount - y.Available})
+			}
+		}
+		result = append(result, x)
+	}
+	return result, nil
+}
+
+func (b *Bitfinex) TransferBetweenWallets(currency string, amount float64, from, to string) error {
+	for _, wallet := range []string{from, to} {
+		if wallet != BITFINEX_WALLET_EXCHANGE && wallet != BITFINEX_WALLET_TRADING && wallet != BITFINEX_WALLET_DEPOSIT {
+			return fmt.Errorf(ErrWalletNotFound, b.GetName(), wallet)
+		}
+	}
+
+	response, err := b.WalletTransfer(amount, currency, from, to)
+	if err != nil {
+		return err
+	}
+
+	if len(response) == 0 {
+		return errors.New("Unable to transfer: no response.")
+	}
+
+	if response[0].Status != "success" {
+		return errors.New(response[0].Message)
+	}
+	return nil
+}
+
 type BitfinexWithdrawal struct {
 	Status       string `json:"status"`
 	Message      string `json:"message"`
 	WithdrawalID int64  `json:"withdrawal_id"`
 }
 
-func (b *Bitfinex) Withdrawal(withdrawType, wallet, address string, amount float64) ([]BitfinexWithdrawal, error) {
+// withdrawal sends funds from a wallet off the exchange.
+func (b *Bitfinex) withdrawal(withdrawType, wallet, address string, amount float64) ([]BitfinexWithdrawal, error) {
 	request := make(map[string]interface{})
 	request["withdrawal_type"] = withdrawType
 	request["walletselected"] = wallet
@@ -871,6 +1161,28 @@ func (b *Bitfinex) Withdrawal(withdrawType, wallet, address string, amount float
 	return response, nil
 }
 
+// withdraw sends a withdrawal from the exchange wallet.
+func (b *Bitfinex) withdraw(currency, address string, amount float64) (string, error) {
+	withdrawType, ok := BITFINEX_WITHDRAWAL_TYPES[currency]
+	if !ok {
+		return "", fmt.Errorf(ErrWithdrawalCurrencyNotSupported, b.GetName(), currency)
+	}
+
+	response, err := b.withdrawal(withdrawType, BITFINEX_WALLET_EXCHANGE, address, amount)
+	if err != nil {
+		return "", err
+	}
+
+	if len(response) == 0 {
+		return "", errors.New("Unable to withdraw: no response.")
+	}
+
+	if response[0].Status != "success" {
+		return "", errors.New(response[0].Message)
+	}
+	return strconv.FormatInt(response[0].WithdrawalID, 10), nil
+}
+
 func (b *Bitfinex) SendAuthenticatedHTTPRequest(method, path string, params map[string]interface{}, result interface{}) (err error) {
 	request := make(map[string]interface{})
 	request["request"] = fmt.Sprintf("/v%s/%s", BITFINEX_API_VERSION, path)
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"github.com/gorilla/websocket"
 	"log"
 	"net/http"
@@ -151,9 +152,26 @@ func (b *Bitfinex) WebsocketAddSubscriptionChannel(chanID int, channel, pair str
 	}
 }
 
-func (b *Bitfinex) WebsocketClient() {
+// ConvertWebsocketTrades converts websocket trades into trade records. Sells
+// are sent with a negative amount.
+func (b *Bitfinex) ConvertWebsocketTrades(trades []BitfinexWebsocketTrade) []TradeRecord {
+	result := []TradeRecord{}
+	for _, x := range trades {
+		trade := TradeRecord{time.Unix(x.Timestamp, 0), strconv.FormatInt(x.ID, 10), x.Price, x.Amount, ORDER_SIDE_BUY}
+		if x.Amount < 0 {
+			trade.Amount = -x.Amount
+			trade.Side = ORDER_SIDE_SELL
+		}
+		result = append(result, trade)
+	}
+	return result
+}
+
+func (b *Bitfinex) WebsocketClient(ctx context.Context) {
+	defer SetWebsocketConnected(b.GetName(), false)
+
 	channels := []string{"book", "trades", "ticker"}
-	for b.Enabled && b.Websocket {
+	for b.Enabled && b.Websocket && ctx.Err() == nil {
 		var Dialer websocket.Dialer
 		var err error
 		b.WebsocketConn, _, err = Dialer.Dial(BITFINEX_WEBSOCKET, http.Header{})
@@ -163,6 +181,8 @@ func (b *Bitfinex) WebsocketClient() {
 			continue
 		}
 
+		stopClose := CloseOnDone(ctx, b.WebsocketConn)
+
 		msgType, resp, err := b.WebsocketConn.ReadMessage()
 		if msgType != websocket.TextMessage {
 			continue
@@ -181,6 +201,8 @@ func (b *Bitfinex) WebsocketClient() {
 			continue
 		}
 
+		SetWebsocketConnected(b.GetName(), true)
+
 		if hs.Event == "info" {
 			if b.Verbose {
 				log.Printf("%s Connected to Websocket.\n", b.GetName())
@@ -330,12 +352,15 @@ func (b *Bitfinex) WebsocketClient() {
 									log.Printf("Bitfinex %s Websocket Trade ID %d Timestamp %d Price %f Amount %f\n", chanInfo.Pair, trade.ID, trade.Timestamp, trade.Price, trade.Amount)
 								}
 							}
+							ProcessTrades(b.GetName(), ParseCurrencyPair(StringToUpper(chanInfo.Pair), b.BaseCurrencies), b.ConvertWebsocketTrades(trades))
 						}
 					}
 				}
 			}
 		}
+		stopClose()
 		b.WebsocketConn.Close()
 		log.Printf("%s Websocket client disconnected.\n", b.GetName())
+		SetWebsocketConnected(b.GetName(), false)
 	}
 }
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
+	"math"
 	"net/url"
 	"strconv"
 	"strings"
@@ -31,6 +34,12 @@ const (
 	BITSTAMP_API_UNCONFIRMED_BITCOIN = "unconfirmed_btc/"
 	BITSTAMP_API_RIPPLE_WITHDRAWAL   = "ripple_withdrawal/"
 	BITSTAMP_API_RIPPLE_DESPOIT      = "ripple_address/"
+	BITSTAMP_TRANSACTION_DEPOSIT     = 0
+	BITSTAMP_TRANSACTION_TRADE       = 2
+	BITSTAMP_WITHDRAWAL_BITCOIN      = 1
+	BITSTAMP_WITHDRAWAL_RIPPLE       = 14
+	BITSTAMP_TRANSACTIONS_LIMIT      = 1000
+	BITSTAMP_TIME_FORMAT             = "2006-01-02 15:04:05"
 )
 
 type Bitstamp struct {
@@ -59,9 +68,9 @@ type BitstampTicker struct {
 }
 
 type BitstampAccountBalance struct {
-	BTCReserved  float64 `json:"usd_balance,string"`
+	BTCReserved  float64 `json:"btc_reserved,string"`
 	Fee          float64 `json:",string"`
-	BTCAvailable float64 `json:"btc_balance,string"`
+	BTCAvailable float64 `json:"btc_available,string"`
 	USDReserved  float64 `json:"usd_reserved,string"`
 	BTCBalance   float64 `json:"btc_balance,string"`
 	USDBalance   float64 `json:"usd_balance,string"`
@@ -142,7 +151,7 @@ func (b *Bitstamp) SetDefaults() {
 	b.Enabled = true
 	b.Verbose = false
 	b.Websocket = false
-	b.RESTPollingDelay = 10
+	b.RESTPollingDelay = 10 * time.Second
 }
 
 func (b *Bitstamp) GetName() string {
@@ -157,6 +166,26 @@ func (b *Bitstamp) IsEnabled() bool {
 	return b.Enabled
 }
 
+func (b *Bitstamp) Setup(exch Exchanges) {
+	if !exch.Enabled {
+		b.SetEnabled(false)
+	} else {
+		b.SetEnabled(true)
+		b.AuthenticatedAPISupport = exch.AuthenticatedAPISupport
+		b.SetAPIKeys(exch.ClientID, exch.APIKey, exch.APISecret)
+		b.RESTPollingDelay = exch.RESTPollingDelay.Duration
+		b.Verbose = exch.Verbose
+		b.Websocket = exch.Websocket
+		b.BaseCurrencies = exch.BaseCurrencies
+		b.AvailablePairs = CurrencyPairsToStrings(exch.AvailablePairs)
+		b.EnabledPairs = CurrencyPairsToStrings(exch.EnabledPairs)
+	}
+}
+
+func (b *Bitstamp) GetRequiredCredentials() []string {
+	return []string{CREDENTIAL_API_KEY, CREDENTIAL_API_SECRET, CREDENTIAL_CLIENT_ID}
+}
+
 func (b *Bitstamp) GetFee() float64 {
 	return b.Balance.Fee
 }
@@ -167,18 +196,18 @@ func (b *Bitstamp) SetAPIKeys(clientID, apiKey, apiSecret string) {
 	b.APISecret = apiSecret
 }
 
-func (b *Bitstamp) Run() {
+func (b *Bitstamp) Run(ctx context.Context) {
 	if b.Verbose {
 		log.Printf("%s Websocket: %s.", b.GetName(), IsEnabled(b.Websocket))
-		log.Printf("%s polling delay: %ds.\n", b.GetName(), b.RESTPollingDelay)
+		log.Printf("%s polling delay: %s.\n", b.GetName(), b.RESTPollingDelay)
 		log.Printf("%s %d currencies enabled: %s.\n", b.GetName(), len(b.EnabledPairs), b.EnabledPairs)
 	}
 
 	if b.Websocket {
-		go b.PusherClient()
+		StartRoutine(func() { b.PusherClient(ctx) })
 	}
 
-	for b.Enabled {
+	for b.Enabled && ctx.Err() == nil {
 		for _, x := range b.EnabledPairs {
 			currency := x
 			go func() {
@@ -191,7 +220,7 @@ func (b *Bitstamp) Run() {
 				AddExchangeInfo(b.GetName(), currency[0:3], currency[3:], ticker.Last, ticker.Volume)
 			}()
 		}
-		time.Sleep(time.Second * b.RESTPollingDelay)
+		SleepContext(ctx, b.RESTPollingDelay)
 	}
 }
 
@@ -302,6 +331,56 @@ func (b *Bitstamp) GetUserTransactions(values url.Values) ([]BitstampUserTransac
 	return response, nil
 }
 
+// GetAccountFills returns the fills within [start, end). The API can't filter
+// by time, so user transactions are paged through from the oldest. Fees are
+// charged in USD.
+func (b *Bitstamp) GetAccountFills(pair CurrencyPair, start, end time.Time) ([]AccountFill, error) {
+	err := b.checkPair(pair)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []AccountFill{}
+	for offset := 0; ; offset += BITSTAMP_TRANSACTIONS_LIMIT {
+		values := url.Values{}
+		values.Set("offset", strconv.Itoa(offset))
+		values.Set("limit", strconv.Itoa(BITSTAMP_TRANSACTIONS_LIMIT))
+		values.Set("sort", "asc")
+		transactions, err := b.GetUserTransactions(values)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, x := range transactions {
+			timestamp, err := time.Parse(BITSTAMP_TIME_FORMAT, x.Date)
+			if err != nil || x.Type != BITSTAMP_TRANSACTION_TRADE || timestamp.Before(start) || !timestamp.Before(end) {
+				continue
+			}
+
+			side := ORDER_SIDE_BUY
+			if x.BTC < 0 {
+				side = ORDER_SIDE_SELL
+			}
+			result = append(result, AccountFill{
+				Exchange:    b.GetName(),
+				ID:          strconv.FormatInt(x.TransID, 10),
+				OrderID:     fmt.Sprint(x.OrderID),
+				Timestamp:   timestamp,
+				Pair:        pair,
+				Side:        side,
+				Price:       x.BTCUSD,
+				Amount:      math.Abs(x.BTC),
+				Fee:         x.Fee,
+				FeeCurrency: "USD",
+			})
+		}
+
+		if len(transactions) < BITSTAMP_TRANSACTIONS_LIMIT {
+			return result, nil
+		}
+	}
+}
+
 func (b *Bitstamp) GetOpenOrders() ([]BitstampOrder, error) {
 	resp := []BitstampOrder{}
 	err := b.SendAuthenticatedHTTPRequest(BITSTAMP_API_OPEN_ORDERS, nil, &resp)
@@ -352,6 +431,111 @@ func (b *Bitstamp) CancelAllOrders() (bool, error) {
 	return result, nil
 }
 
+func (b *Bitstamp) CancelAllOpenOrders() error {
+	_, err := b.CancelAllOrders()
+	return err
+}
+
+// Bitstamp only trades BTCUSD.
+func (b *Bitstamp) checkPair(pair CurrencyPair) error {
+	if pair.String() != "BTCUSD" {
+		return fmt.Errorf(ErrExchangePairNotSupported, b.GetName(), pair)
+	}
+	return nil
+}
+
+func (b *Bitstamp) GetTickerPrice(pair CurrencyPair) (TickerPrice, error) {
+	err := b.checkPair(pair)
+	if err != nil {
+		return TickerPrice{}, err
+	}
+
+	ticker, err := b.GetTicker(false)
+	if err != nil {
+		return TickerPrice{}, err
+	}
+	return TickerPrice{pair.Base, pair.Quote, ticker.Last, ticker.High, ticker.Low, ticker.Bid, ticker.Ask, ticker.Volume}, nil
+}
+
+func (b *Bitstamp) GetOrderbookDepth(pair CurrencyPair) (Orderbook, error) {
+	err := b.checkPair(pair)
+	if err != nil {
+		return Orderbook{}, err
+	}
+
+	orderbook, err := b.GetOrderbook()
+	if err != nil {
+		return Orderbook{}, err
+	}
+
+	result := Orderbook{Pair: pair}
+	for _, x := range orderbook.Bids {
+		result.Bids = append(result.Bids, OrderbookItem{x.Price, x.Amount})
+	}
+	for _, x := range orderbook.Asks {
+		result.Asks = append(result.Asks, OrderbookItem{x.Price, x.Amount})
+	}
+	return result, nil
+}
+
+func (b *Bitstamp) GetAccountBalances() ([]AccountBalance, error) {
+	balance, err := b.GetBalance()
+	if err != nil {
+		return nil, err
+	}
+
+	return []AccountBalance{
+		{"BTC", balance.BTCBalance, balance.BTCAvailable, balance.BTCReserved},
+		{"USD", balance.USDBalance, balance.USDAvailable, balance.USDReserved},
+	}, nil
+}
+
+func (b *Bitstamp) GetOpenOrderDetails() ([]OrderDetail, error) {
+	orders, err := b.GetOpenOrders()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []OrderDetail{}
+	for _, x := range orders {
+		side := ORDER_SIDE_BUY
+		if x.Type == 1 {
+			side = ORDER_SIDE_SELL
+		}
+		result = append(result, OrderDetail{
+			ID:     strconv.FormatInt(x.ID, 10),
+			Pair:   NewCurrencyPair("BTC", "USD"),
+			Side:   side,
+			Price:  x.Price,
+			Amount: x.Amount,
+			Status: "open",
+		})
+	}
+	return result, nil
+}
+
+func (b *Bitstamp) SubmitOrder(pair CurrencyPair, side string, amount, price float64) (string, error) {
+	err := b.checkPair(pair)
+	if err != nil {
+		return "", err
+	}
+
+	order, err := b.PlaceOrder(price, amount, side == ORDER_SIDE_BUY)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(order.ID, 10), nil
+}
+
+func (b *Bitstamp) CancelOrderByID(pair CurrencyPair, orderID string) error {
+	id, err := strconv.ParseInt(orderID, 10, 64)
+	if err != nil {
+		return err
+	}
+	_, err = b.CancelOrder(id)
+	return err
+}
+
 func (b *Bitstamp) PlaceOrder(price float64, amount float64, buy bool) (BitstampOrder, error) {
 	var req = url.Values{}
 	req.Add("amount", strconv.FormatFloat(amount, 'f', -1, 64))
@@ -383,7 +567,118 @@ func (b *Bitstamp) GetWithdrawalRequests() ([]BitstampWithdrawalRequests, error)
 	return resp, nil
 }
 
-func (b *Bitstamp) BitcoinWithdrawal(amount float64, address string) (string, error) {
+// GetFundingHistory returns deposits from the user transactions, pending
+// bitcoin deposits and withdrawal requests. Unconfirmed deposits have no ID or
+// time, so they are identified by address and amount and timestamped now.
+func (b *Bitstamp) GetFundingHistory(start, end time.Time) ([]FundingMovement, error) {
+	result := []FundingMovement{}
+	for offset := 0; ; offset += BITSTAMP_TRANSACTIONS_LIMIT {
+		values := url.Values{}
+		values.Set("offset", strconv.Itoa(offset))
+		values.Set("limit", strconv.Itoa(BITSTAMP_TRANSACTIONS_LIMIT))
+		values.Set("sort", "asc")
+		transactions, err := b.GetUserTransactions(values)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, x := range transactions {
+			timestamp, err := time.Parse(BITSTAMP_TIME_FORMAT, x.Date)
+			if err != nil || x.Type != BITSTAMP_TRANSACTION_DEPOSIT || timestamp.Before(start) || timestamp.After(end) {
+				continue
+			}
+
+			currency, amount := "USD", x.USD
+			if x.BTC != 0 {
+				currency, amount = "BTC", x.BTC
+			}
+			result = append(result, FundingMovement{
+				Exchange:  b.GetName(),
+				ID:        strconv.FormatInt(x.TransID, 10),
+				Type:      FUNDING_DEPOSIT,
+				Status:    FUNDING_STATUS_COMPLETE,
+				Timestamp: timestamp,
+				Currency:  currency,
+				Amount:    math.Abs(amount),
+				Fee:       x.Fee,
+			})
+		}
+
+		if len(transactions) < BITSTAMP_TRANSACTIONS_LIMIT {
+			break
+		}
+	}
+
+	unconfirmed, err := b.GetUnconfirmedBitcoinDeposits()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, x := range unconfirmed {
+		result = append(result, FundingMovement{
+			Exchange:  b.GetName(),
+			ID:        "unconfirmed/" + x.Address + "/" + strconv.FormatFloat(x.Amount, 'f', -1, 64),
+			Type:      FUNDING_DEPOSIT,
+			Status:    FUNDING_STATUS_PENDING,
+			Timestamp: time.Now(),
+			Currency:  "BTC",
+			Amount:    x.Amount,
+			Address:   x.Address,
+		})
+	}
+
+	withdrawals, err := b.GetWithdrawalRequests()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, x := range withdrawals {
+		timestamp, err := time.Parse(BITSTAMP_TIME_FORMAT, x.Date)
+		if err != nil || timestamp.Before(start) || timestamp.After(end) {
+			continue
+		}
+
+		currency := "USD"
+		switch x.Type {
+		case BITSTAMP_WITHDRAWAL_BITCOIN:
+			currency = "BTC"
+		case BITSTAMP_WITHDRAWAL_RIPPLE:
+			currency = "XRP"
+		}
+
+		// 0 is open, 1 in process, 2 finished, 3 cancelled and 4 failed.
+		status := FUNDING_STATUS_PENDING
+		switch x.Status {
+		case 2:
+			status = FUNDING_STATUS_COMPLETE
+		case 3, 4:
+			status = FUNDING_STATUS_CANCELLED
+		}
+
+		movement := FundingMovement{
+			Exchange:  b.GetName(),
+			ID:        strconv.FormatInt(x.OrderID, 10),
+			Type:      FUNDING_WITHDRAWAL,
+			Status:    status,
+			Timestamp: timestamp,
+			Currency:  currency,
+			Amount:    x.Amount,
+		}
+		if data, ok := x.Data.(map[string]interface{}); ok {
+			if address, ok := data["address"].(string); ok {
+				movement.Address = address
+			}
+			if txID, ok := data["transaction_id"].(string); ok {
+				movement.TxID = txID
+			}
+		}
+		result = append(result, movement)
+	}
+	return result, nil
+}
+
+// bitcoinWithdrawal sends bitcoin off the exchange.
+func (b *Bitstamp) bitcoinWithdrawal(amount float64, address string) (string, error) {
 	var req = url.Values{}
 	req.Add("amount", strconv.FormatFloat(amount, 'f', -1, 64))
 	req.Add("address", address)
@@ -412,6 +707,16 @@ func (b *Bitstamp) GetBitcoinDepositAddress() (string, error) {
 	return address, nil
 }
 
+func (b *Bitstamp) GetCryptoDepositAddress(currency string) (string, error) {
+	switch currency {
+	case "BTC":
+		return b.GetBitcoinDepositAddress()
+	case "XRP":
+		return b.GetRippleDepositAddress()
+	}
+	return "", fmt.Errorf(ErrDepositCurrencyNotSupported, b.GetName(), currency)
+}
+
 func (b *Bitstamp) GetUnconfirmedBitcoinDeposits() ([]BitstampUnconfirmedBTCTransactions, error) {
 	response := []BitstampUnconfirmedBTCTransactions{}
 	err := b.SendAuthenticatedHTTPRequest(BITSTAMP_API_UNCONFIRMED_BITCOIN, nil, &response)
@@ -423,7 +728,8 @@ func (b *Bitstamp) GetUnconfirmedBitcoinDeposits() ([]BitstampUnconfirmedBTCTran
 	return response, nil
 }
 
-func (b *Bitstamp) RippleWithdrawal(amount float64, address, currency string) (bool, error) {
+// rippleWithdrawal sends currency to a Ripple address.
+func (b *Bitstamp) rippleWithdrawal(amount float64, address, currency string) (bool, error) {
 	var req = url.Values{}
 	req.Add("amount", strconv.FormatFloat(amount, 'f', -1, 64))
 	req.Add("address", address)
@@ -438,6 +744,19 @@ func (b *Bitstamp) RippleWithdrawal(amount float64, address, currency string) (b
 	return true, nil
 }
 
+// withdraw sends BTC as a bitcoin withdrawal and XRP as a Ripple withdrawal.
+// Ripple withdrawals don't return an ID.
+func (b *Bitstamp) withdraw(currency, address string, amount float64) (string, error) {
+	switch currency {
+	case "BTC":
+		return b.bitcoinWithdrawal(amount, address)
+	case "XRP":
+		_, err := b.rippleWithdrawal(amount, address, currency)
+		return "", err
+	}
+	return "", fmt.Errorf(ErrWithdrawalCurrencyNotSupported, b.GetName(), currency)
+}
+
 func (b *Bitstamp) GetRippleDepositAddress() (string, error) {
 	type response struct {
 		Address string
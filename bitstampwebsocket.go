@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"github.com/toorop/go-pusher"
 	"log"
 )
@@ -19,8 +20,10 @@ const (
 	BITSTAMP_PUSHER_KEY = "de504dc5763aeef9ff52"
 )
 
-func (b *Bitstamp) PusherClient() {
-	for b.Enabled && b.Websocket {
+func (b *Bitstamp) PusherClient(ctx context.Context) {
+	defer SetWebsocketConnected(b.GetName(), false)
+
+	for b.Enabled && b.Websocket && ctx.Err() == nil {
 		pusherClient, err := pusher.NewClient(BITSTAMP_PUSHER_KEY)
 		if err != nil {
 			log.Printf("%s Unable to connect to Websocket. Error: %s\n", b.GetName(), err)
@@ -49,9 +52,12 @@ func (b *Bitstamp) PusherClient() {
 		}
 
 		log.Printf("%s Pusher client connected.\n", b.GetName())
+		SetWebsocketConnected(b.GetName(), true)
 
 		for b.Websocket {
 			select {
+			case <-ctx.Done():
+				return
 			case data := <-dataChannelTrade:
 				result := BitstampPusherOrderbook{}
 				err := JSONDecode([]byte(data.Data), &result)
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -178,7 +180,7 @@ func (b *BTCC) SetDefaults() {
 	b.Fee = 0
 	b.Verbose = false
 	b.Websocket = false
-	b.RESTPollingDelay = 10
+	b.RESTPollingDelay = 10 * time.Second
 }
 
 func (b *BTCC) GetName() string {
@@ -193,6 +195,26 @@ func (b *BTCC) IsEnabled() bool {
 	return b.Enabled
 }
 
+func (b *BTCC) Setup(exch Exchanges) {
+	if !exch.Enabled {
+		b.SetEnabled(false)
+	} else {
+		b.SetEnabled(true)
+		b.AuthenticatedAPISupport = exch.AuthenticatedAPISupport
+		b.SetAPIKeys(exch.APIKey, exch.APISecret)
+		b.RESTPollingDelay = exch.RESTPollingDelay.Duration
+		b.Verbose = exch.Verbose
+		b.Websocket = exch.Websocket
+		b.BaseCurrencies = exch.BaseCurrencies
+		b.AvailablePairs = CurrencyPairsToStrings(exch.AvailablePairs)
+		b.EnabledPairs = CurrencyPairsToStrings(exch.EnabledPairs)
+	}
+}
+
+func (b *BTCC) GetRequiredCredentials() []string {
+	return []string{CREDENTIAL_API_KEY, CREDENTIAL_API_SECRET}
+}
+
 func (b *BTCC) SetAPIKeys(apiKey, apiSecret string) {
 	b.APIKey = apiKey
 	b.APISecret = apiSecret
@@ -202,18 +224,18 @@ func (b *BTCC) GetFee() float64 {
 	return b.Fee
 }
 
-func (b *BTCC) Run() {
+func (b *BTCC) Run(ctx context.Context) {
 	if b.Verbose {
 		log.Printf("%s Websocket: %s.", b.GetName(), IsEnabled(b.Websocket))
-		log.Printf("%s polling delay: %ds.\n", b.GetName(), b.RESTPollingDelay)
+		log.Printf("%s polling delay: %s.\n", b.GetName(), b.RESTPollingDelay)
 		log.Printf("%s %d currencies enabled: %s.\n", b.GetName(), len(b.EnabledPairs), b.EnabledPairs)
 	}
 
 	if b.Websocket {
-		go b.WebsocketClient()
+		StartRoutine(func() { b.WebsocketClient(ctx) })
 	}
 
-	for b.Enabled {
+	for b.Enabled && ctx.Err() == nil {
 		for _, x := range b.EnabledPairs {
 			currency := StringToLower(x)
 			go func() {
@@ -231,7 +253,7 @@ func (b *BTCC) Run() {
 				}
 			}()
 		}
-		time.Sleep(time.Second * b.RESTPollingDelay)
+		SleepContext(ctx, b.RESTPollingDelay)
 	}
 }
 
@@ -300,7 +322,7 @@ func (b *BTCC) GetAccountInfo(infoType string) {
 		params = append(params, infoType)
 	}
 
-	err := b.SendAuthenticatedHTTPRequest(BTCC_ACCOUNT_INFO, params)
+	err := b.SendAuthenticatedHTTPRequest(BTCC_ACCOUNT_INFO, params, nil)
 
 	if err != nil {
 		log.Println(err)
@@ -321,7 +343,7 @@ func (b *BTCC) PlaceOrder(buyOrder bool, price, amount float64, market string) {
 		req = BTCC_ORDER_SELL
 	}
 
-	err := b.SendAuthenticatedHTTPRequest(req, params)
+	err := b.SendAuthenticatedHTTPRequest(req, params, nil)
 
 	if err != nil {
 		log.Println(err)
@@ -336,26 +358,30 @@ func (b *BTCC) CancelOrder(orderID int64, market string) {
 		params = append(params, market)
 	}
 
-	err := b.SendAuthenticatedHTTPRequest(BTCC_ORDER_CANCEL, params)
+	err := b.SendAuthenticatedHTTPRequest(BTCC_ORDER_CANCEL, params, nil)
 
 	if err != nil {
 		log.Println(err)
 	}
 }
 
-func (b *BTCC) GetDeposits(currency string, pending bool) {
+// GetDeposits returns the deposits of currency, only those still pending if
+// pending is set.
+func (b *BTCC) GetDeposits(currency string, pending bool) ([]BTCCDeposit, error) {
 	params := make([]interface{}, 0)
 	params = append(params, currency)
+	params = append(params, pending)
 
-	if pending {
-		params = append(params, pending)
-	}
-
-	err := b.SendAuthenticatedHTTPRequest(BTCC_DEPOSITS, params)
+	result := struct {
+		Deposit []BTCCDeposit `json:"deposit"`
+	}{}
+	err := b.SendAuthenticatedHTTPRequest(BTCC_DEPOSITS, params, &result)
 
 	if err != nil {
-		log.Println(err)
+		return nil, err
 	}
+
+	return result.Deposit, nil
 }
 
 func (b *BTCC) GetMarketDepth(market string, limit int64) {
@@ -369,7 +395,7 @@ func (b *BTCC) GetMarketDepth(market string, limit int64) {
 		params = append(params, market)
 	}
 
-	err := b.SendAuthenticatedHTTPRequest(BTCC_MARKETDEPTH, params)
+	err := b.SendAuthenticatedHTTPRequest(BTCC_MARKETDEPTH, params, nil)
 
 	if err != nil {
 		log.Println(err)
@@ -388,7 +414,7 @@ func (b *BTCC) GetOrder(orderID int64, market string, detailed bool) {
 		params = append(params, detailed)
 	}
 
-	err := b.SendAuthenticatedHTTPRequest(BTCC_ORDER, params)
+	err := b.SendAuthenticatedHTTPRequest(BTCC_ORDER, params, nil)
 
 	if err != nil {
 		log.Println(err)
@@ -422,7 +448,7 @@ func (b *BTCC) GetOrders(openonly bool, market string, limit, offset, since int6
 		params = append(params, detailed)
 	}
 
-	err := b.SendAuthenticatedHTTPRequest(BTCC_ORDERS, params)
+	err := b.SendAuthenticatedHTTPRequest(BTCC_ORDERS, params, nil)
 
 	if err != nil {
 		log.Println(err)
@@ -452,7 +478,7 @@ func (b *BTCC) GetTransactions(transType string, limit, offset, since int64, sin
 		params = append(params, sinceType)
 	}
 
-	err := b.SendAuthenticatedHTTPRequest(BTCC_TRANSACTIONS, params)
+	err := b.SendAuthenticatedHTTPRequest(BTCC_TRANSACTIONS, params, nil)
 
 	if err != nil {
 		log.Println(err)
@@ -467,38 +493,119 @@ func (b *BTCC) GetWithdrawal(withdrawalID int64, currency string) {
 		params = append(params, currency)
 	}
 
-	err := b.SendAuthenticatedHTTPRequest(BTCC_WITHDRAWAL, params)
+	err := b.SendAuthenticatedHTTPRequest(BTCC_WITHDRAWAL, params, nil)
 
 	if err != nil {
 		log.Println(err)
 	}
 }
 
-func (b *BTCC) GetWithdrawals(currency string, pending bool) {
+// GetWithdrawals returns the withdrawals of currency, only those still pending
+// if pending is set.
+func (b *BTCC) GetWithdrawals(currency string, pending bool) ([]BTCCWithdrawal, error) {
 	params := make([]interface{}, 0)
 	params = append(params, currency)
+	params = append(params, pending)
+
+	result := struct {
+		Withdrawal []BTCCWithdrawal `json:"withdrawal"`
+	}{}
+	err := b.SendAuthenticatedHTTPRequest(BTCC_WITHDRAWALS, params, &result)
 
-	if pending {
-		params = append(params, pending)
+	if err != nil {
+		return nil, err
 	}
 
-	err := b.SendAuthenticatedHTTPRequest(BTCC_WITHDRAWALS, params)
+	return result.Withdrawal, nil
+}
+
+// GetFundingHistory returns the deposits and withdrawals of the enabled
+// pairs' base currencies, which are the only ones the API reports.
+func (b *BTCC) GetFundingHistory(start, end time.Time) ([]FundingMovement, error) {
+	result := []FundingMovement{}
+	seen := make(map[string]bool)
+	for _, pair := range ParseCurrencyPairs(b.EnabledPairs, b.BaseCurrencies) {
+		if seen[pair.Base] {
+			continue
+		}
+		seen[pair.Base] = true
 
-	if err != nil {
-		log.Println(err)
+		deposits, err := b.GetDeposits(pair.Base, false)
+		if err != nil {
+			return nil, err
+		}
+
+		withdrawals, err := b.GetWithdrawals(pair.Base, false)
+		if err != nil {
+			return nil, err
+		}
+
+		movements := []FundingMovement{}
+		for _, x := range deposits {
+			movements = append(movements, FundingMovement{
+				Exchange:  b.GetName(),
+				ID:        "deposit/" + strconv.FormatInt(x.ID, 10),
+				Type:      FUNDING_DEPOSIT,
+				Status:    btccFundingStatus(x.Status),
+				Timestamp: time.Unix(x.Date, 0),
+				Currency:  StringToUpper(x.Currency),
+				Amount:    x.Amount,
+				Address:   x.Address,
+			})
+		}
+		for _, x := range withdrawals {
+			movements = append(movements, FundingMovement{
+				Exchange:  b.GetName(),
+				ID:        "withdrawal/" + strconv.FormatInt(x.ID, 10),
+				Type:      FUNDING_WITHDRAWAL,
+				Status:    btccFundingStatus(x.Status),
+				Timestamp: time.Unix(x.Date, 0),
+				Currency:  StringToUpper(x.Currency),
+				Amount:    x.Amount,
+				Address:   x.Address,
+				TxID:      x.Transaction,
+			})
+		}
+
+		for _, x := range movements {
+			if !x.Timestamp.Before(start) && !x.Timestamp.After(end) {
+				result = append(result, x)
+			}
+		}
+	}
+	return result, nil
+}
+
+func btccFundingStatus(status string) string {
+	switch StringToLower(status) {
+	case "completed":
+		return FUNDING_STATUS_COMPLETE
+	case "cancelled", "canceled", "failed":
+		return FUNDING_STATUS_CANCELLED
 	}
+	return FUNDING_STATUS_PENDING
 }
 
-func (b *BTCC) RequestWithdrawal(currency string, amount float64) {
+// requestWithdrawal withdraws to the registered address and returns its ID.
+func (b *BTCC) requestWithdrawal(currency string, amount float64) (int64, error) {
 	params := make([]interface{}, 0)
 	params = append(params, currency)
 	params = append(params, amount)
 
-	err := b.SendAuthenticatedHTTPRequest(BTCC_WITHDRAWAL_REQUEST, params)
+	var id int64
+	err := b.SendAuthenticatedHTTPRequest(BTCC_WITHDRAWAL_REQUEST, params, &id)
+	return id, err
+}
 
+// withdraw can't choose the address, as BTCC always withdraws to the one
+// registered on the account. The address given should be that one so that it
+// is checked against the whitelist.
+func (b *BTCC) withdraw(currency, address string, amount float64) (string, error) {
+	id, err := b.requestWithdrawal(currency, amount)
 	if err != nil {
-		log.Println(err)
+		return "", err
 	}
+	return strconv.FormatInt(id, 10), nil
 }
 
 func (b *BTCC) IcebergOrder(buyOrder bool, price, amount, discAmount, variance float64, market string) {
@@ -517,7 +624,7 @@ func (b *BTCC) IcebergOrder(buyOrder bool, price, amount, discAmount, variance f
 		req = BTCC_ICEBERG_SELL
 	}
 
-	err := b.SendAuthenticatedHTTPRequest(req, params)
+	err := b.SendAuthenticatedHTTPRequest(req, params, nil)
 
 	if err != nil {
 		log.Println(err)
@@ -532,7 +639,7 @@ func (b *BTCC) GetIcebergOrder(orderID int64, market string) {
 		params = append(params, market)
 	}
 
-	err := b.SendAuthenticatedHTTPRequest(BTCC_ICEBERG_ORDER, params)
+	err := b.SendAuthenticatedHTTPRequest(BTCC_ICEBERG_ORDER, params, nil)
 
 	if err != nil {
 		log.Println(err)
@@ -554,7 +661,7 @@ func (b *BTCC) GetIcebergOrders(limit, offset int64, market string) {
 		params = append(params, market)
 	}
 
-	err := b.SendAuthenticatedHTTPRequest(BTCC_ICEBERG_ORDERS, params)
+	err := b.SendAuthenticatedHTTPRequest(BTCC_ICEBERG_ORDERS, params, nil)
 
 	if err != nil {
 		log.Println(err)
@@ -569,45 +676,46 @@ func (b *BTCC) CancelIcebergOrder(orderID int64, market string) {
 		params = append(params, market)
 	}
 
-	err := b.SendAuthenticatedHTTPRequest(BTCC_ICEBERG_CANCEL, params)
+	err := b.SendAuthenticatedHTTPRequest(BTCC_ICEBERG_CANCEL, params, nil)
 
 	if err != nil {
 		log.Println(err)
 	}
 }
 
-func (b *BTCC) PlaceStopOrder(buyOder bool, stopPrice, price, amount, trailingAmt, trailingPct float64, market string) {
-	params := make([]interface{}, 0)
-
-	if stopPrice > 0 {
-		params = append(params, stopPrice)
+// PlaceStopOrder places a stop order and returns its ID. Either stopPrice or
+// one of the trailing amounts is set. A price of zero places a market order
+// when the stop triggers.
+func (b *BTCC) PlaceStopOrder(buyOrder bool, stopPrice, price, amount, trailingAmt, trailingPct float64, market string) (int64, error) {
+	optional := func(value float64) interface{} {
+		if value > 0 {
+			return strconv.FormatFloat(value, 'f', -1, 64)
+		}
+		return nil
 	}
 
-	params = append(params, strconv.FormatFloat(price, 'f', -1, 64))
+	params := make([]interface{}, 0)
+	params = append(params, optional(stopPrice))
+	params = append(params, optional(price))
 	params = append(params, strconv.FormatFloat(amount, 'f', -1, 64))
-
-	if trailingAmt > 0 {
-		params = append(params, strconv.FormatFloat(trailingAmt, 'f', -1, 64))
-	}
-
-	if trailingPct > 0 {
-		params = append(params, strconv.FormatFloat(trailingPct, 'f', -1, 64))
-	}
+	params = append(params, optional(trailingAmt))
+	params = append(params, optional(trailingPct))
 
 	if len(market) > 0 {
 		params = append(params, market)
 	}
 
 	req := BTCC_STOPORDER_BUY
-	if !buyOder {
+	if !buyOrder {
 		req = BTCC_STOPORDER_SELL
 	}
 
-	err := b.SendAuthenticatedHTTPRequest(req, params)
-
+	var orderID int64
+	err := b.SendAuthenticatedHTTPRequest(req, params, &orderID)
 	if err != nil {
-		log.Println(err)
+		return 0, err
 	}
+	return orderID, nil
 }
 
 func (b *BTCC) GetStopOrder(orderID int64, market string) {
@@ -618,7 +726,7 @@ func (b *BTCC) GetStopOrder(orderID int64, market string) {
 		params = append(params, market)
 	}
 
-	err := b.SendAuthenticatedHTTPRequest(BTCC_STOPORDER, params)
+	err := b.SendAuthenticatedHTTPRequest(BTCC_STOPORDER, params, nil)
 
 	if err != nil {
 		log.Println(err)
@@ -652,14 +760,14 @@ func (b *BTCC) GetStopOrders(status, orderType string, stopPrice float64, limit,
 		params = append(params, market)
 	}
 
-	err := b.SendAuthenticatedHTTPRequest(BTCC_STOPORDERS, params)
+	err := b.SendAuthenticatedHTTPRequest(BTCC_STOPORDERS, params, nil)
 
 	if err != nil {
 		log.Println(err)
 	}
 }
 
-func (b *BTCC) CancelStopOrder(orderID int64, market string) {
+func (b *BTCC) CancelStopOrder(orderID int64, market string) error {
 	params := make([]interface{}, 0)
 	params = append(params, orderID)
 
@@ -667,14 +775,38 @@ func (b *BTCC) CancelStopOrder(orderID int64, market string) {
 		params = append(params, market)
 	}
 
-	err := b.SendAuthenticatedHTTPRequest(BTCC_STOPORDER_CANCEL, params)
+	return b.SendAuthenticatedHTTPRequest(BTCC_STOPORDER_CANCEL, params, nil)
+}
 
+// SubmitStopOrder places a native stop order, implementing IStopOrderer.
+func (b *BTCC) SubmitStopOrder(pair CurrencyPair, side string, amount, stopPrice, price, trailingPercent float64) (string, error) {
+	err := IsValidOrderSide(side)
 	if err != nil {
-		log.Println(err)
+		return "", err
+	}
+
+	if trailingPercent > 0 {
+		stopPrice = 0
 	}
+
+	orderID, err := b.PlaceStopOrder(side == ORDER_SIDE_BUY, stopPrice, price, amount, 0, trailingPercent, StringToLower(pair.String()))
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(orderID, 10), nil
+}
+
+func (b *BTCC) CancelStopOrderByID(pair CurrencyPair, orderID string) error {
+	id, err := strconv.ParseInt(orderID, 10, 64)
+	if err != nil {
+		return err
+	}
+	return b.CancelStopOrder(id, StringToLower(pair.String()))
 }
 
-func (b *BTCC) SendAuthenticatedHTTPRequest(method string, params []interface{}) (err error) {
+// SendAuthenticatedHTTPRequest calls a JSON-RPC trade API method. The reply's
+// result is decoded into result unless it is nil.
+func (b *BTCC) SendAuthenticatedHTTPRequest(method string, params []interface{}, result interface{}) (err error) {
 	nonce := strconv.FormatInt(time.Now().UnixNano(), 10)[0:16]
 	encoded := fmt.Sprintf("tonce=%s&accesskey=%s&requestmethod=post&id=%d&method=%s&params=", nonce, b.APIKey, 1, method)
 
@@ -705,6 +837,10 @@ func (b *BTCC) SendAuthenticatedHTTPRequest(method string, params []interface{})
 						items = append(items, "")
 					}
 				}
+			case "<nil>":
+				{
+					items = append(items, "")
+				}
 			default:
 				{
 					items = append(items, fmt.Sprintf("%v", x))
@@ -748,5 +884,25 @@ func (b *BTCC) SendAuthenticatedHTTPRequest(method string, params []interface{})
 		log.Printf("Recv'd :%s\n", resp)
 	}
 
-	return nil
+	reply := struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}{}
+
+	err = JSONDecode([]byte(resp), &reply)
+	if err != nil {
+		return errors.New("Unable to JSON Unmarshal response.")
+	}
+
+	if reply.Error != nil {
+		return fmt.Errorf("%s API error %d: %s", b.GetName(), reply.Error.Code, reply.Error.Message)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return JSONDecode(reply.Result, result)
 }
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/thrasher-/socketio"
 	"log"
+	"strconv"
+	"time"
 )
 
 const (
@@ -48,6 +51,8 @@ type BTCCWebsocketTicker struct {
 var BTCCSocket *socketio.SocketIO
 
 func (b *BTCC) OnConnect(output chan socketio.Message) {
+	SetWebsocketConnected(b.GetName(), true)
+
 	if b.Verbose {
 		log.Printf("%s Connected to Websocket.", b.GetName())
 	}
@@ -72,12 +77,11 @@ func (b *BTCC) OnConnect(output chan socketio.Message) {
 
 func (b *BTCC) OnDisconnect(output chan socketio.Message) {
 	log.Printf("%s Disconnected from websocket server.. Reconnecting.\n", b.GetName())
-	b.WebsocketClient()
+	SetWebsocketConnected(b.GetName(), false)
 }
 
 func (b *BTCC) OnError() {
 	log.Printf("%s Error with Websocket connection.. Reconnecting.\n", b.GetName())
-	b.WebsocketClient()
 }
 
 func (b *BTCC) OnMessage(message []byte, output chan socketio.Message) {
@@ -121,9 +125,20 @@ func (b *BTCC) OnTrade(message []byte, output chan socketio.Message) {
 		log.Println(err)
 		return
 	}
+
+	record := TradeRecord{
+		Timestamp: time.Unix(int64(trade.Date), 0),
+		ID:        strconv.FormatInt(int64(trade.TradeID), 10),
+		Price:     trade.Price,
+		Amount:    trade.Amount,
+		Side:      StringToUpper(trade.Type),
+	}
+	ProcessTrades(b.GetName(), ParseCurrencyPair(StringToUpper(trade.Market), b.BaseCurrencies), []TradeRecord{record})
 }
 
-func (b *BTCC) WebsocketClient() {
+func (b *BTCC) WebsocketClient(ctx context.Context) {
+	defer SetWebsocketConnected(b.GetName(), false)
+
 	events := make(map[string]func(message []byte, output chan socketio.Message))
 	events["grouporder"] = b.OnGroupOrder
 	events["ticker"] = b.OnTicker
@@ -138,8 +153,23 @@ func (b *BTCC) WebsocketClient() {
 		OnDisconnect: b.OnDisconnect,
 	}
 
-	for b.Enabled && b.Websocket {
-		err := socketio.ConnectToSocket(BTCC_SOCKETIO_ADDRESS, BTCCSocket)
+	for b.Enabled && b.Websocket && ctx.Err() == nil {
+		// ConnectToSocket blocks until disconnected and cannot be cancelled,
+		// so wait on it in the background and return once ctx is done. The
+		// connection is left to the server to close, ignoring its events.
+		result := make(chan error, 1)
+		socket := IgnoreSocketIOOnDone(ctx, BTCCSocket)
+		go func() {
+			result <- socketio.ConnectToSocket(BTCC_SOCKETIO_ADDRESS, socket)
+		}()
+
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case err = <-result:
+		}
+
 		if err != nil {
 			log.Printf("%s Unable to connect to Websocket. Err: %s\n", err)
 			continue
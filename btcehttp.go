@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -29,6 +31,7 @@ const (
 	BTCE_WITHDRAW_COIN       = "WithdrawCoin"
 	BTCE_CREATE_COUPON       = "CreateCoupon"
 	BTCE_REDEEM_COUPON       = "RedeemCoupon"
+	BTCE_TRADE_HISTORY_LIMIT = 1000
 )
 
 type BTCE struct {
@@ -83,7 +86,7 @@ func (b *BTCE) SetDefaults() {
 	b.Fee = 0.2
 	b.Verbose = false
 	b.Websocket = false
-	b.RESTPollingDelay = 10
+	b.RESTPollingDelay = 10 * time.Second
 	b.Ticker = make(map[string]BTCeTicker)
 }
 
@@ -99,6 +102,26 @@ func (b *BTCE) IsEnabled() bool {
 	return b.Enabled
 }
 
+func (b *BTCE) Setup(exch Exchanges) {
+	if !exch.Enabled {
+		b.SetEnabled(false)
+	} else {
+		b.SetEnabled(true)
+		b.AuthenticatedAPISupport = exch.AuthenticatedAPISupport
+		b.SetAPIKeys(exch.APIKey, exch.APISecret)
+		b.RESTPollingDelay = exch.RESTPollingDelay.Duration
+		b.Verbose = exch.Verbose
+		b.Websocket = exch.Websocket
+		b.BaseCurrencies = exch.BaseCurrencies
+		b.AvailablePairs = CurrencyPairsToStrings(exch.AvailablePairs)
+		b.EnabledPairs = CurrencyPairsToStrings(exch.EnabledPairs)
+	}
+}
+
+func (b *BTCE) GetRequiredCredentials() []string {
+	return []string{CREDENTIAL_API_KEY, CREDENTIAL_API_SECRET}
+}
+
 func (b *BTCE) SetAPIKeys(apiKey, apiSecret string) {
 	b.APIKey = apiKey
 	b.APISecret = apiSecret
@@ -108,10 +131,10 @@ func (b *BTCE) GetFee() float64 {
 	return b.Fee
 }
 
-func (b *BTCE) Run() {
+func (b *BTCE) Run(ctx context.Context) {
 	if b.Verbose {
 		log.Printf("%s Websocket: %s.", b.GetName(), IsEnabled(b.Websocket))
-		log.Printf("%s polling delay: %ds.\n", b.GetName(), b.RESTPollingDelay)
+		log.Printf("%s polling delay: %s.\n", b.GetName(), b.RESTPollingDelay)
 		log.Printf("%s %d currencies enabled: %s.\n", b.GetName(), len(b.EnabledPairs), b.EnabledPairs)
 	}
 
@@ -122,7 +145,7 @@ func (b *BTCE) Run() {
 	}
 	pairsString := JoinStrings(pairs, "-")
 
-	for b.Enabled {
+	for b.Enabled && ctx.Err() == nil {
 		go func() {
 			ticker, err := b.GetTicker(pairsString)
 			if err != nil {
@@ -136,7 +159,7 @@ func (b *BTCE) Run() {
 				AddExchangeInfo(b.GetName(), StringToUpper(x[0:3]), StringToUpper(x[4:]), y.Last, y.Vol_cur)
 			}
 		}()
-		time.Sleep(time.Second * b.RESTPollingDelay)
+		SleepContext(ctx, b.RESTPollingDelay)
 	}
 }
 
@@ -371,15 +394,27 @@ type BTCETradeHistory struct {
 func (b *BTCE) GetTradeHistory(TIDFrom, Count, TIDEnd int64, order, since, end, pair string) (map[string]BTCETradeHistory, error) {
 	req := url.Values{}
 
-	req.Add("from", strconv.FormatInt(TIDFrom, 10))
 	req.Add("count", strconv.FormatInt(Count, 10))
-	req.Add("from_id", strconv.FormatInt(TIDFrom, 10))
-	req.Add("end_id", strconv.FormatInt(TIDEnd, 10))
 	req.Add("order", order)
-	req.Add("since", since)
-	req.Add("end", end)
 	req.Add("pair", pair)
 
+	// Unset bounds are left out, as the API takes zero as a trade ID.
+	if TIDFrom != 0 {
+		req.Add("from_id", strconv.FormatInt(TIDFrom, 10))
+	}
+
+	if TIDEnd != 0 {
+		req.Add("end_id", strconv.FormatInt(TIDEnd, 10))
+	}
+
+	if since != "" {
+		req.Add("since", since)
+	}
+
+	if end != "" {
+		req.Add("end", end)
+	}
+
 	var result map[string]BTCETradeHistory
 	err := b.SendAuthenticatedHTTPRequest(BTCE_TRADE_HISTORY, req, &result)
 
@@ -390,13 +425,42 @@ func (b *BTCE) GetTradeHistory(TIDFrom, Count, TIDEnd int64, order, since, end,
 	return result, nil
 }
 
+// GetAccountFills returns the oldest fills of pair within [start, end). The
+// trade history doesn't include fees.
+func (b *BTCE) GetAccountFills(pair CurrencyPair, start, end time.Time) ([]AccountFill, error) {
+	symbol := StringToLower(pair.Base + "_" + pair.Quote)
+	trades, err := b.GetTradeHistory(0, BTCE_TRADE_HISTORY_LIMIT, 0, "ASC", strconv.FormatInt(start.Unix(), 10), strconv.FormatInt(end.Unix(), 10), symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []AccountFill{}
+	for id, x := range trades {
+		result = append(result, AccountFill{
+			Exchange:  b.GetName(),
+			ID:        id,
+			OrderID:   strconv.FormatFloat(x.OrderID, 'f', -1, 64),
+			Timestamp: time.Unix(int64(x.Timestamp), 0),
+			Pair:      pair,
+			Side:      StringToUpper(x.Type),
+			Price:     x.Rate,
+			Amount:    x.Amount,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+	return result, nil
+}
+
 type BTCEWithdrawCoins struct {
 	TID        int64     `json:"tId"`
 	AmountSent float64   `json:"amountSent"`
 	Funds      BTCEFunds `json:"funds"`
 }
 
-func (b *BTCE) WithdrawCoins(coin string, amount float64, address string) (BTCEWithdrawCoins, error) {
+// withdrawCoins sends coins off the exchange.
+func (b *BTCE) withdrawCoins(coin string, amount float64, address string) (BTCEWithdrawCoins, error) {
 	req := url.Values{}
 
 	req.Add("coinName", coin)
@@ -412,6 +476,14 @@ func (b *BTCE) WithdrawCoins(coin string, amount float64, address string) (BTCEW
 	return result, nil
 }
 
+func (b *BTCE) withdraw(currency, address string, amount float64) (string, error) {
+	result, err := b.withdrawCoins(currency, amount, address)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(result.TID, 10), nil
+}
+
 type BTCECreateCoupon struct {
 	Coupon  string    `json:"coupon"`
 	TransID int64     `json:"transID"`
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"strconv"
@@ -89,7 +90,7 @@ func (b *BTCMarkets) SetDefaults() {
 	b.Fee = 0.85
 	b.Verbose = false
 	b.Websocket = false
-	b.RESTPollingDelay = 10
+	b.RESTPollingDelay = 10 * time.Second
 	b.Ticker = make(map[string]BTCMarketsTicker)
 }
 
@@ -105,6 +106,26 @@ func (b *BTCMarkets) IsEnabled() bool {
 	return b.Enabled
 }
 
+func (b *BTCMarkets) Setup(exch Exchanges) {
+	if !exch.Enabled {
+		b.SetEnabled(false)
+	} else {
+		b.SetEnabled(true)
+		b.AuthenticatedAPISupport = exch.AuthenticatedAPISupport
+		b.SetAPIKeys(exch.APIKey, exch.APISecret)
+		b.RESTPollingDelay = exch.RESTPollingDelay.Duration
+		b.Verbose = exch.Verbose
+		b.Websocket = exch.Websocket
+		b.BaseCurrencies = exch.BaseCurrencies
+		b.AvailablePairs = CurrencyPairsToStrings(exch.AvailablePairs)
+		b.EnabledPairs = CurrencyPairsToStrings(exch.EnabledPairs)
+	}
+}
+
+func (b *BTCMarkets) GetRequiredCredentials() []string {
+	return []string{CREDENTIAL_API_KEY, CREDENTIAL_API_SECRET}
+}
+
 func (b *BTCMarkets) SetAPIKeys(apiKey, apiSecret string) {
 	if !b.AuthenticatedAPISupport {
 		return
@@ -126,22 +147,22 @@ func (b *BTCMarkets) GetFee() float64 {
 	return b.Fee
 }
 
-func (b *BTCMarkets) Run() {
+func (b *BTCMarkets) Run(ctx context.Context) {
 	if b.Verbose {
-		log.Printf("%s polling delay: %ds.\n", b.GetName(), b.RESTPollingDelay)
+		log.Printf("%s polling delay: %s.\n", b.GetName(), b.RESTPollingDelay)
 		log.Printf("%s %d currencies enabled: %s.\n", b.GetName(), len(b.EnabledPairs), b.EnabledPairs)
 	}
 
-	for b.Enabled {
+	for b.Enabled && ctx.Err() == nil {
 		for _, x := range b.EnabledPairs {
 			currency := x
 			go func() {
-				ticker, err := b.GetTicker(currency)
+				ticker, err := b.GetTicker(currency[0:3])
 				if err != nil {
 					log.Println(err)
 					return
 				}
-				b.Ticker[currency] = ticker
+				b.Ticker[currency[0:3]] = ticker
 				BTCMarketsLastUSD, _ := ConvertCurrency(ticker.LastPrice, "AUD", "USD")
 				BTCMarketsBestBidUSD, _ := ConvertCurrency(ticker.BestBID, "AUD", "USD")
 				BTCMarketsBestAskUSD, _ := ConvertCurrency(ticker.BestAsk, "AUD", "USD")
@@ -150,7 +171,7 @@ func (b *BTCMarkets) Run() {
 				AddExchangeInfo(b.GetName(), currency[0:3], "USD", BTCMarketsLastUSD, 0)
 			}()
 		}
-		time.Sleep(time.Second * b.RESTPollingDelay)
+		SleepContext(ctx, b.RESTPollingDelay)
 	}
 }
 
@@ -174,6 +195,49 @@ func (b *BTCMarkets) GetOrderbook(symbol string) (BTCMarketsOrderbook, error) {
 	return orderbook, nil
 }
 
+// BTC Markets only quotes in AUD, which its ticker and orderbook endpoints
+// assume.
+func (b *BTCMarkets) checkPair(pair CurrencyPair) error {
+	if pair.Quote != "AUD" {
+		return fmt.Errorf(ErrExchangePairNotSupported, b.GetName(), pair)
+	}
+	return nil
+}
+
+func (b *BTCMarkets) GetTickerPrice(pair CurrencyPair) (TickerPrice, error) {
+	err := b.checkPair(pair)
+	if err != nil {
+		return TickerPrice{}, err
+	}
+
+	ticker, err := b.GetTicker(pair.Base)
+	if err != nil {
+		return TickerPrice{}, err
+	}
+	return TickerPrice{CryptoCurrency: pair.Base, FiatCurrency: pair.Quote, Last: ticker.LastPrice, Bid: ticker.BestBID, Ask: ticker.BestAsk}, nil
+}
+
+func (b *BTCMarkets) GetOrderbookDepth(pair CurrencyPair) (Orderbook, error) {
+	err := b.checkPair(pair)
+	if err != nil {
+		return Orderbook{}, err
+	}
+
+	orderbook, err := b.GetOrderbook(pair.Base)
+	if err != nil {
+		return Orderbook{}, err
+	}
+
+	result := Orderbook{Pair: pair}
+	for _, x := range orderbook.Bids {
+		result.Bids = append(result.Bids, OrderbookItem{x[0], x[1]})
+	}
+	for _, x := range orderbook.Asks {
+		result.Asks = append(result.Asks, OrderbookItem{x[0], x[1]})
+	}
+	return result, nil
+}
+
 func (b *BTCMarkets) GetTrades(symbol, since string) ([]BTCMarketsTrade, error) {
 	trades := []BTCMarketsTrade{}
 	path := ""
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	CANDLE_FLUSH_INTERVAL     = time.Second
+	CANDLE_SUBSCRIBER_BUFFER  = 100
+	WarningCandleTradeDropped = "%s: Dropped %s trade at %s, its %s candle was already finished."
+)
+
+var CANDLE_DEFAULT_INTERVALS = []time.Duration{time.Minute, time.Minute * 5, time.Hour, time.Hour * 24}
+
+// CandleEvent is sent to subscribers when a candle is finished.
+type CandleEvent struct {
+	Exchange string
+	Pair     CurrencyPair
+	Interval time.Duration
+	Candle   Candle
+}
+
+// openCandle tracks the first and last trade times so trades arriving out of
+// order still give the correct open and close.
+type openCandle struct {
+	Candle
+	first time.Time
+	last  time.Time
+}
+
+type candleSeries struct {
+	exchange string
+	pair     CurrencyPair
+	interval time.Duration
+	open     map[int64]*openCandle
+	// finishedBefore is the end of the latest finished candle. Trades before
+	// it are too late to be counted.
+	finishedBefore time.Time
+}
+
+// CandleBuilder aggregates live trades into candles for each configured
+// interval. A candle is finished once its interval plus the late trade window
+// has passed, then stored and sent to subscribers.
+type CandleBuilder struct {
+	sync.Mutex
+	intervals   []time.Duration
+	lateWindow  time.Duration
+	series      map[string]*candleSeries
+	subscribers map[chan CandleEvent]bool
+}
+
+func NewCandleBuilder(cfg CandleConfig) *CandleBuilder {
+	c := &CandleBuilder{
+		lateWindow:  cfg.LateTradeWindow.Duration,
+		series:      make(map[string]*candleSeries),
+		subscribers: make(map[chan CandleEvent]bool),
+	}
+	for _, x := range cfg.Intervals {
+		c.intervals = append(c.intervals, x.Duration)
+	}
+	return c
+}
+
+func (c *CandleBuilder) getSeries(exchange string, pair CurrencyPair, interval time.Duration) *candleSeries {
+	name := GetCandleSeriesName(exchange, pair, interval)
+	series, ok := c.series[name]
+	if !ok {
+		series = &candleSeries{exchange: exchange, pair: pair, interval: interval, open: make(map[int64]*openCandle)}
+		c.series[name] = series
+	}
+	return series
+}
+
+// AddTrades adds trades to the open candle of every interval.
+func (c *CandleBuilder) AddTrades(exchange string, pair CurrencyPair, trades []TradeRecord) {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, interval := range c.intervals {
+		series := c.getSeries(exchange, pair, interval)
+		for _, x := range trades {
+			start := x.Timestamp.Truncate(interval)
+			if start.Before(series.finishedBefore) {
+				log.Printf(WarningCandleTradeDropped+"\n", exchange, pair, x.Timestamp, interval)
+				continue
+			}
+
+			candle, ok := series.open[start.UnixNano()]
+			if !ok {
+				series.open[start.UnixNano()] = &openCandle{NewCandle(start, x.Price, x.Amount), x.Timestamp, x.Timestamp}
+				continue
+			}
+
+			if x.Price > candle.High {
+				candle.High = x.Price
+			}
+			if x.Price < candle.Low {
+				candle.Low = x.Price
+			}
+			if x.Timestamp.Before(candle.first) {
+				candle.Open = x.Price
+				candle.first = x.Timestamp
+			}
+			if !x.Timestamp.Before(candle.last) {
+				candle.Close = x.Price
+				candle.last = x.Timestamp
+			}
+			candle.Volume += x.Amount
+		}
+	}
+}
+
+// Flush finishes every candle whose interval and late trade window ended
+// before now. Flushing with a zero time finishes all open candles.
+func (c *CandleBuilder) Flush(now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, series := range c.series {
+		finished := []Candle{}
+		for key, x := range series.open {
+			end := x.Timestamp.Add(series.interval)
+			if !now.IsZero() && end.Add(c.lateWindow).After(now) {
+				continue
+			}
+
+			finished = append(finished, x.Candle)
+			delete(series.open, key)
+			if end.After(series.finishedBefore) {
+				series.finishedBefore = end
+			}
+		}
+
+		if len(finished) == 0 {
+			continue
+		}
+
+		SortCandles(finished)
+		StoreCandles(series.exchange, series.pair, series.interval, finished)
+		for _, x := range finished {
+			c.publish(CandleEvent{series.exchange, series.pair, series.interval, x})
+		}
+	}
+}
+
+// GetOpenCandles returns the unfinished candles of a series in time order.
+func (c *CandleBuilder) GetOpenCandles(exchange string, pair CurrencyPair, interval time.Duration) []Candle {
+	c.Lock()
+	defer c.Unlock()
+
+	result := []Candle{}
+	series, ok := c.series[GetCandleSeriesName(exchange, pair, interval)]
+	if !ok {
+		return result
+	}
+
+	for _, x := range series.open {
+		result = append(result, x.Candle)
+	}
+	SortCandles(result)
+	return result
+}
+
+// Subscribe returns a channel receiving every finished candle. Events are
+// dropped for subscribers that fall behind rather than blocking the builder.
+func (c *CandleBuilder) Subscribe() chan CandleEvent {
+	c.Lock()
+	defer c.Unlock()
+
+	ch := make(chan CandleEvent, CANDLE_SUBSCRIBER_BUFFER)
+	c.subscribers[ch] = true
+	return ch
+}
+
+func (c *CandleBuilder) Unsubscribe(ch chan CandleEvent) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.subscribers[ch] {
+		delete(c.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (c *CandleBuilder) publish(event CandleEvent) {
+	for ch := range c.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// ProcessTrades is called by exchanges with new public trades. The trades are
+// recorded in storage and added to live candles where each is enabled, then
+// matched against paper orders and passed to running strategies and feed
+// clients.
+func ProcessTrades(exchange string, pair CurrencyPair, trades []TradeRecord) {
+	if len(trades) == 0 {
+		return
+	}
+
+	StoreTrades(exchange, pair, trades)
+	if bot.candles != nil {
+		bot.candles.AddTrades(exchange, pair, trades)
+	}
+	ProcessPaperTrades(exchange, pair, trades)
+	ProcessStrategyTrades(exchange, pair, trades)
+	ProcessConditionalTrades(exchange, pair, trades)
+	PublishFeed(FEED_CHANNEL_TRADES, exchange, pair, trades)
+}
+
+// CandleRoutine finishes due candles until ctx is cancelled, then finishes
+// the remaining open candles so they are not lost.
+func CandleRoutine(ctx context.Context) {
+	ticker := time.NewTicker(CANDLE_FLUSH_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			bot.candles.Flush(time.Time{})
+			return
+		case now := <-ticker.C:
+			bot.candles.Flush(now)
+		}
+	}
+}
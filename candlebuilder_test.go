@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCandleBuilder(t *testing.T) {
+	c := NewCandleBuilder(CandleConfig{
+		Enabled:         true,
+		Intervals:       []ConfigDuration{{time.Minute}, {time.Minute * 5}},
+		LateTradeWindow: ConfigDuration{time.Second * 10},
+	})
+	events := c.Subscribe()
+	defer c.Unsubscribe(events)
+
+	pair := NewCurrencyPair("BTC", "USD")
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time { return start.Add(time.Second * time.Duration(seconds)) }
+
+	// Trades arrive out of order.
+	c.AddTrades("Bitstamp", pair, []TradeRecord{
+		{Timestamp: at(30), Price: 10, Amount: 1},
+		{Timestamp: at(10), Price: 8, Amount: 2},
+		{Timestamp: at(50), Price: 12, Amount: 1},
+	})
+	c.AddTrades("Bitstamp", pair, []TradeRecord{
+		{Timestamp: at(20), Price: 15, Amount: 0.5},
+		{Timestamp: at(70), Price: 9, Amount: 1},
+	})
+
+	open := c.GetOpenCandles("Bitstamp", pair, time.Minute)
+	want := Candle{start, 8, 15, 8, 12, 4.5}
+	if len(open) != 2 || open[0] != want || open[1] != NewCandle(at(60), 9, 1) {
+		t.Fatalf("open 1m candles = %+v, want %+v and one at 9", open, want)
+	}
+
+	// A candle waits for the late trade window after its interval.
+	c.Flush(at(69))
+	select {
+	case x := <-events:
+		t.Fatalf("finished %+v within the late trade window", x)
+	default:
+	}
+
+	c.Flush(at(70))
+	select {
+	case x := <-events:
+		if x.Exchange != "Bitstamp" || x.Pair != pair || x.Interval != time.Minute || x.Candle != want {
+			t.Errorf("finished %+v, want the first 1m candle", x)
+		}
+	default:
+		t.Fatal("no candle finished")
+	}
+
+	// Later trades for a finished candle are dropped, but still count towards
+	// longer intervals.
+	c.AddTrades("Bitstamp", pair, []TradeRecord{{Timestamp: at(40), Price: 20, Amount: 1}})
+	if open := c.GetOpenCandles("Bitstamp", pair, time.Minute); len(open) != 1 || open[0].Timestamp != at(60) {
+		t.Errorf("open 1m candles = %+v, want the late trade dropped", open)
+	}
+	if open := c.GetOpenCandles("Bitstamp", pair, time.Minute*5); len(open) != 1 || open[0].High != 20 || open[0].Close != 9 || open[0].Volume != 6.5 {
+		t.Errorf("open 5m candles = %+v, want the late trade counted", open)
+	}
+
+	c.Flush(time.Time{})
+	finished := 0
+	for len(events) > 0 {
+		<-events
+		finished++
+	}
+	if finished != 2 || len(c.GetOpenCandles("Bitstamp", pair, time.Minute*5)) != 0 {
+		t.Errorf("flushing everything finished %d candles, want 2", finished)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	ErrCandleIntervalNotSupported = "%s does not support a candle interval of %s."
+)
+
+type Candle struct {
+	Timestamp time.Time
+	Open      float64
+	High      float64
+	Low       float64
+	Close     float64
+	Volume    float64
+}
+
+type candleIntervalError struct {
+	exchange string
+	interval time.Duration
+}
+
+func (e candleIntervalError) Error() string {
+	return fmt.Sprintf(ErrCandleIntervalNotSupported, e.exchange, e.interval)
+}
+
+// NewCandleIntervalError is returned by exchanges asked for a candle interval
+// their API doesn't offer.
+func NewCandleIntervalError(exchange string, interval time.Duration) error {
+	return candleIntervalError{exchange, interval}
+}
+
+func IsCandleIntervalError(err error) bool {
+	_, ok := err.(candleIntervalError)
+	return ok
+}
+
+// NewCandle starts a candle at timestamp from its first trade.
+func NewCandle(timestamp time.Time, price, amount float64) Candle {
+	return Candle{timestamp, price, price, price, price, amount}
+}
+
+// AddTrade updates the candle with a later trade inside its interval.
+func (c *Candle) AddTrade(price, amount float64) {
+	if price > c.High {
+		c.High = price
+	}
+	if price < c.Low {
+		c.Low = price
+	}
+	c.Close = price
+	c.Volume += amount
+}
+
+// BuildCandles aggregates trades into candles of the given interval. Trades
+// need not be sorted. Intervals without trades produce no candle.
+func BuildCandles(trades []TradeRecord, interval time.Duration) []Candle {
+	sorted := make([]TradeRecord, len(trades))
+	copy(sorted, trades)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+
+	candles := []Candle{}
+	for _, x := range sorted {
+		start := x.Timestamp.Truncate(interval)
+		if len(candles) == 0 || !candles[len(candles)-1].Timestamp.Equal(start) {
+			candles = append(candles, NewCandle(start, x.Price, x.Amount))
+			continue
+		}
+		candles[len(candles)-1].AddTrade(x.Price, x.Amount)
+	}
+	return candles
+}
+
+func SortCandles(candles []Candle) {
+	sort.Slice(candles, func(i, j int) bool {
+		return candles[i].Timestamp.Before(candles[j].Timestamp)
+	})
+}
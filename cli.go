@@ -0,0 +1,1590 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	CLI_EXIT_SUCCESS           = 0
+	CLI_EXIT_FAILURE           = 1
+	CLI_EXIT_USAGE             = 2
+	CLI_DEFAULT_ORDERBOOK_SIZE = 10
+	CLI_DEFAULT_CANDLE_PERIOD  = time.Hour
+	CLI_DEFAULT_HISTORY        = time.Hour * 24
+	ErrCLIUnknownCommand       = "Unknown command %s."
+	ErrCLIUnknownExchange      = "Exchange %s not found in config."
+	ErrCLIEventNotFound        = "Event %d not found."
+	ErrCLIInvalidTime          = "Invalid time %s. Use RFC3339 or a duration such as 24h."
+	ErrCLIInvalidInterval      = "Invalid candle interval %s."
+	ErrCLIInvalidKeyValue      = "Invalid setting %s. Use key=value pairs separated by commas."
+	ErrCLIInvalidNumber        = "Invalid number %s."
+	ErrCLIUnknownUser          = "Unable to find the OS user running the CLI. Error: %s"
+	ErrCLIAPIStatus            = "The bot's API returned %s."
+)
+
+// VerboseOutput is set with the -verbose flag and turns on verbose output for
+// every exchange.
+var VerboseOutput bool
+
+const cliUsage = `Usage: gocryptotrader [flags] [command]
+
+Without a command the bot is started. Commands run a single operation
+against one exchange and exit:
+
+  ticker <exchange> <pair>                     Show the ticker for a pair.
+  orderbook <exchange> <pair> [depth]          Show the top of the orderbook.
+  balances <exchange>                          Show account balances.
+  orders <exchange>                            Show open orders.
+  place <exchange> <pair> <buy|sell> <amount> <price>
+                                               Place a limit order.
+  cancel <exchange> <pair> <order id>          Cancel an order.
+  events list                                  List saved events.
+  events add <exchange> <item> <condition> <crypto> <fiat> <action>
+                                               Add an event, e.g.
+                                               events add Bitfinex PRICE ">=,500" BTC USD CONSOLE_PRINT
+                                               events add Kraken "RSI(14)@1h" "<,30" XBT USD CONSOLE_PRINT
+  events remove <id>                           Remove an event.
+  config validate                              Check the config file and exit.
+  history <tickers|trades|orderbooks|candles> <exchange> <pair> [start] [end] [interval]
+                                               Query stored history. Times are RFC3339
+                                               or a duration before now, e.g. 24h.
+                                               Defaults to the last 24 hours and 1h candles.
+  indicator <exchange> <pair> <indicator> [start] [end]
+                                               Calculate an indicator over stored candles,
+                                               e.g. "MACD(12,26,9).SIGNAL@4h". Indicators are
+                                               SMA, EMA, RSI, MACD, BB, ATR, VWAP, OBV and STOCH.
+  download <exchange> <pair> <start> [end] [interval]
+                                               Download historical candles into storage,
+                                               1h by default. Running again with the same
+                                               start resumes where the last run stopped.
+  import <exchange> <pair> <start> [end]       Import the account's fills into the trade
+                                               ledger. Running again with the same start
+                                               resumes where the last run stopped.
+  tax [options] <start> [end]                  Report gains realized between start and end
+                                               on ledger fills. Run "tax -h" for options.
+  funding import <exchange> <start> [end]      Import the account's deposits and withdrawals
+                                               into the funding ledger.
+  funding list [start] [end]                   List ledger deposits and withdrawals.
+  funding reconcile [options] [start] [end]    Match transfers between our own exchange
+                                               accounts and flag pending or unmatched
+                                               movements. Run "funding reconcile -h" for options.
+  withdrawal list                              List withdrawals and their audit logs.
+  withdrawal request [-dry-run] <exchange> <currency> <amount> <address>
+                                               Request a withdrawal. It must be to a whitelisted
+                                               address within the daily limit, and waits for
+                                               approval through the API if that is required.
+  withdrawal reject <id> [reason]              Reject a withdrawal requested by the same OS user,
+                                               or by the admin login while the bot runs.
+  wallet list <exchange>                       List the account's wallets or sub-accounts
+                                               and their balances.
+  wallet transfer <exchange> <currency> <amount> <from> <to>
+                                               Move funds between two wallets by ID.
+  wallet create <exchange> <name> [currency]   Open a wallet or sub-account.
+  portfolio                                    Show balances held on every exchange, broken
+                                               down by wallet.
+  rebalance [-execute] [-dry-run]              Plan transfers to bring holdings to their
+                                               rebalance targets, requesting them as
+                                               withdrawals with -execute.
+  backtest [options] <exchange> <pair> <strategy> <start> [end]
+                                               Replay stored history through a strategy.
+                                               Run "backtest -h" for options.
+  conditional list                             List conditional orders.
+  conditional add <exchange> <pair> <buy|sell> <amount> <stop|take_profit|trailing_stop> <trigger> [limit]
+                                               Add a conditional order. The trigger is a price,
+                                               or a percent for trailing stops. Without a limit
+                                               price a market order is placed.
+  conditional oco <exchange> <pair> <buy|sell> <amount> <take profit> <stop>
+                                               Add a take profit and stop which cancel each other.
+  conditional cancel <id>                      Cancel a conditional order.
+                                               Conditional orders are kept in storage and are
+                                               watched while the bot runs.
+  execute [options] <exchange> <pair> <buy|sell> <amount>
+                                               Work an order with TWAP, VWAP or iceberg
+                                               child orders. Run "execute -h" for options.
+
+Pairs may be written as BTCUSD, BTC-USD or BTC/USD.
+
+Flags:
+`
+
+func PrintUsage() {
+	fmt.Fprint(os.Stderr, cliUsage)
+	flag.PrintDefaults()
+}
+
+// RunCommand runs a single CLI command and returns the process exit code.
+func RunCommand(args []string) int {
+	var err error
+	switch args[0] {
+	case "ticker":
+		err = runTickerCommand(args[1:])
+	case "orderbook":
+		err = runOrderbookCommand(args[1:])
+	case "balances":
+		err = runBalancesCommand(args[1:])
+	case "orders":
+		err = runOrdersCommand(args[1:])
+	case "place":
+		err = runPlaceCommand(args[1:])
+	case "cancel":
+		err = runCancelCommand(args[1:])
+	case "events":
+		err = runEventsCommand(args[1:])
+	case "config":
+		err = runConfigCommand(args[1:])
+	case "history":
+		err = runHistoryCommand(args[1:])
+	case "download":
+		err = runDownloadCommand(args[1:])
+	case "indicator":
+		err = runIndicatorCommand(args[1:])
+	case "import":
+		err = runImportCommand(args[1:])
+	case "tax":
+		err = runTaxCommand(args[1:])
+	case "funding":
+		err = runFundingCommand(args[1:])
+	case "withdrawal":
+		err = runWithdrawalCommand(args[1:])
+	case "wallet":
+		err = runWalletCommand(args[1:])
+	case "portfolio":
+		err = runPortfolioCommand(args[1:])
+	case "rebalance":
+		err = runRebalanceCommand(args[1:])
+	case "backtest":
+		err = runBacktestCommand(args[1:])
+	case "execute":
+		err = runExecuteCommand(args[1:])
+	case "conditional":
+		err = runConditionalCommand(args[1:])
+	case "help":
+		PrintUsage()
+		return CLI_EXIT_SUCCESS
+	default:
+		err = fmt.Errorf(ErrCLIUnknownCommand, args[0])
+	}
+
+	if bot.storage != nil {
+		bot.storage.Close()
+	}
+
+	if err == errCLIUsage {
+		PrintUsage()
+		return CLI_EXIT_USAGE
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return CLI_EXIT_FAILURE
+	}
+	return CLI_EXIT_SUCCESS
+}
+
+var errCLIUsage = errors.New("usage")
+
+// LoadCLIConfig reads the config file and sets up every configured exchange
+// without starting it.
+func LoadCLIConfig() error {
+	var err error
+	bot.config, err = ReadConfig()
+	if err != nil {
+		return err
+	}
+
+	SetConfigBaseCurrencies(bot.config)
+	bot.exchange.SetDefaults()
+	for _, exch := range bot.config.Exchanges {
+		exchange := bot.exchange.GetExchangeByName(exch.Name)
+		if exchange == nil {
+			continue
+		}
+
+		SetupExchange(exchange, exch)
+	}
+	return nil
+}
+
+// GetCLIExchange loads the config and returns the named exchange along with
+// its config.
+func GetCLIExchange(name string) (IBotExchange, Exchanges, error) {
+	err := LoadCLIConfig()
+	if err != nil {
+		return nil, Exchanges{}, err
+	}
+
+	exch, err := GetExchangeConfig(name)
+	if err != nil {
+		return nil, Exchanges{}, fmt.Errorf(ErrCLIUnknownExchange, name)
+	}
+
+	exchange := bot.exchange.GetExchangeByName(exch.Name)
+	if exchange == nil {
+		return nil, Exchanges{}, fmt.Errorf(ErrCLIUnknownExchange, name)
+	}
+	return exchange, exch, nil
+}
+
+// ParseCLICurrencyPair parses a pair written as BTCUSD, BTC-USD or BTC/USD.
+// Pairs without a separator are split using the exchange's base currencies
+// and the configured cryptocurrencies.
+func ParseCLICurrencyPair(exch Exchanges, pair string) CurrencyPair {
+	pair = StringToUpper(pair)
+	for _, separator := range []string{"-", "/", "_"} {
+		if StringContains(pair, separator) {
+			currencies := SplitStrings(pair, separator)
+			return NewCurrencyPair(currencies[0], currencies[1])
+		}
+	}
+
+	quotes := []string{}
+	quotes = append(quotes, exch.BaseCurrencies...)
+	if bot.config.Cryptocurrencies != "" {
+		quotes = append(quotes, SplitStrings(bot.config.Cryptocurrencies, ",")...)
+	}
+	return ParseCurrencyPair(pair, quotes)
+}
+
+func PrintJSON(v interface{}) error {
+	payload, err := json.MarshalIndent(v, "", " ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(payload))
+	return nil
+}
+
+func runTickerCommand(args []string) error {
+	if len(args) != 2 {
+		return errCLIUsage
+	}
+
+	exchange, exch, err := GetCLIExchange(args[0])
+	if err != nil {
+		return err
+	}
+
+	fetcher, ok := exchange.(ITickerFetcher)
+	if !ok {
+		return NewExchangeFeatureError(exchange, "tickers")
+	}
+
+	ticker, err := fetcher.GetTickerPrice(ParseCLICurrencyPair(exch, args[1]))
+	if err != nil {
+		return err
+	}
+	return PrintJSON(ticker)
+}
+
+func runOrderbookCommand(args []string) error {
+	if len(args) != 2 && len(args) != 3 {
+		return errCLIUsage
+	}
+
+	depth := CLI_DEFAULT_ORDERBOOK_SIZE
+	if len(args) == 3 {
+		var err error
+		depth, err = strconv.Atoi(args[2])
+		if err != nil || depth <= 0 {
+			return errCLIUsage
+		}
+	}
+
+	exchange, exch, err := GetCLIExchange(args[0])
+	if err != nil {
+		return err
+	}
+
+	fetcher, ok := exchange.(IOrderbookFetcher)
+	if !ok {
+		return NewExchangeFeatureError(exchange, "orderbooks")
+	}
+
+	orderbook, err := fetcher.GetOrderbookDepth(ParseCLICurrencyPair(exch, args[1]))
+	if err != nil {
+		return err
+	}
+
+	return PrintJSON(TrimOrderbook(orderbook, depth))
+}
+
+func runBalancesCommand(args []string) error {
+	if len(args) != 1 {
+		return errCLIUsage
+	}
+
+	exchange, exch, err := GetCLIExchange(args[0])
+	if err != nil {
+		return err
+	}
+
+	err = openCLIPaperStorage(exch)
+	if err != nil {
+		return err
+	}
+
+	fetcher, err := GetBalanceFetcher(exchange)
+	if err != nil {
+		return err
+	}
+
+	balances, err := fetcher.GetAccountBalances()
+	if err != nil {
+		return err
+	}
+	return PrintJSON(balances)
+}
+
+func getCLIOrderManager(name string) (IOrderManager, Exchanges, error) {
+	exchange, exch, err := GetCLIExchange(name)
+	if err != nil {
+		return nil, Exchanges{}, err
+	}
+
+	err = openCLIPaperStorage(exch)
+	if err != nil {
+		return nil, Exchanges{}, err
+	}
+
+	manager, err := GetOrderManager(exchange)
+	if err != nil {
+		return nil, Exchanges{}, err
+	}
+	return manager, exch, nil
+}
+
+// openCLIPaperStorage opens storage for an exchange set to paper trade, so
+// its paper account is loaded and saved. RunCommand closes it.
+func openCLIPaperStorage(exch Exchanges) error {
+	if !exch.PaperTrading {
+		return nil
+	}
+
+	var err error
+	bot.storage, err = OpenCLIStorage(false)
+	return err
+}
+
+func runOrdersCommand(args []string) error {
+	if len(args) != 1 {
+		return errCLIUsage
+	}
+
+	manager, _, err := getCLIOrderManager(args[0])
+	if err != nil {
+		return err
+	}
+
+	orders, err := manager.GetOpenOrderDetails()
+	if err != nil {
+		return err
+	}
+	return PrintJSON(orders)
+}
+
+func runPlaceCommand(args []string) error {
+	if len(args) != 5 {
+		return errCLIUsage
+	}
+
+	side := StringToUpper(args[2])
+	err := IsValidOrderSide(side)
+	if err != nil {
+		return err
+	}
+
+	amount, err := strconv.ParseFloat(args[3], 64)
+	if err != nil {
+		return fmt.Errorf("Invalid amount %s.", args[3])
+	}
+
+	price, err := strconv.ParseFloat(args[4], 64)
+	if err != nil {
+		return fmt.Errorf("Invalid price %s.", args[4])
+	}
+
+	manager, exch, err := getCLIOrderManager(args[0])
+	if err != nil {
+		return err
+	}
+
+	orderID, err := manager.SubmitOrder(ParseCLICurrencyPair(exch, args[1]), side, amount, price)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Order placed. ID: %s\n", orderID)
+	return nil
+}
+
+func runCancelCommand(args []string) error {
+	if len(args) != 3 {
+		return errCLIUsage
+	}
+
+	manager, exch, err := getCLIOrderManager(args[0])
+	if err != nil {
+		return err
+	}
+
+	err = manager.CancelOrderByID(ParseCLICurrencyPair(exch, args[1]), args[2])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Order %s cancelled.\n", args[2])
+	return nil
+}
+
+func runEventsCommand(args []string) error {
+	if len(args) == 0 {
+		return errCLIUsage
+	}
+
+	err := LoadCLIConfig()
+	if err != nil {
+		return err
+	}
+
+	err = LoadEvents()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		if len(args) != 1 {
+			return errCLIUsage
+		}
+
+		if len(Events) == 0 {
+			fmt.Println("No events.")
+		}
+		for _, x := range Events {
+			fmt.Printf("%d: %s Executed: %t.\n", x.ID, x.EventToString(), x.Executed)
+		}
+		return nil
+	case "add":
+		if len(args) != 7 {
+			return errCLIUsage
+		}
+
+		id, err := AddEvent(args[1], args[2], args[3], args[4], args[5], args[6])
+		if err != nil {
+			return err
+		}
+
+		err = SaveEvents()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Event %d added.\n", id)
+		return nil
+	case "remove":
+		if len(args) != 2 {
+			return errCLIUsage
+		}
+
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return errCLIUsage
+		}
+
+		if !RemoveEvent(id) {
+			return fmt.Errorf(ErrCLIEventNotFound, id)
+		}
+
+		err = SaveEvents()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Event %d removed.\n", id)
+		return nil
+	}
+	return errCLIUsage
+}
+
+func runConfigCommand(args []string) error {
+	if len(args) != 1 || args[0] != "validate" {
+		return errCLIUsage
+	}
+
+	cfg, err := ReadConfig()
+	if err != nil {
+		return err
+	}
+
+	bot.exchange.SetDefaults()
+	warnings, err := cfg.CheckConfigValues()
+	if err != nil {
+		return err
+	}
+
+	for _, x := range warnings {
+		// non fatal events
+		fmt.Fprintln(os.Stderr, x)
+	}
+
+	fmt.Printf("%s is valid.\n", ConfigFile)
+	return nil
+}
+
+// ParseCLITime parses an RFC3339 time, or a duration which is taken as that
+// long before now.
+func ParseCLITime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf(ErrCLIInvalidTime, value)
+	}
+	return time.Now().Add(-duration), nil
+}
+
+// OpenCLIStorage opens the configured storage database, which must already
+// exist when opened read only.
+func OpenCLIStorage(readOnly bool) (*Storage, error) {
+	path := bot.config.Storage.Path
+	if path == "" {
+		path = STORAGE_DEFAULT_PATH
+	}
+	return OpenStorage(path, readOnly)
+}
+
+func ParseCLIInterval(value string) (time.Duration, error) {
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf(ErrCLIInvalidInterval, value)
+	}
+	return interval, nil
+}
+
+func runHistoryCommand(args []string) error {
+	if len(args) < 3 || len(args) > 6 {
+		return errCLIUsage
+	}
+
+	end := time.Now()
+	start := end.Add(-CLI_DEFAULT_HISTORY)
+	interval := CLI_DEFAULT_CANDLE_PERIOD
+	var err error
+	if len(args) > 3 {
+		start, err = ParseCLITime(args[3])
+		if err != nil {
+			return err
+		}
+	}
+	if len(args) > 4 {
+		end, err = ParseCLITime(args[4])
+		if err != nil {
+			return err
+		}
+	}
+	if len(args) > 5 {
+		interval, err = ParseCLIInterval(args[5])
+		if err != nil {
+			return err
+		}
+	}
+
+	_, exch, err := GetCLIExchange(args[1])
+	if err != nil {
+		return err
+	}
+	pair := ParseCLICurrencyPair(exch, args[2])
+
+	storage, err := OpenCLIStorage(true)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	var result interface{}
+	switch args[0] {
+	case "tickers":
+		result, err = storage.GetTickers(exch.Name, pair, start, end)
+	case "trades":
+		result, err = storage.GetTrades(exch.Name, pair, start, end)
+	case "orderbooks":
+		result, err = storage.GetOrderbooks(exch.Name, pair, start, end)
+	case "candles":
+		result, err = storage.GetCandles(exch.Name, pair, interval, start, end)
+	default:
+		return errCLIUsage
+	}
+
+	if err != nil {
+		return err
+	}
+	return PrintJSON(result)
+}
+
+func runDownloadCommand(args []string) error {
+	if len(args) < 3 || len(args) > 5 {
+		return errCLIUsage
+	}
+
+	start, err := ParseCLITime(args[2])
+	if err != nil {
+		return err
+	}
+
+	end := time.Now()
+	if len(args) > 3 {
+		end, err = ParseCLITime(args[3])
+		if err != nil {
+			return err
+		}
+	}
+
+	interval := CLI_DEFAULT_CANDLE_PERIOD
+	if len(args) > 4 {
+		interval, err = ParseCLIInterval(args[4])
+		if err != nil {
+			return err
+		}
+	}
+
+	exchange, exch, err := GetCLIExchange(args[0])
+	if err != nil {
+		return err
+	}
+
+	storage, err := OpenCLIStorage(false)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	downloader := Downloader{storage, exchange, ParseCLICurrencyPair(exch, args[1]), interval}
+	return downloader.Download(ctx, start, end)
+}
+
+func runImportCommand(args []string) error {
+	if len(args) < 3 || len(args) > 4 {
+		return errCLIUsage
+	}
+
+	start, err := ParseCLITime(args[2])
+	if err != nil {
+		return err
+	}
+
+	end := time.Now()
+	if len(args) > 3 {
+		end, err = ParseCLITime(args[3])
+		if err != nil {
+			return err
+		}
+	}
+
+	exchange, exch, err := GetCLIExchange(args[0])
+	if err != nil {
+		return err
+	}
+
+	// Paper accounts are loaded from the same database, so it is opened once
+	// as the bot's storage. RunCommand closes it.
+	bot.storage, err = OpenCLIStorage(false)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	downloader := Downloader{bot.storage, exchange, ParseCLICurrencyPair(exch, args[1]), 0}
+	return downloader.DownloadFills(ctx, start, end)
+}
+
+func runTaxCommand(args []string) error {
+	flags := flag.NewFlagSet("tax", flag.ContinueOnError)
+	method := flags.String("method", TAX_METHOD_FIFO, "cost basis method: FIFO, LIFO or AVERAGE")
+	fiat := flags.String("fiat", TAX_DEFAULT_FIAT, "currency to value trades in")
+	output := flags.String("output", "", "write the report to a .json or .csv file")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gocryptotrader tax [options] <start> [end]\n\nFills are valued at the time of the trade using stored candles, trades\nor tickers, which the download command can backfill. Fills before start\nare used to work out the cost basis.\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	args = flags.Args()
+	if len(args) < 1 || len(args) > 2 {
+		return errCLIUsage
+	}
+
+	start, err := ParseCLITime(args[0])
+	if err != nil {
+		return err
+	}
+
+	end := time.Now()
+	if len(args) > 1 {
+		end, err = ParseCLITime(args[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	err = LoadCLIConfig()
+	if err != nil {
+		return err
+	}
+
+	storage, err := OpenCLIStorage(true)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	calculator, err := NewTaxCalculator(storage, *method, *fiat)
+	if err != nil {
+		return err
+	}
+
+	fills, err := storage.GetFills(time.Unix(0, 0), end)
+	if err != nil {
+		return err
+	}
+
+	report, err := calculator.Calculate(fills, start, end)
+	if err != nil {
+		return err
+	}
+
+	if *output != "" {
+		err = report.Export(*output)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The disposal and fill lists only go to the output file.
+	report.Disposals, report.Fills = nil, nil
+	return PrintJSON(report)
+}
+
+// parseCLITimeRange parses optional start and end arguments, defaulting to
+// the last 24 hours.
+func parseCLITimeRange(args []string) (time.Time, time.Time, error) {
+	start, end := time.Now().Add(-CLI_DEFAULT_HISTORY), time.Now()
+	var err error
+	if len(args) > 0 {
+		start, err = ParseCLITime(args[0])
+		if err != nil {
+			return start, end, err
+		}
+	}
+
+	if len(args) > 1 {
+		end, err = ParseCLITime(args[1])
+	}
+	return start, end, err
+}
+
+func runFundingCommand(args []string) error {
+	if len(args) == 0 {
+		return errCLIUsage
+	}
+
+	switch args[0] {
+	case "import":
+		if len(args) < 3 || len(args) > 4 {
+			return errCLIUsage
+		}
+
+		start, end, err := parseCLITimeRange(args[2:])
+		if err != nil {
+			return err
+		}
+
+		exchange, _, err := GetCLIExchange(args[1])
+		if err != nil {
+			return err
+		}
+
+		bot.storage, err = OpenCLIStorage(false)
+		if err != nil {
+			return err
+		}
+
+		count, err := ImportFundingHistory(bot.storage, exchange, start, end)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported %d %s deposits and withdrawals.\n", count, exchange.GetName())
+		return nil
+	case "list":
+		if len(args) > 3 {
+			return errCLIUsage
+		}
+
+		start, end, err := parseCLITimeRange(args[1:])
+		if err != nil {
+			return err
+		}
+
+		err = LoadCLIConfig()
+		if err != nil {
+			return err
+		}
+
+		bot.storage, err = OpenCLIStorage(true)
+		if err != nil {
+			return err
+		}
+
+		movements, err := bot.storage.GetFundingMovements(start, end)
+		if err != nil {
+			return err
+		}
+		return PrintJSON(movements)
+	case "reconcile":
+		return runFundingReconcileCommand(args[1:])
+	}
+	return errCLIUsage
+}
+
+func runFundingReconcileCommand(args []string) error {
+	flags := flag.NewFlagSet("funding reconcile", flag.ContinueOnError)
+	window := flags.Duration("window", FUNDING_DEFAULT_MATCH_WINDOW, "longest time between a withdrawal and the matching deposit")
+	tolerance := flags.Float64("tolerance", FUNDING_DEFAULT_TOLERANCE, "percent a deposit may differ from the withdrawal less fees")
+	output := flags.String("output", "", "write the reconciliation to a .json or .csv file")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gocryptotrader funding reconcile [options] [start] [end]\n\nWithdrawals are matched with deposits to another exchange by transaction\nID, or else by currency, amount and time. Import every exchange's funding\nhistory first. Defaults to the last 24 hours.\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	args = flags.Args()
+	if len(args) > 2 {
+		return errCLIUsage
+	}
+
+	start, end, err := parseCLITimeRange(args)
+	if err != nil {
+		return err
+	}
+
+	err = LoadCLIConfig()
+	if err != nil {
+		return err
+	}
+
+	bot.storage, err = OpenCLIStorage(true)
+	if err != nil {
+		return err
+	}
+
+	movements, err := bot.storage.GetFundingMovements(start, end)
+	if err != nil {
+		return err
+	}
+
+	result := ReconcileFunding(movements, *window, *tolerance)
+	result.Start, result.End = start, end
+	if *output != "" {
+		err = result.Export(*output)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Only movements needing attention are printed.
+	records := []FundingRecord{}
+	for _, x := range result.Records {
+		if x.Flag == FUNDING_FLAG_PENDING || x.Flag == FUNDING_FLAG_UNMATCHED {
+			records = append(records, x)
+		}
+	}
+	result.Records = records
+	return PrintJSON(result)
+}
+
+func runWithdrawalCommand(args []string) error {
+	if len(args) == 0 {
+		return errCLIUsage
+	}
+
+	dryRun := false
+	if args[0] == "request" && len(args) > 1 && args[1] == "-dry-run" {
+		dryRun = true
+		args = append(args[:1], args[2:]...)
+	}
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+	case args[0] == "request" && len(args) == 5:
+	case args[0] == "reject" && (len(args) == 2 || len(args) == 3):
+	default:
+		return errCLIUsage
+	}
+
+	err := LoadCLIConfig()
+	if err != nil {
+		return err
+	}
+
+	// Approval needs the webserver, so its settings are checked first.
+	bot.config.CheckWebserverConfigValues()
+	err = bot.config.CheckWithdrawalConfigValues()
+	if err != nil {
+		return err
+	}
+
+	// A running bot holds the storage open, so the command goes through its
+	// API, where the admin login is recorded as the requester.
+	called, err := runWithdrawalAPICommand(args, dryRun)
+	if called || err != nil {
+		return err
+	}
+
+	bot.storage, err = OpenCLIStorage(false)
+	if err != nil {
+		return err
+	}
+
+	requester, err := getCLIRequester()
+	if err != nil {
+		return err
+	}
+
+	var withdrawal Withdrawal
+	switch args[0] {
+	case "list":
+		withdrawals, err := GetWithdrawals()
+		if err != nil {
+			return err
+		}
+		return PrintJSON(withdrawals)
+	case "request":
+		exch, err := GetExchangeConfig(args[1])
+		if err != nil {
+			return fmt.Errorf(ErrCLIUnknownExchange, args[1])
+		}
+
+		amount, err := strconv.ParseFloat(args[3], 64)
+		if err != nil {
+			return fmt.Errorf(ErrCLIInvalidNumber, args[3])
+		}
+
+		withdrawal, err = RequestWithdrawal(exch.Name, args[2], args[4], amount, requester, dryRun)
+		if err != nil {
+			return err
+		}
+	case "reject":
+		reason := ""
+		if len(args) > 2 {
+			reason = args[2]
+		}
+
+		withdrawal, err = RejectWithdrawal(args[1], requester, reason)
+		if err != nil {
+			return err
+		}
+	}
+	return PrintJSON(withdrawal)
+}
+
+// runWithdrawalAPICommand runs a withdrawal command through the running bot's
+// API. It returns false if the bot's webserver isn't enabled or listening.
+func runWithdrawalAPICommand(args []string, dryRun bool) (bool, error) {
+	var result interface{}
+	called := false
+	err := error(nil)
+	switch args[0] {
+	case "list":
+		withdrawals := []Withdrawal{}
+		called, err = callCLIAPI("GET", "withdrawals", nil, &withdrawals)
+		result = withdrawals
+	case "request":
+		amount, parseErr := strconv.ParseFloat(args[3], 64)
+		if parseErr != nil {
+			return false, fmt.Errorf(ErrCLIInvalidNumber, args[3])
+		}
+
+		request := APIWithdrawal{Exchange: args[1], Currency: args[2], Address: args[4], Amount: amount, DryRun: dryRun}
+		withdrawal := Withdrawal{}
+		called, err = callCLIAPI("POST", "withdrawals", request, &withdrawal)
+		result = withdrawal
+	case "reject":
+		request := APIWithdrawalRejection{}
+		if len(args) > 2 {
+			request.Reason = args[2]
+		}
+
+		withdrawal := Withdrawal{}
+		called, err = callCLIAPI("POST", "withdrawals/"+url.PathEscape(args[1])+"/reject", request, &withdrawal)
+		result = withdrawal
+	}
+
+	if !called || err != nil {
+		return called, err
+	}
+	return true, PrintJSON(result)
+}
+
+// callCLIAPI calls the running bot's API as the admin user and decodes the
+// response into result. It returns false without an error if the bot's
+// webserver isn't enabled or can't be connected to, so the request can't
+// have been made.
+func callCLIAPI(method, path string, request, result interface{}) (bool, error) {
+	cfg := bot.config.Webserver
+	if !cfg.Enabled {
+		return false, nil
+	}
+
+	body := []byte{}
+	if request != nil {
+		var err error
+		body, err = json.Marshal(request)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	r, err := http.NewRequest(method, "http://"+cfg.ListenAddress+API_PATH+path, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	r.SetBasicAuth(cfg.AdminUsername, cfg.AdminPassword)
+	r.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{}
+	resp, err := client.Do(r)
+	if err != nil {
+		if e, ok := err.(*url.Error); ok {
+			if op, ok := e.Err.(*net.OpError); ok && op.Op == "dial" {
+				return false, nil
+			}
+		}
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := APIError{}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) != nil || apiErr.Error == "" {
+			return true, fmt.Errorf(ErrCLIAPIStatus, resp.Status)
+		}
+		return true, errors.New(apiErr.Error)
+	}
+	return true, json.NewDecoder(resp.Body).Decode(result)
+}
+
+// getCLIRequester returns the OS user running the CLI, who is recorded as
+// requesting or rejecting withdrawals.
+func getCLIRequester() (string, error) {
+	current, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf(ErrCLIUnknownUser, err)
+	}
+	return current.Username, nil
+}
+
+func runWalletCommand(args []string) error {
+	switch {
+	case len(args) == 2 && args[0] == "list":
+	case len(args) == 6 && args[0] == "transfer":
+	case (len(args) == 3 || len(args) == 4) && args[0] == "create":
+	default:
+		return errCLIUsage
+	}
+
+	_, exch, err := GetCLIExchange(args[1])
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		wallets, err := GetWallets(exch.Name)
+		if err != nil {
+			return err
+		}
+		return PrintJSON(wallets)
+	case "transfer":
+		amount, err := strconv.ParseFloat(args[3], 64)
+		if err != nil {
+			return fmt.Errorf(ErrCLIInvalidNumber, args[3])
+		}
+		err = TransferBetweenWallets(exch.Name, args[2], amount, args[4], args[5])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Transferred %s %s from %s to %s.\n", args[3], StringToUpper(args[2]), args[4], args[5])
+		return nil
+	}
+
+	currency := ""
+	if len(args) > 3 {
+		currency = args[3]
+	}
+
+	id, err := CreateWallet(exch.Name, args[2], currency)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wallet created. ID: %s\n", id)
+	return nil
+}
+
+func runPortfolioCommand(args []string) error {
+	if len(args) != 0 {
+		return errCLIUsage
+	}
+
+	err := LoadCLIConfig()
+	if err != nil {
+		return err
+	}
+	return PrintJSON(GetPortfolio())
+}
+
+func runRebalanceCommand(args []string) error {
+	flags := flag.NewFlagSet("rebalance", flag.ContinueOnError)
+	execute := flags.Bool("execute", false, "request the proposed transfers as withdrawals")
+	dryRun := flags.Bool("dry-run", false, "record the withdrawals without sending them")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gocryptotrader rebalance [options]\n\nCompares balances with the Rebalance targets in the config and proposes\ntransfers between exchanges. Executed transfers go through the same\nwhitelist, limit and approval checks as any other withdrawal.\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	if len(flags.Args()) != 0 {
+		return errCLIUsage
+	}
+
+	err = LoadCLIConfig()
+	if err != nil {
+		return err
+	}
+
+	bot.config.CheckWebserverConfigValues()
+	bot.config.CheckWithdrawalConfigValues()
+	err = bot.config.CheckRebalanceConfigValues()
+	if _, ok := err.(ConfigErrors); ok {
+		return err
+	}
+
+	if bot.config.Withdrawals.Enabled {
+		bot.storage, err = OpenCLIStorage(false)
+		if err != nil {
+			return err
+		}
+	}
+
+	plan := GetRebalancePlan()
+	if *execute {
+		ExecuteRebalance(&plan, *dryRun)
+	}
+	return PrintJSON(plan)
+}
+
+func runIndicatorCommand(args []string) error {
+	if len(args) < 3 || len(args) > 5 {
+		return errCLIUsage
+	}
+
+	spec, err := ParseIndicatorSpec(args[2])
+	if err != nil {
+		return err
+	}
+
+	end := time.Now()
+	start := end.Add(-spec.Interval * INDICATOR_WARMUP_CANDLES)
+	if len(args) > 3 {
+		start, err = ParseCLITime(args[3])
+		if err != nil {
+			return err
+		}
+	}
+	if len(args) > 4 {
+		end, err = ParseCLITime(args[4])
+		if err != nil {
+			return err
+		}
+	}
+
+	_, exch, err := GetCLIExchange(args[0])
+	if err != nil {
+		return err
+	}
+	pair := ParseCLICurrencyPair(exch, args[1])
+
+	storage, err := OpenCLIStorage(true)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	candles, err := storage.GetCandles(exch.Name, pair, spec.Interval, start, end)
+	if err != nil {
+		return err
+	}
+
+	values, err := spec.Batch(candles)
+	if err != nil {
+		return err
+	}
+
+	type IndicatorValue struct {
+		Timestamp time.Time
+		Value     float64
+	}
+
+	result := []IndicatorValue{}
+	for i, x := range values {
+		if !math.IsNaN(x) {
+			result = append(result, IndicatorValue{candles[i].Timestamp, x})
+		}
+	}
+	return PrintJSON(result)
+}
+
+// ParseCLIKeyValues parses settings written as key=value,key=value.
+func ParseCLIKeyValues(value string) (map[string]string, error) {
+	result := make(map[string]string)
+	if value == "" {
+		return result, nil
+	}
+
+	for _, x := range SplitStrings(value, ",") {
+		kv := SplitStrings(x, "=")
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf(ErrCLIInvalidKeyValue, x)
+		}
+		result[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return result, nil
+}
+
+func runBacktestCommand(args []string) error {
+	flags := flag.NewFlagSet("backtest", flag.ContinueOnError)
+	interval := flags.Duration("interval", CLI_DEFAULT_CANDLE_PERIOD, "candle interval")
+	trades := flags.Bool("trades", false, "replay stored trades instead of candles")
+	fee := flags.Float64("fee", -1, "maker and taker fee in percent, defaults to the exchange fee")
+	makerFee := flags.Float64("maker", -1, "maker fee in percent")
+	takerFee := flags.Float64("taker", -1, "taker fee in percent")
+	slippage := flags.Float64("slippage", 0, "slippage on taker fills in percent")
+	latency := flags.Duration("latency", 0, "delay before orders and cancels take effect")
+	balance := flags.String("balance", "", "starting balances, e.g. USD=10000,BTC=0")
+	param := flags.String("param", "", "strategy parameters, e.g. fast=10,slow=30")
+	output := flags.String("output", "", "write the report to a .json or .csv file")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gocryptotrader backtest [options] <exchange> <pair> <strategy> <start> [end]\n\nStrategies: %s\n\nOptions:\n", JoinStrings(GetStrategyNames(), ", "))
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	args = flags.Args()
+	if len(args) < 4 || len(args) > 5 {
+		return errCLIUsage
+	}
+
+	if *interval <= 0 {
+		return fmt.Errorf(ErrCLIInvalidInterval, interval.String())
+	}
+
+	start, err := ParseCLITime(args[3])
+	if err != nil {
+		return err
+	}
+
+	end := time.Now()
+	if len(args) > 4 {
+		end, err = ParseCLITime(args[4])
+		if err != nil {
+			return err
+		}
+	}
+
+	exchange, exch, err := GetCLIExchange(args[0])
+	if err != nil {
+		return err
+	}
+	pair := ParseCLICurrencyPair(exch, args[1])
+
+	params, err := ParseCLIKeyValues(*param)
+	if err != nil {
+		return err
+	}
+
+	settings, err := ParseCLIKeyValues(*balance)
+	if err != nil {
+		return err
+	}
+
+	balances := make(map[string]float64)
+	for currency, x := range settings {
+		balances[StringToUpper(currency)], err = strconv.ParseFloat(x, 64)
+		if err != nil {
+			return fmt.Errorf(ErrCLIInvalidKeyValue, currency+"="+x)
+		}
+	}
+
+	if *fee >= 0 {
+		*makerFee, *takerFee = *fee, *fee
+	}
+	if *makerFee < 0 {
+		*makerFee = GetExchangeFee(exchange, pair, true)
+	}
+	if *takerFee < 0 {
+		*takerFee = GetExchangeFee(exchange, pair, false)
+	}
+
+	storage, err := OpenCLIStorage(true)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	backtest := Backtest{
+		Exchange: exch.Name,
+		Pair:     pair,
+		Strategy: args[2],
+		Params:   params,
+		Interval: *interval,
+		Trades:   *trades,
+		Start:    start,
+		End:      end,
+		MakerFee: *makerFee,
+		TakerFee: *takerFee,
+		Slippage: *slippage,
+		Latency:  *latency,
+		Balances: balances,
+	}
+
+	report, err := backtest.Run(storage)
+	if err != nil {
+		return err
+	}
+
+	if *output != "" {
+		err = report.Export(*output)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The full fill list and equity curve only go to the output file.
+	report.Fills, report.Equity = nil, nil
+	return PrintJSON(report)
+}
+
+func runExecuteCommand(args []string) error {
+	flags := flag.NewFlagSet("execute", flag.ContinueOnError)
+	algorithm := flags.String("algo", EXECUTION_TWAP, "algorithm: TWAP, VWAP or ICEBERG")
+	duration := flags.Duration("duration", time.Hour, "time to spread TWAP and VWAP orders over")
+	slices := flags.Int("slices", 0, "TWAP and VWAP child orders, defaults to one a minute")
+	limit := flags.Float64("limit", 0, "worst price to trade at, required for ICEBERG")
+	visible := flags.Float64("visible", 0, "ICEBERG visible size")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gocryptotrader execute [options] <exchange> <pair> <buy|sell> <amount>\n\nInterrupt to cancel the remaining amount.\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	args = flags.Args()
+	if len(args) != 4 {
+		return errCLIUsage
+	}
+
+	amount, err := strconv.ParseFloat(args[3], 64)
+	if err != nil {
+		return fmt.Errorf("Invalid amount %s.", args[3])
+	}
+
+	exchange, exch, err := GetCLIExchange(args[0])
+	if err != nil {
+		return err
+	}
+
+	err = openCLIPaperStorage(exch)
+	if err != nil {
+		return err
+	}
+
+	if bot.storage == nil && StringToUpper(*algorithm) == EXECUTION_VWAP {
+		bot.storage, err = OpenCLIStorage(true)
+		if err != nil {
+			return err
+		}
+	}
+
+	execution, err := NewExecution(exchange, ExecutionOrder{
+		Algorithm:   *algorithm,
+		Pair:        ParseCLICurrencyPair(exch, args[1]),
+		Side:        args[2],
+		Amount:      amount,
+		LimitPrice:  *limit,
+		Duration:    *duration,
+		Slices:      *slices,
+		VisibleSize: *visible,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Nothing else fills paper orders while the bot is not running.
+	if exch.PaperTrading {
+		paperCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go PaperTradingRoutine(paperCtx)
+	}
+
+	execution.Run(ctx)
+	return PrintJSON(execution.Status())
+}
+
+func runConditionalCommand(args []string) error {
+	if len(args) == 0 {
+		return errCLIUsage
+	}
+
+	err := LoadCLIConfig()
+	if err != nil {
+		return err
+	}
+
+	bot.storage, err = OpenCLIStorage(args[0] == "list")
+	if err != nil {
+		return err
+	}
+
+	_, err = LoadConditionalOrders()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		if len(args) != 1 {
+			return errCLIUsage
+		}
+		return PrintJSON(GetConditionalOrders())
+	case "add", "oco":
+		if len(args) < 7 || len(args) > 8 || args[0] == "oco" && len(args) != 7 {
+			return errCLIUsage
+		}
+
+		exch, err := GetExchangeConfig(args[1])
+		if err != nil {
+			return fmt.Errorf(ErrCLIUnknownExchange, args[1])
+		}
+
+		// Every argument after the side is a number except add's type.
+		numbers := make(map[int]float64)
+		for i := 4; i < len(args); i++ {
+			if i == 5 && args[0] == "add" {
+				continue
+			}
+			numbers[i], err = strconv.ParseFloat(args[i], 64)
+			if err != nil {
+				return fmt.Errorf(ErrCLIInvalidNumber, args[i])
+			}
+		}
+
+		order := ConditionalOrder{
+			Exchange: exch.Name,
+			Pair:     ParseCLICurrencyPair(exch, args[2]),
+			Side:     args[3],
+			Amount:   numbers[4],
+		}
+
+		if args[0] == "oco" {
+			takeProfit, stop := order, order
+			takeProfit.Type, takeProfit.TriggerPrice = CONDITIONAL_TAKE_PROFIT, numbers[5]
+			stop.Type, stop.TriggerPrice = CONDITIONAL_STOP, numbers[6]
+			first, second, err := AddOCOOrders(takeProfit, stop)
+			if err != nil {
+				return err
+			}
+			return PrintJSON([]ConditionalOrder{first, second})
+		}
+
+		order.Type = StringToUpper(args[5])
+		if order.Type == CONDITIONAL_TRAILING_STOP {
+			order.TrailingPercent = numbers[6]
+		} else {
+			order.TriggerPrice = numbers[6]
+		}
+		order.LimitPrice = numbers[7]
+
+		order, err = AddConditionalOrder(order)
+		if err != nil {
+			return err
+		}
+		return PrintJSON(order)
+	case "cancel":
+		if len(args) != 2 {
+			return errCLIUsage
+		}
+
+		err = CancelConditionalOrder(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Conditional order %s cancelled.\n", args[1])
+		return nil
+	}
+	return errCLIUsage
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/url"
+	"sort"
 	"strconv"
 	"time"
 )
@@ -27,6 +30,12 @@ const (
 	COINBASE_FILLS       = "fills"
 	COINBASE_TRANSFERS   = "transfers"
 	COINBASE_REPORTS     = "reports"
+	COINBASE_WALLETS     = "coinbase-accounts"
+	COINBASE_MAX_CANDLES = 300
+	COINBASE_MAX_FILLS   = 100
+	// COINBASE_WALLET_EXCHANGE is the ID ListWallets gives the exchange
+	// accounts, as opposed to the Coinbase wallets funds are moved to and from.
+	COINBASE_WALLET_EXCHANGE = "exchange"
 )
 
 type Coinbase struct {
@@ -128,7 +137,7 @@ func (c *Coinbase) SetDefaults() {
 	c.MakerFee = 0
 	c.Verbose = false
 	c.Websocket = false
-	c.RESTPollingDelay = 10
+	c.RESTPollingDelay = 10 * time.Second
 }
 
 func (c *Coinbase) GetName() string {
@@ -143,6 +152,26 @@ func (c *Coinbase) IsEnabled() bool {
 	return c.Enabled
 }
 
+func (c *Coinbase) Setup(exch Exchanges) {
+	if !exch.Enabled {
+		c.SetEnabled(false)
+	} else {
+		c.SetEnabled(true)
+		c.AuthenticatedAPISupport = exch.AuthenticatedAPISupport
+		c.SetAPIKeys(exch.ClientID, exch.APIKey, exch.APISecret)
+		c.RESTPollingDelay = exch.RESTPollingDelay.Duration
+		c.Verbose = exch.Verbose
+		c.Websocket = exch.Websocket
+		c.BaseCurrencies = exch.BaseCurrencies
+		c.AvailablePairs = CurrencyPairsToStrings(exch.AvailablePairs)
+		c.EnabledPairs = CurrencyPairsToStrings(exch.EnabledPairs)
+	}
+}
+
+func (c *Coinbase) GetRequiredCredentials() []string {
+	return []string{CREDENTIAL_API_KEY, CREDENTIAL_API_SECRET, CREDENTIAL_CLIENT_ID}
+}
+
 func (c *Coinbase) GetFee(maker bool) float64 {
 	if maker {
 		return c.MakerFee
@@ -151,15 +180,15 @@ func (c *Coinbase) GetFee(maker bool) float64 {
 	}
 }
 
-func (c *Coinbase) Run() {
+func (c *Coinbase) Run(ctx context.Context) {
 	if c.Verbose {
 		log.Printf("%s Websocket: %s. (url: %s).\n", c.GetName(), IsEnabled(c.Websocket), COINBASE_WEBSOCKET_URL)
-		log.Printf("%s polling delay: %ds.\n", c.GetName(), c.RESTPollingDelay)
+		log.Printf("%s polling delay: %s.\n", c.GetName(), c.RESTPollingDelay)
 		log.Printf("%s %d currencies enabled: %s.\n", c.GetName(), len(c.EnabledPairs), c.EnabledPairs)
 	}
 
 	if c.Websocket {
-		go c.WebsocketClient()
+		StartRoutine(func() { c.WebsocketClient(ctx) })
 	}
 
 	exchangeProducts, err := c.GetProducts()
@@ -179,13 +208,13 @@ func (c *Coinbase) Run() {
 				log.Println(err)
 			} else {
 				log.Printf("%s Updating available pairs. Difference: %s.\n", c.Name, diff)
-				exch.AvailablePairs = JoinStrings(currencies, ",")
+				exch.AvailablePairs = ParseCurrencyPairs(currencies, exch.BaseCurrencies)
 				UpdateExchangeConfig(exch)
 			}
 		}
 	}
 
-	for c.Enabled {
+	for c.Enabled && ctx.Err() == nil {
 		for _, x := range c.EnabledPairs {
 			currency := x[0:3] + "-" + x[3:]
 			go func() {
@@ -205,7 +234,7 @@ func (c *Coinbase) Run() {
 				AddExchangeInfo(c.GetName(), currency[0:3], currency[4:], ticker.Price, stats.Volume)
 			}()
 		}
-		time.Sleep(time.Second * c.RESTPollingDelay)
+		SleepContext(ctx, c.RESTPollingDelay)
 	}
 }
 
@@ -350,16 +379,19 @@ func (c *Coinbase) GetTrades(symbol string) ([]CoinbaseTrade, error) {
 	return trades, nil
 }
 
+// GetHistoricRates returns candles between the start and end unix times. The
+// API sends each candle as an array of time, low, high, open, close, volume,
+// newest first, and at most COINBASE_MAX_CANDLES per request.
 func (c *Coinbase) GetHistoricRates(symbol string, start, end, granularity int64) ([]CoinbaseHistory, error) {
-	history := []CoinbaseHistory{}
+	resp := [][]float64{}
 	values := url.Values{}
 
 	if start > 0 {
-		values.Set("start", strconv.FormatInt(start, 10))
+		values.Set("start", time.Unix(start, 0).UTC().Format(time.RFC3339))
 	}
 
 	if end > 0 {
-		values.Set("end", strconv.FormatInt(end, 10))
+		values.Set("end", time.Unix(end, 0).UTC().Format(time.RFC3339))
 	}
 
 	if granularity > 0 {
@@ -367,11 +399,19 @@ func (c *Coinbase) GetHistoricRates(symbol string, start, end, granularity int64
 	}
 
 	path := EncodeURLValues(fmt.Sprintf("%s/%s/%s", COINBASE_API_URL+COINBASE_PRODUCTS, symbol, COINBASE_HISTORY), values)
-	err := SendHTTPGetRequest(path, true, &history)
+	err := SendHTTPGetRequest(path, true, &resp)
 
 	if err != nil {
 		return nil, err
 	}
+
+	history := []CoinbaseHistory{}
+	for _, x := range resp {
+		if len(x) < 6 {
+			continue
+		}
+		history = append(history, CoinbaseHistory{int64(x[0]), x[1], x[2], x[3], x[4], x[5]})
+	}
 	return history, nil
 }
 
@@ -425,12 +465,18 @@ func (c *Coinbase) GetAccount(account string) (CoinbaseAccountResponse, error) {
 }
 
 type CoinbaseAccountLedgerResponse struct {
-	ID        string      `json:"id"`
-	CreatedAt string      `json:"created_at"`
-	Amount    float64     `json:"amount,string"`
-	Balance   float64     `json:"balance,string"`
-	Type      string      `json:"type"`
-	details   interface{} `json:"details"`
+	ID        string  `json:"id"`
+	CreatedAt string  `json:"created_at"`
+	Amount    float64 `json:"amount,string"`
+	Balance   float64 `json:"balance,string"`
+	Type      string  `json:"type"`
+	Details   struct {
+		OrderID      string `json:"order_id"`
+		TradeID      string `json:"trade_id"`
+		ProductID    string `json:"product_id"`
+		TransferID   string `json:"transfer_id"`
+		TransferType string `json:"transfer_type"`
+	} `json:"details"`
 }
 
 func (c *Coinbase) GetAccountHistory(accountID string) ([]CoinbaseAccountLedgerResponse, error) {
@@ -443,6 +489,51 @@ func (c *Coinbase) GetAccountHistory(accountID string) ([]CoinbaseAccountLedgerR
 	return resp, nil
 }
 
+// GetFundingHistory returns the transfers in and out of every account's
+// ledger. Only settled transfers are entered in the ledger, and only its most
+// recent page is read, so import regularly to keep a full history.
+func (c *Coinbase) GetFundingHistory(start, end time.Time) ([]FundingMovement, error) {
+	accounts, err := c.GetAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []FundingMovement{}
+	for _, account := range accounts {
+		entries, err := c.GetAccountHistory(account.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, x := range entries {
+			timestamp, err := time.Parse(time.RFC3339Nano, x.CreatedAt)
+			if err != nil || x.Type != "transfer" || timestamp.Before(start) || timestamp.After(end) {
+				continue
+			}
+
+			movementType := FUNDING_DEPOSIT
+			if x.Details.TransferType == "withdraw" || x.Amount < 0 {
+				movementType = FUNDING_WITHDRAWAL
+			}
+
+			id := x.Details.TransferID
+			if id == "" {
+				id = x.ID
+			}
+			result = append(result, FundingMovement{
+				Exchange:  c.GetName(),
+				ID:        id,
+				Type:      movementType,
+				Status:    FUNDING_STATUS_COMPLETE,
+				Timestamp: timestamp,
+				Currency:  StringToUpper(account.Currency),
+				Amount:    math.Abs(x.Amount),
+			})
+		}
+	}
+	return result, nil
+}
+
 type CoinbaseAccountHolds struct {
 	ID        string  `json:"id"`
 	AccountID string  `json:"account_id"`
@@ -524,6 +615,112 @@ func (c *Coinbase) GetOrders(params url.Values) ([]CoinbaseOrdersResponse, error
 	return resp, nil
 }
 
+func (c *Coinbase) GetTickerPrice(pair CurrencyPair) (TickerPrice, error) {
+	productID := c.GetProductID(pair)
+	ticker, err := c.GetTicker(productID)
+	if err != nil {
+		return TickerPrice{}, err
+	}
+
+	stats, err := c.GetStats(productID)
+	if err != nil {
+		return TickerPrice{}, err
+	}
+	return TickerPrice{CryptoCurrency: pair.Base, FiatCurrency: pair.Quote, Last: ticker.Price, High: stats.High, Low: stats.Low, Volume: stats.Volume}, nil
+}
+
+func (c *Coinbase) GetOrderbookDepth(pair CurrencyPair) (Orderbook, error) {
+	response, err := c.GetOrderbook(c.GetProductID(pair), 2)
+	if err != nil {
+		return Orderbook{}, err
+	}
+
+	orderbook := response.(CoinbaseOrderbookL1L2)
+	result := Orderbook{Pair: pair}
+	for _, x := range orderbook.Bids {
+		result.Bids = append(result.Bids, OrderbookItem{x[0].Price, x[0].Amount})
+	}
+	for _, x := range orderbook.Asks {
+		result.Asks = append(result.Asks, OrderbookItem{x[0].Price, x[0].Amount})
+	}
+	return result, nil
+}
+
+func (c *Coinbase) GetAccountBalances() ([]AccountBalance, error) {
+	accounts, err := c.GetAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []AccountBalance{}
+	for _, x := range accounts {
+		result = append(result, AccountBalance{x.Currency, x.Balance, x.Available, x.Hold})
+	}
+	return result, nil
+}
+
+func (c *Coinbase) GetOpenOrderDetails() ([]OrderDetail, error) {
+	orders, err := c.GetOrders(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []OrderDetail{}
+	for _, x := range orders {
+		pair := SplitStrings(x.ProductID, "-")
+		if len(pair) != 2 {
+			continue
+		}
+		result = append(result, OrderDetail{
+			ID:     x.ID,
+			Pair:   NewCurrencyPair(pair[0], pair[1]),
+			Side:   StringToUpper(x.Side),
+			Price:  x.Price,
+			Amount: x.Size,
+			Filled: x.FilledSize,
+			Status: x.Status,
+		})
+	}
+	return result, nil
+}
+
+func (c *Coinbase) SubmitOrder(pair CurrencyPair, side string, amount, price float64) (string, error) {
+	return c.PlaceOrder("", price, amount, StringToLower(side), c.GetProductID(pair), "")
+}
+
+func (c *Coinbase) CancelOrderByID(pair CurrencyPair, orderID string) error {
+	return c.CancelOrder(orderID)
+}
+
+// GetHistoricCandles requests at most COINBASE_MAX_CANDLES from start, as the
+// API rejects larger ranges. Granularity is given in seconds.
+func (c *Coinbase) GetHistoricCandles(pair CurrencyPair, start, end time.Time, interval time.Duration) ([]Candle, error) {
+	granularity := int64(interval / time.Second)
+	if granularity <= 0 || interval%time.Second != 0 {
+		return nil, NewCandleIntervalError(c.GetName(), interval)
+	}
+
+	if limit := start.Add(interval * (COINBASE_MAX_CANDLES - 1)); limit.Before(end) {
+		end = limit
+	}
+
+	history, err := c.GetHistoricRates(c.GetProductID(pair), start.Unix(), end.Unix(), granularity)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Candle{}
+	for _, x := range history {
+		result = append(result, Candle{time.Unix(x.Time, 0), x.Open, x.High, x.Low, x.Close, x.Volume})
+	}
+	SortCandles(result)
+	return result, nil
+}
+
+func (c *Coinbase) GetProductID(pair CurrencyPair) string {
+	return pair.Base + "-" + pair.Quote
+}
+
 type CoinbaseOrderResponse struct {
 	ID         string  `json:"id"`
 	Size       float64 `json:"size,string"`
@@ -572,6 +769,57 @@ func (c *Coinbase) GetFills(params url.Values) ([]CoinbaseFillResponse, error) {
 	return resp, nil
 }
 
+// GetAccountFills returns the fills of pair within [start, end). Fills are
+// listed newest first, so pages are requested with decreasing trade IDs until
+// one reaches back past start. Fees are charged in the quote currency.
+func (c *Coinbase) GetAccountFills(pair CurrencyPair, start, end time.Time) ([]AccountFill, error) {
+	result := []AccountFill{}
+	params := url.Values{}
+	params.Set("product_id", c.GetProductID(pair))
+	params.Set("limit", strconv.Itoa(COINBASE_MAX_FILLS))
+	for {
+		fills, err := c.GetFills(params)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, x := range fills {
+			timestamp, err := time.Parse(time.RFC3339Nano, x.CreatedAt)
+			if err != nil || timestamp.Before(start) || !timestamp.Before(end) {
+				continue
+			}
+			result = append(result, AccountFill{
+				Exchange:    c.GetName(),
+				ID:          strconv.Itoa(x.TradeID),
+				OrderID:     x.OrderID,
+				Timestamp:   timestamp,
+				Pair:        pair,
+				Side:        StringToUpper(x.Side),
+				Price:       x.Price,
+				Amount:      x.Size,
+				Fee:         x.Fee,
+				FeeCurrency: pair.Quote,
+			})
+		}
+
+		if len(fills) < COINBASE_MAX_FILLS {
+			break
+		}
+
+		last := fills[len(fills)-1]
+		timestamp, err := time.Parse(time.RFC3339Nano, last.CreatedAt)
+		if err == nil && timestamp.Before(start) {
+			break
+		}
+		params.Set("after", strconv.Itoa(last.TradeID))
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+	return result, nil
+}
+
 func (c *Coinbase) Transfer(transferType string, amount float64, accountID string) error {
 	request := make(map[string]interface{})
 	request["type"] = transferType
@@ -585,6 +833,82 @@ func (c *Coinbase) Transfer(transferType string, amount float64, accountID strin
 	return nil
 }
 
+type CoinbaseWalletResponse struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	Balance  float64 `json:"balance,string"`
+	Currency string  `json:"currency"`
+	Type     string  `json:"type"`
+	Primary  bool    `json:"primary"`
+	Active   bool    `json:"active"`
+}
+
+// GetCoinbaseWallets returns the wallets of the linked Coinbase account.
+func (c *Coinbase) GetCoinbaseWallets() ([]CoinbaseWalletResponse, error) {
+	resp := []CoinbaseWalletResponse{}
+	err := c.SendAuthenticatedHTTPRequest("GET", COINBASE_API_URL+COINBASE_WALLETS, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ListWallets returns the exchange accounts as one wallet, followed by the
+// wallets of the linked Coinbase account.
+func (c *Coinbase) ListWallets() ([]Wallet, error) {
+	balances, err := c.GetAccountBalances()
+	if err != nil {
+		return nil, err
+	}
+
+	wallets, err := c.GetCoinbaseWallets()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Wallet{{Exchange: c.GetName(), ID: COINBASE_WALLET_EXCHANGE, Name: COINBASE_WALLET_EXCHANGE, Balances: balances}}
+	for _, x := range wallets {
+		result = append(result, Wallet{
+			Exchange: c.GetName(),
+			ID:       x.ID,
+			Name:     x.Name,
+			Balances: []AccountBalance{{x.Currency, x.Balance, x.Balance, 0}},
+		})
+	}
+	return result, nil
+}
+
+// TransferBetweenWallets moves funds between the exchange accounts and a
+// Coinbase wallet of the same currency.
+func (c *Coinbase) TransferBetweenWallets(currency string, amount float64, from, to string) error {
+	transferType, walletID := "", ""
+	switch {
+	case from == COINBASE_WALLET_EXCHANGE && to != COINBASE_WALLET_EXCHANGE:
+		transferType, walletID = "withdraw", to
+	case from != COINBASE_WALLET_EXCHANGE && to == COINBASE_WALLET_EXCHANGE:
+		transferType, walletID = "deposit", from
+	default:
+		return fmt.Errorf(ErrWalletTransferUnsupported, c.GetName(), COINBASE_WALLET_EXCHANGE)
+	}
+
+	wallets, err := c.GetCoinbaseWallets()
+	if err != nil {
+		return err
+	}
+
+	for _, x := range wallets {
+		if x.ID != walletID {
+			continue
+		}
+
+		if x.Currency != currency {
+			return fmt.Errorf(ErrWalletCurrencyMismatch, c.GetName(), walletID, x.Currency, currency)
+		}
+		return c.Transfer(transferType, amount, walletID)
+	}
+	return fmt.Errorf(ErrWalletNotFound, c.GetName(), walletID)
+}
+
 type CoinbaseReportResponse struct {
 	ID          string `json:"id"`
 	Type        string `json:"type"`
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"github.com/gorilla/websocket"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 const (
@@ -49,6 +52,7 @@ type CoinbaseWebsocketDone struct {
 type CoinbaseWebsocketMatch struct {
 	Type         string  `json:"type"`
 	TradeID      int     `json:"trade_id"`
+	ProductID    string  `json:"product_id"`
 	Sequence     int     `json:"sequence"`
 	MakerOrderID string  `json:"maker_order_id"`
 	TakerOrderID string  `json:"taker_order_id"`
@@ -84,8 +88,25 @@ func (c *Coinbase) WebsocketSubscribe(product string, conn *websocket.Conn) erro
 	return nil
 }
 
-func (c *Coinbase) WebsocketClient() {
-	for c.Enabled && c.Websocket {
+func (c *Coinbase) ProcessWebsocketMatch(match CoinbaseWebsocketMatch) {
+	pair := SplitStrings(match.ProductID, "-")
+	if len(pair) != 2 {
+		return
+	}
+
+	timestamp, err := time.Parse(time.RFC3339Nano, match.Time)
+	if err != nil {
+		timestamp = time.Now()
+	}
+
+	trade := TradeRecord{timestamp, strconv.Itoa(match.TradeID), match.Price, match.Size, StringToUpper(match.Side)}
+	ProcessTrades(c.GetName(), NewCurrencyPair(pair[0], pair[1]), []TradeRecord{trade})
+}
+
+func (c *Coinbase) WebsocketClient(ctx context.Context) {
+	defer SetWebsocketConnected(c.GetName(), false)
+
+	for c.Enabled && c.Websocket && ctx.Err() == nil {
 		var Dialer websocket.Dialer
 		conn, _, err := Dialer.Dial(COINBASE_WEBSOCKET_URL, http.Header{})
 
@@ -94,7 +115,10 @@ func (c *Coinbase) WebsocketClient() {
 			continue
 		}
 
+		stopClose := CloseOnDone(ctx, conn)
+
 		log.Printf("%s Connected to Websocket.\n", c.GetName())
+		SetWebsocketConnected(c.GetName(), true)
 
 		currencies := []string{}
 		for _, x := range c.EnabledPairs {
@@ -166,6 +190,7 @@ func (c *Coinbase) WebsocketClient() {
 						log.Println(err)
 						continue
 					}
+					c.ProcessWebsocketMatch(match)
 				case "change":
 					change := CoinbaseWebsocketChange{}
 					err := JSONDecode(resp, &change)
@@ -176,7 +201,9 @@ func (c *Coinbase) WebsocketClient() {
 				}
 			}
 		}
+		stopClose()
 		conn.Close()
 		log.Printf("%s Websocket client disconnected.", c.GetName())
+		SetWebsocketConnected(c.GetName(), false)
 	}
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/md5"
 	"crypto/sha1"
@@ -18,6 +19,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 const (
@@ -268,3 +270,34 @@ func EncodeURLValues(url string, values url.Values) string {
 	}
 	return path
 }
+
+// SleepContext sleeps for the given duration, returning early if ctx is
+// cancelled. It returns false if the sleep was cut short.
+func SleepContext(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// CloseOnDone closes c when ctx is cancelled, unblocking any pending reads on
+// it. Call the returned func once c is no longer in use.
+func CloseOnDone(ctx context.Context, c io.Closer) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	CONDITIONAL_STOP                     = "STOP"
+	CONDITIONAL_TAKE_PROFIT              = "TAKE_PROFIT"
+	CONDITIONAL_TRAILING_STOP            = "TRAILING_STOP"
+	CONDITIONAL_STATUS_PENDING           = "pending"
+	CONDITIONAL_STATUS_NATIVE            = "native"
+	CONDITIONAL_STATUS_TRIGGERED         = "triggered"
+	CONDITIONAL_STATUS_CANCELLED         = "cancelled"
+	CONDITIONAL_STATUS_FAILED            = "failed"
+	CONDITIONAL_MARKET_SLIPPAGE          = 1 // percent
+	ErrConditionalTypeInvalid            = "Invalid conditional order type %s. Use STOP, TAKE_PROFIT or TRAILING_STOP."
+	ErrConditionalAmountInvalid          = "Conditional order amount must be greater than zero."
+	ErrConditionalTriggerPriceInvalid    = "%s orders need a trigger price greater than zero."
+	ErrConditionalTrailingPercentInvalid = "Trailing stops need a trailing percent between 0 and 100."
+	ErrConditionalOrderNotFound          = "Conditional order %s not found."
+	ErrConditionalOrderNotActive         = "Conditional order %s is already %s."
+	ErrConditionalOCOMismatch            = "Both orders of an OCO pair must be on the same exchange and pair."
+)
+
+// ConditionalOrder places an order when the exchange's price reaches a
+// trigger. Prices come from the ticker and public trade streams.
+//
+// STOP orders sell when the price falls to TriggerPrice, or buy when it rises
+// to it. TAKE_PROFIT orders do the opposite. TRAILING_STOP orders are stops
+// whose TriggerPrice follows the best price seen at a distance of
+// TrailingPercent. The order placed is a limit order at LimitPrice, or a
+// marketable limit order CONDITIONAL_MARKET_SLIPPAGE through the trigger
+// price when LimitPrice is zero.
+//
+// Stops and trailing stops on exchanges with native stop orders are placed
+// on the exchange instead, unless the exchange is paper trading or the order
+// is half of an OCO pair. Their status stays native as the bot does not
+// follow them once placed.
+type ConditionalOrder struct {
+	ID              string
+	Exchange        string
+	Pair            CurrencyPair
+	Type            string
+	Side            string
+	Amount          float64
+	TriggerPrice    float64
+	TrailingPercent float64
+	LimitPrice      float64
+	OCO             string // order cancelled when this one triggers or is cancelled
+	Extreme         float64
+	Status          string
+	NativeID        string
+	OrderID         string
+	Error           string
+	Created         time.Time
+	Triggered       time.Time
+}
+
+var conditionalOrders = struct {
+	sync.Mutex
+	orders map[string]*ConditionalOrder
+	nextID int64
+}{orders: make(map[string]*ConditionalOrder)}
+
+type conditionalTrigger struct {
+	order ConditionalOrder
+	price float64
+}
+
+// conditionalTriggers queues triggered orders for ConditionalOrderRoutine to
+// place, so prices are processed without waiting on the exchange.
+var conditionalTriggers = struct {
+	sync.Mutex
+	pending []conditionalTrigger
+	ready   chan struct{}
+}{ready: make(chan struct{}, 1)}
+
+// IsActive reports whether the order is still waiting to trigger.
+func (o ConditionalOrder) IsActive() bool {
+	return o.Status == CONDITIONAL_STATUS_PENDING || o.Status == CONDITIONAL_STATUS_NATIVE
+}
+
+// update moves a trailing stop's trigger price along with price and reports
+// whether the order triggers at price, and whether it changed.
+func (o *ConditionalOrder) update(price float64) (bool, bool) {
+	changed := false
+	if o.Type == CONDITIONAL_TRAILING_STOP {
+		if o.Side == ORDER_SIDE_SELL && price > o.Extreme {
+			o.Extreme, o.TriggerPrice, changed = price, price*(1-o.TrailingPercent/100), true
+		} else if o.Side == ORDER_SIDE_BUY && (o.Extreme == 0 || price < o.Extreme) {
+			o.Extreme, o.TriggerPrice, changed = price, price*(1+o.TrailingPercent/100), true
+		}
+	}
+
+	// Stops sell as the price falls and buy as it rises, take profits the
+	// other way round.
+	if (o.Side == ORDER_SIDE_SELL) == (o.Type != CONDITIONAL_TAKE_PROFIT) {
+		return price <= o.TriggerPrice, changed
+	}
+	return price >= o.TriggerPrice, changed
+}
+
+// checkConditionalOrder validates an order and returns its exchange.
+func checkConditionalOrder(order *ConditionalOrder) (IBotExchange, error) {
+	exchange := bot.exchange.GetExchangeByName(order.Exchange)
+	if exchange == nil {
+		return nil, fmt.Errorf(ErrExchangeNotFound, order.Exchange)
+	}
+	order.Exchange = exchange.GetName()
+
+	order.Side = StringToUpper(order.Side)
+	err := IsValidOrderSide(order.Side)
+	if err != nil {
+		return nil, err
+	}
+
+	if order.Amount <= 0 {
+		return nil, errors.New(ErrConditionalAmountInvalid)
+	}
+
+	order.Type = StringToUpper(order.Type)
+	switch order.Type {
+	case CONDITIONAL_STOP, CONDITIONAL_TAKE_PROFIT:
+		if order.TriggerPrice <= 0 {
+			return nil, fmt.Errorf(ErrConditionalTriggerPriceInvalid, order.Type)
+		}
+	case CONDITIONAL_TRAILING_STOP:
+		if order.TrailingPercent <= 0 || order.TrailingPercent >= 100 {
+			return nil, errors.New(ErrConditionalTrailingPercentInvalid)
+		}
+		order.TriggerPrice, order.Extreme = 0, 0
+	default:
+		return nil, fmt.Errorf(ErrConditionalTypeInvalid, order.Type)
+	}
+	return exchange, nil
+}
+
+func newConditionalOrderID() string {
+	conditionalOrders.Lock()
+	defer conditionalOrders.Unlock()
+	conditionalOrders.nextID++
+	return strconv.FormatInt(conditionalOrders.nextID, 10)
+}
+
+// AddConditionalOrder validates an order and starts watching it, or places
+// it on the exchange if the exchange has native stop orders.
+func AddConditionalOrder(order ConditionalOrder) (ConditionalOrder, error) {
+	exchange, err := checkConditionalOrder(&order)
+	if err != nil {
+		return ConditionalOrder{}, err
+	}
+
+	order.OCO = ""
+	order.Status = CONDITIONAL_STATUS_PENDING
+	order.Created = time.Now()
+
+	stops, ok := exchange.(IStopOrderer)
+	if ok && order.Type != CONDITIONAL_TAKE_PROFIT && !IsPaperTrading(order.Exchange) {
+		order.NativeID, err = stops.SubmitStopOrder(order.Pair, order.Side, order.Amount, order.TriggerPrice, order.LimitPrice, order.TrailingPercent)
+		if err != nil {
+			return ConditionalOrder{}, err
+		}
+		order.Status = CONDITIONAL_STATUS_NATIVE
+	}
+
+	order.ID = newConditionalOrderID()
+	storeConditionalOrders(order)
+	log.Printf("%s: Conditional order %s added: %s.\n", order.Exchange, order.ID, order)
+	return order, nil
+}
+
+// AddOCOOrders watches a pair of orders on the same exchange and pair, where
+// either triggering or being cancelled cancels the other. Both are watched by
+// the bot even where the exchange has native stop orders.
+func AddOCOOrders(first, second ConditionalOrder) (ConditionalOrder, ConditionalOrder, error) {
+	orders := []*ConditionalOrder{&first, &second}
+	for _, x := range orders {
+		_, err := checkConditionalOrder(x)
+		if err != nil {
+			return ConditionalOrder{}, ConditionalOrder{}, err
+		}
+		x.Status = CONDITIONAL_STATUS_PENDING
+		x.Created = time.Now()
+	}
+
+	if first.Exchange != second.Exchange || first.Pair != second.Pair {
+		return ConditionalOrder{}, ConditionalOrder{}, errors.New(ErrConditionalOCOMismatch)
+	}
+
+	first.ID, second.ID = newConditionalOrderID(), newConditionalOrderID()
+	first.OCO, second.OCO = second.ID, first.ID
+	storeConditionalOrders(first, second)
+	log.Printf("%s: OCO orders %s and %s added: %s, %s.\n", first.Exchange, first.ID, second.ID, first, second)
+	return first, second, nil
+}
+
+func (o ConditionalOrder) String() string {
+	trigger := strconv.FormatFloat(o.TriggerPrice, 'f', -1, 64)
+	if o.Type == CONDITIONAL_TRAILING_STOP {
+		trigger = strconv.FormatFloat(o.TrailingPercent, 'f', -1, 64) + "%"
+	}
+
+	price := "market"
+	if o.LimitPrice > 0 {
+		price = strconv.FormatFloat(o.LimitPrice, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%s %s %s %f @ %s at %s", o.Type, o.Side, o.Pair, o.Amount, price, trigger)
+}
+
+// storeConditionalOrders adds or updates orders in memory and storage.
+func storeConditionalOrders(orders ...ConditionalOrder) {
+	conditionalOrders.Lock()
+	for _, x := range orders {
+		order := x
+		conditionalOrders.orders[x.ID] = &order
+	}
+	conditionalOrders.Unlock()
+	saveConditionalOrders(orders...)
+}
+
+func saveConditionalOrders(orders ...ConditionalOrder) {
+	if bot.storage == nil {
+		return
+	}
+
+	for _, x := range orders {
+		err := bot.storage.SetConditionalOrder(x)
+		if err != nil {
+			log.Printf("%s: Unable to save conditional order %s. Error: %s\n", x.Exchange, x.ID, err)
+		}
+	}
+}
+
+// LoadConditionalOrders restores saved conditional orders from storage and
+// returns how many are still active.
+func LoadConditionalOrders() (int, error) {
+	if bot.storage == nil {
+		return 0, nil
+	}
+
+	orders, err := bot.storage.GetConditionalOrders()
+	if err != nil {
+		return 0, err
+	}
+
+	conditionalOrders.Lock()
+	defer conditionalOrders.Unlock()
+
+	active := 0
+	for _, x := range orders {
+		order := x
+		conditionalOrders.orders[x.ID] = &order
+		id, err := strconv.ParseInt(x.ID, 10, 64)
+		if err == nil && id > conditionalOrders.nextID {
+			conditionalOrders.nextID = id
+		}
+		if x.IsActive() {
+			active++
+		}
+	}
+	return active, nil
+}
+
+// GetConditionalOrders returns every conditional order, active or not, in
+// the order they were added.
+func GetConditionalOrders() []ConditionalOrder {
+	conditionalOrders.Lock()
+	defer conditionalOrders.Unlock()
+
+	result := []ConditionalOrder{}
+	for _, x := range conditionalOrders.orders {
+		result = append(result, *x)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return simulatedOrderIDLess(result[i].ID, result[j].ID)
+	})
+	return result
+}
+
+// CancelConditionalOrder cancels an active order along with its OCO pair.
+func CancelConditionalOrder(id string) error {
+	conditionalOrders.Lock()
+	order, ok := conditionalOrders.orders[id]
+	if !ok {
+		conditionalOrders.Unlock()
+		return fmt.Errorf(ErrConditionalOrderNotFound, id)
+	}
+	if !order.IsActive() {
+		conditionalOrders.Unlock()
+		return fmt.Errorf(ErrConditionalOrderNotActive, id, order.Status)
+	}
+	snapshot := *order
+	conditionalOrders.Unlock()
+
+	if snapshot.Status == CONDITIONAL_STATUS_NATIVE {
+		exchange := bot.exchange.GetExchangeByName(snapshot.Exchange)
+		stops, ok := exchange.(IStopOrderer)
+		if !ok {
+			return NewExchangeFeatureError(exchange, "stop orders")
+		}
+
+		err := stops.CancelStopOrderByID(snapshot.Pair, snapshot.NativeID)
+		if err != nil {
+			return err
+		}
+	}
+
+	conditionalOrders.Lock()
+	cancelled := []ConditionalOrder{}
+	for _, x := range []string{id, snapshot.OCO} {
+		if sibling, ok := conditionalOrders.orders[x]; ok && sibling.IsActive() {
+			sibling.Status = CONDITIONAL_STATUS_CANCELLED
+			cancelled = append(cancelled, *sibling)
+		}
+	}
+	conditionalOrders.Unlock()
+
+	saveConditionalOrders(cancelled...)
+	log.Printf("%s: Conditional order %s cancelled.\n", snapshot.Exchange, id)
+	return nil
+}
+
+// ProcessConditionalPrice checks the pending orders of an exchange's pair
+// against its latest price, placing the orders of any that trigger.
+func ProcessConditionalPrice(exchange string, pair CurrencyPair, price float64) {
+	if price <= 0 {
+		return
+	}
+
+	conditionalOrders.Lock()
+	triggered := []ConditionalOrder{}
+	changed := []ConditionalOrder{}
+	for _, x := range conditionalOrders.orders {
+		if x.Status != CONDITIONAL_STATUS_PENDING || x.Exchange != exchange || x.Pair != pair {
+			continue
+		}
+
+		fire, moved := x.update(price)
+		if !fire {
+			if moved {
+				changed = append(changed, *x)
+			}
+			continue
+		}
+
+		x.Status = CONDITIONAL_STATUS_TRIGGERED
+		x.Triggered = time.Now()
+		triggered = append(triggered, *x)
+		if sibling, ok := conditionalOrders.orders[x.OCO]; ok && sibling.Status == CONDITIONAL_STATUS_PENDING {
+			sibling.Status = CONDITIONAL_STATUS_CANCELLED
+			changed = append(changed, *sibling)
+		}
+	}
+	conditionalOrders.Unlock()
+
+	// Triggered orders are saved before they are placed so that a restart in
+	// between can't trigger them again.
+	saveConditionalOrders(append(changed, triggered...)...)
+	if len(triggered) == 0 {
+		return
+	}
+
+	conditionalTriggers.Lock()
+	for _, x := range triggered {
+		conditionalTriggers.pending = append(conditionalTriggers.pending, conditionalTrigger{x, price})
+	}
+	conditionalTriggers.Unlock()
+
+	select {
+	case conditionalTriggers.ready <- struct{}{}:
+	default:
+	}
+}
+
+// ConditionalOrderRoutine places the orders of triggered conditional orders
+// until ctx is cancelled, placing any still queued before it returns.
+func ConditionalOrderRoutine(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			placeConditionalTriggers()
+			return
+		case <-conditionalTriggers.ready:
+			placeConditionalTriggers()
+		}
+	}
+}
+
+func placeConditionalTriggers() {
+	conditionalTriggers.Lock()
+	pending := conditionalTriggers.pending
+	conditionalTriggers.pending = nil
+	conditionalTriggers.Unlock()
+
+	for _, x := range pending {
+		placeConditionalOrder(x.order, x.price)
+	}
+}
+
+// ProcessConditionalTrades checks pending orders against live public trades
+// as they arrive from an exchange's websocket.
+func ProcessConditionalTrades(exchange string, pair CurrencyPair, trades []TradeRecord) {
+	for _, x := range trades {
+		ProcessConditionalPrice(exchange, pair, x.Price)
+	}
+}
+
+// placeConditionalOrder places the order of a triggered conditional order
+// through the exchange's order manager, so paper trading is respected.
+func placeConditionalOrder(order ConditionalOrder, price float64) {
+	limit := order.LimitPrice
+	if limit <= 0 {
+		limit = price * (1 + CONDITIONAL_MARKET_SLIPPAGE/100.0)
+		if order.Side == ORDER_SIDE_SELL {
+			limit = price * (1 - CONDITIONAL_MARKET_SLIPPAGE/100.0)
+		}
+	}
+
+	var orderID string
+	exchange := bot.exchange.GetExchangeByName(order.Exchange)
+	orders, err := GetOrderManager(exchange)
+	if err == nil {
+		orderID, err = orders.SubmitOrder(order.Pair, order.Side, order.Amount, limit)
+	}
+
+	conditionalOrders.Lock()
+	x := conditionalOrders.orders[order.ID]
+	if err != nil {
+		x.Status = CONDITIONAL_STATUS_FAILED
+		x.Error = err.Error()
+	} else {
+		x.OrderID = orderID
+	}
+	order = *x
+	conditionalOrders.Unlock()
+
+	saveConditionalOrders(order)
+	if err != nil {
+		log.Printf("%s: Conditional order %s triggered at %f but placing %s %f @ %f failed. Error: %s\n", order.Exchange, order.ID, price, order.Side, order.Amount, limit, err)
+		return
+	}
+	log.Printf("%s: Conditional order %s triggered at %f, placed order %s %s %f @ %f.\n", order.Exchange, order.ID, price, orderID, order.Side, order.Amount, limit)
+}
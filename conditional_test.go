@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+// setupConditionalTest paper trades Bitstamp with conditional orders saved to
+// a temporary storage.
+func setupConditionalTest(t *testing.T) {
+	t.Helper()
+	storage, err := OpenStorage(filepath.Join(t.TempDir(), "storage.db"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot.storage = storage
+
+	bot.exchange = Exchange{}
+	bot.exchange.SetDefaults()
+	pairs := []CurrencyPair{{Base: "BTC", Quote: "USD"}}
+	SetConfig(Config{Exchanges: []Exchanges{{Name: "Bitstamp", Enabled: true, PaperTrading: true, BaseCurrencies: []string{"USD"}, AvailablePairs: pairs, EnabledPairs: pairs, PaperBalances: map[string]float64{"USD": 1000, "BTC": 10}}}})
+	bot.exchange.GetExchangeByName("Bitstamp").Setup(GetConfig().Exchanges[0])
+
+	t.Cleanup(func() {
+		storage.Close()
+		bot.storage = nil
+		paperExchanges.exchanges = make(map[string]*PaperExchange)
+		conditionalOrders.orders = make(map[string]*ConditionalOrder)
+		conditionalTriggers.pending = nil
+		SetConfig(Config{})
+	})
+}
+
+func TestConditionalOrderTriggers(t *testing.T) {
+	tests := []struct {
+		name    string
+		order   ConditionalOrder
+		prices  []float64
+		fire    bool
+		trigger float64
+	}{
+		{"sell stop above", ConditionalOrder{Type: CONDITIONAL_STOP, Side: ORDER_SIDE_SELL, TriggerPrice: 550}, []float64{600}, false, 550},
+		{"sell stop at", ConditionalOrder{Type: CONDITIONAL_STOP, Side: ORDER_SIDE_SELL, TriggerPrice: 550}, []float64{550}, true, 550},
+		{"buy stop below", ConditionalOrder{Type: CONDITIONAL_STOP, Side: ORDER_SIDE_BUY, TriggerPrice: 550}, []float64{500}, false, 550},
+		{"buy stop above", ConditionalOrder{Type: CONDITIONAL_STOP, Side: ORDER_SIDE_BUY, TriggerPrice: 550}, []float64{560}, true, 550},
+		{"sell take profit above", ConditionalOrder{Type: CONDITIONAL_TAKE_PROFIT, Side: ORDER_SIDE_SELL, TriggerPrice: 700}, []float64{710}, true, 700},
+		{"sell take profit below", ConditionalOrder{Type: CONDITIONAL_TAKE_PROFIT, Side: ORDER_SIDE_SELL, TriggerPrice: 700}, []float64{690}, false, 700},
+		{"sell trailing stop follows the high", ConditionalOrder{Type: CONDITIONAL_TRAILING_STOP, Side: ORDER_SIDE_SELL, TrailingPercent: 10}, []float64{500, 600, 580}, false, 540},
+		{"sell trailing stop falls back", ConditionalOrder{Type: CONDITIONAL_TRAILING_STOP, Side: ORDER_SIDE_SELL, TrailingPercent: 10}, []float64{500, 600, 540}, true, 540},
+		{"buy trailing stop follows the low", ConditionalOrder{Type: CONDITIONAL_TRAILING_STOP, Side: ORDER_SIDE_BUY, TrailingPercent: 10}, []float64{500, 400, 430}, false, 440},
+	}
+
+	for _, x := range tests {
+		fire := false
+		for _, price := range x.prices {
+			fire, _ = x.order.update(price)
+		}
+		if fire != x.fire || math.Abs(x.order.TriggerPrice-x.trigger) > 1e-9 {
+			t.Errorf("%s: triggered %t at %f, want %t at %f", x.name, fire, x.order.TriggerPrice, x.fire, x.trigger)
+		}
+	}
+}
+
+func TestConditionalOCOOrders(t *testing.T) {
+	setupConditionalTest(t)
+	pair := NewCurrencyPair("BTC", "USD")
+	takeProfit := ConditionalOrder{Exchange: "Bitstamp", Pair: pair, Type: CONDITIONAL_TAKE_PROFIT, Side: ORDER_SIDE_SELL, Amount: 1, TriggerPrice: 700}
+	stop := ConditionalOrder{Exchange: "Bitstamp", Pair: pair, Type: CONDITIONAL_STOP, Side: ORDER_SIDE_SELL, Amount: 1, TriggerPrice: 550}
+	takeProfit, stop, err := AddOCOOrders(takeProfit, stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ProcessConditionalPrice("Bitstamp", pair, 600)
+	ProcessConditionalPrice("Bitstamp", NewCurrencyPair("LTC", "USD"), 500)
+	if orders := GetConditionalOrders(); orders[0].Status != CONDITIONAL_STATUS_PENDING || orders[1].Status != CONDITIONAL_STATUS_PENDING {
+		t.Fatalf("orders = %+v, want both pending", orders)
+	}
+
+	ProcessConditionalPrice("Bitstamp", pair, 540)
+
+	// The trigger is saved before the order is placed.
+	saved, err := bot.storage.GetConditionalOrders()
+	if err != nil || len(saved) != 2 {
+		t.Fatalf("saved orders = %+v %v", saved, err)
+	}
+	for _, x := range saved {
+		want := CONDITIONAL_STATUS_CANCELLED
+		if x.ID == stop.ID {
+			want = CONDITIONAL_STATUS_TRIGGERED
+		}
+		if x.Status != want || x.OrderID != "" {
+			t.Errorf("saved order %s = %s with order %q, want %s and not placed", x.ID, x.Status, x.OrderID, want)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ConditionalOrderRoutine(ctx)
+
+	orders := GetConditionalOrders()
+	if orders[1].ID != stop.ID || orders[1].OrderID == "" || orders[0].ID != takeProfit.ID || orders[0].OrderID != "" {
+		t.Fatalf("orders = %+v, want only the stop placed", orders)
+	}
+
+	paper, err := GetPaperExchange("Bitstamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	open, err := paper.GetOpenOrderDetails()
+	if err != nil || len(open) != 1 || open[0].ID != orders[1].OrderID || open[0].Side != ORDER_SIDE_SELL || open[0].Price != 540*(1-CONDITIONAL_MARKET_SLIPPAGE/100.0) {
+		t.Errorf("open paper orders = %+v %v, want a marketable sell", open, err)
+	}
+
+	err = CancelConditionalOrder(takeProfit.ID)
+	if err == nil {
+		t.Error("cancelling an order cancelled by its OCO pair succeeded")
+	}
+}
@@ -6,25 +6,65 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
+	"os"
+	"sync"
 	"time"
 )
 
 const (
-	CONFIG_FILE = "config.json"
+	CONFIG_FILE    = "config.json"
+	CONFIG_VERSION = 1
 )
 
+// ConfigFile is the path of the config file, set with the -config flag.
+var ConfigFile = CONFIG_FILE
+
+// configMutex guards bot.config once the bot is running, as the config can be
+// reloaded or updated by exchanges while other goroutines read it.
+var configMutex sync.RWMutex
+
 var (
-	ErrExchangeNameEmpty                            = "Exchange #%d in config: Exchange name is empty."
+	ErrExchangeNameEmpty                            = "Exchange name is empty."
+	ErrExchangeNameDuplicate                        = "Exchange %s is configured more than once."
 	ErrExchangeAvailablePairsEmpty                  = "Exchange %s: Available pairs is empty."
 	ErrExchangeEnabledPairsEmpty                    = "Exchange %s: Enabled pairs is empty."
+	ErrExchangeEnabledPairNotAvailable              = "Exchange %s: Enabled pair %s is not an available pair."
 	ErrExchangeBaseCurrenciesEmpty                  = "Exchange %s: Base currencies is empty."
-	WarningExchangeAuthAPIDefaultOrEmptyValues      = "WARNING -- Exchange %s: Authenticated API support disabled due to default/empty APIKey/Secret/ClientID values."
+	ErrExchangeCurrencyPairInvalid                  = "Exchange %s: Currency pair must have a base and quote currency."
+	ErrExchangeRESTPollingDelayInvalid              = "Exchange %s: REST polling delay must be greater than zero."
+	ErrExchangePaperBalanceInvalid                  = "Exchange %s: Paper balance of %s must not be negative."
+	WarningExchangeAuthAPIDefaultOrEmptyValues      = "WARNING -- Exchange %s: Authenticated API support disabled due to default/empty %s value."
 	ErrExchangeNotFound                             = "Exchange %s: Not found."
 	ErrNoEnabledExchanges                           = "No Exchanges enabled."
 	ErrCryptocurrenciesEmpty                        = "Cryptocurrencies variable is empty."
+	ErrConfigVersionUnsupported                     = "Config version %d is newer than the supported version %d."
+	ErrConfigVersionInvalid                         = "Config version %d is invalid."
 	WarningSMSGlobalDefaultOrEmptyValues            = "WARNING -- SMS Support disabled due to default or empty Username/Password values."
 	WarningSSMSGlobalSMSContactDefaultOrEmptyValues = "WARNING -- SMS contact #%d Name/Number disabled due to default or empty values."
 	WarningSSMSGlobalSMSNoContacts                  = "WARNING -- SMS Support disabled due to no enabled contacts."
+	WarningStorageDurationInvalid                   = "WARNING -- Storage support disabled due to negative %s value."
+	WarningCandleIntervalInvalid                    = "WARNING -- Candle interval %s ignored as it is not positive."
+	WarningCandleLateTradeWindowInvalid             = "WARNING -- Candle late trade window set to zero due to negative value."
+	WarningStrategyDisabled                         = "WARNING -- Strategy %s disabled: %s"
+	ErrStrategyNameDuplicate                        = "Name %s is used by more than one strategy."
+	ErrStrategyTimerIntervalInvalid                 = "Timer interval must not be negative."
+	ErrStrategyExchangeDisabled                     = "Exchange %s is not enabled."
+	WarningWebserverCredentialsDefaultOrEmpty       = "WARNING -- Webserver support disabled due to default or empty AdminUsername/AdminPassword values."
+	WarningWebserverListenAddressInvalid            = "WARNING -- Webserver support disabled due to invalid listen address %s."
+	WarningGRPCCredentialsDefaultOrEmpty            = "WARNING -- gRPC support disabled due to default or empty Username/Password values."
+	WarningGRPCListenAddressInvalid                 = "WARNING -- gRPC support disabled due to invalid listen address %s."
+	WarningFIXPasswordDefaultOrEmpty                = "WARNING -- FIX support disabled due to default or empty Password value."
+	WarningFIXCompIDEmpty                           = "WARNING -- FIX support disabled due to empty SenderCompID/TargetCompID values."
+	WarningFIXListenAddressInvalid                  = "WARNING -- FIX support disabled due to invalid listen address %s."
+	WarningFIXStorageDisabled                       = "WARNING -- FIX support disabled as storage support is needed to keep session state."
+	WarningWithdrawalsStorageDisabled               = "WARNING -- Withdrawals disabled as storage support is needed to keep the audit log."
+	WarningWithdrawalsApproversInvalid              = "WARNING -- Withdrawals disabled as RequireApproval needs the webserver enabled and at least one approver with a username and non-default password other than the admin's."
+	ErrWithdrawalLimitInvalid                       = "Daily limit must not be negative."
+	WarningRebalanceExecuteDisabled                 = "WARNING -- Rebalance transfers will only be proposed as withdrawals are disabled."
+	ErrRebalanceWeightInvalid                       = "Target weight must not be negative."
+	ErrRebalanceAmountInvalid                       = "Amount must not be negative."
+	ErrRebalanceToleranceInvalid                    = "Tolerance must not be negative."
 )
 
 type SMSGlobal struct {
@@ -38,11 +78,142 @@ type SMSGlobal struct {
 	}
 }
 
+// StorageConfig controls the on-disk history store. Retention values of zero
+// keep records forever. Ticker samples older than DownsampleAfter are thinned
+// to one per DownsampleInterval.
+type StorageConfig struct {
+	Enabled                   bool
+	Path                      string
+	OrderbookSnapshotInterval ConfigDuration
+	OrderbookDepth            int
+	TickerRetention           ConfigDuration
+	TradeRetention            ConfigDuration
+	OrderbookRetention        ConfigDuration
+	DownsampleAfter           ConfigDuration
+	DownsampleInterval        ConfigDuration
+	MaintenanceInterval       ConfigDuration
+}
+
+// CandleConfig controls building candles from live trade streams. Candles
+// are held open for LateTradeWindow after their interval ends so that trades
+// arriving late are still counted.
+type CandleConfig struct {
+	Enabled         bool
+	Intervals       []ConfigDuration
+	LateTradeWindow ConfigDuration
+}
+
+// StrategyConfig runs one strategy on one exchange pair. Name identifies the
+// running instance and defaults to the strategy's name. OnTimer is called
+// every TimerInterval, and OnBook only when Orderbook is set.
+type StrategyConfig struct {
+	Name          string
+	Strategy      string
+	Enabled       bool
+	Exchange      string
+	Pair          CurrencyPair
+	Params        map[string]string
+	TimerInterval ConfigDuration
+	Orderbook     bool
+}
+
+// WebserverConfig controls the web dashboard, which is served on
+// ListenAddress behind HTTP basic auth with the admin credentials.
+type WebserverConfig struct {
+	Enabled       bool
+	ListenAddress string
+	AdminUsername string
+	AdminPassword string
+}
+
+// GRPCConfig controls the gRPC server, which authenticates calls with
+// Username and Password sent as HTTP basic auth metadata.
+type GRPCConfig struct {
+	Enabled       bool
+	ListenAddress string
+	Username      string
+	Password      string
+}
+
+// FIXConfig controls the FIX 4.4 gateway. It accepts one session, from the
+// counterparty logging on as TargetCompID with Password.
+type FIXConfig struct {
+	Enabled       bool
+	ListenAddress string
+	SenderCompID  string
+	TargetCompID  string
+	Password      string
+}
+
+// WithdrawalApprover is a login for the control API which may only list,
+// approve and reject withdrawals.
+type WithdrawalApprover struct {
+	Username string
+	Password string
+}
+
+// WithdrawalConfig guards withdrawals. Addresses must be in the currency's
+// Whitelist and withdrawals in the last 24 hours are capped at the currency's
+// DailyLimits amount, so currencies without a limit can't be withdrawn. With
+// RequireApproval a withdrawal waits for one of Approvers, other than the
+// person who requested it, to approve it through the control API within
+// ApprovalTimeout. DryRun runs every check without sending withdrawals.
+type WithdrawalConfig struct {
+	Enabled         bool
+	DryRun          bool
+	RequireApproval bool
+	ApprovalTimeout ConfigDuration
+	Approvers       []WithdrawalApprover
+	Whitelist       map[string][]string
+	DailyLimits     map[string]float64
+}
+
+// RebalanceConfig keeps currencies spread across exchanges. Targets maps each
+// currency to the weight of each exchange, and the currency's total across
+// those exchanges is shared out in proportion. Transfers are proposed for
+// exchanges more than Tolerance percent of the total away from their target,
+// 5 when it isn't set.
+// A currency's NetworkFees amount is deducted from what each transfer
+// delivers, and transfers of less than its MinTransfers amount are skipped.
+// Once a currency has been sent, it isn't rebalanced again for Cooldown so
+// that funds in transit aren't sent twice. When Enabled the bot checks every
+// Interval and, with Execute set, requests the transfers as withdrawals.
+type RebalanceConfig struct {
+	Enabled      bool
+	Execute      bool
+	Interval     ConfigDuration
+	Tolerance    *float64
+	Cooldown     ConfigDuration
+	Targets      map[string]map[string]float64
+	NetworkFees  map[string]float64
+	MinTransfers map[string]float64
+}
+
+// GetTolerance returns Tolerance, or the default when it isn't set.
+func (r RebalanceConfig) GetTolerance() float64 {
+	if r.Tolerance == nil {
+		return REBALANCE_DEFAULT_TOLERANCE
+	}
+	return *r.Tolerance
+}
+
 type Config struct {
-	Name             string
-	Cryptocurrencies string
-	SMS              SMSGlobal `json:"SMSGlobal"`
-	Exchanges        []Exchanges
+	Version                int
+	EncryptConfig          bool
+	Name                   string
+	Cryptocurrencies       string
+	ShutdownTimeout        ConfigDuration
+	CancelOrdersOnShutdown bool
+	SMS                    SMSGlobal `json:"SMSGlobal"`
+	Storage                StorageConfig
+	Candles                CandleConfig
+	Strategies             []StrategyConfig
+	Webserver              WebserverConfig
+	GRPC                   GRPCConfig
+	FIX                    FIXConfig
+	Withdrawals            WithdrawalConfig
+	Rebalance              RebalanceConfig
+	Exchanges              []Exchanges
 }
 
 type Exchanges struct {
@@ -50,20 +221,86 @@ type Exchanges struct {
 	Enabled                 bool
 	Verbose                 bool
 	Websocket               bool
-	RESTPollingDelay        time.Duration
+	RESTPollingDelay        ConfigDuration
 	AuthenticatedAPISupport bool
+	PaperTrading            bool
+	PaperBalances           map[string]float64
 	APIKey                  string
 	APISecret               string
 	ClientID                string
-	AvailablePairs          string
-	EnabledPairs            string
-	BaseCurrencies          string
+	AvailablePairs          []CurrencyPair
+	EnabledPairs            []CurrencyPair
+	BaseCurrencies          []string
+}
+
+// ConfigDuration is a time.Duration stored in the config file as a string
+// such as "10s" or "1m30s".
+type ConfigDuration struct {
+	time.Duration
+}
+
+func (d ConfigDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *ConfigDuration) UnmarshalJSON(data []byte) error {
+	var value string
+	err := json.Unmarshal(data, &value)
+
+	if err != nil {
+		return fmt.Errorf("Duration must be a string such as \"10s\". Got: %s", data)
+	}
+
+	d.Duration, err = time.ParseDuration(value)
+	return err
+}
+
+// ConfigError describes a single config problem and the JSON path it was
+// found at, e.g. Exchanges[3].EnabledPairs[0].
+type ConfigError struct {
+	Path    string
+	Message string
+}
+
+func (c ConfigError) Error() string {
+	return fmt.Sprintf("%s: %s", c.Path, c.Message)
+}
+
+type ConfigErrors []ConfigError
+
+func (c ConfigErrors) Error() string {
+	result := []string{}
+	for _, x := range c {
+		result = append(result, x.Error())
+	}
+	return JoinStrings(result, "\n")
+}
+
+func (c *ConfigErrors) Add(path, message string) {
+	*c = append(*c, ConfigError{Path: path, Message: message})
+}
+
+// GetConfig returns a copy of the running config. The copy shares its slices
+// and maps with the running config, which are replaced rather than modified
+// when the config changes.
+func GetConfig() Config {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return bot.config
+}
+
+// SetConfig replaces the running config.
+func SetConfig(cfg Config) {
+	configMutex.Lock()
+	bot.config = cfg
+	configMutex.Unlock()
 }
 
 func GetEnabledExchanges() int {
 	counter := 0
-	for i := range bot.config.Exchanges {
-		if bot.config.Exchanges[i].Enabled {
+	cfg := GetConfig()
+	for i := range cfg.Exchanges {
+		if cfg.Exchanges[i].Enabled {
 			counter++
 		}
 	}
@@ -71,115 +308,551 @@ func GetEnabledExchanges() int {
 }
 
 func GetExchangeConfig(name string) (Exchanges, error) {
-	for i, _ := range bot.config.Exchanges {
-		if bot.config.Exchanges[i].Name == name {
-			return bot.config.Exchanges[i], nil
+	cfg := GetConfig()
+	return cfg.GetExchangeConfig(name)
+}
+
+func (c *Config) GetExchangeConfig(name string) (Exchanges, error) {
+	for i, _ := range c.Exchanges {
+		if c.Exchanges[i].Name == name {
+			return c.Exchanges[i], nil
 		}
 	}
 	return Exchanges{}, fmt.Errorf(ErrExchangeNotFound, name)
 }
 
 func UpdateExchangeConfig(e Exchanges) error {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
 	for i, _ := range bot.config.Exchanges {
 		if bot.config.Exchanges[i].Name == e.Name {
-			bot.config.Exchanges[i] = e
+			exchanges := make([]Exchanges, len(bot.config.Exchanges))
+			copy(exchanges, bot.config.Exchanges)
+			exchanges[i] = e
+			bot.config.Exchanges = exchanges
 			return nil
 		}
 	}
 	return fmt.Errorf(ErrExchangeNotFound, e.Name)
 }
 
-func CheckSMSGlobalConfigValues() error {
-	if bot.config.SMS.Enabled {
-		if bot.config.SMS.Username == "" || bot.config.SMS.Username == "Username" || bot.config.SMS.Password == "" || bot.config.SMS.Password == "Password" {
-			bot.config.SMS.Enabled = false
+func (c *Config) CheckSMSGlobalConfigValues() error {
+	if c.SMS.Enabled {
+		if c.SMS.Username == "" || c.SMS.Username == "Username" || c.SMS.Password == "" || c.SMS.Password == "Password" {
+			c.SMS.Enabled = false
 			return errors.New(WarningSMSGlobalDefaultOrEmptyValues)
 		}
 		contacts := 0
-		for i := range bot.config.SMS.Contacts {
-			if bot.config.SMS.Contacts[i].Enabled {
-				if bot.config.SMS.Contacts[i].Name == "" || bot.config.SMS.Contacts[i].Number == "" || (bot.config.SMS.Contacts[i].Name == "Bob" && bot.config.SMS.Contacts[i].Number == "12345") {
+		for i := range c.SMS.Contacts {
+			if c.SMS.Contacts[i].Enabled {
+				if c.SMS.Contacts[i].Name == "" || c.SMS.Contacts[i].Number == "" || (c.SMS.Contacts[i].Name == "Bob" && c.SMS.Contacts[i].Number == "12345") {
 					log.Printf(WarningSSMSGlobalSMSContactDefaultOrEmptyValues, i)
-					bot.config.SMS.Contacts[i].Enabled = false
+					c.SMS.Contacts[i].Enabled = false
 					continue
 				}
 				contacts++
 			}
 		}
 		if contacts == 0 {
-			bot.config.SMS.Enabled = false
+			c.SMS.Enabled = false
 			return errors.New(WarningSSMSGlobalSMSNoContacts)
 		}
 	}
 	return nil
 }
 
-func CheckExchangeConfigValues() error {
-	if bot.config.Cryptocurrencies == "" {
-		return errors.New(ErrCryptocurrenciesEmpty)
+func (c *Config) CheckStorageConfigValues() error {
+	if !c.Storage.Enabled {
+		return nil
 	}
 
-	exchanges := 0
-	for i, exch := range bot.config.Exchanges {
-		if exch.Enabled {
-			if exch.Name == "" {
-				return fmt.Errorf(ErrExchangeNameEmpty, i)
+	if c.Storage.Path == "" {
+		c.Storage.Path = STORAGE_DEFAULT_PATH
+	}
+
+	durations := map[string]ConfigDuration{
+		"OrderbookSnapshotInterval": c.Storage.OrderbookSnapshotInterval,
+		"TickerRetention":           c.Storage.TickerRetention,
+		"TradeRetention":            c.Storage.TradeRetention,
+		"OrderbookRetention":        c.Storage.OrderbookRetention,
+		"DownsampleAfter":           c.Storage.DownsampleAfter,
+		"DownsampleInterval":        c.Storage.DownsampleInterval,
+		"MaintenanceInterval":       c.Storage.MaintenanceInterval,
+	}
+	for name, x := range durations {
+		if x.Duration < 0 {
+			c.Storage.Enabled = false
+			return fmt.Errorf(WarningStorageDurationInvalid, name)
+		}
+	}
+	return nil
+}
+
+// CheckCandleConfigValues drops invalid candle intervals and defaults the
+// interval list when it is empty.
+func (c *Config) CheckCandleConfigValues() error {
+	if !c.Candles.Enabled {
+		return nil
+	}
+
+	var err error
+	if c.Candles.LateTradeWindow.Duration < 0 {
+		c.Candles.LateTradeWindow.Duration = 0
+		err = errors.New(WarningCandleLateTradeWindowInvalid)
+	}
+
+	intervals := []ConfigDuration{}
+	for _, x := range c.Candles.Intervals {
+		if x.Duration <= 0 {
+			err = fmt.Errorf(WarningCandleIntervalInvalid, x)
+			continue
+		}
+		intervals = append(intervals, x)
+	}
+
+	if len(intervals) == 0 {
+		for _, x := range CANDLE_DEFAULT_INTERVALS {
+			intervals = append(intervals, ConfigDuration{x})
+		}
+	}
+	c.Candles.Intervals = intervals
+	return err
+}
+
+func (c *Config) CheckWebserverConfigValues() error {
+	if !c.Webserver.Enabled {
+		return nil
+	}
+
+	if c.Webserver.AdminUsername == "" || c.Webserver.AdminUsername == "admin" || c.Webserver.AdminPassword == "" || c.Webserver.AdminPassword == "Password" {
+		c.Webserver.Enabled = false
+		return errors.New(WarningWebserverCredentialsDefaultOrEmpty)
+	}
+
+	if c.Webserver.ListenAddress == "" {
+		c.Webserver.ListenAddress = WEBSERVER_DEFAULT_LISTEN_ADDRESS
+	}
+
+	_, _, err := net.SplitHostPort(c.Webserver.ListenAddress)
+	if err != nil {
+		c.Webserver.Enabled = false
+		return fmt.Errorf(WarningWebserverListenAddressInvalid, c.Webserver.ListenAddress)
+	}
+	return nil
+}
+
+func (c *Config) CheckGRPCConfigValues() error {
+	if !c.GRPC.Enabled {
+		return nil
+	}
+
+	if c.GRPC.Username == "" || c.GRPC.Username == "admin" || c.GRPC.Password == "" || c.GRPC.Password == "Password" {
+		c.GRPC.Enabled = false
+		return errors.New(WarningGRPCCredentialsDefaultOrEmpty)
+	}
+
+	if c.GRPC.ListenAddress == "" {
+		c.GRPC.ListenAddress = GRPC_DEFAULT_LISTEN_ADDRESS
+	}
+
+	_, _, err := net.SplitHostPort(c.GRPC.ListenAddress)
+	if err != nil {
+		c.GRPC.Enabled = false
+		return fmt.Errorf(WarningGRPCListenAddressInvalid, c.GRPC.ListenAddress)
+	}
+	return nil
+}
+
+func (c *Config) CheckFIXConfigValues() error {
+	if !c.FIX.Enabled {
+		return nil
+	}
+
+	if !c.Storage.Enabled {
+		c.FIX.Enabled = false
+		return errors.New(WarningFIXStorageDisabled)
+	}
+
+	if c.FIX.Password == "" || c.FIX.Password == "Password" {
+		c.FIX.Enabled = false
+		return errors.New(WarningFIXPasswordDefaultOrEmpty)
+	}
+
+	if c.FIX.SenderCompID == "" {
+		c.FIX.SenderCompID = FIX_DEFAULT_SENDER_COMP_ID
+	}
+
+	if c.FIX.TargetCompID == "" {
+		c.FIX.Enabled = false
+		return errors.New(WarningFIXCompIDEmpty)
+	}
+
+	if c.FIX.ListenAddress == "" {
+		c.FIX.ListenAddress = FIX_DEFAULT_LISTEN_ADDRESS
+	}
+
+	_, _, err := net.SplitHostPort(c.FIX.ListenAddress)
+	if err != nil {
+		c.FIX.Enabled = false
+		return fmt.Errorf(WarningFIXListenAddressInvalid, c.FIX.ListenAddress)
+	}
+	return nil
+}
+
+// CheckWithdrawalConfigValues upper cases currency names and disables
+// withdrawals if approvals can't be given. It must run after the webserver
+// check.
+func (c *Config) CheckWithdrawalConfigValues() error {
+	if !c.Withdrawals.Enabled {
+		return nil
+	}
+
+	if !c.Storage.Enabled {
+		c.Withdrawals.Enabled = false
+		return errors.New(WarningWithdrawalsStorageDisabled)
+	}
+
+	if c.Withdrawals.ApprovalTimeout.Duration <= 0 {
+		c.Withdrawals.ApprovalTimeout.Duration = WITHDRAWAL_DEFAULT_APPROVAL_TIMEOUT
+	}
+
+	whitelist := make(map[string][]string)
+	for currency, addresses := range c.Withdrawals.Whitelist {
+		whitelist[StringToUpper(currency)] = addresses
+	}
+	c.Withdrawals.Whitelist = whitelist
+
+	errs := ConfigErrors{}
+	limits := make(map[string]float64)
+	for currency, limit := range c.Withdrawals.DailyLimits {
+		if limit < 0 {
+			errs.Add(fmt.Sprintf("Withdrawals.DailyLimits.%s", currency), ErrWithdrawalLimitInvalid)
+		}
+		limits[StringToUpper(currency)] = limit
+	}
+	c.Withdrawals.DailyLimits = limits
+
+	if len(errs) > 0 {
+		c.Withdrawals.Enabled = false
+		return errs
+	}
+
+	if !c.Withdrawals.RequireApproval {
+		return nil
+	}
+
+	approvers := 0
+	for _, x := range c.Withdrawals.Approvers {
+		if x.Username != "" && x.Username != c.Webserver.AdminUsername && x.Password != "" && x.Password != "Password" {
+			approvers++
+		}
+	}
+
+	if !c.Webserver.Enabled || approvers == 0 || approvers != len(c.Withdrawals.Approvers) {
+		c.Withdrawals.Enabled = false
+		return errors.New(WarningWithdrawalsApproversInvalid)
+	}
+	return nil
+}
+
+// CheckRebalanceConfigValues upper cases currency names, sets defaults and
+// checks targets name configured exchanges. Execute is turned off when
+// withdrawals are disabled, so it must run after the withdrawal check.
+func (c *Config) CheckRebalanceConfigValues() error {
+	cfg := &c.Rebalance
+	if cfg.Interval.Duration <= 0 {
+		cfg.Interval.Duration = REBALANCE_DEFAULT_INTERVAL
+	}
+
+	if cfg.Cooldown.Duration <= 0 {
+		cfg.Cooldown.Duration = REBALANCE_DEFAULT_COOLDOWN
+	}
+
+	errs := ConfigErrors{}
+	if cfg.Tolerance == nil {
+		tolerance := float64(REBALANCE_DEFAULT_TOLERANCE)
+		cfg.Tolerance = &tolerance
+	} else if *cfg.Tolerance < 0 {
+		errs.Add("Rebalance.Tolerance", ErrRebalanceToleranceInvalid)
+	}
+
+	targets := make(map[string]map[string]float64)
+	for currency, weights := range cfg.Targets {
+		currency = StringToUpper(currency)
+		targets[currency] = make(map[string]float64)
+		for name, weight := range weights {
+			path := fmt.Sprintf("Rebalance.Targets.%s.%s", currency, name)
+			exch, err := c.GetExchangeConfig(name)
+			if err != nil {
+				errs.Add(path, err.Error())
+				continue
 			}
-			if exch.AvailablePairs == "" {
-				return fmt.Errorf(ErrExchangeAvailablePairsEmpty, exch.Name)
+
+			if weight < 0 {
+				errs.Add(path, ErrRebalanceWeightInvalid)
 			}
-			if exch.EnabledPairs == "" {
-				return fmt.Errorf(ErrExchangeEnabledPairsEmpty, exch.Name)
+			targets[currency][exch.Name] = weight
+		}
+	}
+	cfg.Targets = targets
+
+	for name, amounts := range map[string]*map[string]float64{"NetworkFees": &cfg.NetworkFees, "MinTransfers": &cfg.MinTransfers} {
+		result := make(map[string]float64)
+		for currency, amount := range *amounts {
+			if amount < 0 {
+				errs.Add(fmt.Sprintf("Rebalance.%s.%s", name, currency), ErrRebalanceAmountInvalid)
 			}
-			if exch.BaseCurrencies == "" {
-				return fmt.Errorf(ErrExchangeBaseCurrenciesEmpty, exch.Name)
+			result[StringToUpper(currency)] = amount
+		}
+		*amounts = result
+	}
+
+	if len(errs) > 0 {
+		cfg.Enabled, cfg.Execute = false, false
+		return errs
+	}
+
+	if cfg.Execute && !c.Withdrawals.Enabled {
+		cfg.Execute = false
+		return errors.New(WarningRebalanceExecuteDisabled)
+	}
+	return nil
+}
+
+// CheckStrategyConfigValues defaults strategy names and disables strategies
+// which can't be run, returning a warning for each.
+func (c *Config) CheckStrategyConfigValues() error {
+	errs := ConfigErrors{}
+	names := make(map[string]bool)
+	for i := range c.Strategies {
+		x := &c.Strategies[i]
+		path := fmt.Sprintf("Strategies[%d]", i)
+		if x.Name == "" {
+			x.Name = x.Strategy
+		}
+
+		if !x.Enabled {
+			continue
+		}
+
+		problem := ""
+		exch, err := c.GetExchangeConfig(x.Exchange)
+		switch {
+		case names[x.Name]:
+			problem = fmt.Sprintf(ErrStrategyNameDuplicate, x.Name)
+		case x.TimerInterval.Duration < 0:
+			problem = ErrStrategyTimerIntervalInvalid
+		case err != nil:
+			problem = err.Error()
+		case x.Pair.IsEmpty():
+			problem = fmt.Sprintf(ErrExchangeCurrencyPairInvalid, x.Exchange)
+		case !exch.Enabled:
+			problem = fmt.Sprintf(ErrStrategyExchangeDisabled, x.Exchange)
+		default:
+			_, err = NewStrategy(x.Strategy)
+			if err != nil {
+				problem = err.Error()
 			}
-			if exch.AuthenticatedAPISupport { // non-fatal error
-				if exch.APIKey == "" || exch.APISecret == "" || exch.APIKey == "Key" || exch.APISecret == "Secret" {
-					bot.config.Exchanges[i].AuthenticatedAPISupport = false
-					log.Printf(WarningExchangeAuthAPIDefaultOrEmptyValues, exch.Name)
-					continue
-				} else if exch.Name == "ITBIT" || exch.Name == "Bitstamp" || exch.Name == "Coinbase" {
-					if exch.ClientID == "" || exch.ClientID == "ClientID" {
-						bot.config.Exchanges[i].AuthenticatedAPISupport = false
-						log.Printf(WarningExchangeAuthAPIDefaultOrEmptyValues, exch.Name)
-						continue
-					}
+		}
+		names[x.Name] = true
+
+		if problem != "" {
+			x.Enabled = false
+			errs.Add(path, fmt.Sprintf(WarningStrategyDisabled, x.Name, problem))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// CheckConfigValues runs every config check in the order the bot needs them,
+// filling in defaults. An invalid exchange config is returned as the error, as
+// the bot can't run with it. Problems with other sections only disable or
+// default those features, so they are returned as warnings.
+func (c *Config) CheckConfigValues() ([]error, error) {
+	err := c.CheckExchangeConfigValues()
+	if err != nil {
+		return nil, err
+	}
+
+	warnings := []error{}
+	checks := []func() error{
+		c.CheckSMSGlobalConfigValues,
+		c.CheckStorageConfigValues,
+		c.CheckCandleConfigValues,
+		c.CheckStrategyConfigValues,
+		c.CheckWebserverConfigValues,
+		c.CheckGRPCConfigValues,
+		c.CheckFIXConfigValues,
+		c.CheckWithdrawalConfigValues,
+		c.CheckRebalanceConfigValues,
+	}
+	for _, check := range checks {
+		err = check()
+		if err != nil {
+			warnings = append(warnings, err)
+		}
+	}
+	return warnings, nil
+}
+
+// CheckExchangeConfigValues validates every exchange and returns all problems
+// found as ConfigErrors. Missing credentials are non-fatal and only disable
+// authenticated API support.
+func (c *Config) CheckExchangeConfigValues() error {
+	errs := ConfigErrors{}
+
+	if c.Cryptocurrencies == "" {
+		errs.Add("Cryptocurrencies", ErrCryptocurrenciesEmpty)
+	}
+
+	exchanges := 0
+	names := make(map[string]bool)
+	for i, exch := range c.Exchanges {
+		path := fmt.Sprintf("Exchanges[%d]", i)
+		if exch.Name == "" {
+			errs.Add(path+".Name", ErrExchangeNameEmpty)
+			continue
+		}
+
+		if names[exch.Name] {
+			errs.Add(path+".Name", fmt.Sprintf(ErrExchangeNameDuplicate, exch.Name))
+		}
+		names[exch.Name] = true
+
+		exchange := bot.exchange.GetExchangeByName(exch.Name)
+		if exchange == nil {
+			errs.Add(path+".Name", fmt.Sprintf(ErrExchangeNotFound, exch.Name))
+			continue
+		}
+
+		if !exch.Enabled {
+			continue
+		}
+
+		if exch.RESTPollingDelay.Duration <= 0 {
+			errs.Add(path+".RESTPollingDelay", fmt.Sprintf(ErrExchangeRESTPollingDelayInvalid, exch.Name))
+		}
+		if len(exch.BaseCurrencies) == 0 {
+			errs.Add(path+".BaseCurrencies", fmt.Sprintf(ErrExchangeBaseCurrenciesEmpty, exch.Name))
+		}
+		if len(exch.AvailablePairs) == 0 {
+			errs.Add(path+".AvailablePairs", fmt.Sprintf(ErrExchangeAvailablePairsEmpty, exch.Name))
+		}
+		for j, pair := range exch.AvailablePairs {
+			if pair.IsEmpty() {
+				errs.Add(fmt.Sprintf("%s.AvailablePairs[%d]", path, j), fmt.Sprintf(ErrExchangeCurrencyPairInvalid, exch.Name))
+			}
+		}
+		if len(exch.EnabledPairs) == 0 {
+			errs.Add(path+".EnabledPairs", fmt.Sprintf(ErrExchangeEnabledPairsEmpty, exch.Name))
+		}
+		for j, pair := range exch.EnabledPairs {
+			if pair.IsEmpty() {
+				errs.Add(fmt.Sprintf("%s.EnabledPairs[%d]", path, j), fmt.Sprintf(ErrExchangeCurrencyPairInvalid, exch.Name))
+			} else if !ContainsCurrencyPair(exch.AvailablePairs, pair) {
+				errs.Add(fmt.Sprintf("%s.EnabledPairs[%d]", path, j), fmt.Sprintf(ErrExchangeEnabledPairNotAvailable, exch.Name, pair))
+			}
+		}
+
+		for currency, balance := range exch.PaperBalances {
+			if balance < 0 {
+				errs.Add(path+".PaperBalances."+currency, fmt.Sprintf(ErrExchangePaperBalanceInvalid, exch.Name, currency))
+			}
+		}
+
+		if exch.AuthenticatedAPISupport { // non-fatal error
+			for _, credential := range exchange.GetRequiredCredentials() {
+				if !IsCredentialSet(exch, credential) {
+					c.Exchanges[i].AuthenticatedAPISupport = false
+					log.Printf(WarningExchangeAuthAPIDefaultOrEmptyValues, exch.Name, credential)
+					break
 				}
 			}
-			exchanges++
 		}
+		exchanges++
 	}
+
 	if exchanges == 0 {
-		return errors.New(ErrNoEnabledExchanges)
+		errs.Add("Exchanges", ErrNoEnabledExchanges)
+	}
+
+	if len(errs) > 0 {
+		return errs
 	}
 	return nil
 }
 
 func ReadConfig() (Config, error) {
-	file, err := ioutil.ReadFile(CONFIG_FILE)
+	file, err := ioutil.ReadFile(ConfigFile)
+
+	if err != nil {
+		return Config{}, err
+	}
+
+	CheckConfigFilePermissions(ConfigFile)
+
+	if IsEncryptedConfig(file) {
+		file, err = DecryptConfig(file)
+		if err != nil {
+			return Config{}, err
+		}
+		log.Println("Config file decrypted.")
+	}
+
+	file, version, err := MigrateConfig(file)
 
 	if err != nil {
 		return Config{}, err
 	}
 
+	if version != CONFIG_VERSION {
+		log.Printf("Config file migrated from version %d to %d.\n", version, CONFIG_VERSION)
+	}
+
 	cfg := Config{}
 	err = json.Unmarshal(file, &cfg)
-	return cfg, err
+
+	if err != nil {
+		return Config{}, err
+	}
+
+	ApplyConfigSecretOverrides(&cfg)
+	return cfg, nil
 }
 
+// SaveConfig writes the running config back to disk, encrypting it when
+// EncryptConfig is set. Secrets loaded from the environment are not saved.
 func SaveConfig() error {
-	payload, err := json.MarshalIndent(bot.config, "", " ")
+	cfg := GetConfig()
+	payload, err := json.MarshalIndent(RestoreConfigFileSecrets(cfg), "", " ")
 
 	if err != nil {
 		return err
 	}
 
-	err = ioutil.WriteFile(CONFIG_FILE, payload, 0644)
+	if cfg.EncryptConfig {
+		payload, err = EncryptConfig(payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ioutil.WriteFile(ConfigFile, payload, CONFIG_FILE_PERMISSIONS)
+
+	if err != nil {
+		return err
+	}
 
+	err = os.Chmod(ConfigFile, CONFIG_FILE_PERMISSIONS)
 	if err != nil {
 		return err
 	}
 
+	SetConfigSaved()
 	return nil
 }
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+	"io"
+	"log"
+	"os"
+)
+
+const (
+	CONFIG_ENCRYPTION_HEADER       = "GCT_ENCRYPTED_CONFIG"
+	CONFIG_ENCRYPTION_SALT_LEN     = 32
+	CONFIG_ENCRYPTION_KEY_LEN      = 32
+	CONFIG_PASSPHRASE_ENV          = "GCT_CONFIG_PASSPHRASE"
+	CONFIG_FILE_PERMISSIONS        = 0600
+	CONFIG_SCRYPT_N                = 32768
+	CONFIG_SCRYPT_R                = 8
+	CONFIG_SCRYPT_P                = 1
+	ErrConfigPassphraseEmpty       = "Config passphrase is empty."
+	ErrConfigPassphraseMismatch    = "Config passphrases do not match."
+	ErrConfigDecryptionFailed      = "Unable to decrypt config file. Check the passphrase."
+	ErrConfigEncryptedFileTooShort = "Encrypted config file is truncated."
+)
+
+var (
+	configEncryptionKey  []byte
+	configEncryptionSalt []byte
+)
+
+func IsEncryptedConfig(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(CONFIG_ENCRYPTION_HEADER))
+}
+
+// GetConfigPassphrase reads the config passphrase from GCT_CONFIG_PASSPHRASE,
+// falling back to prompting on the terminal. When confirm is set the
+// passphrase is asked for twice.
+func GetConfigPassphrase(confirm bool) ([]byte, error) {
+	if passphrase := os.Getenv(CONFIG_PASSPHRASE_ENV); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("Config passphrase required. Set %s or run the bot from a terminal.", CONFIG_PASSPHRASE_ENV)
+	}
+
+	passphrase, err := PromptForPassphrase("Enter config passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+
+	if confirm {
+		confirmation, err := PromptForPassphrase("Confirm config passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(passphrase, confirmation) {
+			return nil, errors.New(ErrConfigPassphraseMismatch)
+		}
+	}
+	return passphrase, nil
+}
+
+func PromptForPassphrase(prompt string) ([]byte, error) {
+	fmt.Print(prompt)
+	passphrase, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(passphrase) == 0 {
+		return nil, errors.New(ErrConfigPassphraseEmpty)
+	}
+	return passphrase, nil
+}
+
+func DeriveConfigKey(passphrase, salt []byte) ([]byte, error) {
+	return scrypt.Key(passphrase, salt, CONFIG_SCRYPT_N, CONFIG_SCRYPT_R, CONFIG_SCRYPT_P, CONFIG_ENCRYPTION_KEY_LEN)
+}
+
+// SetConfigPassphrase derives a new key and salt used for all subsequent
+// config saves.
+func SetConfigPassphrase(passphrase []byte) error {
+	salt := make([]byte, CONFIG_ENCRYPTION_SALT_LEN)
+	_, err := io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return err
+	}
+
+	key, err := DeriveConfigKey(passphrase, salt)
+	if err != nil {
+		return err
+	}
+
+	configEncryptionKey, configEncryptionSalt = key, salt
+	return nil
+}
+
+// EncryptConfig encrypts the config payload with AES-256-GCM. The output is
+// laid out as header | salt | nonce | ciphertext.
+func EncryptConfig(data []byte) ([]byte, error) {
+	if configEncryptionKey == nil {
+		passphrase, err := GetConfigPassphrase(true)
+		if err != nil {
+			return nil, err
+		}
+		err = SetConfigPassphrase(passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	gcm, err := newConfigCipher(configEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []byte(CONFIG_ENCRYPTION_HEADER)
+	result = append(result, configEncryptionSalt...)
+	result = append(result, nonce...)
+	return gcm.Seal(result, nonce, data, []byte(CONFIG_ENCRYPTION_HEADER)), nil
+}
+
+// DecryptConfig decrypts a config file produced by EncryptConfig. The derived
+// key is kept so the config can be re-encrypted on save, or reloaded, without
+// prompting.
+func DecryptConfig(data []byte) ([]byte, error) {
+	data = data[len(CONFIG_ENCRYPTION_HEADER):]
+	if len(data) < CONFIG_ENCRYPTION_SALT_LEN {
+		return nil, errors.New(ErrConfigEncryptedFileTooShort)
+	}
+
+	salt := data[:CONFIG_ENCRYPTION_SALT_LEN]
+	data = data[CONFIG_ENCRYPTION_SALT_LEN:]
+
+	// Reuse the key from the last load when the salt is unchanged, so the
+	// config can be reloaded without asking for the passphrase again
+	key := configEncryptionKey
+	if key == nil || !bytes.Equal(salt, configEncryptionSalt) {
+		passphrase, err := GetConfigPassphrase(false)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err = DeriveConfigKey(passphrase, salt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	gcm, err := newConfigCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New(ErrConfigEncryptedFileTooShort)
+	}
+
+	nonce := data[:gcm.NonceSize()]
+	result, err := gcm.Open(nil, nonce, data[gcm.NonceSize():], []byte(CONFIG_ENCRYPTION_HEADER))
+	if err != nil {
+		return nil, errors.New(ErrConfigDecryptionFailed)
+	}
+
+	configEncryptionKey, configEncryptionSalt = key, append([]byte{}, salt...)
+	return result, nil
+}
+
+func newConfigCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// CheckConfigFilePermissions restricts the config file to its owner if it is
+// readable by group or others.
+func CheckConfigFilePermissions(path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	if info.Mode().Perm()&0077 != 0 {
+		log.Printf("WARNING -- %s has permissions %s. Restricting to %s.\n", path, info.Mode().Perm(), os.FileMode(CONFIG_FILE_PERMISSIONS))
+		err = os.Chmod(path, CONFIG_FILE_PERMISSIONS)
+		if err != nil {
+			log.Printf("Unable to change %s permissions. Error: %s\n", path, err)
+		}
+	}
+}
+
+// CheckConfigEncryption encrypts a plain text config file straight away when
+// EncryptConfig is enabled, rather than waiting for the next save. Otherwise
+// it warns if the plain text file holds credentials.
+func CheckConfigEncryption() error {
+	cfg := GetConfig()
+	if !cfg.EncryptConfig {
+		if ConfigHasPlainTextSecrets(RestoreConfigFileSecrets(cfg)) {
+			log.Println("WARNING -- Config file contains plain text credentials. Set EncryptConfig to true to encrypt it.")
+		}
+		return nil
+	}
+
+	if configEncryptionKey != nil {
+		return nil
+	}
+
+	log.Println("EncryptConfig enabled. Encrypting config file..")
+	return SaveConfig()
+}
+
+func ConfigHasPlainTextSecrets(cfg Config) bool {
+	for _, exch := range cfg.Exchanges {
+		if IsCredentialSet(exch, CREDENTIAL_API_KEY) || IsCredentialSet(exch, CREDENTIAL_API_SECRET) || IsCredentialSet(exch, CREDENTIAL_CLIENT_ID) {
+			return true
+		}
+	}
+	return cfg.SMS.Password != "" && cfg.SMS.Password != "Password"
+}
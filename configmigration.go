@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// configMigrations[n] upgrades a decoded config from version n to n+1.
+var configMigrations = []func(cfg map[string]interface{}) error{
+	MigrateConfigV0ToV1,
+}
+
+// MigrateConfig upgrades raw config file data to CONFIG_VERSION. It returns
+// the upgraded data along with the version the data was originally at.
+func MigrateConfig(data []byte) ([]byte, int, error) {
+	cfg := make(map[string]interface{})
+	err := json.Unmarshal(data, &cfg)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	version := 0
+	if v, ok := cfg["Version"].(float64); ok {
+		version = int(v)
+	}
+
+	if version < 0 {
+		return nil, version, fmt.Errorf(ErrConfigVersionInvalid, version)
+	}
+
+	if version > CONFIG_VERSION {
+		return nil, version, fmt.Errorf(ErrConfigVersionUnsupported, version, CONFIG_VERSION)
+	}
+
+	if version == CONFIG_VERSION {
+		return data, version, nil
+	}
+
+	for i := version; i < CONFIG_VERSION; i++ {
+		err = configMigrations[i](cfg)
+		if err != nil {
+			return nil, version, fmt.Errorf("Unable to migrate config from version %d to %d. Error: %s", i, i+1, err)
+		}
+		cfg["Version"] = i + 1
+	}
+
+	data, err = json.Marshal(cfg)
+	if err != nil {
+		return nil, version, err
+	}
+	return data, version, nil
+}
+
+// MigrateConfigV0ToV1 converts the comma-joined AvailablePairs, EnabledPairs
+// and BaseCurrencies strings into lists, and RESTPollingDelay from a number
+// of seconds into a duration string. DisplayCurrency is dropped as nothing
+// ever read it.
+func MigrateConfigV0ToV1(cfg map[string]interface{}) error {
+	delete(cfg, "DisplayCurrency")
+	cryptocurrencies, _ := cfg["Cryptocurrencies"].(string)
+	exchanges, _ := cfg["Exchanges"].([]interface{})
+
+	for i := range exchanges {
+		exch, ok := exchanges[i].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("Exchanges[%d] is not an object.", i)
+		}
+
+		baseCurrencies := []string{}
+		if value, ok := exch["BaseCurrencies"].(string); ok && value != "" {
+			baseCurrencies = SplitStrings(value, ",")
+		}
+		exch["BaseCurrencies"] = baseCurrencies
+
+		quotes := []string{}
+		quotes = append(quotes, baseCurrencies...)
+		if cryptocurrencies != "" {
+			quotes = append(quotes, SplitStrings(cryptocurrencies, ",")...)
+		}
+
+		for _, key := range []string{"AvailablePairs", "EnabledPairs"} {
+			pairs := []CurrencyPair{}
+			if value, ok := exch[key].(string); ok && value != "" {
+				for _, x := range SplitStrings(value, ",") {
+					pair := ParseCurrencyPair(x, quotes)
+					if pair.Quote == "" && len(baseCurrencies) > 0 {
+						// Exchanges such as BTC Markets list only the base
+						// currency and quote everything in their fiat currency
+						pair.Quote = baseCurrencies[0]
+					}
+					pairs = append(pairs, pair)
+				}
+			}
+			exch[key] = pairs
+		}
+
+		if value, ok := exch["RESTPollingDelay"].(float64); ok {
+			exch["RESTPollingDelay"] = (time.Duration(value) * time.Second).String()
+		}
+	}
+	return nil
+}
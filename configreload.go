@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	CONFIG_WATCH_INTERVAL = time.Second * 5
+)
+
+var (
+	configReloadMutex sync.Mutex
+	configSavedMutex  sync.Mutex
+	configSavedTime   time.Time
+)
+
+// SetConfigSaved records the modification time of the config file after the
+// bot has saved it, so that WatchConfigFile doesn't reload the bot's own
+// changes.
+func SetConfigSaved() {
+	info, err := os.Stat(ConfigFile)
+	if err != nil {
+		return
+	}
+
+	configSavedMutex.Lock()
+	configSavedTime = info.ModTime()
+	configSavedMutex.Unlock()
+}
+
+func isConfigSaved(modified time.Time) bool {
+	configSavedMutex.Lock()
+	defer configSavedMutex.Unlock()
+	return modified.Equal(configSavedTime)
+}
+
+// HandleConfigReload reloads the config file whenever the bot receives SIGHUP,
+// until ctx is cancelled.
+func HandleConfigReload(ctx context.Context) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	StartRoutine(func() {
+		defer signal.Stop(c)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-c:
+				log.Printf("Captured %v. Reloading config.", sig)
+				err := ReloadConfig()
+				if err != nil {
+					log.Printf("Unable to reload config. Error: %s\n", err)
+				}
+			}
+		}
+	})
+}
+
+// WatchConfigFile polls the config file and reloads it when its modification
+// time changes.
+func WatchConfigFile(ctx context.Context) {
+	lastModified := time.Time{}
+	info, err := os.Stat(ConfigFile)
+	if err == nil {
+		lastModified = info.ModTime()
+	}
+
+	for SleepContext(ctx, CONFIG_WATCH_INTERVAL) {
+		info, err := os.Stat(ConfigFile)
+		if err != nil {
+			continue
+		}
+
+		if info.ModTime().Equal(lastModified) {
+			continue
+		}
+
+		lastModified = info.ModTime()
+		if isConfigSaved(lastModified) {
+			continue
+		}
+
+		log.Printf("%s changed. Reloading config.", ConfigFile)
+		err = ReloadConfig()
+		if err != nil {
+			log.Printf("Unable to reload config. Error: %s\n", err)
+		}
+	}
+}
+
+// ReloadConfig reads and validates the config file, then applies the
+// differences to the running bot. The running config is left untouched if
+// the new one fails validation.
+func ReloadConfig() error {
+	configReloadMutex.Lock()
+	defer configReloadMutex.Unlock()
+
+	newConfig, err := ReadConfig()
+	if err != nil {
+		return err
+	}
+
+	warnings, err := newConfig.CheckConfigValues()
+	if err != nil {
+		return err
+	}
+
+	for _, x := range warnings {
+		// non fatal events
+		log.Println(x)
+	}
+
+	oldConfig := GetConfig()
+	SetConfig(newConfig)
+
+	pairsChanged := false
+	for _, exch := range newConfig.Exchanges {
+		old, err := oldConfig.GetExchangeConfig(exch.Name)
+		if err != nil {
+			log.Printf("%s: Added to config.\n", exch.Name)
+		}
+
+		if !reflect.DeepEqual(old.EnabledPairs, exch.EnabledPairs) {
+			pairsChanged = true
+		}
+		ApplyExchangeConfigChanges(old, exch)
+	}
+
+	for _, old := range oldConfig.Exchanges {
+		_, err := newConfig.GetExchangeConfig(old.Name)
+		if err != nil && old.Enabled {
+			log.Printf("%s: Removed from config. Stopping exchange.\n", old.Name)
+			exchange := bot.exchange.GetExchangeByName(old.Name)
+			if exchange != nil {
+				StopExchange(exchange)
+			}
+		}
+	}
+
+	if !reflect.DeepEqual(oldConfig.SMS, newConfig.SMS) {
+		if newConfig.SMS.Enabled {
+			log.Printf("SMS support reloaded. Number of SMS contacts %d.\n", GetEnabledSMSContacts())
+		} else {
+			log.Println("SMS support disabled.")
+		}
+	}
+
+	ApplyStrategyConfigChanges(oldConfig, newConfig)
+
+	// Credentials are read on every request, but the listener is not moved.
+	if oldConfig.Webserver.Enabled != newConfig.Webserver.Enabled || oldConfig.Webserver.ListenAddress != newConfig.Webserver.ListenAddress {
+		log.Println("Webserver listen settings changed. Restart the bot to apply them.")
+	}
+
+	if oldConfig.GRPC.Enabled != newConfig.GRPC.Enabled || oldConfig.GRPC.ListenAddress != newConfig.GRPC.ListenAddress {
+		log.Println("gRPC listen settings changed. Restart the bot to apply them.")
+	}
+
+	if oldConfig.FIX.Enabled != newConfig.FIX.Enabled || oldConfig.FIX.ListenAddress != newConfig.FIX.ListenAddress || oldConfig.FIX.SenderCompID != newConfig.FIX.SenderCompID || oldConfig.FIX.TargetCompID != newConfig.FIX.TargetCompID {
+		log.Println("FIX session settings changed. Restart the bot to apply them.")
+	}
+
+	if pairsChanged {
+		err = RetrieveConfigCurrencyPairs(newConfig)
+		if err != nil {
+			log.Println("Error retrieving config currency AvailablePairs. Error: ", err)
+		}
+	}
+
+	log.Println("Config reloaded.")
+	return nil
+}
+
+// ApplyExchangeConfigChanges updates a running exchange to match its new
+// config. Any change to an enabled exchange restarts it, as Setup must not
+// run alongside the exchange's Run loop.
+func ApplyExchangeConfigChanges(oldExch, newExch Exchanges) {
+	if reflect.DeepEqual(oldExch, newExch) {
+		return
+	}
+
+	exchange := bot.exchange.GetExchangeByName(newExch.Name)
+	if exchange == nil {
+		return
+	}
+
+	switch {
+	case oldExch.Enabled && !newExch.Enabled:
+		log.Printf("%s: Exchange disabled. Stopping exchange.\n", newExch.Name)
+		StopExchange(exchange)
+	case newExch.Enabled && !oldExch.Enabled:
+		log.Printf("%s: Exchange enabled. Starting exchange.\n", newExch.Name)
+		StartRoutine(func() { RestartExchange(bot.ctx, exchange, newExch) })
+	case newExch.Enabled:
+		log.Printf("%s: Config updated. Restarting exchange.\n", newExch.Name)
+		StartRoutine(func() { RestartExchange(bot.ctx, exchange, newExch) })
+	}
+}
+
+// SetExchangeEnabled enables or disables an exchange at runtime, starting or
+// stopping it as a config reload would, and saves the change to the config
+// file. The change is refused if the resulting config fails validation.
+func SetExchangeEnabled(name string, enabled bool) (Exchanges, error) {
+	configReloadMutex.Lock()
+	defer configReloadMutex.Unlock()
+
+	cfg := GetConfig()
+	oldExch, err := cfg.GetExchangeConfig(name)
+	if err != nil {
+		return Exchanges{}, err
+	}
+
+	exchanges := make([]Exchanges, len(cfg.Exchanges))
+	copy(exchanges, cfg.Exchanges)
+	cfg.Exchanges = exchanges
+	for i := range cfg.Exchanges {
+		if cfg.Exchanges[i].Name == name {
+			cfg.Exchanges[i].Enabled = enabled
+		}
+	}
+
+	err = cfg.CheckExchangeConfigValues()
+	if err != nil {
+		return Exchanges{}, err
+	}
+
+	newExch, _ := cfg.GetExchangeConfig(name)
+	err = UpdateExchangeConfig(newExch)
+	if err != nil {
+		return Exchanges{}, err
+	}
+	ApplyExchangeConfigChanges(oldExch, newExch)
+
+	err = SaveConfig()
+	if err != nil {
+		log.Printf("%s: Unable to save config. Error: %s\n", name, err)
+	}
+	return newExch, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+const (
+	CONFIG_SECRET_ENV_PREFIX  = "GCT_"
+	CONFIG_SECRET_FILE_SUFFIX = "_FILE"
+)
+
+// configSecret is a config field which may be supplied through the
+// environment instead of the config file.
+type configSecret struct {
+	Owner string
+	Field string
+	Value *string
+}
+
+// configSecretOverride remembers the value a secret had in the config file
+// before it was replaced from the environment, so it is never saved.
+type configSecretOverride struct {
+	Owner     string
+	Field     string
+	FileValue string
+}
+
+var configSecretOverrides []configSecretOverride
+
+// getConfigSecrets lists every secret in cfg which can be overridden.
+// Withdrawal approvers are named by their username, e.g.
+// GCT_WITHDRAWALS_APPROVER_ALICE_PASSWORD.
+func getConfigSecrets(cfg *Config) []configSecret {
+	secrets := []configSecret{}
+	for i := range cfg.Exchanges {
+		exch := &cfg.Exchanges[i]
+		secrets = append(secrets,
+			configSecret{exch.Name, CREDENTIAL_API_KEY, &exch.APIKey},
+			configSecret{exch.Name, CREDENTIAL_API_SECRET, &exch.APISecret},
+			configSecret{exch.Name, CREDENTIAL_CLIENT_ID, &exch.ClientID},
+		)
+	}
+
+	secrets = append(secrets,
+		configSecret{"SMSGlobal", "Password", &cfg.SMS.Password},
+		configSecret{"Webserver", "AdminPassword", &cfg.Webserver.AdminPassword},
+		configSecret{"GRPC", "Password", &cfg.GRPC.Password},
+		configSecret{"FIX", "Password", &cfg.FIX.Password},
+	)
+
+	for i := range cfg.Withdrawals.Approvers {
+		x := &cfg.Withdrawals.Approvers[i]
+		secrets = append(secrets, configSecret{"Withdrawals_Approver_" + x.Username, "Password", &x.Password})
+	}
+	return secrets
+}
+
+// GetSecretEnvName returns the environment variable name for a secret, e.g.
+// GCT_OKCOIN_INTERNATIONAL_APISECRET.
+func GetSecretEnvName(owner, field string) string {
+	name := StringToUpper(owner + "_" + field)
+	name = strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+	return CONFIG_SECRET_ENV_PREFIX + name
+}
+
+// GetSecretOverride looks up a secret from the environment variable NAME or
+// from the file named by NAME_FILE. Trailing newlines are trimmed from files.
+func GetSecretOverride(owner, field string) (string, bool) {
+	name := GetSecretEnvName(owner, field)
+	if value := os.Getenv(name); value != "" {
+		return value, true
+	}
+
+	path := os.Getenv(name + CONFIG_SECRET_FILE_SUFFIX)
+	if path == "" {
+		return "", false
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("Unable to read secret file %s for %s. Error: %s\n", path, name, err)
+		return "", false
+	}
+	return strings.TrimRight(string(data), "\r\n"), true
+}
+
+// ApplyConfigSecretOverrides replaces exchange credentials and the SMSGlobal,
+// Webserver, GRPC, FIX and withdrawal approver passwords with values supplied
+// through the environment or secret files.
+func ApplyConfigSecretOverrides(cfg *Config) {
+	configSecretOverrides = nil
+
+	for _, x := range getConfigSecrets(cfg) {
+		secret, ok := GetSecretOverride(x.Owner, x.Field)
+		if !ok {
+			continue
+		}
+
+		configSecretOverrides = append(configSecretOverrides, configSecretOverride{x.Owner, x.Field, *x.Value})
+		*x.Value = secret
+	}
+
+	if len(configSecretOverrides) > 0 {
+		log.Printf("Loaded %d secret(s) from the environment.\n", len(configSecretOverrides))
+	}
+}
+
+// RestoreConfigFileSecrets returns a copy of cfg with every overridden secret
+// set back to its config file value, so that secrets supplied through the
+// environment are never written to disk.
+func RestoreConfigFileSecrets(cfg Config) Config {
+	exchanges := make([]Exchanges, len(cfg.Exchanges))
+	copy(exchanges, cfg.Exchanges)
+	cfg.Exchanges = exchanges
+
+	approvers := make([]WithdrawalApprover, len(cfg.Withdrawals.Approvers))
+	copy(approvers, cfg.Withdrawals.Approvers)
+	cfg.Withdrawals.Approvers = approvers
+
+	secrets := getConfigSecrets(&cfg)
+	for _, x := range configSecretOverrides {
+		for _, y := range secrets {
+			if y.Owner == x.Owner && y.Field == x.Field {
+				*y.Value = x.FileValue
+			}
+		}
+	}
+	return cfg
+}
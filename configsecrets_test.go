@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigSecretOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	err := os.WriteFile(path, []byte("filesecret\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("GCT_OKCOIN_INTERNATIONAL_APISECRET", "envsecret")
+	t.Setenv("GCT_GRPC_PASSWORD", "grpcpass")
+	t.Setenv("GCT_WITHDRAWALS_APPROVER_ALICE_PASSWORD_FILE", path)
+	defer func() { configSecretOverrides = nil }()
+
+	cfg := Config{
+		Exchanges: []Exchanges{{Name: "OKCOIN International", APISecret: "Secret"}},
+		GRPC:      GRPCConfig{Password: "Password"},
+		Withdrawals: WithdrawalConfig{Approvers: []WithdrawalApprover{
+			{Username: "alice", Password: "Password"},
+			{Username: "bob", Password: "bobpass"},
+		}},
+	}
+	ApplyConfigSecretOverrides(&cfg)
+
+	if cfg.Exchanges[0].APISecret != "envsecret" {
+		t.Errorf("APISecret = %q, want envsecret", cfg.Exchanges[0].APISecret)
+	}
+
+	if cfg.GRPC.Password != "grpcpass" {
+		t.Errorf("GRPC.Password = %q, want grpcpass", cfg.GRPC.Password)
+	}
+
+	if cfg.Withdrawals.Approvers[0].Password != "filesecret" || cfg.Withdrawals.Approvers[1].Password != "bobpass" {
+		t.Errorf("Approvers = %+v, want alice's password from the file only", cfg.Withdrawals.Approvers)
+	}
+
+	saved := RestoreConfigFileSecrets(cfg)
+	if saved.Exchanges[0].APISecret != "Secret" || saved.GRPC.Password != "Password" || saved.Withdrawals.Approvers[0].Password != "Password" {
+		t.Errorf("RestoreConfigFileSecrets left an override in place: %+v", saved)
+	}
+
+	if cfg.Exchanges[0].APISecret != "envsecret" || cfg.Withdrawals.Approvers[0].Password != "filesecret" {
+		t.Error("RestoreConfigFileSecrets changed the running config")
+	}
+}
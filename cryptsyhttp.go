@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -141,7 +142,7 @@ func (c *Cryptsy) SetDefaults() {
 	c.TakerFee = 0.33
 	c.MakerFee = 0.33
 	c.Verbose = false
-	c.RESTPollingDelay = 10
+	c.RESTPollingDelay = 10 * time.Second
 	c.Market = make(map[string]CryptsyMarket)
 	c.Ticker = make(map[string]CryptsyTicker)
 	c.Volume = make(map[string]CryptsyVolume)
@@ -159,6 +160,26 @@ func (c *Cryptsy) IsEnabled() bool {
 	return c.Enabled
 }
 
+func (c *Cryptsy) Setup(exch Exchanges) {
+	if !exch.Enabled {
+		c.SetEnabled(false)
+	} else {
+		c.SetEnabled(true)
+		c.AuthenticatedAPISupport = exch.AuthenticatedAPISupport
+		c.SetAPIKeys(exch.APIKey, exch.APISecret)
+		c.RESTPollingDelay = exch.RESTPollingDelay.Duration
+		c.Verbose = exch.Verbose
+		c.Websocket = exch.Websocket
+		c.BaseCurrencies = exch.BaseCurrencies
+		c.AvailablePairs = CurrencyPairsToStrings(exch.AvailablePairs)
+		c.EnabledPairs = CurrencyPairsToStrings(exch.EnabledPairs)
+	}
+}
+
+func (c *Cryptsy) GetRequiredCredentials() []string {
+	return []string{CREDENTIAL_API_KEY, CREDENTIAL_API_SECRET}
+}
+
 func (c *Cryptsy) GetFee(maker bool) float64 {
 	if maker {
 		return c.MakerFee
@@ -167,15 +188,15 @@ func (c *Cryptsy) GetFee(maker bool) float64 {
 	}
 }
 
-func (c *Cryptsy) Run() {
+func (c *Cryptsy) Run(ctx context.Context) {
 	if c.Verbose {
 		log.Printf("%s Websocket: %s.", c.GetName(), IsEnabled(c.Websocket))
-		log.Printf("%s polling delay: %ds.\n", c.GetName(), c.RESTPollingDelay)
+		log.Printf("%s polling delay: %s.\n", c.GetName(), c.RESTPollingDelay)
 		log.Printf("%s %d currencies enabled: %s.\n", c.GetName(), len(c.EnabledPairs), c.EnabledPairs)
 	}
 
 	if c.Websocket {
-		go c.PusherClient()
+		StartRoutine(func() { c.PusherClient(ctx) })
 	}
 
 	err := c.GetMarkets()
@@ -193,13 +214,13 @@ func (c *Cryptsy) Run() {
 				log.Println(err)
 			} else {
 				log.Printf("%s Updating available pairs. Difference: %s.\n", c.Name, diff)
-				exch.AvailablePairs = JoinStrings(markets, ",")
+				exch.AvailablePairs = ParseCurrencyPairs(markets, exch.BaseCurrencies)
 				UpdateExchangeConfig(exch)
 			}
 		}
 	}
 
-	for c.Enabled {
+	for c.Enabled && ctx.Err() == nil {
 		err := c.GetMarkets()
 		if err != nil {
 			log.Println(err)
@@ -212,7 +233,7 @@ func (c *Cryptsy) Run() {
 				}
 			}
 		}
-		time.Sleep(time.Second * c.RESTPollingDelay)
+		SleepContext(ctx, c.RESTPollingDelay)
 	}
 }
 
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"github.com/toorop/go-pusher"
 	"log"
 	"time"
@@ -41,20 +42,26 @@ const (
 	CRYPTSY_PUSHER_KEY = "cb65d0a7a72cd94adf1f"
 )
 
-func (c *Cryptsy) PusherClient() {
-	for c.Enabled && c.Websocket {
+func (c *Cryptsy) PusherClient(ctx context.Context) {
+	defer SetWebsocketConnected(c.GetName(), false)
+
+	for c.Enabled && c.Websocket && ctx.Err() == nil {
 		pusherClient, err := pusher.NewClient(CRYPTSY_PUSHER_KEY)
 		if err != nil {
 			log.Printf("%s Unable to connect to Websocket. Error: %s\n", c.GetName(), err)
 			continue
 		}
 
+		SetWebsocketConnected(c.GetName(), true)
+
 		if c.Verbose {
 			log.Printf("%s Pusher client connected.\n", c.GetName())
 		}
 
 		for len(c.Market) == 0 {
-			time.Sleep(time.Second * 1)
+			if !SleepContext(ctx, time.Second*1) {
+				return
+			}
 		}
 
 		marketID := []string{}
@@ -90,6 +97,8 @@ func (c *Cryptsy) PusherClient() {
 
 		for c.Enabled && c.Websocket {
 			select {
+			case <-ctx.Done():
+				return
 			case data := <-dataChannel:
 				if StringContains(data.Data, "topbuy") {
 					result := CryptsyPusherTicker{}
@@ -48,6 +48,22 @@ var (
 	ErrQueryingYahoo          = errors.New("Unable to query Yahoo currency values.")
 )
 
+// currencyAliases maps exchange specific currency names to the common one.
+var currencyAliases = map[string]string{
+	"XBT": "BTC",
+	"XDG": "DOGE",
+}
+
+// GetCommonCurrencyName returns the name most exchanges use for a currency,
+// so that balances and transfers on different exchanges can be compared.
+func GetCommonCurrencyName(currency string) string {
+	currency = StringToUpper(currency)
+	if x, ok := currencyAliases[currency]; ok {
+		return x
+	}
+	return currency
+}
+
 func IsFiatCurrency(currency string) bool {
 	if StringContains(BaseCurrencies, StringToUpper(currency)) {
 		return true
@@ -56,19 +72,32 @@ func IsFiatCurrency(currency string) bool {
 }
 
 func IsCryptocurrency(currency string) bool {
-	if StringContains(bot.config.Cryptocurrencies, StringToUpper(currency)) {
+	if StringContains(GetConfig().Cryptocurrencies, StringToUpper(currency)) {
 		return true
 	}
 	return false
 }
 
 func RetrieveConfigCurrencyPairs(config Config) error {
+	SetConfigBaseCurrencies(config)
+	err := QueryYahooCurrencyValues(BaseCurrencies)
+
+	if err != nil {
+		return ErrQueryingYahoo
+	}
+
+	log.Println("Fetched currency value data.")
+	return nil
+}
+
+// SetConfigBaseCurrencies sets BaseCurrencies to the default fiat currencies
+// plus any other quote currency used by an enabled exchange.
+func SetConfigBaseCurrencies(config Config) {
 	currencyPairs := SplitStrings(DEFAULT_CURRENCIES, ",")
 	for _, exchange := range config.Exchanges {
 		if exchange.Enabled {
-			currencies := SplitStrings(exchange.EnabledPairs, ",")
-			for _, x := range currencies {
-				currency := x[len(x)-3:]
+			for _, x := range exchange.EnabledPairs {
+				currency := x.Quote
 				if !StringContains(DEFAULT_CURRENCIES, currency) && !IsCryptocurrency(currency) {
 					currencyPairs = append(currencyPairs, currency)
 				}
@@ -77,14 +106,6 @@ func RetrieveConfigCurrencyPairs(config Config) error {
 	}
 
 	BaseCurrencies = JoinStrings(currencyPairs, ",")
-	err := QueryYahooCurrencyValues(BaseCurrencies)
-
-	if err != nil {
-		return ErrQueryingYahoo
-	}
-
-	log.Println("Fetched currency value data.")
-	return nil
 }
 
 func MakecurrencyPairs(supportedCurrencies string) string {
@@ -142,3 +163,72 @@ func QueryYahooCurrencyValues(currencies string) error {
 
 	return nil
 }
+
+type CurrencyPair struct {
+	Base  string
+	Quote string
+}
+
+func (c CurrencyPair) String() string {
+	return c.Base + c.Quote
+}
+
+func (c CurrencyPair) IsEmpty() bool {
+	return c.Base == "" || c.Quote == ""
+}
+
+func NewCurrencyPair(base, quote string) CurrencyPair {
+	return CurrencyPair{Base: StringToUpper(base), Quote: StringToUpper(quote)}
+}
+
+// ParseCurrencyPair splits a concatenated pair such as "DASHBTC" into its base
+// and quote currencies. The longest matching suffix from quotes is used, falling
+// back to the last three characters. Quotes match regardless of case, but the
+// base keeps its casing as Cryptsy has mixed case tickers such as CAIxBTC.
+func ParseCurrencyPair(pair string, quotes []string) CurrencyPair {
+	upper := StringToUpper(pair)
+	quote := ""
+	for _, x := range quotes {
+		x = StringToUpper(x)
+		if len(x) > len(quote) && len(x) < len(upper) && strings.HasSuffix(upper, x) {
+			quote = x
+		}
+	}
+
+	if quote == "" {
+		if len(pair) <= 3 {
+			return CurrencyPair{Base: pair}
+		}
+		quote = upper[len(upper)-3:]
+	}
+	return CurrencyPair{Base: pair[:len(pair)-len(quote)], Quote: quote}
+}
+
+func ParseCurrencyPairs(pairs []string, baseCurrencies []string) []CurrencyPair {
+	quotes := []string{}
+	quotes = append(quotes, baseCurrencies...)
+	quotes = append(quotes, SplitStrings(GetConfig().Cryptocurrencies, ",")...)
+
+	result := []CurrencyPair{}
+	for _, x := range pairs {
+		result = append(result, ParseCurrencyPair(x, quotes))
+	}
+	return result
+}
+
+func CurrencyPairsToStrings(pairs []CurrencyPair) []string {
+	result := []string{}
+	for _, x := range pairs {
+		result = append(result, x.String())
+	}
+	return result
+}
+
+func ContainsCurrencyPair(pairs []CurrencyPair, pair CurrencyPair) bool {
+	for _, x := range pairs {
+		if x == pair {
+			return true
+		}
+	}
+	return false
+}
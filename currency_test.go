@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseCurrencyPair(t *testing.T) {
+	quotes := []string{"BTC", "USD", "usdt"}
+	tests := []struct {
+		pair  string
+		base  string
+		quote string
+	}{
+		{"DASHBTC", "DASH", "BTC"},
+		{"CAIxBTC", "CAIx", "BTC"},
+		{"USDeBTC", "USDe", "BTC"},
+		{"ethusdt", "eth", "USDT"},
+		{"XRPEUR", "XRP", "EUR"},
+		{"BTC", "BTC", ""},
+	}
+
+	for _, x := range tests {
+		pair := ParseCurrencyPair(x.pair, quotes)
+		if pair.Base != x.base || pair.Quote != x.quote {
+			t.Errorf("ParseCurrencyPair(%q) = %s/%s, want %s/%s", x.pair, pair.Base, pair.Quote, x.base, x.quote)
+		}
+	}
+}
+
+func TestMigrateConfigV0ToV1(t *testing.T) {
+	data := []byte(`{"DisplayCurrency":"USD","Cryptocurrencies":"BTC,LTC","Exchanges":[{"Name":"Cryptsy","BaseCurrencies":"USD","AvailablePairs":"CAIxBTC,LTCUSD","EnabledPairs":"","RESTPollingDelay":10}]}`)
+	migrated, version, err := MigrateConfig(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != 0 {
+		t.Errorf("version = %d, want 0", version)
+	}
+
+	cfg := Config{}
+	err = json.Unmarshal(migrated, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := make(map[string]interface{})
+	json.Unmarshal(migrated, &raw)
+	if _, ok := raw["DisplayCurrency"]; ok {
+		t.Error("DisplayCurrency was not dropped")
+	}
+
+	exch := cfg.Exchanges[0]
+	want := []CurrencyPair{{Base: "CAIx", Quote: "BTC"}, {Base: "LTC", Quote: "USD"}}
+	if len(exch.AvailablePairs) != len(want) || exch.AvailablePairs[0] != want[0] || exch.AvailablePairs[1] != want[1] {
+		t.Errorf("AvailablePairs = %v, want %v", exch.AvailablePairs, want)
+	}
+
+	if exch.RESTPollingDelay.Duration.Seconds() != 10 {
+		t.Errorf("RESTPollingDelay = %s, want 10s", exch.RESTPollingDelay.Duration)
+	}
+}
+
+func TestMigrateConfigVersions(t *testing.T) {
+	tests := []struct {
+		data  string
+		fails bool
+	}{
+		{`{"Version":-1}`, true},
+		{`{"Version":1000}`, true},
+		{`{"Version":"1"}`, false},
+		{`{}`, false},
+	}
+
+	for _, x := range tests {
+		_, _, err := MigrateConfig([]byte(x.data))
+		if (err != nil) != x.fails {
+			t.Errorf("MigrateConfig(%s) returned %v, want failure %t", x.data, err, x.fails)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	DOWNLOAD_REQUEST_DELAY   = time.Second
+	DOWNLOAD_MAX_RETRIES     = 3
+	DOWNLOAD_CANDLE_BATCH    = 1000
+	DOWNLOAD_PAGE_CANDLES    = 300
+	DOWNLOAD_PAGE_PERIOD     = 24 * time.Hour
+	ErrDownloadNotSupported  = "%s does not support downloading candles or trades."
+	ErrDownloadInvalidPeriod = "Download start %s must be before end %s."
+)
+
+// ICandleFetcher is implemented by exchanges with a candle endpoint. It
+// returns candles in time order from start, and may return fewer than the
+// whole range. The downloader pages by calling it again from the last candle.
+type ICandleFetcher interface {
+	GetHistoricCandles(pair CurrencyPair, start, end time.Time, interval time.Duration) ([]Candle, error)
+}
+
+// ITradeHistoryFetcher is implemented by exchanges able to list public trades
+// by date. Like ICandleFetcher it may return only the start of the range.
+type ITradeHistoryFetcher interface {
+	GetHistoricTrades(pair CurrencyPair, start, end time.Time) ([]TradeRecord, error)
+}
+
+// DownloadProgress is saved after every page so that an interrupted download
+// resumes from Cursor when run again with the same start.
+type DownloadProgress struct {
+	Start    time.Time
+	End      time.Time
+	Cursor   time.Time
+	Complete bool
+}
+
+type Downloader struct {
+	Storage  *Storage
+	Exchange IBotExchange
+	Pair     CurrencyPair
+	Interval time.Duration
+}
+
+// GetProgressName returns the name a download's progress is saved under.
+// Fill imports have no interval.
+func (d *Downloader) GetProgressName(kind string) string {
+	if d.Interval == 0 {
+		return GetStorageSeriesName(d.Exchange.GetName(), d.Pair) + "/" + kind
+	}
+	return GetCandleSeriesName(d.Exchange.GetName(), d.Pair, d.Interval) + "/" + kind
+}
+
+// Download backfills candles for [start, end). Exchanges without a candle
+// endpoint have their trades downloaded and built into candles instead.
+func (d *Downloader) Download(ctx context.Context, start, end time.Time) error {
+	start = start.Truncate(d.Interval)
+	if !start.Before(end) {
+		return fmt.Errorf(ErrDownloadInvalidPeriod, start, end)
+	}
+
+	if fetcher, ok := d.Exchange.(ICandleFetcher); ok {
+		// Pages are no longer than the fewest candles an exchange returns per
+		// request (Coinbase's 300), so an empty page is a gap in the data.
+		return d.page(ctx, "candles", start, end, d.Interval*DOWNLOAD_PAGE_CANDLES, func(cursor, pageEnd time.Time) (time.Time, int, error) {
+			candles, err := fetcher.GetHistoricCandles(d.Pair, cursor, pageEnd, d.Interval)
+			if err != nil {
+				return cursor, 0, err
+			}
+
+			candles = filterCandles(candles, cursor, pageEnd)
+			if len(candles) == 0 {
+				return pageEnd, 0, nil
+			}
+			return candles[len(candles)-1].Timestamp.Add(d.Interval), len(candles), d.Storage.AddCandles(d.Exchange.GetName(), d.Pair, d.Interval, candles)
+		})
+	}
+
+	if fetcher, ok := d.Exchange.(ITradeHistoryFetcher); ok {
+		err := d.page(ctx, "trades", start, end, DOWNLOAD_PAGE_PERIOD, func(cursor, pageEnd time.Time) (time.Time, int, error) {
+			trades, err := fetcher.GetHistoricTrades(d.Pair, cursor, pageEnd)
+			if err != nil {
+				return cursor, 0, err
+			}
+
+			trades = filterTrades(trades, cursor, pageEnd)
+			if len(trades) == 0 {
+				return pageEnd, 0, nil
+			}
+
+			next := trades[0].Timestamp
+			for _, x := range trades {
+				if x.Timestamp.After(next) {
+					next = x.Timestamp
+				}
+			}
+
+			// Trades sharing the last timestamp may continue on the next page,
+			// so it is fetched again. Stored trades are keyed by ID, so the
+			// overlap is not stored twice.
+			if !next.After(cursor) {
+				next = cursor.Add(time.Second)
+			}
+			return next, len(trades), d.Storage.AddTrades(d.Exchange.GetName(), d.Pair, trades)
+		})
+		if err != nil {
+			return err
+		}
+		return d.BuildCandlesFromTrades(start, end)
+	}
+	return fmt.Errorf(ErrDownloadNotSupported, d.Exchange.GetName())
+}
+
+// DownloadFills imports the account's fills for [start, end) into the trade
+// ledger. Paper trading exchanges import their paper fills.
+func (d *Downloader) DownloadFills(ctx context.Context, start, end time.Time) error {
+	if !start.Before(end) {
+		return fmt.Errorf(ErrDownloadInvalidPeriod, start, end)
+	}
+
+	fetcher, err := GetFillFetcher(d.Exchange)
+	if err != nil {
+		return err
+	}
+
+	return d.page(ctx, "fills", start, end, DOWNLOAD_PAGE_PERIOD, func(cursor, pageEnd time.Time) (time.Time, int, error) {
+		fills, err := fetcher.GetAccountFills(d.Pair, cursor, pageEnd)
+		if err != nil {
+			return cursor, 0, err
+		}
+
+		fills = filterFills(fills, cursor, pageEnd)
+		if len(fills) == 0 {
+			return pageEnd, 0, nil
+		}
+
+		// As with trades, the last timestamp is fetched again in case more
+		// fills share it.
+		next := fills[0].Timestamp
+		for _, x := range fills {
+			if x.Timestamp.After(next) {
+				next = x.Timestamp
+			}
+		}
+		if !next.After(cursor) {
+			next = cursor.Add(time.Second)
+		}
+		return next, len(fills), d.Storage.AddFills(fills)
+	})
+}
+
+// page repeatedly calls fetch from the saved cursor until the cursor reaches
+// end. Each page asks for at most window from the cursor. fetch returns the
+// cursor for the next page, which is the page's end when it was empty, and the
+// number of records stored.
+func (d *Downloader) page(ctx context.Context, kind string, start, end time.Time, window time.Duration, fetch func(cursor, pageEnd time.Time) (time.Time, int, error)) error {
+	name := d.GetProgressName(kind)
+	progress := DownloadProgress{}
+	found, err := d.Storage.GetDownloadProgress(name, &progress)
+	if err != nil {
+		return err
+	}
+
+	// A download with the same start continues from its cursor, so rerunning
+	// with a later end only fetches the new data.
+	if found && progress.Start.Equal(start) {
+		if !progress.Cursor.Before(end) {
+			log.Printf("%s: %s %s already downloaded up to %s.\n", d.Exchange.GetName(), d.Pair, kind, progress.Cursor)
+			return nil
+		}
+		log.Printf("%s: Resuming %s %s download from %s.\n", d.Exchange.GetName(), d.Pair, kind, progress.Cursor)
+		progress.End = end
+		progress.Complete = false
+	} else {
+		progress = DownloadProgress{Start: start, End: end, Cursor: start}
+	}
+
+	total := 0
+	for progress.Cursor.Before(end) {
+		pageEnd := progress.Cursor.Add(window)
+		if pageEnd.After(end) {
+			pageEnd = end
+		}
+
+		next, count, err := d.fetchWithRetry(ctx, fetch, progress.Cursor, pageEnd)
+		if err != nil {
+			return err
+		}
+
+		total += count
+		progress.Cursor = next
+		err = d.Storage.SetDownloadProgress(name, progress)
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			log.Printf("%s: Downloaded %d %s %s up to %s.\n", d.Exchange.GetName(), total, d.Pair, kind, progress.Cursor)
+		}
+		if !SleepContext(ctx, DOWNLOAD_REQUEST_DELAY) {
+			return ctx.Err()
+		}
+	}
+
+	progress.Complete = true
+	return d.Storage.SetDownloadProgress(name, progress)
+}
+
+func (d *Downloader) fetchWithRetry(ctx context.Context, fetch func(cursor, pageEnd time.Time) (time.Time, int, error), cursor, pageEnd time.Time) (time.Time, int, error) {
+	var err error
+	for i := 0; i < DOWNLOAD_MAX_RETRIES; i++ {
+		if ctx.Err() != nil {
+			return cursor, 0, ctx.Err()
+		}
+
+		var next time.Time
+		var count int
+		next, count, err = fetch(cursor, pageEnd)
+		if err == nil {
+			return next, count, nil
+		}
+
+		if IsCandleIntervalError(err) {
+			return cursor, 0, err
+		}
+
+		log.Printf("%s: Download request failed, retrying. Error: %s\n", d.Exchange.GetName(), err)
+		SleepContext(ctx, DOWNLOAD_REQUEST_DELAY*time.Duration(i+1))
+	}
+	return cursor, 0, err
+}
+
+// BuildCandlesFromTrades rebuilds stored candles for [start, end) from the
+// stored trades, a batch of candles at a time.
+func (d *Downloader) BuildCandlesFromTrades(start, end time.Time) error {
+	batch := d.Interval * DOWNLOAD_CANDLE_BATCH
+	built := 0
+	for from := start; from.Before(end); from = from.Add(batch) {
+		trades, err := d.Storage.GetTrades(d.Exchange.GetName(), d.Pair, from, from.Add(batch-1))
+		if err != nil {
+			return err
+		}
+
+		candles := BuildCandles(trades, d.Interval)
+		err = d.Storage.AddCandles(d.Exchange.GetName(), d.Pair, d.Interval, candles)
+		if err != nil {
+			return err
+		}
+		built += len(candles)
+	}
+
+	log.Printf("%s: Built %d %s %s candles from trades.\n", d.Exchange.GetName(), built, d.Pair, d.Interval)
+	return nil
+}
+
+func filterCandles(candles []Candle, start, end time.Time) []Candle {
+	SortCandles(candles)
+	result := []Candle{}
+	for _, x := range candles {
+		if !x.Timestamp.Before(start) && x.Timestamp.Before(end) {
+			result = append(result, x)
+		}
+	}
+	return result
+}
+
+func filterTrades(trades []TradeRecord, start, end time.Time) []TradeRecord {
+	result := []TradeRecord{}
+	for _, x := range trades {
+		if !x.Timestamp.Before(start) && x.Timestamp.Before(end) {
+			result = append(result, x)
+		}
+	}
+	return result
+}
+
+func filterFills(fills []AccountFill, start, end time.Time) []AccountFill {
+	result := []AccountFill{}
+	for _, x := range fills {
+		if !x.Timestamp.Before(start) && x.Timestamp.Before(end) {
+			result = append(result, x)
+		}
+	}
+	return result
+}
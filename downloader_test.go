@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCandleExchange serves candles from a fixed series, at most three a
+// request, and keeps the ranges requested.
+type testCandleExchange struct {
+	Bitstamp
+	candles  []Candle
+	requests [][2]time.Time
+}
+
+func (e *testCandleExchange) GetHistoricCandles(pair CurrencyPair, start, end time.Time, interval time.Duration) ([]Candle, error) {
+	e.requests = append(e.requests, [2]time.Time{start, end})
+	result := []Candle{}
+	for _, x := range e.candles {
+		if !x.Timestamp.Before(start) && x.Timestamp.Before(end) && len(result) < 3 {
+			result = append(result, x)
+		}
+	}
+	return result, nil
+}
+
+func TestDownloadCandleGaps(t *testing.T) {
+	storage, err := OpenStorage(filepath.Join(t.TempDir(), "storage.db"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour * 400)
+	exchange := &testCandleExchange{}
+	exchange.SetDefaults()
+	// Nothing is returned for the first page, so the download only finds
+	// these by carrying on past it.
+	for i := 350; i < 355; i++ {
+		exchange.candles = append(exchange.candles, Candle{Timestamp: start.Add(time.Hour * time.Duration(i)), Close: float64(i)})
+	}
+
+	pair := NewCurrencyPair("BTC", "USD")
+	d := Downloader{storage, exchange, pair, time.Hour}
+	err = d.Download(context.Background(), start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	candles, err := storage.GetCandles(exchange.GetName(), pair, time.Hour, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candles) != 5 || candles[0].Close != 350 || candles[4].Close != 354 {
+		t.Errorf("stored candles = %+v, want the 5 after the gap", candles)
+	}
+
+	progress := DownloadProgress{}
+	found, err := storage.GetDownloadProgress(d.GetProgressName("candles"), &progress)
+	if err != nil || !found || !progress.Complete || !progress.Cursor.Equal(end) {
+		t.Errorf("progress = %+v %t %v, want complete up to %s", progress, found, err, end)
+	}
+
+	for _, x := range exchange.requests {
+		if x[1].Sub(x[0]) > time.Hour*DOWNLOAD_PAGE_CANDLES {
+			t.Errorf("requested %s to %s, longer than a page", x[0], x[1])
+		}
+	}
+
+	requests := len(exchange.requests)
+	err = d.Download(context.Background(), start, end)
+	if err != nil || len(exchange.requests) != requests {
+		t.Errorf("downloading again returned %v after %d requests, want none", err, len(exchange.requests)-requests)
+	}
+}
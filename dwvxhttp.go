@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"github.com/gorilla/websocket"
 	"log"
+	"strconv"
 	"time"
 )
 
@@ -34,7 +37,7 @@ func (d *DWVX) SetDefaults() {
 	d.Enabled = true
 	d.Verbose = false
 	d.Websocket = false
-	d.RESTPollingDelay = 10
+	d.RESTPollingDelay = 10 * time.Second
 	d.DepositAddresses = make(map[string]string)
 }
 
@@ -50,21 +53,41 @@ func (d *DWVX) IsEnabled() bool {
 	return d.Enabled
 }
 
+func (d *DWVX) Setup(exch Exchanges) {
+	if !exch.Enabled {
+		d.SetEnabled(false)
+	} else {
+		d.SetEnabled(true)
+		d.AuthenticatedAPISupport = exch.AuthenticatedAPISupport
+		d.SetAPIKeys(exch.ClientID, exch.APIKey, exch.APISecret)
+		d.RESTPollingDelay = exch.RESTPollingDelay.Duration
+		d.Verbose = exch.Verbose
+		d.Websocket = exch.Websocket
+		d.BaseCurrencies = exch.BaseCurrencies
+		d.AvailablePairs = CurrencyPairsToStrings(exch.AvailablePairs)
+		d.EnabledPairs = CurrencyPairsToStrings(exch.EnabledPairs)
+	}
+}
+
+func (d *DWVX) GetRequiredCredentials() []string {
+	return []string{CREDENTIAL_API_KEY, CREDENTIAL_API_SECRET, CREDENTIAL_CLIENT_ID}
+}
+
 func (d *DWVX) SetAPIKeys(userID, apiKey, apiSecret string) {
 	d.API.APIKey = apiKey
 	d.API.APISecret = apiSecret
 	d.API.UserID = userID
 }
 
-func (d *DWVX) Run() {
+func (d *DWVX) Run(ctx context.Context) {
 	if d.Verbose {
 		log.Printf("%s Websocket: %s. (url: %s).\n", d.GetName(), IsEnabled(d.Websocket), DWVX_WEBSOCKET_URL)
-		log.Printf("%s polling delay: %ds.\n", d.GetName(), d.RESTPollingDelay)
+		log.Printf("%s polling delay: %s.\n", d.GetName(), d.RESTPollingDelay)
 		log.Printf("%s %d currencies enabled: %s.\n", d.GetName(), len(d.EnabledPairs), d.EnabledPairs)
 	}
 
 	if d.Websocket {
-		go d.WebsocketClient()
+		StartRoutine(func() { d.WebsocketClient(ctx) })
 	}
 
 	products, err := d.GetProductPairs()
@@ -83,13 +106,13 @@ func (d *DWVX) Run() {
 				log.Println(err)
 			} else {
 				log.Printf("%s Updating available pairs. Difference: %s.\n", d.Name, diff)
-				exch.AvailablePairs = JoinStrings(availProducts, ",")
+				exch.AvailablePairs = ParseCurrencyPairs(availProducts, exch.BaseCurrencies)
 				UpdateExchangeConfig(exch)
 			}
 		}
 	}
 
-	for d.Enabled {
+	for d.Enabled && ctx.Err() == nil {
 		for _, x := range d.EnabledPairs {
 			currency := x
 			go func() {
@@ -102,7 +125,7 @@ func (d *DWVX) Run() {
 				AddExchangeInfo(d.GetName(), currency[0:3], currency[3:], ticker.Last, ticker.Volume)
 			}()
 		}
-		time.Sleep(time.Second * d.RESTPollingDelay)
+		SleepContext(ctx, d.RESTPollingDelay)
 	}
 }
 
@@ -154,8 +177,30 @@ func (d *DWVX) GetDepositAddresses() error {
 	return nil
 }
 
-func (d *DWVX) WithdrawCoins(symbol, product string, amount float64, address string) error {
-	return d.API.WithdrawCoins(symbol, product, amount, address)
+func (d *DWVX) GetCryptoDepositAddress(currency string) (string, error) {
+	result, err := d.API.GetDepositAddresses()
+	if err != nil {
+		return "", err
+	}
+
+	for _, x := range result {
+		if x.Name == currency && x.DepositAddress != "" {
+			return x.DepositAddress, nil
+		}
+	}
+	return "", fmt.Errorf(ErrDepositCurrencyNotSupported, d.GetName(), currency)
+}
+
+// withdraw sends currency under the first enabled pair it is the base of, as
+// Alphapoint withdrawals name an instrument as well as the product. No ID is
+// returned.
+func (d *DWVX) withdraw(currency, address string, amount float64) (string, error) {
+	for _, x := range d.EnabledPairs {
+		if ParseCurrencyPair(x, d.BaseCurrencies).Base == currency {
+			return "", d.API.withdrawCoins(x, currency, amount, address)
+		}
+	}
+	return "", fmt.Errorf(ErrWithdrawalCurrencyNotSupported, d.GetName(), currency)
 }
 
 func (d *DWVX) CreateOrder(symbol, side string, orderType int, quantity, price float64) (int64, error) {
@@ -174,6 +219,122 @@ func (d *DWVX) CancelAllOrders(symbol string) error {
 	return d.API.CancelAllOrders(symbol)
 }
 
+func (d *DWVX) CancelAllOpenOrders() error {
+	for _, x := range d.EnabledPairs {
+		err := d.CancelAllOrders(x)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DWVX) GetTickerPrice(pair CurrencyPair) (TickerPrice, error) {
+	ticker, err := d.GetTicker(pair.String())
+	if err != nil {
+		return TickerPrice{}, err
+	}
+	return TickerPrice{pair.Base, pair.Quote, ticker.Last, ticker.High, ticker.Low, ticker.Bid, ticker.Ask, ticker.Volume}, nil
+}
+
+func (d *DWVX) GetOrderbookDepth(pair CurrencyPair) (Orderbook, error) {
+	orderbook, err := d.GetOrderbook(pair.String())
+	if err != nil {
+		return Orderbook{}, err
+	}
+
+	result := Orderbook{Pair: pair}
+	for _, x := range orderbook.Bids {
+		result.Bids = append(result.Bids, OrderbookItem{x.Price, x.Quantity})
+	}
+	for _, x := range orderbook.Asks {
+		result.Asks = append(result.Asks, OrderbookItem{x.Price, x.Quantity})
+	}
+	return result, nil
+}
+
+func (d *DWVX) GetAccountBalances() ([]AccountBalance, error) {
+	info, err := d.GetAccountInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []AccountBalance{}
+	for _, x := range info.Currencies {
+		balance, hold := float64(x.Balance), float64(x.Hold)
+		result = append(result, AccountBalance{x.Name, balance, balance - hold, hold})
+	}
+	return result, nil
+}
+
+func (d *DWVX) GetOpenOrderDetails() ([]OrderDetail, error) {
+	orders, err := d.GetOrders()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []OrderDetail{}
+	for _, x := range orders {
+		for _, order := range x.Openorders {
+			side := ORDER_SIDE_BUY
+			if order.Side == 1 {
+				side = ORDER_SIDE_SELL
+			}
+			result = append(result, OrderDetail{
+				ID:     strconv.Itoa(order.Serverorderid),
+				Pair:   ParseCurrencyPair(StringToUpper(x.Instrument), d.BaseCurrencies),
+				Side:   side,
+				Price:  float64(order.Price),
+				Amount: float64(order.QtyTotal),
+				Filled: float64(order.QtyTotal - order.QtyRemaining),
+				Status: "open",
+			})
+		}
+	}
+	return result, nil
+}
+
+// SubmitOrder places a limit order, which is Alphapoint order type 1.
+func (d *DWVX) SubmitOrder(pair CurrencyPair, side string, amount, price float64) (string, error) {
+	orderID, err := d.CreateOrder(pair.String(), StringToLower(side), 1, amount, price)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(orderID, 10), nil
+}
+
+func (d *DWVX) CancelOrderByID(pair CurrencyPair, orderID string) error {
+	id, err := strconv.ParseInt(orderID, 10, 64)
+	if err != nil {
+		return err
+	}
+	_, err = d.CancelOrder(pair.String(), id)
+	return err
+}
+
+func (d *DWVX) GetHistoricTrades(pair CurrencyPair, start, end time.Time) ([]TradeRecord, error) {
+	trades, err := d.GetTradesByDate(pair.String(), start.Unix(), end.Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	result := []TradeRecord{}
+	for _, x := range trades.Trades {
+		side := ORDER_SIDE_BUY
+		if x.IncomingOrderSide == 1 {
+			side = ORDER_SIDE_SELL
+		}
+		result = append(result, TradeRecord{
+			Timestamp: time.Unix(int64(x.Unixtime), 0),
+			ID:        strconv.FormatInt(x.TID, 10),
+			Price:     x.Price,
+			Amount:    x.Quantity,
+			Side:      side,
+		})
+	}
+	return result, nil
+}
+
 func (d *DWVX) GetOrders() ([]AlphapointOpenOrders, error) {
 	return d.API.GetOrders()
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"github.com/gorilla/websocket"
 	"log"
 	"net/http"
@@ -10,8 +11,10 @@ const (
 	DWVX_WEBSOCKET_URL = "wss://api.dwvx.com.au:8401/v1/GetTicker/"
 )
 
-func (d *DWVX) WebsocketClient() {
-	for d.Enabled && d.Websocket {
+func (d *DWVX) WebsocketClient(ctx context.Context) {
+	defer SetWebsocketConnected(d.Name, false)
+
+	for d.Enabled && d.Websocket && ctx.Err() == nil {
 		var Dialer websocket.Dialer
 		var err error
 		d.WebsocketConn, _, err = Dialer.Dial(DWVX_WEBSOCKET_URL, http.Header{})
@@ -21,6 +24,10 @@ func (d *DWVX) WebsocketClient() {
 			continue
 		}
 
+		stopClose := CloseOnDone(ctx, d.WebsocketConn)
+
+		SetWebsocketConnected(d.Name, true)
+
 		if d.Verbose {
 			log.Printf("%s Connected to Websocket.\n", d.Name)
 		}
@@ -63,7 +70,9 @@ func (d *DWVX) WebsocketClient() {
 				}
 			}
 		}
+		stopClose()
 		d.WebsocketConn.Close()
 		log.Printf("%s Websocket client disconnected.", d.Name)
+		SetWebsocketConnected(d.Name, false)
 	}
 }
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
 	"strconv"
+	"sync"
+	"time"
 )
 
 const (
@@ -16,6 +22,8 @@ const (
 	IS_EQUAL              = "=="
 	ACTION_SMS_NOTIFY     = "SMS"
 	ACTION_CONSOLE_PRINT  = "CONSOLE_PRINT"
+	EVENT_CHECK_INTERVAL  = time.Second
+	EVENTS_FILE           = "events.json"
 )
 
 var (
@@ -39,6 +47,12 @@ type Event struct {
 
 var Events []*Event
 
+// eventsMutex guards Events, which the API changes while CheckEvents runs.
+var eventsMutex sync.Mutex
+
+// EventsFile is the path events are persisted to, set with the -events flag.
+var EventsFile = EVENTS_FILE
+
 func AddEvent(Exchange, Item, Condition, CryptoCurrency, FiatCurrency, Action string) (int, error) {
 	err := IsValidEvent(Exchange, Item, Condition, CryptoCurrency, FiatCurrency, Action)
 
@@ -46,12 +60,15 @@ func AddEvent(Exchange, Item, Condition, CryptoCurrency, FiatCurrency, Action st
 		return 0, err
 	}
 
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+
 	Event := &Event{}
 
-	if len(Events) == 0 {
-		Event.ID = 0
-	} else {
-		Event.ID = len(Events) + 1
+	for _, x := range Events {
+		if x.ID >= Event.ID {
+			Event.ID = x.ID + 1
+		}
 	}
 
 	Event.Exchange = Exchange
@@ -66,6 +83,9 @@ func AddEvent(Exchange, Item, Condition, CryptoCurrency, FiatCurrency, Action st
 }
 
 func RemoveEvent(EventID int) bool {
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+
 	for i, x := range Events {
 		if x.ID == EventID {
 			Events = append(Events[:i], Events[i+1:]...)
@@ -75,7 +95,57 @@ func RemoveEvent(EventID int) bool {
 	return false
 }
 
+// LoadEvents reads saved events from EventsFile. A missing file is not an
+// error.
+func LoadEvents() error {
+	file, err := ioutil.ReadFile(EventsFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	events := []*Event{}
+	err = json.Unmarshal(file, &events)
+	if err != nil {
+		return err
+	}
+
+	eventsMutex.Lock()
+	Events = events
+	eventsMutex.Unlock()
+	return nil
+}
+
+func SaveEvents() error {
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+
+	payload, err := json.MarshalIndent(Events, "", " ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(EventsFile, payload, 0644)
+}
+
+// GetEvents returns a copy of the current events.
+func GetEvents() []Event {
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+
+	events := []Event{}
+	for _, x := range Events {
+		events = append(events, *x)
+	}
+	return events
+}
+
 func GetEventCounter() (int, int) {
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+
 	total := len(Events)
 	executed := 0
 
@@ -110,9 +180,20 @@ func (e *Event) EventToString() string {
 }
 
 func (e *Event) CheckCondition() bool {
+	if e.Item != ITEM_PRICE {
+		spec, err := ParseIndicatorSpec(e.Item)
+		if err != nil {
+			return false
+		}
+
+		value, ok := GetIndicatorValue(e.Exchange, NewCurrencyPair(e.CryptoCurrency, e.FiatCurrency), spec)
+		if !ok {
+			return false
+		}
+		return e.CheckValue(value)
+	}
+
 	lastPrice := 0.00
-	condition := SplitStrings(e.Condition, ",")
-	targetPrice, _ := strconv.ParseFloat(condition[1], 64)
 
 	/* to-do: add event handling for all currencies and fiat currencies */
 	if bot.exchange.bitfinex.GetName() == e.Exchange {
@@ -171,41 +252,54 @@ func (e *Event) CheckCondition() bool {
 	} else if bot.exchange.anx.GetName() == e.Exchange {
 		lastPrice = bot.exchange.anx.GetTicker("BTCUSD").Data.Last.Value
 	} else if bot.exchange.kraken.GetName() == e.Exchange {
-		lastPrice = bot.exchange.kraken.Ticker["XBTUSD"].Last
+		result, err := bot.exchange.kraken.GetTickerPrice(NewCurrencyPair("XBT", "USD"))
+		if err != nil {
+			lastPrice = 0
+		} else {
+			lastPrice = result.Last
+		}
 	}
 
 	if lastPrice == 0 {
 		return false
 	}
+	return e.CheckValue(lastPrice)
+}
+
+// CheckValue compares value against the event's condition and executes the
+// action if it is met.
+func (e *Event) CheckValue(value float64) bool {
+	condition := SplitStrings(e.Condition, ",")
+	target, _ := strconv.ParseFloat(condition[1], 64)
 
 	switch condition[0] {
 	case GREATER_THAN:
 		{
-			if lastPrice > targetPrice {
+			if value > target {
 				return e.ExecuteAction()
 			}
 		}
 	case GREATER_THAN_OR_EQUAL:
 		{
-			if lastPrice >= targetPrice {
+			if value >= target {
 				return e.ExecuteAction()
 			}
 		}
 	case LESS_THAN:
 		{
-			if lastPrice < targetPrice {
+			if value < target {
 				return e.ExecuteAction()
 			}
 		}
 	case LESS_THAN_OR_EQUAL:
 		{
-			if lastPrice <= targetPrice {
+			if value <= target {
 				return e.ExecuteAction()
 			}
 		}
 	case IS_EQUAL:
 		{
-			if lastPrice == targetPrice {
+			if value == target {
 				return e.ExecuteAction()
 			}
 		}
@@ -254,23 +348,33 @@ func IsValidEvent(Exchange, Item, Condition, CryptoCurrency, FiatCurrency, Actio
 	return nil
 }
 
-func CheckEvents() {
-	for {
-		total, executed := GetEventCounter()
-		if total > 0 && executed != total {
-			for _, event := range Events {
-				if !event.Executed {
-					success := event.CheckCondition()
-					if success {
-						log.Printf("Event %d triggered on %s successfully.\n", event.ID, event.Exchange)
-						event.Executed = true
-					}
+func CheckEvents(ctx context.Context) {
+	for SleepContext(ctx, EVENT_CHECK_INTERVAL) {
+		for _, event := range GetEvents() {
+			if !event.Executed {
+				success := event.CheckCondition()
+				if success {
+					log.Printf("Event %d triggered on %s successfully.\n", event.ID, event.Exchange)
+					SetEventExecuted(event.ID)
 				}
 			}
 		}
 	}
 }
 
+// SetEventExecuted marks an event as triggered. Events removed in the meantime
+// are ignored.
+func SetEventExecuted(EventID int) {
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+
+	for _, x := range Events {
+		if x.ID == EventID {
+			x.Executed = true
+		}
+	}
+}
+
 func IsValidExchange(Exchange string) bool {
 	if bot.exchange.bitfinex.GetName() == Exchange && bot.exchange.bitfinex.IsEnabled() ||
 		bot.exchange.bitstamp.GetName() == Exchange && bot.exchange.bitstamp.IsEnabled() ||
@@ -309,10 +413,13 @@ func IsValidAction(Action string) bool {
 	return false
 }
 
+// IsValidItem accepts PRICE or an indicator such as RSI(14)@1h.
 func IsValidItem(Item string) bool {
 	switch Item {
 	case ITEM_PRICE:
 		return true
 	}
-	return false
+
+	_, err := ParseIndicatorSpec(Item)
+	return err == nil
 }
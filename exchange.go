@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/thrasher-/socketio"
+)
+
+const (
+	CREDENTIAL_API_KEY             = "APIKey"
+	CREDENTIAL_API_SECRET          = "APISecret"
+	CREDENTIAL_CLIENT_ID           = "ClientID"
+	ORDER_SIDE_BUY                 = "BUY"
+	ORDER_SIDE_SELL                = "SELL"
+	ErrExchangeFeatureNotSupported = "%s does not support %s."
+	ErrExchangePairNotSupported    = "%s does not support currency pair %s."
+	ErrInvalidOrderSide            = "Invalid order side %s. Use BUY or SELL."
+	ErrDepositCurrencyNotSupported = "%s does not support depositing %s."
+	FUNDING_DEPOSIT                = "DEPOSIT"
+	FUNDING_WITHDRAWAL             = "WITHDRAWAL"
+	FUNDING_STATUS_PENDING         = "PENDING"
+	FUNDING_STATUS_COMPLETE        = "COMPLETE"
+	FUNDING_STATUS_CANCELLED       = "CANCELLED"
+)
+
+type IBotExchange interface {
+	SetDefaults()
+	Setup(exch Exchanges)
+	Run(ctx context.Context)
+	GetName() string
+	SetEnabled(bool)
+	IsEnabled() bool
+	GetRequiredCredentials() []string
+}
+
+// IOrderCanceller is implemented by exchanges able to cancel every open order
+// in a single call. It is used to flatten open orders on shutdown.
+type IOrderCanceller interface {
+	CancelAllOpenOrders() error
+}
+
+type AccountBalance struct {
+	Currency  string
+	Total     float64
+	Available float64
+	Hold      float64
+}
+
+type OrderDetail struct {
+	ID     string
+	Pair   CurrencyPair
+	Side   string
+	Price  float64
+	Amount float64
+	Filled float64
+	Status string
+}
+
+// AccountFill is one of the account's own trades as recorded in the trade
+// ledger. Fees are positive and charged in FeeCurrency.
+type AccountFill struct {
+	Exchange    string
+	ID          string
+	OrderID     string
+	Timestamp   time.Time
+	Pair        CurrencyPair
+	Side        string
+	Price       float64
+	Amount      float64
+	Fee         float64
+	FeeCurrency string
+}
+
+// The following interfaces expose exchange functionality in a common form so
+// that callers such as the CLI don't need to know each exchange's API.
+// Exchanges implement whichever of them their API supports.
+
+type ITickerFetcher interface {
+	GetTickerPrice(pair CurrencyPair) (TickerPrice, error)
+}
+
+type IOrderbookFetcher interface {
+	GetOrderbookDepth(pair CurrencyPair) (Orderbook, error)
+}
+
+type IBalanceFetcher interface {
+	GetAccountBalances() ([]AccountBalance, error)
+}
+
+// IOrderManager places and cancels limit orders. Order IDs are passed around
+// as strings since some exchanges use non-numeric IDs.
+type IOrderManager interface {
+	GetOpenOrderDetails() ([]OrderDetail, error)
+	SubmitOrder(pair CurrencyPair, side string, amount, price float64) (string, error)
+	CancelOrderByID(pair CurrencyPair, orderID string) error
+}
+
+// IOrderReplacer is implemented by exchanges able to cancel an order and
+// place its replacement in a single call. It returns the new order's ID. On
+// failure it returns orderID, which may still be open, unless the order is
+// known to have been cancelled, when the ID is empty.
+type IOrderReplacer interface {
+	ReplaceOrderByID(pair CurrencyPair, orderID, side string, amount, price float64) (string, error)
+}
+
+// IStopOrderer is implemented by exchanges with native stop orders, which
+// conditional orders are placed as instead of being watched by the bot. A
+// trailingPercent above zero places a trailing stop in place of stopPrice,
+// and a price of zero places a market order when the stop triggers.
+type IStopOrderer interface {
+	SubmitStopOrder(pair CurrencyPair, side string, amount, stopPrice, price, trailingPercent float64) (string, error)
+	CancelStopOrderByID(pair CurrencyPair, orderID string) error
+}
+
+// FundingMovement is a deposit to or withdrawal from an exchange account as
+// recorded in the funding ledger. Amount and Fee are positive and in Currency.
+// TxID is the blockchain transaction ID where the exchange reports one.
+type FundingMovement struct {
+	Exchange  string
+	ID        string
+	Type      string
+	Status    string
+	Timestamp time.Time
+	Currency  string
+	Amount    float64
+	Fee       float64
+	Address   string
+	TxID      string
+}
+
+// IFundingHistoryFetcher is implemented by exchanges able to list the
+// account's deposits and withdrawals. It returns every movement within
+// [start, end] in any currency, including pending ones.
+type IFundingHistoryFetcher interface {
+	GetFundingHistory(start, end time.Time) ([]FundingMovement, error)
+}
+
+// IFillFetcher is implemented by exchanges able to list the account's own
+// fills for a pair. Like ITradeHistoryFetcher it may return only the start of
+// the range.
+type IFillFetcher interface {
+	GetAccountFills(pair CurrencyPair, start, end time.Time) ([]AccountFill, error)
+}
+
+// IWithdrawer is implemented by exchanges able to send currency off the
+// exchange. Its method is unexported so that withdrawals are only made through
+// RequestWithdrawal and ApproveWithdrawal. It returns the exchange's ID for the
+// withdrawal where there is one.
+type IWithdrawer interface {
+	withdraw(currency, address string, amount float64) (string, error)
+}
+
+// IDepositAddressFetcher is implemented by exchanges able to give the address
+// to deposit a cryptocurrency to.
+type IDepositAddressFetcher interface {
+	GetCryptoDepositAddress(currency string) (string, error)
+}
+
+// Wallet is one of the internal wallets or sub-accounts an exchange account
+// holds funds in. ID is what TransferBetweenWallets takes, and Name is shown
+// to people.
+type Wallet struct {
+	Exchange string
+	ID       string
+	Name     string
+	Balances []AccountBalance
+}
+
+// IWalletManager is implemented by exchanges whose accounts hold funds in
+// several wallets or sub-accounts, moving funds between them without leaving
+// the exchange.
+type IWalletManager interface {
+	ListWallets() ([]Wallet, error)
+	TransferBetweenWallets(currency string, amount float64, from, to string) error
+}
+
+// IWalletCreator is implemented by exchanges able to open another wallet or
+// sub-account. Some exchanges hold a single currency per sub-account. It
+// returns the new wallet's ID.
+type IWalletCreator interface {
+	CreateNewWallet(name, currency string) (string, error)
+}
+
+func NewExchangeFeatureError(exchange IBotExchange, feature string) error {
+	return fmt.Errorf(ErrExchangeFeatureNotSupported, exchange.GetName(), feature)
+}
+
+func IsValidOrderSide(side string) error {
+	if side != ORDER_SIDE_BUY && side != ORDER_SIDE_SELL {
+		return fmt.Errorf(ErrInvalidOrderSide, side)
+	}
+	return nil
+}
+
+// GetExchangeFee returns an exchange's trading fee for a pair as a percentage.
+// Exchanges don't agree on a GetFee signature, so each form is handled here.
+// Exchanges without a fee return zero.
+func GetExchangeFee(exchange IBotExchange, pair CurrencyPair, maker bool) float64 {
+	switch e := exchange.(type) {
+	case *Kraken:
+		return e.GetFee(!IsFiatCurrency(pair.Quote))
+	case interface {
+		GetFee(maker bool) float64
+	}:
+		return e.GetFee(maker)
+	case interface {
+		GetFee() float64
+	}:
+		return e.GetFee()
+	}
+	return 0
+}
+
+// WebsocketStatus is the state of an exchange's websocket connection and
+// when it last changed.
+type WebsocketStatus struct {
+	Connected bool
+	Since     time.Time
+}
+
+var websocketStatus = struct {
+	sync.Mutex
+	status map[string]WebsocketStatus
+}{status: make(map[string]WebsocketStatus)}
+
+// SetWebsocketConnected records an exchange's websocket client connecting or
+// disconnecting.
+func SetWebsocketConnected(exchange string, connected bool) {
+	websocketStatus.Lock()
+	defer websocketStatus.Unlock()
+
+	current, ok := websocketStatus.status[exchange]
+	if ok && current.Connected == connected {
+		return
+	}
+	websocketStatus.status[exchange] = WebsocketStatus{connected, time.Now()}
+}
+
+// IgnoreSocketIOOnDone wraps the handlers of socket so that anything received
+// once ctx is cancelled is dropped. socketio doesn't expose its connection, so
+// unlike CloseOnDone this can't unblock ConnectToSocket, which returns once
+// the server disconnects.
+func IgnoreSocketIOOnDone(ctx context.Context, socket *socketio.SocketIO) *socketio.SocketIO {
+	wrapped := *socket
+	wrapped.OnEvent = make(map[string]func(message []byte, output chan socketio.Message))
+	for name, handler := range socket.OnEvent {
+		handler := handler
+		wrapped.OnEvent[name] = func(message []byte, output chan socketio.Message) {
+			if ctx.Err() == nil {
+				handler(message, output)
+			}
+		}
+	}
+
+	if socket.OnConnect != nil {
+		wrapped.OnConnect = func(output chan socketio.Message) {
+			if ctx.Err() == nil {
+				socket.OnConnect(output)
+			}
+		}
+	}
+
+	if socket.OnMessage != nil {
+		wrapped.OnMessage = func(message []byte, output chan socketio.Message) {
+			if ctx.Err() == nil {
+				socket.OnMessage(message, output)
+			}
+		}
+	}
+
+	if socket.OnError != nil {
+		wrapped.OnError = func() {
+			if ctx.Err() == nil {
+				socket.OnError()
+			}
+		}
+	}
+
+	if socket.OnDisconnect != nil {
+		wrapped.OnDisconnect = func(output chan socketio.Message) {
+			if ctx.Err() == nil {
+				socket.OnDisconnect(output)
+			}
+		}
+	}
+	return &wrapped
+}
+
+// GetWebsocketStatus returns false if the exchange's websocket client has
+// never run.
+func GetWebsocketStatus(exchange string) (WebsocketStatus, bool) {
+	websocketStatus.Lock()
+	defer websocketStatus.Unlock()
+
+	status, ok := websocketStatus.status[exchange]
+	return status, ok
+}
+
+func (e *Exchange) GetExchanges() []IBotExchange {
+	return []IBotExchange{
+		&e.anx,
+		&e.btcc,
+		&e.bitstamp,
+		&e.bitfinex,
+		&e.btce,
+		&e.btcmarkets,
+		&e.coinbase,
+		&e.cryptsy,
+		&e.dwvx,
+		&e.gemini,
+		&e.okcoinChina,
+		&e.okcoinIntl,
+		&e.itbit,
+		&e.lakebtc,
+		&e.localbitcoins,
+		&e.huobi,
+		&e.kraken,
+	}
+}
+
+func (e *Exchange) GetExchangeByName(name string) IBotExchange {
+	for _, x := range e.GetExchanges() {
+		if x.GetName() == name {
+			return x
+		}
+	}
+	return nil
+}
+
+func (e *Exchange) SetDefaults() {
+	e.okcoinChina.SetURL(OKCOIN_API_URL_CHINA)
+	e.okcoinIntl.SetURL(OKCOIN_API_URL)
+
+	for _, x := range e.GetExchanges() {
+		x.SetDefaults()
+	}
+}
+
+func GetCredentialValue(exch Exchanges, credential string) string {
+	switch credential {
+	case CREDENTIAL_API_KEY:
+		return exch.APIKey
+	case CREDENTIAL_API_SECRET:
+		return exch.APISecret
+	case CREDENTIAL_CLIENT_ID:
+		return exch.ClientID
+	}
+	return ""
+}
+
+// IsCredentialSet reports whether the credential has been changed from its
+// empty or config_example.json placeholder value.
+func IsCredentialSet(exch Exchanges, credential string) bool {
+	value := GetCredentialValue(exch, credential)
+	switch credential {
+	case CREDENTIAL_API_KEY:
+		return value != "" && value != "Key"
+	case CREDENTIAL_API_SECRET:
+		return value != "" && value != "Secret"
+	case CREDENTIAL_CLIENT_ID:
+		return value != "" && value != "ClientID"
+	}
+	return false
+}
+
+type exchangeRoutine struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var (
+	exchangeRunning      = make(map[string]exchangeRoutine)
+	exchangeRunningMutex sync.Mutex
+)
+
+// StartExchange runs the exchange in its own goroutine and tracks when its
+// Run loop returns. The exchange stops when ctx is cancelled.
+func StartExchange(ctx context.Context, exchange IBotExchange) {
+	ctx, cancel := context.WithCancel(ctx)
+	routine := exchangeRoutine{cancel, make(chan struct{})}
+	exchangeRunningMutex.Lock()
+	exchangeRunning[exchange.GetName()] = routine
+	exchangeRunningMutex.Unlock()
+
+	StartRoutine(func() {
+		defer close(routine.done)
+		exchange.Run(ctx)
+	})
+}
+
+// StopExchange disables the exchange, cancels its Run loop and returns a
+// channel which is closed once the loop has returned.
+func StopExchange(exchange IBotExchange) <-chan struct{} {
+	exchange.SetEnabled(false)
+
+	exchangeRunningMutex.Lock()
+	routine, ok := exchangeRunning[exchange.GetName()]
+	exchangeRunningMutex.Unlock()
+
+	if !ok {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+
+	routine.cancel()
+	return routine.done
+}
+
+// SetupExchange applies an exchange's config, with verbose output turned on
+// when the bot was started with -verbose.
+func SetupExchange(exchange IBotExchange, exch Exchanges) {
+	if VerboseOutput {
+		exch.Verbose = true
+	}
+	exchange.Setup(exch)
+}
+
+// RestartExchange waits for the running exchange to stop, applies the new
+// config and starts it again.
+func RestartExchange(ctx context.Context, exchange IBotExchange, exch Exchanges) {
+	<-StopExchange(exchange)
+	if ctx.Err() != nil {
+		return
+	}
+
+	SetupExchange(exchange, exch)
+	if exchange.IsEnabled() {
+		StartExchange(ctx, exchange)
+	}
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	EXECUTION_TWAP                  = "TWAP"
+	EXECUTION_VWAP                  = "VWAP"
+	EXECUTION_ICEBERG               = "ICEBERG"
+	EXECUTION_STATUS_RUNNING        = "running"
+	EXECUTION_STATUS_COMPLETED      = "completed"
+	EXECUTION_STATUS_CANCELLED      = "cancelled"
+	EXECUTION_POLL_INTERVAL         = time.Second * 5
+	EXECUTION_DEFAULT_SLICE         = time.Minute
+	EXECUTION_PROFILE_DAYS          = 7
+	ErrExecutionUnknownAlgorithm    = "Unknown execution algorithm %s. Use TWAP, VWAP or ICEBERG."
+	ErrExecutionAmountInvalid       = "Execution amount must be greater than zero."
+	ErrExecutionDurationInvalid     = "TWAP and VWAP executions need a duration greater than zero."
+	ErrExecutionVisibleSizeInvalid  = "Iceberg executions need a visible size greater than zero."
+	ErrExecutionLimitPriceRequired  = "Iceberg executions need a limit price."
+	ErrExecutionNotFound            = "Execution %s not found."
+	ErrExecutionNoMarketPrice       = "No market price for %s %s."
+	WarningExecutionVolumeProfile   = "%s: No stored candles for a %s volume profile, slicing evenly.\n"
+	WarningExecutionChildOrderError = "%s: Execution %s unable to place child order. Error: %s\n"
+)
+
+// ExecutionOrder is a parent order worked by an execution algorithm.
+//
+// TWAP splits Amount into Slices equal child orders spread evenly over
+// Duration. VWAP does the same but sizes each slice by the volume traded at
+// that time of day over the last EXECUTION_PROFILE_DAYS of stored candles.
+// Both price each child at the touch, capped at LimitPrice if set, and
+// reprice whatever has not filled with the next slice. ICEBERG keeps a child
+// of VisibleSize resting at LimitPrice until Amount has filled.
+type ExecutionOrder struct {
+	Algorithm   string
+	Exchange    string
+	Pair        CurrencyPair
+	Side        string
+	Amount      float64
+	LimitPrice  float64
+	Duration    time.Duration
+	Slices      int
+	VisibleSize float64
+}
+
+type ExecutionStatus struct {
+	ExecutionOrder
+	ID           string
+	Status       string
+	Filled       float64
+	AveragePrice float64
+	Progress     float64 // percent
+	ChildOrders  int
+	Started      time.Time
+	Finished     time.Time
+}
+
+type executionChild struct {
+	OrderDetail
+	cancelled bool
+}
+
+type executionSlice struct {
+	at     time.Time
+	amount float64
+}
+
+// Execution works one parent order through an exchange's order manager, or
+// its paper account when paper trading. Child order fills are found by
+// polling open orders: a child which is no longer open is taken as filled at
+// its limit price unless the execution cancelled it.
+type Execution struct {
+	sync.Mutex
+	status   ExecutionStatus
+	exchange IBotExchange
+	orders   IOrderManager
+	schedule []executionSlice
+	children map[string]*executionChild
+	value    float64
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+var executions = struct {
+	sync.Mutex
+	executions map[string]*Execution
+	nextID     int64
+}{executions: make(map[string]*Execution)}
+
+// NewExecution checks an order and plans its slices.
+func NewExecution(exchange IBotExchange, order ExecutionOrder) (*Execution, error) {
+	order.Algorithm = StringToUpper(order.Algorithm)
+	order.Side = StringToUpper(order.Side)
+	err := IsValidOrderSide(order.Side)
+	if err != nil {
+		return nil, err
+	}
+
+	if order.Amount <= 0 {
+		return nil, errors.New(ErrExecutionAmountInvalid)
+	}
+
+	switch order.Algorithm {
+	case EXECUTION_TWAP, EXECUTION_VWAP:
+		if order.Duration <= 0 {
+			return nil, errors.New(ErrExecutionDurationInvalid)
+		}
+		if order.Slices <= 0 {
+			order.Slices = int(math.Max(1, float64(order.Duration/EXECUTION_DEFAULT_SLICE)))
+		}
+	case EXECUTION_ICEBERG:
+		if order.VisibleSize <= 0 {
+			return nil, errors.New(ErrExecutionVisibleSizeInvalid)
+		}
+		if order.LimitPrice <= 0 {
+			return nil, errors.New(ErrExecutionLimitPriceRequired)
+		}
+	default:
+		return nil, fmt.Errorf(ErrExecutionUnknownAlgorithm, order.Algorithm)
+	}
+
+	orders, err := GetOrderManager(exchange)
+	if err != nil {
+		return nil, err
+	}
+
+	order.Exchange = exchange.GetName()
+	e := &Execution{
+		status:   ExecutionStatus{ExecutionOrder: order, Status: EXECUTION_STATUS_RUNNING, Started: time.Now()},
+		exchange: exchange,
+		orders:   orders,
+		children: make(map[string]*executionChild),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	executions.Lock()
+	executions.nextID++
+	e.status.ID = strconv.FormatInt(executions.nextID, 10)
+	executions.Unlock()
+
+	if order.Algorithm != EXECUTION_ICEBERG {
+		weights := []float64{}
+		if order.Algorithm == EXECUTION_VWAP {
+			weights = GetVolumeProfile(order.Exchange, order.Pair, e.status.Started, order.Duration/time.Duration(order.Slices), order.Slices)
+		}
+		e.plan(weights)
+	}
+	return e, nil
+}
+
+// plan splits the order into slices sized by weights, or evenly when there
+// are no weights.
+func (e *Execution) plan(weights []float64) {
+	order := e.status.ExecutionOrder
+	total := 0.0
+	for _, x := range weights {
+		total += x
+	}
+
+	interval := order.Duration / time.Duration(order.Slices)
+	for i := 0; i < order.Slices; i++ {
+		amount := order.Amount / float64(order.Slices)
+		if total > 0 {
+			amount = order.Amount * weights[i] / total
+		}
+		e.schedule = append(e.schedule, executionSlice{e.status.Started.Add(interval * time.Duration(i)), amount})
+	}
+}
+
+// GetVolumeProfile returns the average volume traded at the time of day of
+// each of count slices starting at start, from stored candles over the last
+// EXECUTION_PROFILE_DAYS. It returns nil if no candles are stored.
+func GetVolumeProfile(exchange string, pair CurrencyPair, start time.Time, interval time.Duration, count int) []float64 {
+	if bot.storage == nil {
+		log.Printf(WarningExecutionVolumeProfile, exchange, pair)
+		return nil
+	}
+
+	candidates := []time.Duration{}
+	for _, x := range GetConfig().Candles.Intervals {
+		candidates = append(candidates, x.Duration)
+	}
+	candidates = append(candidates, CANDLE_DEFAULT_INTERVALS...)
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i] < candidates[j] })
+
+	for _, candleInterval := range candidates {
+		if candleInterval >= time.Hour*24 {
+			continue
+		}
+
+		candles, err := bot.storage.GetCandles(exchange, pair, candleInterval, start.Add(-time.Hour*24*EXECUTION_PROFILE_DAYS), start)
+		if err != nil || len(candles) == 0 {
+			continue
+		}
+
+		buckets := make(map[time.Duration]float64)
+		for _, x := range candles {
+			buckets[timeOfDay(x.Timestamp).Truncate(candleInterval)] += x.Volume
+		}
+
+		result := []float64{}
+		for i := 0; i < count; i++ {
+			at := start.Add(interval * time.Duration(i))
+			result = append(result, buckets[timeOfDay(at).Truncate(candleInterval)])
+		}
+		return result
+	}
+
+	log.Printf(WarningExecutionVolumeProfile, exchange, pair)
+	return nil
+}
+
+func timeOfDay(t time.Time) time.Duration {
+	t = t.UTC()
+	return t.Sub(t.Truncate(time.Hour * 24))
+}
+
+func (e *Execution) Status() ExecutionStatus {
+	e.Lock()
+	defer e.Unlock()
+	return e.status
+}
+
+// Cancel stops the execution and cancels its open child orders.
+func (e *Execution) Cancel() {
+	e.stopOnce.Do(func() { close(e.stop) })
+}
+
+// Done returns a channel which is closed once the execution has finished.
+func (e *Execution) Done() <-chan struct{} {
+	return e.done
+}
+
+func (e *Execution) getMarketPrice() (float64, error) {
+	order := e.status.ExecutionOrder
+	if order.Algorithm == EXECUTION_ICEBERG {
+		return order.LimitPrice, nil
+	}
+
+	fetcher, ok := e.exchange.(ITickerFetcher)
+	if !ok {
+		return 0, NewExchangeFeatureError(e.exchange, "tickers")
+	}
+
+	ticker, err := fetcher.GetTickerPrice(order.Pair)
+	if err != nil {
+		return 0, err
+	}
+
+	price := ticker.Ask
+	if order.Side == ORDER_SIDE_SELL {
+		price = ticker.Bid
+	}
+	if price <= 0 {
+		price = ticker.Last
+	}
+	if price <= 0 {
+		return 0, fmt.Errorf(ErrExecutionNoMarketPrice, order.Exchange, order.Pair)
+	}
+
+	if order.LimitPrice > 0 {
+		if order.Side == ORDER_SIDE_BUY {
+			price = math.Min(price, order.LimitPrice)
+		} else {
+			price = math.Max(price, order.LimitPrice)
+		}
+	}
+	return price, nil
+}
+
+// place submits a child order for amount at the current price.
+func (e *Execution) place(amount float64) error {
+	price, err := e.getMarketPrice()
+	if err != nil {
+		return err
+	}
+
+	order := e.status.ExecutionOrder
+	id, err := e.orders.SubmitOrder(order.Pair, order.Side, amount, price)
+	if err != nil {
+		return err
+	}
+
+	e.Lock()
+	e.children[id] = &executionChild{OrderDetail: OrderDetail{ID: id, Pair: order.Pair, Side: order.Side, Price: price, Amount: amount, Status: ORDER_STATUS_OPEN}}
+	e.status.ChildOrders++
+	e.Unlock()
+	return nil
+}
+
+// cancelChildren cancels every open child order. Amounts they had filled
+// are counted when they next drop out of the open orders.
+func (e *Execution) cancelChildren() {
+	e.Lock()
+	children := []*executionChild{}
+	for _, x := range e.children {
+		children = append(children, x)
+	}
+	e.Unlock()
+
+	for _, x := range children {
+		// A child which couldn't be cancelled may still fill, so it keeps
+		// counting in full.
+		err := e.orders.CancelOrderByID(x.Pair, x.ID)
+		if err != nil {
+			log.Printf("%s: Execution %s unable to cancel child order %s. Error: %s\n", e.status.Exchange, e.status.ID, x.ID, err)
+			continue
+		}
+		e.Lock()
+		x.cancelled = true
+		e.Unlock()
+	}
+}
+
+// checkChildren records the fills of child orders which are no longer open.
+func (e *Execution) checkChildren() error {
+	open, err := e.orders.GetOpenOrderDetails()
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]OrderDetail)
+	for _, x := range open {
+		current[x.ID] = x
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	changed := false
+	for id, x := range e.children {
+		if order, ok := current[id]; ok {
+			x.Filled = order.Filled
+			continue
+		}
+
+		filled := x.Amount
+		if x.cancelled {
+			filled = x.Filled
+		}
+		delete(e.children, id)
+
+		if filled > 0 {
+			e.value += filled * x.Price
+			e.status.Filled += filled
+			e.status.AveragePrice = e.value / e.status.Filled
+			e.status.Progress = math.Min(100, e.status.Filled/e.status.Amount*100)
+			changed = true
+		}
+	}
+
+	if changed {
+		log.Printf("%s: Execution %s %s %s %s %.2f%% filled at average price %f.\n", e.status.Exchange, e.status.ID, e.status.Algorithm, e.status.Side, e.status.Pair, e.status.Progress, e.status.AveragePrice)
+	}
+	return nil
+}
+
+// remaining returns the part of target not yet filled or working. Children
+// count in full until they drop out of the open orders, except cancelled
+// ones which only count what they filled.
+func (e *Execution) remaining(target float64) float64 {
+	e.Lock()
+	defer e.Unlock()
+
+	pending := 0.0
+	for _, x := range e.children {
+		if x.cancelled {
+			pending += x.Filled
+		} else {
+			pending += x.Amount
+		}
+	}
+	return target - e.status.Filled - pending
+}
+
+func (e *Execution) finish(status string) {
+	e.Lock()
+	defer e.Unlock()
+
+	e.status.Status = status
+	e.status.Finished = time.Now()
+	log.Printf("%s: Execution %s %s after filling %f of %f at average price %f.\n", e.status.Exchange, e.status.ID, status, e.status.Filled, e.status.Amount, e.status.AveragePrice)
+}
+
+// Run works the order until it fills, or until it or ctx is cancelled when
+// any child orders left open are cancelled.
+func (e *Execution) Run(ctx context.Context) {
+	defer close(e.done)
+
+	order := e.status.ExecutionOrder
+	next := 0
+	lastPlaced := time.Time{}
+	interval := time.Duration(0)
+	if order.Slices > 0 {
+		interval = order.Duration / time.Duration(order.Slices)
+	}
+
+	for {
+		err := e.checkChildren()
+		if err != nil {
+			log.Printf("%s: Execution %s unable to fetch open orders. Error: %s\n", order.Exchange, e.status.ID, err)
+		} else if e.Status().Filled >= order.Amount*(1-1e-9) {
+			e.finish(EXECUTION_STATUS_COMPLETED)
+			return
+		}
+
+		if err == nil {
+			now := time.Now()
+			switch {
+			case order.Algorithm == EXECUTION_ICEBERG:
+				amount := math.Min(order.VisibleSize, e.remaining(order.Amount))
+				if len(e.children) == 0 && amount > 0 {
+					err = e.place(amount)
+				}
+			case next < len(e.schedule) && !now.Before(e.schedule[next].at):
+				// Unfilled amounts from earlier slices are repriced with this one.
+				target := 0.0
+				for _, x := range e.schedule[:next+1] {
+					target += x.amount
+				}
+				e.cancelChildren()
+				if amount := e.remaining(target); amount > 0 {
+					err = e.place(amount)
+				}
+				next++
+				lastPlaced = now
+			case next >= len(e.schedule) && now.Sub(lastPlaced) >= interval:
+				// The schedule is over, keep repricing what is left.
+				e.cancelChildren()
+				if amount := e.remaining(order.Amount); amount > 0 {
+					err = e.place(amount)
+				}
+				lastPlaced = now
+			}
+
+			if err != nil {
+				log.Printf(WarningExecutionChildOrderError, order.Exchange, e.status.ID, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-e.stop:
+		case <-time.After(EXECUTION_POLL_INTERVAL):
+			continue
+		}
+
+		e.cancelChildren()
+		e.checkChildren()
+		e.finish(EXECUTION_STATUS_CANCELLED)
+		return
+	}
+}
+
+// StartExecution starts working an order in the background on a configured
+// exchange.
+func StartExecution(ctx context.Context, order ExecutionOrder) (*Execution, error) {
+	exchange := bot.exchange.GetExchangeByName(order.Exchange)
+	if exchange == nil {
+		return nil, fmt.Errorf(ErrExchangeNotFound, order.Exchange)
+	}
+
+	e, err := NewExecution(exchange, order)
+	if err != nil {
+		return nil, err
+	}
+
+	executions.Lock()
+	executions.executions[e.status.ID] = e
+	executions.Unlock()
+
+	StartRoutine(func() { e.Run(ctx) })
+	return e, nil
+}
+
+func GetExecutions() []ExecutionStatus {
+	executions.Lock()
+	defer executions.Unlock()
+
+	result := []ExecutionStatus{}
+	for _, x := range executions.executions {
+		result = append(result, x.Status())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return simulatedOrderIDLess(result[i].ID, result[j].ID)
+	})
+	return result
+}
+
+func GetExecution(id string) (*Execution, error) {
+	executions.Lock()
+	defer executions.Unlock()
+
+	e, ok := executions.executions[id]
+	if !ok {
+		return nil, fmt.Errorf(ErrExecutionNotFound, id)
+	}
+	return e, nil
+}
+
+func CancelExecution(id string) error {
+	e, err := GetExecution(id)
+	if err != nil {
+		return err
+	}
+	e.Cancel()
+	return nil
+}
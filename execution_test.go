@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testOrderExchange keeps open orders in memory. Orders only fill when a test
+// changes them.
+type testOrderExchange struct {
+	Bitstamp
+	ticker    TickerPrice
+	open      map[string]*OrderDetail
+	nextID    int
+	cancelErr error
+}
+
+// newTestOrderExchange clears the config so the exchange isn't paper traded.
+func newTestOrderExchange() *testOrderExchange {
+	SetConfig(Config{})
+	e := &testOrderExchange{open: make(map[string]*OrderDetail)}
+	e.SetDefaults()
+	e.ticker = TickerPrice{Last: 100, Bid: 99, Ask: 101}
+	return e
+}
+
+func (e *testOrderExchange) GetTickerPrice(pair CurrencyPair) (TickerPrice, error) {
+	return e.ticker, nil
+}
+
+func (e *testOrderExchange) GetOpenOrderDetails() ([]OrderDetail, error) {
+	result := []OrderDetail{}
+	for _, x := range e.open {
+		result = append(result, *x)
+	}
+	return result, nil
+}
+
+func (e *testOrderExchange) SubmitOrder(pair CurrencyPair, side string, amount, price float64) (string, error) {
+	e.nextID++
+	id := strconv.Itoa(e.nextID)
+	e.open[id] = &OrderDetail{ID: id, Pair: pair, Side: side, Price: price, Amount: amount, Status: ORDER_STATUS_OPEN}
+	return id, nil
+}
+
+func (e *testOrderExchange) CancelOrderByID(pair CurrencyPair, orderID string) error {
+	if e.cancelErr != nil {
+		return e.cancelErr
+	}
+	delete(e.open, orderID)
+	return nil
+}
+
+func TestExecutionPlan(t *testing.T) {
+	exchange := newTestOrderExchange()
+	order := ExecutionOrder{Algorithm: "twap", Pair: NewCurrencyPair("BTC", "USD"), Side: "buy", Amount: 10, Duration: time.Minute * 4}
+	e, err := NewExecution(exchange, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.schedule) != 4 {
+		t.Fatalf("schedule = %+v, want 4 slices", e.schedule)
+	}
+	for i, x := range e.schedule {
+		if x.amount != 2.5 || !x.at.Equal(e.status.Started.Add(time.Minute*time.Duration(i))) {
+			t.Errorf("slice %d = %+v, want 2.5 after %d minutes", i, x, i)
+		}
+	}
+
+	e.plan([]float64{1, 3, 0, 0})
+	if e.schedule[4].amount != 2.5 || e.schedule[5].amount != 7.5 || e.schedule[6].amount != 0 {
+		t.Errorf("weighted schedule = %+v, want 2.5, 7.5, 0 and 0", e.schedule[4:])
+	}
+
+	invalid := []ExecutionOrder{
+		{Algorithm: "twap", Side: "buy", Amount: 0, Duration: time.Minute},
+		{Algorithm: "twap", Side: "buy", Amount: 1},
+		{Algorithm: "iceberg", Side: "buy", Amount: 1, LimitPrice: 100},
+		{Algorithm: "iceberg", Side: "buy", Amount: 1, VisibleSize: 0.1},
+		{Algorithm: "market", Side: "buy", Amount: 1},
+	}
+	for _, x := range invalid {
+		_, err = NewExecution(exchange, x)
+		if err == nil {
+			t.Errorf("NewExecution(%+v) succeeded", x)
+		}
+	}
+}
+
+func TestExecutionChildFills(t *testing.T) {
+	exchange := newTestOrderExchange()
+	order := ExecutionOrder{Algorithm: EXECUTION_TWAP, Pair: NewCurrencyPair("BTC", "USD"), Side: ORDER_SIDE_BUY, Amount: 10, LimitPrice: 100, Duration: time.Minute * 4}
+	e, err := NewExecution(exchange, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Children are priced at the ask, capped at the limit price.
+	err = e.place(2.5)
+	if err != nil || exchange.open["1"] == nil || exchange.open["1"].Price != 100 {
+		t.Fatalf("place returned %v with open orders %+v, want one at 100", err, exchange.open)
+	}
+
+	exchange.open["1"].Filled = 1
+	err = e.checkChildren()
+	if err != nil || e.status.Filled != 0 {
+		t.Fatalf("checkChildren returned %v with %f filled, want the open child left", err, e.status.Filled)
+	}
+
+	// A child which couldn't be cancelled may still fill in full, so nothing
+	// more is placed for it.
+	exchange.cancelErr = errors.New("Timed out")
+	e.cancelChildren()
+	if amount := e.remaining(2.5); amount != 0 {
+		t.Errorf("remaining after a failed cancel = %f, want 0", amount)
+	}
+
+	delete(exchange.open, "1")
+	e.checkChildren()
+	if e.status.Filled != 2.5 {
+		t.Errorf("filled = %f, want the child filled in full", e.status.Filled)
+	}
+
+	// A cancelled child only counts what it filled.
+	exchange.cancelErr = nil
+	exchange.ticker.Ask = 90
+	e.place(2.5)
+	exchange.open["2"].Filled = 1
+	e.checkChildren()
+	e.cancelChildren()
+	if amount := e.remaining(5); amount != 1.5 {
+		t.Errorf("remaining after a cancel = %f, want 1.5", amount)
+	}
+
+	e.checkChildren()
+	status := e.Status()
+	if status.Filled != 3.5 || math.Abs(status.AveragePrice-(2.5*100+90)/3.5) > 1e-9 || status.ChildOrders != 2 {
+		t.Errorf("status = %+v, want 3.5 filled", status)
+	}
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"log"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	FEED_PATH                 = "/ws"
+	FEED_CHANNEL_TICKER       = "ticker"
+	FEED_CHANNEL_TRADES       = "trades"
+	FEED_CHANNEL_BOOK         = "book"
+	FEED_CHANNEL_ORDERS       = "orders"
+	FEED_EVENT_SUBSCRIBE      = "subscribe"
+	FEED_EVENT_UNSUBSCRIBE    = "unsubscribe"
+	FEED_EVENT_SUBSCRIBED     = "subscribed"
+	FEED_EVENT_UNSUBSCRIBED   = "unsubscribed"
+	FEED_EVENT_SNAPSHOT       = "snapshot"
+	FEED_EVENT_UPDATE         = "update"
+	FEED_EVENT_DROPPED        = "dropped"
+	FEED_EVENT_ERROR          = "error"
+	FEED_CLIENT_BUFFER        = 256
+	FEED_READ_LIMIT           = 4096
+	FEED_WRITE_TIMEOUT        = time.Second * 10
+	FEED_PING_INTERVAL        = time.Second * 30
+	FEED_BOOK_DEPTH           = 20
+	FEED_BOOK_INTERVAL        = time.Second * 5
+	ErrFeedUnknownEvent       = "Unknown event %s. Use subscribe or unsubscribe."
+	ErrFeedUnknownChannel     = "Unknown channel %s. Use ticker, trades, book or orders."
+	ErrFeedPairRequired       = "The book channel needs a pair."
+	ErrFeedInvalidDepth       = "Invalid depth %d."
+	ErrFeedNotSubscribed      = "Not subscribed to %s %s %s."
+	ErrFeedInvalidRequest     = "Invalid request. Error: %s"
+	WarningFeedClientDropping = "WARNING -- Feed client %s: Send buffer full, dropping messages."
+)
+
+// FeedRequest subscribes to or unsubscribes from a channel of an exchange.
+// An empty Pair subscribes to every pair, except on the book channel.
+type FeedRequest struct {
+	Event    string
+	Channel  string
+	Exchange string
+	Pair     string
+	Depth    int
+}
+
+// FeedMessage is sent to feed clients. Data holds a TickerSample on the
+// ticker channel, a list of TradeRecord on the trades channel, an Orderbook
+// on the book channel and an OrderDetail on the orders channel.
+type FeedMessage struct {
+	Event     string
+	Channel   string `json:",omitempty"`
+	Exchange  string `json:",omitempty"`
+	Pair      string `json:",omitempty"`
+	Timestamp time.Time
+	Data      interface{} `json:",omitempty"`
+	Dropped   int64       `json:",omitempty"`
+	Error     string      `json:",omitempty"`
+}
+
+// FeedUpdate is published data as received by SubscribeFeed channels.
+type FeedUpdate struct {
+	Channel  string
+	Exchange string
+	Pair     CurrencyPair
+	Data     interface{}
+}
+
+type FeedSubscription struct {
+	Channel  string
+	Exchange string
+	Pair     CurrencyPair
+}
+
+// FeedClient is a websocket connection to the feed. Messages are queued to
+// a buffered channel written by its own goroutine, so a slow client never
+// blocks the exchange feeds. Messages which don't fit are dropped and the
+// client is told how many it missed; a client which stops reading is
+// disconnected once a write times out.
+type FeedClient struct {
+	dropped int64 // first for 64-bit alignment of atomic access
+	conn    *websocket.Conn
+	send    chan FeedMessage
+	done    chan struct{}
+	mutex   sync.Mutex
+	// subscriptions maps each subscription to its book depth.
+	subscriptions map[FeedSubscription]int
+}
+
+type feedBookKey struct {
+	Exchange string
+	Pair     CurrencyPair
+}
+
+// feedBookPoller fetches an order book for as long as any client is
+// subscribed to it. last is the latest book, sent to new subscribers.
+type feedBookPoller struct {
+	subscribers int
+	cancel      context.CancelFunc
+	last        *Orderbook
+}
+
+var feedClients = struct {
+	sync.Mutex
+	clients map[*FeedClient]bool
+}{clients: make(map[*FeedClient]bool)}
+
+var feedBooks = struct {
+	sync.Mutex
+	pollers map[feedBookKey]*feedBookPoller
+}{pollers: make(map[feedBookKey]*feedBookPoller)}
+
+var feedSubscribers = struct {
+	sync.Mutex
+	subscribers map[chan FeedUpdate]bool
+}{subscribers: make(map[chan FeedUpdate]bool)}
+
+var feedUpgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+
+// PublishFeed sends data to every client subscribed to the channel of the
+// exchange and pair, and to every SubscribeFeed channel.
+func PublishFeed(channel, exchange string, pair CurrencyPair, data interface{}) {
+	feedClients.Lock()
+	for client := range feedClients.clients {
+		client.publish(FEED_EVENT_UPDATE, channel, exchange, pair, data)
+	}
+	feedClients.Unlock()
+
+	feedSubscribers.Lock()
+	defer feedSubscribers.Unlock()
+	for ch := range feedSubscribers.subscribers {
+		select {
+		case ch <- FeedUpdate{channel, exchange, pair, data}:
+		default:
+		}
+	}
+}
+
+// SubscribeFeed returns a channel receiving every update published to the
+// feed, for consumers inside the bot. Updates are dropped while the channel
+// is full. Book updates are only published while a feed client, or a
+// SubscribeFeedBook caller, is subscribed to the book.
+func SubscribeFeed(buffer int) chan FeedUpdate {
+	feedSubscribers.Lock()
+	defer feedSubscribers.Unlock()
+
+	ch := make(chan FeedUpdate, buffer)
+	feedSubscribers.subscribers[ch] = true
+	return ch
+}
+
+func UnsubscribeFeed(ch chan FeedUpdate) {
+	feedSubscribers.Lock()
+	defer feedSubscribers.Unlock()
+
+	if feedSubscribers.subscribers[ch] {
+		delete(feedSubscribers.subscribers, ch)
+		close(ch)
+	}
+}
+
+// CloseFeedClients disconnects every feed client. The webserver doesn't
+// track connections upgraded to websockets, so this is called on shutdown.
+func CloseFeedClients() {
+	feedClients.Lock()
+	defer feedClients.Unlock()
+
+	for client := range feedClients.clients {
+		client.conn.Close()
+	}
+}
+
+func handleFeed(w http.ResponseWriter, r *http.Request) {
+	conn, err := feedUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the client.
+		return
+	}
+
+	client := &FeedClient{
+		conn:          conn,
+		send:          make(chan FeedMessage, FEED_CLIENT_BUFFER),
+		done:          make(chan struct{}),
+		subscriptions: make(map[FeedSubscription]int),
+	}
+
+	feedClients.Lock()
+	feedClients.clients[client] = true
+	feedClients.Unlock()
+	log.Printf("Feed client %s connected.\n", conn.RemoteAddr())
+
+	go client.writeRoutine()
+	client.readRoutine()
+
+	feedClients.Lock()
+	delete(feedClients.clients, client)
+	feedClients.Unlock()
+
+	client.mutex.Lock()
+	subscriptions := client.subscriptions
+	client.subscriptions = make(map[FeedSubscription]int)
+	client.mutex.Unlock()
+
+	for subscription := range subscriptions {
+		if subscription.Channel == FEED_CHANNEL_BOOK {
+			releaseFeedBook(feedBookKey{subscription.Exchange, subscription.Pair})
+		}
+	}
+
+	close(client.done)
+	log.Printf("Feed client %s disconnected.\n", conn.RemoteAddr())
+}
+
+func (c *FeedClient) readRoutine() {
+	c.conn.SetReadLimit(FEED_READ_LIMIT)
+	c.conn.SetReadDeadline(time.Now().Add(FEED_PING_INTERVAL * 2))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(FEED_PING_INTERVAL * 2))
+	})
+
+	for {
+		_, payload, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(FEED_PING_INTERVAL * 2))
+
+		request := FeedRequest{}
+		err = json.Unmarshal(payload, &request)
+		if err != nil {
+			c.queue(FeedMessage{Event: FEED_EVENT_ERROR, Timestamp: time.Now(), Error: fmt.Sprintf(ErrFeedInvalidRequest, err)})
+			continue
+		}
+
+		switch request.Event {
+		case FEED_EVENT_SUBSCRIBE:
+			err = c.subscribe(request)
+		case FEED_EVENT_UNSUBSCRIBE:
+			err = c.unsubscribe(request)
+		default:
+			err = fmt.Errorf(ErrFeedUnknownEvent, request.Event)
+		}
+
+		if err != nil {
+			c.queue(FeedMessage{Event: FEED_EVENT_ERROR, Channel: request.Channel, Exchange: request.Exchange, Pair: request.Pair, Timestamp: time.Now(), Error: err.Error()})
+		}
+	}
+}
+
+// writeRoutine writes queued messages and pings until the client
+// disconnects. Closing the connection on a failed write ends readRoutine.
+func (c *FeedClient) writeRoutine() {
+	ping := time.NewTicker(FEED_PING_INTERVAL)
+	defer ping.Stop()
+	defer c.conn.Close()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case message := <-c.send:
+			if dropped := atomic.SwapInt64(&c.dropped, 0); dropped > 0 {
+				if c.write(FeedMessage{Event: FEED_EVENT_DROPPED, Timestamp: time.Now(), Dropped: dropped}) != nil {
+					return
+				}
+			}
+			if c.write(message) != nil {
+				return
+			}
+		case <-ping.C:
+			if c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(FEED_WRITE_TIMEOUT)) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *FeedClient) write(message FeedMessage) error {
+	c.conn.SetWriteDeadline(time.Now().Add(FEED_WRITE_TIMEOUT))
+	return c.conn.WriteJSON(message)
+}
+
+// queue adds a message to the client's send buffer, dropping it if the
+// buffer is full.
+func (c *FeedClient) queue(message FeedMessage) {
+	select {
+	case c.send <- message:
+	default:
+		if atomic.AddInt64(&c.dropped, 1) == 1 {
+			log.Printf(WarningFeedClientDropping, c.conn.RemoteAddr())
+		}
+	}
+}
+
+// publish queues data if the client is subscribed to the pair, or to every
+// pair of the exchange's channel.
+func (c *FeedClient) publish(event, channel, exchange string, pair CurrencyPair, data interface{}) {
+	c.mutex.Lock()
+	depth, ok := c.subscriptions[FeedSubscription{channel, exchange, pair}]
+	if !ok {
+		depth, ok = c.subscriptions[FeedSubscription{channel, exchange, CurrencyPair{}}]
+	}
+	c.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if orderbook, isBook := data.(Orderbook); isBook {
+		data = TrimOrderbook(orderbook, depth)
+	}
+	c.queue(FeedMessage{Event: event, Channel: channel, Exchange: exchange, Pair: pair.String(), Timestamp: time.Now(), Data: data})
+}
+
+func (c *FeedClient) parseSubscription(request FeedRequest) (FeedSubscription, error) {
+	switch request.Channel {
+	case FEED_CHANNEL_TICKER, FEED_CHANNEL_TRADES, FEED_CHANNEL_BOOK, FEED_CHANNEL_ORDERS:
+	default:
+		return FeedSubscription{}, fmt.Errorf(ErrFeedUnknownChannel, request.Channel)
+	}
+
+	exch, err := GetExchangeConfig(request.Exchange)
+	if err != nil {
+		return FeedSubscription{}, err
+	}
+
+	subscription := FeedSubscription{Channel: request.Channel, Exchange: exch.Name}
+	if request.Pair != "" {
+		subscription.Pair = ParseCLICurrencyPair(exch, request.Pair)
+	}
+	return subscription, nil
+}
+
+func (c *FeedClient) subscribe(request FeedRequest) error {
+	subscription, err := c.parseSubscription(request)
+	if err != nil {
+		return err
+	}
+
+	depth := 0
+	if subscription.Channel == FEED_CHANNEL_BOOK {
+		if subscription.Pair.IsEmpty() {
+			return errors.New(ErrFeedPairRequired)
+		}
+
+		depth = request.Depth
+		if depth < 0 {
+			return fmt.Errorf(ErrFeedInvalidDepth, depth)
+		}
+		if depth == 0 {
+			depth = FEED_BOOK_DEPTH
+		}
+
+		exchange := bot.exchange.GetExchangeByName(subscription.Exchange)
+		fetcher, ok := exchange.(IOrderbookFetcher)
+		if !ok {
+			return NewExchangeFeatureError(exchange, "orderbooks")
+		}
+		return c.subscribeBook(subscription, depth, fetcher)
+	}
+
+	c.mutex.Lock()
+	c.subscriptions[subscription] = depth
+	c.mutex.Unlock()
+	c.queue(FeedMessage{Event: FEED_EVENT_SUBSCRIBED, Channel: subscription.Channel, Exchange: subscription.Exchange, Pair: subscription.Pair.String(), Timestamp: time.Now()})
+	return nil
+}
+
+// subscribeBook subscribes the client to an order book and sends it the
+// latest snapshot. If the book hasn't been fetched yet, the poller's first
+// fetch is sent to its subscribers as the snapshot instead.
+func (c *FeedClient) subscribeBook(subscription FeedSubscription, depth int, fetcher IOrderbookFetcher) error {
+	key := feedBookKey{subscription.Exchange, subscription.Pair}
+
+	feedBooks.Lock()
+	defer feedBooks.Unlock()
+
+	c.mutex.Lock()
+	_, subscribed := c.subscriptions[subscription]
+	c.subscriptions[subscription] = depth
+	c.mutex.Unlock()
+
+	poller := getFeedBookPoller(key, fetcher)
+	if !subscribed {
+		poller.subscribers++
+	}
+
+	c.queue(FeedMessage{Event: FEED_EVENT_SUBSCRIBED, Channel: subscription.Channel, Exchange: subscription.Exchange, Pair: subscription.Pair.String(), Timestamp: time.Now()})
+	if poller.last != nil {
+		c.publish(FEED_EVENT_SNAPSHOT, FEED_CHANNEL_BOOK, key.Exchange, key.Pair, *poller.last)
+	}
+	return nil
+}
+
+// SubscribeFeedBook polls an order book for a consumer inside the bot, which
+// receives its updates through SubscribeFeed, until ReleaseFeedBook is
+// called. The latest book is returned if it has been fetched yet.
+func SubscribeFeedBook(exchange string, pair CurrencyPair, fetcher IOrderbookFetcher) *Orderbook {
+	feedBooks.Lock()
+	defer feedBooks.Unlock()
+
+	poller := getFeedBookPoller(feedBookKey{exchange, pair}, fetcher)
+	poller.subscribers++
+	return poller.last
+}
+
+func ReleaseFeedBook(exchange string, pair CurrencyPair) {
+	releaseFeedBook(feedBookKey{exchange, pair})
+}
+
+// getFeedBookPoller returns the poller of a book, starting it if needed.
+// feedBooks must be locked.
+func getFeedBookPoller(key feedBookKey, fetcher IOrderbookFetcher) *feedBookPoller {
+	poller, ok := feedBooks.pollers[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(bot.ctx)
+		poller = &feedBookPoller{cancel: cancel}
+		feedBooks.pollers[key] = poller
+		StartRoutine(func() { feedBookRoutine(ctx, key, fetcher) })
+	}
+	return poller
+}
+
+func (c *FeedClient) unsubscribe(request FeedRequest) error {
+	subscription, err := c.parseSubscription(request)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	_, ok := c.subscriptions[subscription]
+	delete(c.subscriptions, subscription)
+	c.mutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf(ErrFeedNotSubscribed, subscription.Channel, subscription.Exchange, subscription.Pair)
+	}
+
+	if subscription.Channel == FEED_CHANNEL_BOOK {
+		releaseFeedBook(feedBookKey{subscription.Exchange, subscription.Pair})
+	}
+	c.queue(FeedMessage{Event: FEED_EVENT_UNSUBSCRIBED, Channel: subscription.Channel, Exchange: subscription.Exchange, Pair: subscription.Pair.String(), Timestamp: time.Now()})
+	return nil
+}
+
+// releaseFeedBook stops polling a book once its last subscriber has gone.
+func releaseFeedBook(key feedBookKey) {
+	feedBooks.Lock()
+	defer feedBooks.Unlock()
+
+	poller, ok := feedBooks.pollers[key]
+	if !ok {
+		return
+	}
+
+	poller.subscribers--
+	if poller.subscribers <= 0 {
+		poller.cancel()
+		delete(feedBooks.pollers, key)
+	}
+}
+
+// feedBookRoutine fetches a book at the exchange's polling delay and sends
+// it to subscribers whenever it changes. The first book is sent as a
+// snapshot.
+func feedBookRoutine(ctx context.Context, key feedBookKey, fetcher IOrderbookFetcher) {
+	interval := FEED_BOOK_INTERVAL
+	exch, err := GetExchangeConfig(key.Exchange)
+	if err == nil && exch.RESTPollingDelay.Duration > 0 {
+		interval = exch.RESTPollingDelay.Duration
+	}
+
+	for ctx.Err() == nil {
+		orderbook, err := fetcher.GetOrderbookDepth(key.Pair)
+		if err != nil {
+			log.Printf("%s: Feed unable to fetch %s orderbook. Error: %s\n", key.Exchange, key.Pair, err)
+		} else {
+			publishFeedBook(ctx, key, orderbook)
+		}
+
+		if !SleepContext(ctx, interval) {
+			return
+		}
+	}
+}
+
+func publishFeedBook(ctx context.Context, key feedBookKey, orderbook Orderbook) {
+	feedBooks.Lock()
+	defer feedBooks.Unlock()
+
+	// The poller may have been released while the book was being fetched.
+	poller, ok := feedBooks.pollers[key]
+	if !ok || ctx.Err() != nil {
+		return
+	}
+
+	event := FEED_EVENT_UPDATE
+	if poller.last == nil {
+		event = FEED_EVENT_SNAPSHOT
+	} else if reflect.DeepEqual(*poller.last, orderbook) {
+		return
+	}
+	poller.last = &orderbook
+
+	feedClients.Lock()
+	for client := range feedClients.clients {
+		client.publish(event, FEED_CHANNEL_BOOK, key.Exchange, key.Pair, orderbook)
+	}
+	feedClients.Unlock()
+
+	feedSubscribers.Lock()
+	defer feedSubscribers.Unlock()
+	for ch := range feedSubscribers.subscribers {
+		select {
+		case ch <- FeedUpdate{FEED_CHANNEL_BOOK, key.Exchange, key.Pair, orderbook}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	FIX_BEGIN_STRING     = "FIX.4.4"
+	FIX_SOH              = '\x01'
+	FIX_TIME_FORMAT      = "20060102-15:04:05.000"
+	FIX_MAX_MESSAGE_SIZE = 65536
+
+	FIX_TAG_AVG_PX                    = 6
+	FIX_TAG_BEGIN_SEQ_NO              = 7
+	FIX_TAG_BEGIN_STRING              = 8
+	FIX_TAG_BODY_LENGTH               = 9
+	FIX_TAG_CHECK_SUM                 = 10
+	FIX_TAG_CL_ORD_ID                 = 11
+	FIX_TAG_CUM_QTY                   = 14
+	FIX_TAG_END_SEQ_NO                = 16
+	FIX_TAG_EXEC_ID                   = 17
+	FIX_TAG_LAST_PX                   = 31
+	FIX_TAG_LAST_QTY                  = 32
+	FIX_TAG_MSG_SEQ_NUM               = 34
+	FIX_TAG_MSG_TYPE                  = 35
+	FIX_TAG_NEW_SEQ_NO                = 36
+	FIX_TAG_ORDER_ID                  = 37
+	FIX_TAG_ORDER_QTY                 = 38
+	FIX_TAG_ORD_STATUS                = 39
+	FIX_TAG_ORD_TYPE                  = 40
+	FIX_TAG_ORIG_CL_ORD_ID            = 41
+	FIX_TAG_POSS_DUP_FLAG             = 43
+	FIX_TAG_PRICE                     = 44
+	FIX_TAG_REF_SEQ_NUM               = 45
+	FIX_TAG_SENDER_COMP_ID            = 49
+	FIX_TAG_SENDING_TIME              = 52
+	FIX_TAG_SIDE                      = 54
+	FIX_TAG_SYMBOL                    = 55
+	FIX_TAG_TARGET_COMP_ID            = 56
+	FIX_TAG_TEXT                      = 58
+	FIX_TAG_TRANSACT_TIME             = 60
+	FIX_TAG_ENCRYPT_METHOD            = 98
+	FIX_TAG_CXL_REJ_REASON            = 102
+	FIX_TAG_ORD_REJ_REASON            = 103
+	FIX_TAG_HEART_BT_INT              = 108
+	FIX_TAG_TEST_REQ_ID               = 112
+	FIX_TAG_ORIG_SENDING_TIME         = 122
+	FIX_TAG_GAP_FILL_FLAG             = 123
+	FIX_TAG_RESET_SEQ_NUM_FLAG        = 141
+	FIX_TAG_NO_RELATED_SYM            = 146
+	FIX_TAG_EXEC_TYPE                 = 150
+	FIX_TAG_LEAVES_QTY                = 151
+	FIX_TAG_SECURITY_EXCHANGE         = 207
+	FIX_TAG_MD_REQ_ID                 = 262
+	FIX_TAG_SUBSCRIPTION_REQUEST_TYPE = 263
+	FIX_TAG_MARKET_DEPTH              = 264
+	FIX_TAG_MD_UPDATE_TYPE            = 265
+	FIX_TAG_NO_MD_ENTRY_TYPES         = 267
+	FIX_TAG_NO_MD_ENTRIES             = 268
+	FIX_TAG_MD_ENTRY_TYPE             = 269
+	FIX_TAG_MD_ENTRY_PX               = 270
+	FIX_TAG_MD_ENTRY_SIZE             = 271
+	FIX_TAG_MD_UPDATE_ACTION          = 279
+	FIX_TAG_MD_REQ_REJ_REASON         = 281
+	FIX_TAG_REF_TAG_ID                = 371
+	FIX_TAG_REF_MSG_TYPE              = 372
+	FIX_TAG_SESSION_REJECT_REASON     = 373
+	FIX_TAG_BUSINESS_REJECT_REASON    = 380
+	FIX_TAG_CXL_REJ_RESPONSE_TO       = 434
+	FIX_TAG_PASSWORD                  = 554
+
+	FIX_MSG_HEARTBEAT                       = "0"
+	FIX_MSG_TEST_REQUEST                    = "1"
+	FIX_MSG_RESEND_REQUEST                  = "2"
+	FIX_MSG_REJECT                          = "3"
+	FIX_MSG_SEQUENCE_RESET                  = "4"
+	FIX_MSG_LOGOUT                          = "5"
+	FIX_MSG_EXECUTION_REPORT                = "8"
+	FIX_MSG_ORDER_CANCEL_REJECT             = "9"
+	FIX_MSG_LOGON                           = "A"
+	FIX_MSG_NEW_ORDER_SINGLE                = "D"
+	FIX_MSG_ORDER_CANCEL_REQUEST            = "F"
+	FIX_MSG_MARKET_DATA_REQUEST             = "V"
+	FIX_MSG_MARKET_DATA_SNAPSHOT            = "W"
+	FIX_MSG_MARKET_DATA_INCREMENTAL_REFRESH = "X"
+	FIX_MSG_MARKET_DATA_REQUEST_REJECT      = "Y"
+	FIX_MSG_BUSINESS_MESSAGE_REJECT         = "j"
+
+	FIX_YES                         = "Y"
+	FIX_SIDE_BUY                    = "1"
+	FIX_SIDE_SELL                   = "2"
+	FIX_ORD_TYPE_LIMIT              = "2"
+	FIX_EXEC_TYPE_NEW               = "0"
+	FIX_EXEC_TYPE_CANCELED          = "4"
+	FIX_EXEC_TYPE_REJECTED          = "8"
+	FIX_EXEC_TYPE_TRADE             = "F"
+	FIX_ORD_STATUS_NEW              = "0"
+	FIX_ORD_STATUS_PARTIALLY_FILLED = "1"
+	FIX_ORD_STATUS_FILLED           = "2"
+	FIX_ORD_STATUS_CANCELED         = "4"
+	FIX_ORD_STATUS_REJECTED         = "8"
+	FIX_MD_ENTRY_BID                = "0"
+	FIX_MD_ENTRY_OFFER              = "1"
+	FIX_MD_ENTRY_TRADE              = "2"
+	FIX_MD_ACTION_NEW               = "0"
+	FIX_MD_ACTION_CHANGE            = "1"
+	FIX_MD_ACTION_DELETE            = "2"
+	FIX_SUBSCRIPTION_SNAPSHOT       = "0"
+	FIX_SUBSCRIPTION_SUBSCRIBE      = "1"
+	FIX_SUBSCRIPTION_UNSUBSCRIBE    = "2"
+	FIX_MD_UPDATE_FULL_REFRESH      = "0"
+	FIX_MD_UPDATE_INCREMENTAL       = "1"
+	FIX_ORD_REJ_REASON_DUPLICATE    = "6"
+	FIX_ORD_REJ_REASON_OTHER        = "99"
+	FIX_CXL_REJ_REASON_UNKNOWN      = "1"
+	FIX_CXL_REJ_REASON_OTHER        = "99"
+	FIX_CXL_REJ_RESPONSE_TO_CANCEL  = "1"
+	FIX_SESSION_REJ_REQUIRED_TAG    = "1"
+	FIX_SESSION_REJ_INCORRECT_VALUE = "5"
+	FIX_BUSINESS_REJ_UNSUPPORTED    = "3"
+	FIX_MD_REJ_UNKNOWN_SYMBOL       = "0"
+	FIX_MD_REJ_DUPLICATE_ID         = "1"
+	FIX_MD_REJ_SUBSCRIPTION_TYPE    = "4"
+	FIX_MD_REJ_MARKET_DEPTH         = "5"
+	FIX_MD_REJ_UPDATE_TYPE          = "6"
+	FIX_MD_REJ_ENTRY_TYPE           = "8"
+
+	ErrFIXBeginString   = "Unsupported BeginString %s. Only FIX.4.4 is supported."
+	ErrFIXBodyLength    = "Invalid BodyLength %s."
+	ErrFIXCheckSum      = "CheckSum %s does not match the message's checksum %03d."
+	ErrFIXField         = "Invalid field %q."
+	ErrFIXFieldExpected = "Expected tag %d, got %q."
+	ErrFIXTagMissing    = "Required tag %d missing."
+	ErrFIXTagInvalid    = "Invalid value %q for tag %d."
+)
+
+type FIXField struct {
+	Tag   int
+	Value string
+}
+
+// FIXMessage is a FIX message as an ordered list of fields, since tags
+// repeat within repeating groups. BeginString, BodyLength and CheckSum are
+// added when the message is encoded and stripped when it is read.
+type FIXMessage []FIXField
+
+func NewFIXMessage(msgType string) FIXMessage {
+	return FIXMessage{{FIX_TAG_MSG_TYPE, msgType}}
+}
+
+func (m *FIXMessage) Add(tag int, value string) {
+	*m = append(*m, FIXField{tag, value})
+}
+
+func (m *FIXMessage) AddInt(tag int, value int) {
+	m.Add(tag, strconv.Itoa(value))
+}
+
+func (m *FIXMessage) AddFloat(tag int, value float64) {
+	m.Add(tag, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+func (m *FIXMessage) AddTime(tag int, value time.Time) {
+	m.Add(tag, value.UTC().Format(FIX_TIME_FORMAT))
+}
+
+// Type returns the message's MsgType.
+func (m FIXMessage) Type() string {
+	value, _ := m.Get(FIX_TAG_MSG_TYPE)
+	return value
+}
+
+// Get returns the first value of tag.
+func (m FIXMessage) Get(tag int) (string, bool) {
+	for _, x := range m {
+		if x.Tag == tag {
+			return x.Value, true
+		}
+	}
+	return "", false
+}
+
+// GetRequired returns the first value of tag, which must be present and not
+// empty.
+func (m FIXMessage) GetRequired(tag int) (string, error) {
+	value, ok := m.Get(tag)
+	if !ok || value == "" {
+		return "", fmt.Errorf(ErrFIXTagMissing, tag)
+	}
+	return value, nil
+}
+
+func (m FIXMessage) GetInt(tag int) (int, error) {
+	value, err := m.GetRequired(tag)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf(ErrFIXTagInvalid, value, tag)
+	}
+	return result, nil
+}
+
+func (m FIXMessage) GetFloat(tag int) (float64, error) {
+	value, err := m.GetRequired(tag)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf(ErrFIXTagInvalid, value, tag)
+	}
+	return result, nil
+}
+
+// Group returns the entries of the repeating group counted by countTag.
+// tags are the tags which may appear in an entry, starting with the one
+// which begins each entry. The group ends at the first other tag.
+func (m FIXMessage) Group(countTag int, tags ...int) []FIXMessage {
+	members := make(map[int]bool)
+	for _, x := range tags {
+		members[x] = true
+	}
+
+	result := []FIXMessage{}
+	start := -1
+	for i, x := range m {
+		if x.Tag == countTag {
+			start = i + 1
+			break
+		}
+	}
+	if start < 0 {
+		return result
+	}
+
+	for _, x := range m[start:] {
+		if !members[x.Tag] {
+			break
+		}
+		if x.Tag == tags[0] || len(result) == 0 {
+			result = append(result, FIXMessage{})
+		}
+		result[len(result)-1] = append(result[len(result)-1], x)
+	}
+	return result
+}
+
+func fixCheckSum(data []byte) int {
+	sum := 0
+	for _, x := range data {
+		sum += int(x)
+	}
+	return sum % 256
+}
+
+// EncodeFIXMessage returns the message with BeginString, BodyLength and
+// CheckSum added.
+func EncodeFIXMessage(m FIXMessage) []byte {
+	body := bytes.Buffer{}
+	for _, x := range m {
+		body.WriteString(strconv.Itoa(x.Tag))
+		body.WriteByte('=')
+		body.WriteString(x.Value)
+		body.WriteByte(FIX_SOH)
+	}
+
+	result := bytes.Buffer{}
+	fmt.Fprintf(&result, "%d=%s%c%d=%d%c", FIX_TAG_BEGIN_STRING, FIX_BEGIN_STRING, FIX_SOH, FIX_TAG_BODY_LENGTH, body.Len(), FIX_SOH)
+	result.Write(body.Bytes())
+	fmt.Fprintf(&result, "%d=%03d%c", FIX_TAG_CHECK_SUM, fixCheckSum(result.Bytes()), FIX_SOH)
+	return result.Bytes()
+}
+
+// ParseFIXMessage parses a message encoded by EncodeFIXMessage.
+func ParseFIXMessage(data []byte) (FIXMessage, error) {
+	return ReadFIXMessage(bufio.NewReader(bytes.NewReader(data)))
+}
+
+func readFIXField(r *bufio.Reader, tag int) (string, error) {
+	field, err := r.ReadString(FIX_SOH)
+	if err != nil {
+		return "", err
+	}
+
+	prefix := strconv.Itoa(tag) + "="
+	if len(field) < len(prefix) || field[:len(prefix)] != prefix {
+		return "", fmt.Errorf(ErrFIXFieldExpected, tag, field)
+	}
+	return field[len(prefix) : len(field)-1], nil
+}
+
+// ReadFIXMessage reads the next message from r. A message with a bad
+// CheckSum is returned with an error after being read in full, so callers
+// may skip it and carry on reading. Other errors leave the stream unusable.
+func ReadFIXMessage(r *bufio.Reader) (FIXMessage, error) {
+	beginString, err := readFIXField(r, FIX_TAG_BEGIN_STRING)
+	if err != nil {
+		return nil, err
+	}
+	if beginString != FIX_BEGIN_STRING {
+		return nil, fmt.Errorf(ErrFIXBeginString, beginString)
+	}
+
+	value, err := readFIXField(r, FIX_TAG_BODY_LENGTH)
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(value)
+	if err != nil || length <= 0 || length > FIX_MAX_MESSAGE_SIZE {
+		return nil, fmt.Errorf(ErrFIXBodyLength, value)
+	}
+
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return nil, err
+	}
+
+	checkSum, err := readFIXField(r, FIX_TAG_CHECK_SUM)
+	if err != nil {
+		return nil, err
+	}
+
+	header := fmt.Sprintf("%d=%s%c%d=%s%c", FIX_TAG_BEGIN_STRING, beginString, FIX_SOH, FIX_TAG_BODY_LENGTH, value, FIX_SOH)
+	expected := (fixCheckSum([]byte(header)) + fixCheckSum(body)) % 256
+	if checkSum != fmt.Sprintf("%03d", expected) {
+		return nil, fixCheckSumError{checkSum, expected}
+	}
+
+	if body[len(body)-1] != FIX_SOH {
+		return nil, fmt.Errorf(ErrFIXBodyLength, value)
+	}
+
+	result := FIXMessage{}
+	for _, field := range bytes.Split(body[:len(body)-1], []byte{FIX_SOH}) {
+		separator := bytes.IndexByte(field, '=')
+		if separator <= 0 {
+			return nil, fmt.Errorf(ErrFIXField, field)
+		}
+
+		tag, err := strconv.Atoi(string(field[:separator]))
+		if err != nil {
+			return nil, fmt.Errorf(ErrFIXField, field)
+		}
+		result = append(result, FIXField{tag, string(field[separator+1:])})
+	}
+
+	if result.Type() == "" {
+		return nil, fmt.Errorf(ErrFIXTagMissing, FIX_TAG_MSG_TYPE)
+	}
+	return result, nil
+}
+
+type fixCheckSumError struct {
+	checkSum string
+	expected int
+}
+
+func (e fixCheckSumError) Error() string {
+	return fmt.Sprintf(ErrFIXCheckSum, e.checkSum, e.expected)
+}
+
+// IsFIXCheckSumError reports whether ReadFIXMessage failed on a complete
+// message with a bad CheckSum, which FIX says is to be ignored rather than
+// ending the session.
+func IsFIXCheckSumError(err error) bool {
+	_, ok := err.(fixCheckSumError)
+	return ok
+}
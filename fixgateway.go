@@ -0,0 +1,1412 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"reflect"
+	"time"
+)
+
+const (
+	FIX_DEFAULT_LISTEN_ADDRESS = "localhost:9053"
+	FIX_DEFAULT_SENDER_COMP_ID = "GOCRYPTOTRADER"
+	FIX_LOGON_TIMEOUT          = time.Second * 10
+	FIX_WRITE_TIMEOUT          = time.Second * 10
+	FIX_ORDER_POLL_INTERVAL    = time.Second * 10
+	FIX_MAX_HEARTBEAT_INTERVAL = 300
+	FIX_SUBSCRIBER_BUFFER      = 1000
+	FIX_NO_ORDER_ID            = "NONE"
+	FIX_SESSION_DAY_FORMAT     = "2006-01-02"
+	ErrFIXLogonRequired        = "First message must be a Logon."
+	ErrFIXLogonTimeout         = "No Logon received."
+	ErrFIXCompIDs              = "Unexpected SenderCompID/TargetCompID %s/%s."
+	ErrFIXPassword             = "Invalid Password."
+	ErrFIXHeartBtInt           = "Invalid HeartBtInt %s."
+	ErrFIXSeqNumTooLow         = "MsgSeqNum too low, expecting %d but received %d."
+	ErrFIXNewSeqNoTooLow       = "NewSeqNo %d is below the expected MsgSeqNum %d."
+	ErrFIXResendRange          = "Invalid resend range %d to %d."
+	ErrFIXResendBeyondLast     = "BeginSeqNo %d is beyond the last MsgSeqNum sent %d."
+	ErrFIXTestRequestTimeout   = "No reply to TestRequest."
+	ErrFIXUnsupportedMsgType   = "Unsupported MsgType %s."
+	ErrFIXUnsupportedSide      = "Unsupported Side %s. Use 1 (Buy) or 2 (Sell)."
+	ErrFIXUnsupportedOrdType   = "Unsupported OrdType %s. Only limit orders are supported."
+	ErrFIXInvalidOrderQty      = "OrderQty and Price must be above zero."
+	ErrFIXDuplicateClOrdID     = "Duplicate ClOrdID %s."
+	ErrFIXUnknownOrder         = "Unknown order %s."
+	ErrFIXExchangeRequired     = "SecurityExchange is required."
+	ErrFIXDuplicateMDReqID     = "Duplicate MDReqID %s."
+	ErrFIXUnknownMDReqID       = "Unknown MDReqID %s."
+	ErrFIXMDEntryTypesEmpty    = "No MDEntryType requested."
+	ErrFIXRelatedSymEmpty      = "No Symbol requested."
+)
+
+// FIXOrder is an order placed through the FIX gateway. It is tracked until
+// it is filled or cancelled to send its execution reports. FilledValue is
+// the filled quantity times the average fill price.
+type FIXOrder struct {
+	ClOrdID     string
+	OrderID     string
+	Exchange    string
+	Symbol      string
+	Pair        CurrencyPair
+	Side        string
+	Quantity    float64
+	Price       float64
+	Filled      float64
+	FilledValue float64
+	Placed      time.Time
+}
+
+// FIXSessionState is the FIX session state kept in storage, so that a
+// restarted bot carries on with the same sequence numbers and orders.
+// ClOrdIDs holds every ClOrdID received on the UTC day SessionDay, so that
+// one can't be reused that day even once its order has closed.
+type FIXSessionState struct {
+	NextSenderSeqNum int
+	NextTargetSeqNum int
+	NextExecID       int
+	Orders           map[string]FIXOrder
+	SessionDay       string
+	ClOrdIDs         map[string]bool
+}
+
+// fixMarketData is a market data subscription to one symbol. last is the
+// book last sent, which incremental refreshes are worked out from.
+type fixMarketData struct {
+	Exchange    string
+	Symbol      string
+	Pair        CurrencyPair
+	Depth       int
+	Incremental bool
+	Bids        bool
+	Offers      bool
+	Trades      bool
+	last        *Orderbook
+}
+
+type fixIncoming struct {
+	conn    net.Conn
+	message FIXMessage
+	err     error
+}
+
+type fixSubmitResult struct {
+	Order FIXOrder
+	Err   error
+}
+
+// fixPollResult holds the open orders of an exchange, and how its polled
+// orders which are no longer open closed.
+type fixPollResult struct {
+	Exchange string
+	Open     []OrderDetail
+	Closed   []OrderDetail
+	Err      error
+}
+
+// FIXGateway is a FIX 4.4 acceptor for a single counterparty. Orders are
+// routed through the unified order API to the exchange named by their
+// SecurityExchange, and market data comes from the feed. All of its state is
+// owned by the routine running Run.
+type FIXGateway struct {
+	config        FIXConfig
+	session       string
+	state         FIXSessionState
+	conn          net.Conn
+	connected     time.Time
+	loggedOn      bool
+	heartbeat     time.Duration
+	lastSent      time.Time
+	lastReceived  time.Time
+	testRequested bool
+	// resendTo is the MsgSeqNum which prompted an unanswered ResendRequest.
+	resendTo   int
+	marketData map[string][]*fixMarketData
+	polling    bool
+	// submitting counts the orders being placed. Order updates which match
+	// no order are held in unmatched meanwhile, as they may be for one of them.
+	submitting  int
+	unmatched   []FeedUpdate
+	connections chan net.Conn
+	incoming    chan fixIncoming
+	submitted   chan fixSubmitResult
+	polled      chan []fixPollResult
+}
+
+// FIXRoutine runs the FIX gateway until ctx is cancelled.
+func FIXRoutine(ctx context.Context) {
+	gateway, err := NewFIXGateway(GetConfig().FIX)
+	if err != nil {
+		log.Printf("FIX gateway unable to start. Error: %s\n", err)
+		return
+	}
+
+	listener, err := net.Listen("tcp", gateway.config.ListenAddress)
+	if err != nil {
+		log.Printf("FIX gateway unable to listen on %s. Error: %s\n", gateway.config.ListenAddress, err)
+		return
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	log.Printf("FIX support enabled. Accepting %s on %s. Next MsgSeqNum in/out %d/%d.\n", gateway.config.TargetCompID, listener.Addr(), gateway.state.NextTargetSeqNum, gateway.state.NextSenderSeqNum)
+	go gateway.acceptRoutine(ctx, listener)
+	gateway.Run(ctx)
+}
+
+// NewFIXGateway returns the gateway with its session restored from storage.
+func NewFIXGateway(cfg FIXConfig) (*FIXGateway, error) {
+	if bot.storage == nil {
+		return nil, errors.New(WarningFIXStorageDisabled)
+	}
+
+	g := &FIXGateway{
+		config:      cfg,
+		session:     cfg.SenderCompID + "-" + cfg.TargetCompID,
+		marketData:  make(map[string][]*fixMarketData),
+		connections: make(chan net.Conn),
+		incoming:    make(chan fixIncoming),
+		submitted:   make(chan fixSubmitResult),
+		polled:      make(chan []fixPollResult),
+	}
+
+	state, found, err := bot.storage.GetFIXSession(g.session)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		state = FIXSessionState{NextSenderSeqNum: 1, NextTargetSeqNum: 1}
+	}
+	if state.Orders == nil {
+		state.Orders = make(map[string]FIXOrder)
+	}
+	if state.ClOrdIDs == nil {
+		state.ClOrdIDs = make(map[string]bool)
+	}
+	g.state = state
+	return g, nil
+}
+
+func (g *FIXGateway) acceptRoutine(ctx context.Context, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("FIX gateway unable to accept connection. Error: %s\n", err)
+			if !SleepContext(ctx, time.Second) {
+				return
+			}
+			continue
+		}
+
+		select {
+		case g.connections <- conn:
+		case <-ctx.Done():
+			conn.Close()
+			return
+		}
+	}
+}
+
+func (g *FIXGateway) readRoutine(ctx context.Context, conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		message, err := ReadFIXMessage(reader)
+		select {
+		case g.incoming <- fixIncoming{conn, message, err}:
+		case <-ctx.Done():
+			return
+		}
+
+		if err != nil && !IsFIXCheckSumError(err) {
+			return
+		}
+	}
+}
+
+// Run handles the session, order updates and market data until ctx is
+// cancelled. Execution reports for orders which change while the
+// counterparty is away are kept, to be resent once it logs on again.
+func (g *FIXGateway) Run(ctx context.Context) {
+	updates := SubscribeFeed(FIX_SUBSCRIBER_BUFFER)
+	defer UnsubscribeFeed(updates)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	lastPoll := time.Time{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			g.logout("")
+			return
+		case conn := <-g.connections:
+			g.accept(ctx, conn)
+		case in := <-g.incoming:
+			if in.conn != g.conn {
+				continue
+			}
+			if IsFIXCheckSumError(in.err) {
+				g.handleGarbled(in.err)
+				continue
+			}
+			if in.err != nil {
+				g.disconnect(in.err.Error())
+				continue
+			}
+			g.lastReceived = time.Now()
+			g.testRequested = false
+			g.handle(ctx, in.message)
+		case update := <-updates:
+			g.handleFeedUpdate(update)
+		case result := <-g.submitted:
+			g.handleSubmitResult(result)
+		case results := <-g.polled:
+			g.polling = false
+			g.handlePollResults(results)
+		case now := <-ticker.C:
+			g.checkTimers(now)
+			if now.Sub(lastPoll) >= FIX_ORDER_POLL_INTERVAL {
+				lastPoll = now
+				g.pollOrders(ctx)
+			}
+		}
+	}
+}
+
+func (g *FIXGateway) accept(ctx context.Context, conn net.Conn) {
+	if g.conn != nil {
+		log.Printf("FIX: Refusing connection from %s as a session is already connected.\n", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+
+	log.Printf("FIX: Connection from %s.\n", conn.RemoteAddr())
+	g.conn = conn
+	g.connected = time.Now()
+	g.lastReceived = g.connected
+	go g.readRoutine(ctx, conn)
+}
+
+func (g *FIXGateway) disconnect(reason string) {
+	if g.conn == nil {
+		return
+	}
+
+	g.conn.Close()
+	if reason != "" {
+		log.Printf("FIX: %s disconnected. %s\n", g.conn.RemoteAddr(), reason)
+	} else {
+		log.Printf("FIX: %s disconnected.\n", g.conn.RemoteAddr())
+	}
+	g.conn = nil
+	g.loggedOn = false
+	g.testRequested = false
+	g.resendTo = 0
+	for id := range g.marketData {
+		g.removeMarketData(id)
+	}
+}
+
+// logout sends a Logout with the reason, if logged on, and disconnects.
+func (g *FIXGateway) logout(reason string) {
+	if g.loggedOn {
+		message := NewFIXMessage(FIX_MSG_LOGOUT)
+		if reason != "" {
+			message.Add(FIX_TAG_TEXT, reason)
+		}
+		g.send(message)
+	}
+	g.disconnect(reason)
+}
+
+// checkTimers sends heartbeats, and test requests once the counterparty has
+// been quiet for longer than its heartbeat interval. The connection is
+// dropped if a test request gets no reply.
+func (g *FIXGateway) checkTimers(now time.Time) {
+	if g.conn == nil {
+		return
+	}
+
+	if !g.loggedOn {
+		if now.Sub(g.connected) >= FIX_LOGON_TIMEOUT {
+			g.disconnect(ErrFIXLogonTimeout)
+		}
+		return
+	}
+
+	// Allow a fifth of the interval for transmission, as the FIX spec suggests.
+	timeout := g.heartbeat + g.heartbeat/5
+	if now.Sub(g.lastReceived) >= timeout*2 && g.testRequested {
+		g.logout(ErrFIXTestRequestTimeout)
+		return
+	}
+
+	if now.Sub(g.lastReceived) >= timeout && !g.testRequested {
+		message := NewFIXMessage(FIX_MSG_TEST_REQUEST)
+		message.AddTime(FIX_TAG_TEST_REQ_ID, now)
+		g.send(message)
+		g.testRequested = true
+		return
+	}
+
+	if now.Sub(g.lastSent) >= g.heartbeat {
+		g.send(NewFIXMessage(FIX_MSG_HEARTBEAT))
+	}
+}
+
+// isFIXResendable reports whether sent messages of a type are kept for
+// resending. Session messages and market data are gap filled instead.
+func isFIXResendable(msgType string) bool {
+	switch msgType {
+	case FIX_MSG_HEARTBEAT, FIX_MSG_TEST_REQUEST, FIX_MSG_RESEND_REQUEST, FIX_MSG_REJECT, FIX_MSG_SEQUENCE_RESET, FIX_MSG_LOGOUT, FIX_MSG_LOGON,
+		FIX_MSG_MARKET_DATA_SNAPSHOT, FIX_MSG_MARKET_DATA_INCREMENTAL_REFRESH:
+		return false
+	}
+	return true
+}
+
+func (g *FIXGateway) save() {
+	err := bot.storage.SetFIXSession(g.session, g.state, 0, nil)
+	if err != nil {
+		log.Printf("FIX: Unable to save session state. Error: %s\n", err)
+	}
+}
+
+// send gives a message the next MsgSeqNum and sends it. Messages which can
+// be resent are kept, and only kept while the counterparty isn't logged on.
+// Other messages are dropped then.
+func (g *FIXGateway) send(message FIXMessage) {
+	resendable := isFIXResendable(message.Type())
+	online := g.conn != nil && (g.loggedOn || message.Type() == FIX_MSG_LOGON || message.Type() == FIX_MSG_LOGOUT)
+	if !resendable && !online {
+		return
+	}
+
+	seqNum := g.state.NextSenderSeqNum
+	g.state.NextSenderSeqNum++
+	raw := g.encode(message, seqNum, time.Now(), "")
+
+	kept := []byte(nil)
+	if resendable {
+		kept = raw
+	}
+	err := bot.storage.SetFIXSession(g.session, g.state, seqNum, kept)
+	if err != nil {
+		log.Printf("FIX: Unable to save session state. Error: %s\n", err)
+	}
+
+	if online {
+		g.write(raw)
+	}
+}
+
+// encode adds the standard header to a message. A resent message keeps its
+// original SendingTime as OrigSendingTime and is flagged as a possible
+// duplicate.
+func (g *FIXGateway) encode(message FIXMessage, seqNum int, sendingTime time.Time, origSendingTime string) []byte {
+	result := NewFIXMessage(message.Type())
+	result.Add(FIX_TAG_SENDER_COMP_ID, g.config.SenderCompID)
+	result.Add(FIX_TAG_TARGET_COMP_ID, g.config.TargetCompID)
+	result.AddInt(FIX_TAG_MSG_SEQ_NUM, seqNum)
+	result.AddTime(FIX_TAG_SENDING_TIME, sendingTime)
+	if origSendingTime != "" {
+		result.Add(FIX_TAG_POSS_DUP_FLAG, FIX_YES)
+		result.Add(FIX_TAG_ORIG_SENDING_TIME, origSendingTime)
+	}
+
+	for _, x := range message {
+		switch x.Tag {
+		case FIX_TAG_MSG_TYPE, FIX_TAG_SENDER_COMP_ID, FIX_TAG_TARGET_COMP_ID, FIX_TAG_MSG_SEQ_NUM, FIX_TAG_SENDING_TIME, FIX_TAG_POSS_DUP_FLAG, FIX_TAG_ORIG_SENDING_TIME:
+			continue
+		}
+		result = append(result, x)
+	}
+	return EncodeFIXMessage(result)
+}
+
+func (g *FIXGateway) write(raw []byte) {
+	if g.conn == nil {
+		return
+	}
+
+	g.conn.SetWriteDeadline(time.Now().Add(FIX_WRITE_TIMEOUT))
+	_, err := g.conn.Write(raw)
+	if err != nil {
+		g.disconnect(err.Error())
+		return
+	}
+	g.lastSent = time.Now()
+}
+
+func (g *FIXGateway) handle(ctx context.Context, message FIXMessage) {
+	sender, _ := message.Get(FIX_TAG_SENDER_COMP_ID)
+	target, _ := message.Get(FIX_TAG_TARGET_COMP_ID)
+	if sender != g.config.TargetCompID || target != g.config.SenderCompID {
+		g.logout(fmt.Sprintf(ErrFIXCompIDs, sender, target))
+		return
+	}
+
+	seqNum, err := message.GetInt(FIX_TAG_MSG_SEQ_NUM)
+	if err != nil {
+		g.logout(err.Error())
+		return
+	}
+
+	if !g.loggedOn {
+		if message.Type() != FIX_MSG_LOGON {
+			g.disconnect(ErrFIXLogonRequired)
+			return
+		}
+		g.handleLogon(message, seqNum)
+		return
+	}
+
+	// A SequenceReset in reset mode applies whatever its MsgSeqNum.
+	gapFill, _ := message.Get(FIX_TAG_GAP_FILL_FLAG)
+	if message.Type() == FIX_MSG_SEQUENCE_RESET && gapFill != FIX_YES {
+		g.handleSequenceReset(message, seqNum)
+		return
+	}
+
+	expected := g.state.NextTargetSeqNum
+	if seqNum > expected {
+		if message.Type() == FIX_MSG_LOGOUT {
+			g.logout("")
+			return
+		}
+
+		// Later messages are dropped as they will be resent as well.
+		if g.resendTo == 0 {
+			g.resendTo = seqNum
+			g.sendResendRequest(expected)
+		}
+		return
+	}
+
+	if seqNum < expected {
+		possDup, _ := message.Get(FIX_TAG_POSS_DUP_FLAG)
+		if possDup != FIX_YES {
+			g.logout(fmt.Sprintf(ErrFIXSeqNumTooLow, expected, seqNum))
+		}
+		return
+	}
+
+	g.setNextTargetSeqNum(expected + 1)
+	switch message.Type() {
+	case FIX_MSG_HEARTBEAT, FIX_MSG_LOGON:
+	case FIX_MSG_TEST_REQUEST:
+		heartbeat := NewFIXMessage(FIX_MSG_HEARTBEAT)
+		id, _ := message.Get(FIX_TAG_TEST_REQ_ID)
+		heartbeat.Add(FIX_TAG_TEST_REQ_ID, id)
+		g.send(heartbeat)
+	case FIX_MSG_RESEND_REQUEST:
+		g.handleResendRequest(message, seqNum)
+	case FIX_MSG_REJECT:
+		text, _ := message.Get(FIX_TAG_TEXT)
+		log.Printf("FIX: Message rejected by %s. %s\n", g.config.TargetCompID, text)
+	case FIX_MSG_SEQUENCE_RESET:
+		g.handleSequenceReset(message, seqNum)
+	case FIX_MSG_LOGOUT:
+		text, _ := message.Get(FIX_TAG_TEXT)
+		g.send(NewFIXMessage(FIX_MSG_LOGOUT))
+		g.disconnect(text)
+	case FIX_MSG_NEW_ORDER_SINGLE:
+		g.handleNewOrderSingle(ctx, message, seqNum)
+	case FIX_MSG_ORDER_CANCEL_REQUEST:
+		g.handleOrderCancelRequest(message, seqNum)
+	case FIX_MSG_MARKET_DATA_REQUEST:
+		g.handleMarketDataRequest(message, seqNum)
+	default:
+		reject := NewFIXMessage(FIX_MSG_BUSINESS_MESSAGE_REJECT)
+		reject.AddInt(FIX_TAG_REF_SEQ_NUM, seqNum)
+		reject.Add(FIX_TAG_REF_MSG_TYPE, message.Type())
+		reject.Add(FIX_TAG_BUSINESS_REJECT_REASON, FIX_BUSINESS_REJ_UNSUPPORTED)
+		reject.Add(FIX_TAG_TEXT, fmt.Sprintf(ErrFIXUnsupportedMsgType, message.Type()))
+		g.send(reject)
+	}
+}
+
+// handleGarbled asks for a message with a bad CheckSum to be resent. Its
+// MsgSeqNum can't be trusted, so everything from the expected MsgSeqNum is
+// asked for, and later messages are dropped until it arrives.
+func (g *FIXGateway) handleGarbled(err error) {
+	log.Printf("FIX: Received a garbled message. Error: %s\n", err)
+	if !g.loggedOn || g.resendTo != 0 {
+		return
+	}
+
+	g.resendTo = g.state.NextTargetSeqNum
+	g.sendResendRequest(g.state.NextTargetSeqNum)
+}
+
+func (g *FIXGateway) setNextTargetSeqNum(seqNum int) {
+	g.state.NextTargetSeqNum = seqNum
+	if g.resendTo != 0 && seqNum > g.resendTo {
+		g.resendTo = 0
+	}
+	g.save()
+}
+
+func (g *FIXGateway) sendResendRequest(begin int) {
+	message := NewFIXMessage(FIX_MSG_RESEND_REQUEST)
+	message.AddInt(FIX_TAG_BEGIN_SEQ_NO, begin)
+	message.AddInt(FIX_TAG_END_SEQ_NO, 0)
+	g.send(message)
+}
+
+// reject sends a session level Reject of a message.
+func (g *FIXGateway) reject(message FIXMessage, seqNum, tag int, reason, text string) {
+	reject := NewFIXMessage(FIX_MSG_REJECT)
+	reject.AddInt(FIX_TAG_REF_SEQ_NUM, seqNum)
+	reject.AddInt(FIX_TAG_REF_TAG_ID, tag)
+	reject.Add(FIX_TAG_REF_MSG_TYPE, message.Type())
+	reject.Add(FIX_TAG_SESSION_REJECT_REASON, reason)
+	reject.Add(FIX_TAG_TEXT, text)
+	g.send(reject)
+}
+
+// handleLogon logs the counterparty on. Its sequence numbers carry on from
+// the last session unless it asks for them to be reset.
+func (g *FIXGateway) handleLogon(message FIXMessage, seqNum int) {
+	password, _ := message.Get(FIX_TAG_PASSWORD)
+	if subtle.ConstantTimeCompare([]byte(password), []byte(GetConfig().FIX.Password)) != 1 {
+		g.disconnect(ErrFIXPassword)
+		return
+	}
+
+	heartbeat, err := message.GetInt(FIX_TAG_HEART_BT_INT)
+	if err != nil || heartbeat <= 0 || heartbeat > FIX_MAX_HEARTBEAT_INTERVAL {
+		value, _ := message.Get(FIX_TAG_HEART_BT_INT)
+		g.disconnect(fmt.Sprintf(ErrFIXHeartBtInt, value))
+		return
+	}
+
+	reset, _ := message.Get(FIX_TAG_RESET_SEQ_NUM_FLAG)
+	if reset == FIX_YES {
+		log.Printf("FIX: %s reset sequence numbers.\n", g.config.TargetCompID)
+		g.state.NextSenderSeqNum = 1
+		g.state.NextTargetSeqNum = 1
+		err = bot.storage.DeleteFIXMessages(g.session)
+		if err != nil {
+			log.Printf("FIX: Unable to delete sent messages. Error: %s\n", err)
+		}
+	}
+
+	g.loggedOn = true
+	g.heartbeat = time.Duration(heartbeat) * time.Second
+	expected := g.state.NextTargetSeqNum
+	if seqNum < expected {
+		g.logout(fmt.Sprintf(ErrFIXSeqNumTooLow, expected, seqNum))
+		return
+	}
+
+	response := NewFIXMessage(FIX_MSG_LOGON)
+	response.AddInt(FIX_TAG_ENCRYPT_METHOD, 0)
+	response.AddInt(FIX_TAG_HEART_BT_INT, heartbeat)
+	if reset == FIX_YES {
+		response.Add(FIX_TAG_RESET_SEQ_NUM_FLAG, FIX_YES)
+	}
+	g.send(response)
+	log.Printf("FIX: %s logged on from %s.\n", g.config.TargetCompID, g.conn.RemoteAddr())
+
+	if seqNum > expected {
+		g.resendTo = seqNum
+		g.sendResendRequest(expected)
+		return
+	}
+	g.setNextTargetSeqNum(expected + 1)
+}
+
+// handleSequenceReset moves the expected MsgSeqNum forward, either over
+// messages the counterparty chose not to resend or, in reset mode, to
+// recover from lost messages.
+func (g *FIXGateway) handleSequenceReset(message FIXMessage, seqNum int) {
+	newSeqNo, err := message.GetInt(FIX_TAG_NEW_SEQ_NO)
+	if err != nil {
+		g.reject(message, seqNum, FIX_TAG_NEW_SEQ_NO, FIX_SESSION_REJ_REQUIRED_TAG, err.Error())
+		return
+	}
+
+	if newSeqNo < g.state.NextTargetSeqNum {
+		g.reject(message, seqNum, FIX_TAG_NEW_SEQ_NO, FIX_SESSION_REJ_INCORRECT_VALUE, fmt.Sprintf(ErrFIXNewSeqNoTooLow, newSeqNo, g.state.NextTargetSeqNum))
+		return
+	}
+	g.setNextTargetSeqNum(newSeqNo)
+}
+
+// handleResendRequest resends the kept messages in the requested range and
+// gap fills over the rest.
+func (g *FIXGateway) handleResendRequest(message FIXMessage, seqNum int) {
+	begin, err := message.GetInt(FIX_TAG_BEGIN_SEQ_NO)
+	if err != nil {
+		g.reject(message, seqNum, FIX_TAG_BEGIN_SEQ_NO, FIX_SESSION_REJ_REQUIRED_TAG, err.Error())
+		return
+	}
+
+	end, err := message.GetInt(FIX_TAG_END_SEQ_NO)
+	if err != nil {
+		g.reject(message, seqNum, FIX_TAG_END_SEQ_NO, FIX_SESSION_REJ_REQUIRED_TAG, err.Error())
+		return
+	}
+
+	if begin < 1 || (end != 0 && begin > end) {
+		g.reject(message, seqNum, FIX_TAG_BEGIN_SEQ_NO, FIX_SESSION_REJ_INCORRECT_VALUE, fmt.Sprintf(ErrFIXResendRange, begin, end))
+		return
+	}
+
+	last := g.state.NextSenderSeqNum - 1
+	if begin > last {
+		g.reject(message, seqNum, FIX_TAG_BEGIN_SEQ_NO, FIX_SESSION_REJ_INCORRECT_VALUE, fmt.Sprintf(ErrFIXResendBeyondLast, begin, last))
+		return
+	}
+	if end == 0 || end > last {
+		end = last
+	}
+
+	log.Printf("FIX: Resending messages %d to %d.\n", begin, end)
+	messages, err := bot.storage.GetFIXMessages(g.session, begin, end)
+	if err != nil {
+		log.Printf("FIX: Unable to load sent messages. Error: %s\n", err)
+	}
+
+	gapStart := 0
+	for x := begin; x <= end && g.conn != nil; x++ {
+		resent := FIXMessage(nil)
+		if raw, ok := messages[x]; ok {
+			resent, err = ParseFIXMessage(raw)
+			if err != nil {
+				log.Printf("FIX: Unable to parse sent message %d. Error: %s\n", x, err)
+				resent = nil
+			}
+		}
+
+		if resent == nil {
+			if gapStart == 0 {
+				gapStart = x
+			}
+			continue
+		}
+
+		if gapStart != 0 {
+			g.sendGapFill(gapStart, x)
+			gapStart = 0
+		}
+		sendingTime, _ := resent.Get(FIX_TAG_SENDING_TIME)
+		g.write(g.encode(resent, x, time.Now(), sendingTime))
+	}
+
+	if gapStart != 0 {
+		g.sendGapFill(gapStart, end+1)
+	}
+}
+
+func (g *FIXGateway) sendGapFill(seqNum, newSeqNo int) {
+	now := time.Now()
+	message := NewFIXMessage(FIX_MSG_SEQUENCE_RESET)
+	message.Add(FIX_TAG_GAP_FILL_FLAG, FIX_YES)
+	message.AddInt(FIX_TAG_NEW_SEQ_NO, newSeqNo)
+	g.write(g.encode(message, seqNum, now, now.UTC().Format(FIX_TIME_FORMAT)))
+}
+
+func getFIXExchange(name string) (IBotExchange, Exchanges, error) {
+	if name == "" {
+		return nil, Exchanges{}, errors.New(ErrFIXExchangeRequired)
+	}
+
+	exch, err := GetExchangeConfig(name)
+	if err != nil {
+		return nil, Exchanges{}, err
+	}
+
+	exchange := bot.exchange.GetExchangeByName(exch.Name)
+	if exchange == nil {
+		return nil, Exchanges{}, fmt.Errorf(ErrExchangeNotFound, name)
+	}
+	return exchange, exch, nil
+}
+
+func getFIXOrderManager(name string) (IOrderManager, Exchanges, error) {
+	exchange, exch, err := getFIXExchange(name)
+	if err != nil {
+		return nil, Exchanges{}, err
+	}
+
+	manager, err := GetOrderManager(exchange)
+	if err != nil {
+		return nil, Exchanges{}, err
+	}
+	return manager, exch, nil
+}
+
+func fixSide(side string) string {
+	switch side {
+	case ORDER_SIDE_BUY:
+		return FIX_SIDE_BUY
+	case ORDER_SIDE_SELL:
+		return FIX_SIDE_SELL
+	}
+	return side
+}
+
+func fixOrdStatus(order FIXOrder) string {
+	if order.Filled > 0 {
+		return FIX_ORD_STATUS_PARTIALLY_FILLED
+	}
+	return FIX_ORD_STATUS_NEW
+}
+
+func (g *FIXGateway) newExecutionReport(order FIXOrder, execType, ordStatus string) FIXMessage {
+	orderID := order.OrderID
+	if orderID == "" {
+		orderID = FIX_NO_ORDER_ID
+	}
+
+	leaves := order.Quantity - order.Filled
+	if ordStatus == FIX_ORD_STATUS_FILLED || ordStatus == FIX_ORD_STATUS_CANCELED || ordStatus == FIX_ORD_STATUS_REJECTED {
+		leaves = 0
+	}
+
+	avgPx := 0.0
+	if order.Filled > 0 {
+		avgPx = order.FilledValue / order.Filled
+	}
+
+	g.state.NextExecID++
+	report := NewFIXMessage(FIX_MSG_EXECUTION_REPORT)
+	report.Add(FIX_TAG_ORDER_ID, orderID)
+	report.Add(FIX_TAG_CL_ORD_ID, order.ClOrdID)
+	report.AddInt(FIX_TAG_EXEC_ID, g.state.NextExecID)
+	report.Add(FIX_TAG_EXEC_TYPE, execType)
+	report.Add(FIX_TAG_ORD_STATUS, ordStatus)
+	report.Add(FIX_TAG_SYMBOL, order.Symbol)
+	if order.Exchange != "" {
+		report.Add(FIX_TAG_SECURITY_EXCHANGE, order.Exchange)
+	}
+	report.Add(FIX_TAG_SIDE, fixSide(order.Side))
+	report.AddFloat(FIX_TAG_ORDER_QTY, order.Quantity)
+	if order.Price > 0 {
+		report.AddFloat(FIX_TAG_PRICE, order.Price)
+	}
+	report.AddFloat(FIX_TAG_LEAVES_QTY, leaves)
+	report.AddFloat(FIX_TAG_CUM_QTY, order.Filled)
+	report.AddFloat(FIX_TAG_AVG_PX, avgPx)
+	report.AddTime(FIX_TAG_TRANSACT_TIME, time.Now())
+	return report
+}
+
+func (g *FIXGateway) rejectOrder(order FIXOrder, reason, text string) {
+	log.Printf("FIX: Order %s rejected. %s\n", order.ClOrdID, text)
+	report := g.newExecutionReport(order, FIX_EXEC_TYPE_REJECTED, FIX_ORD_STATUS_REJECTED)
+	report.Add(FIX_TAG_ORD_REJ_REASON, reason)
+	report.Add(FIX_TAG_TEXT, text)
+	g.send(report)
+}
+
+// useClOrdID records a ClOrdID as used for the session day. It returns false
+// if it has already been used that day.
+func (g *FIXGateway) useClOrdID(clOrdID string, now time.Time) bool {
+	day := now.UTC().Format(FIX_SESSION_DAY_FORMAT)
+	if g.state.SessionDay != day {
+		g.state.SessionDay = day
+		g.state.ClOrdIDs = make(map[string]bool)
+	}
+
+	if g.state.ClOrdIDs[clOrdID] {
+		return false
+	}
+	g.state.ClOrdIDs[clOrdID] = true
+	g.save()
+	return true
+}
+
+// handleNewOrderSingle places a limit order on the exchange named by its
+// SecurityExchange. The order is placed in the background, and its result
+// is handled by handleSubmitResult.
+func (g *FIXGateway) handleNewOrderSingle(ctx context.Context, message FIXMessage, seqNum int) {
+	clOrdID, err := message.GetRequired(FIX_TAG_CL_ORD_ID)
+	if err != nil {
+		g.reject(message, seqNum, FIX_TAG_CL_ORD_ID, FIX_SESSION_REJ_REQUIRED_TAG, err.Error())
+		return
+	}
+
+	order := FIXOrder{ClOrdID: clOrdID}
+	order.Symbol, _ = message.Get(FIX_TAG_SYMBOL)
+	order.Exchange, _ = message.Get(FIX_TAG_SECURITY_EXCHANGE)
+	order.Side, _ = message.Get(FIX_TAG_SIDE)
+	order.Quantity, _ = message.GetFloat(FIX_TAG_ORDER_QTY)
+	order.Price, _ = message.GetFloat(FIX_TAG_PRICE)
+	ordType, _ := message.Get(FIX_TAG_ORD_TYPE)
+
+	if !g.useClOrdID(clOrdID, time.Now()) {
+		g.rejectOrder(order, FIX_ORD_REJ_REASON_DUPLICATE, fmt.Sprintf(ErrFIXDuplicateClOrdID, clOrdID))
+		return
+	}
+
+	switch order.Side {
+	case FIX_SIDE_BUY:
+		order.Side = ORDER_SIDE_BUY
+	case FIX_SIDE_SELL:
+		order.Side = ORDER_SIDE_SELL
+	default:
+		g.rejectOrder(order, FIX_ORD_REJ_REASON_OTHER, fmt.Sprintf(ErrFIXUnsupportedSide, order.Side))
+		return
+	}
+
+	if ordType != FIX_ORD_TYPE_LIMIT {
+		g.rejectOrder(order, FIX_ORD_REJ_REASON_OTHER, fmt.Sprintf(ErrFIXUnsupportedOrdType, ordType))
+		return
+	}
+
+	if order.Symbol == "" {
+		g.rejectOrder(order, FIX_ORD_REJ_REASON_OTHER, fmt.Sprintf(ErrFIXTagMissing, FIX_TAG_SYMBOL))
+		return
+	}
+
+	if order.Quantity <= 0 || order.Price <= 0 {
+		g.rejectOrder(order, FIX_ORD_REJ_REASON_OTHER, ErrFIXInvalidOrderQty)
+		return
+	}
+
+	manager, exch, err := getFIXOrderManager(order.Exchange)
+	if err != nil {
+		g.rejectOrder(order, FIX_ORD_REJ_REASON_OTHER, err.Error())
+		return
+	}
+
+	order.Exchange = exch.Name
+	order.Pair = ParseCLICurrencyPair(exch, order.Symbol)
+	order.Placed = time.Now()
+	g.submitting++
+	go func() {
+		result := fixSubmitResult{Order: order}
+		result.Order.OrderID, result.Err = manager.SubmitOrder(order.Pair, order.Side, order.Quantity, order.Price)
+
+		select {
+		case g.submitted <- result:
+		case <-ctx.Done():
+			if result.Err == nil {
+				log.Printf("FIX: Order %s placed on %s as %s during shutdown and is no longer tracked.\n", order.ClOrdID, order.Exchange, result.Order.OrderID)
+			}
+		}
+	}()
+}
+
+// handleSubmitResult reports an order placed by handleNewOrderSingle, and
+// applies any updates to it which arrived while it was being placed.
+func (g *FIXGateway) handleSubmitResult(result fixSubmitResult) {
+	g.submitting--
+	order := result.Order
+	if result.Err != nil {
+		g.rejectOrder(order, FIX_ORD_REJ_REASON_OTHER, result.Err.Error())
+	} else {
+		log.Printf("FIX: Order %s placed on %s as %s.\n", order.ClOrdID, order.Exchange, order.OrderID)
+		g.state.Orders[order.ClOrdID] = order
+		g.send(g.newExecutionReport(order, FIX_EXEC_TYPE_NEW, FIX_ORD_STATUS_NEW))
+
+		unmatched := g.unmatched[:0]
+		for _, x := range g.unmatched {
+			if !g.updateOrder(x.Exchange, x.Data.(OrderDetail)) {
+				unmatched = append(unmatched, x)
+			}
+		}
+		g.unmatched = unmatched
+	}
+
+	if g.submitting == 0 {
+		g.unmatched = nil
+	}
+}
+
+func (g *FIXGateway) rejectCancel(order FIXOrder, clOrdID, origClOrdID, ordStatus, reason, text string) {
+	log.Printf("FIX: Cancel of order %s rejected. %s\n", origClOrdID, text)
+	orderID := order.OrderID
+	if orderID == "" {
+		orderID = FIX_NO_ORDER_ID
+	}
+
+	reject := NewFIXMessage(FIX_MSG_ORDER_CANCEL_REJECT)
+	reject.Add(FIX_TAG_ORDER_ID, orderID)
+	reject.Add(FIX_TAG_CL_ORD_ID, clOrdID)
+	reject.Add(FIX_TAG_ORIG_CL_ORD_ID, origClOrdID)
+	reject.Add(FIX_TAG_ORD_STATUS, ordStatus)
+	reject.Add(FIX_TAG_CXL_REJ_RESPONSE_TO, FIX_CXL_REJ_RESPONSE_TO_CANCEL)
+	reject.Add(FIX_TAG_CXL_REJ_REASON, reason)
+	reject.Add(FIX_TAG_TEXT, text)
+	g.send(reject)
+}
+
+func (g *FIXGateway) handleOrderCancelRequest(message FIXMessage, seqNum int) {
+	clOrdID, err := message.GetRequired(FIX_TAG_CL_ORD_ID)
+	if err != nil {
+		g.reject(message, seqNum, FIX_TAG_CL_ORD_ID, FIX_SESSION_REJ_REQUIRED_TAG, err.Error())
+		return
+	}
+
+	origClOrdID, err := message.GetRequired(FIX_TAG_ORIG_CL_ORD_ID)
+	if err != nil {
+		g.reject(message, seqNum, FIX_TAG_ORIG_CL_ORD_ID, FIX_SESSION_REJ_REQUIRED_TAG, err.Error())
+		return
+	}
+
+	order, ok := g.state.Orders[origClOrdID]
+	if !ok {
+		g.rejectCancel(order, clOrdID, origClOrdID, FIX_ORD_STATUS_REJECTED, FIX_CXL_REJ_REASON_UNKNOWN, fmt.Sprintf(ErrFIXUnknownOrder, origClOrdID))
+		return
+	}
+
+	manager, _, err := getFIXOrderManager(order.Exchange)
+	if err == nil {
+		err = manager.CancelOrderByID(order.Pair, order.OrderID)
+	}
+	if err != nil {
+		g.rejectCancel(order, clOrdID, origClOrdID, fixOrdStatus(order), FIX_CXL_REJ_REASON_OTHER, err.Error())
+		return
+	}
+
+	log.Printf("FIX: Order %s cancelled on %s.\n", origClOrdID, order.Exchange)
+	delete(g.state.Orders, origClOrdID)
+	order.ClOrdID = clOrdID
+	report := g.newExecutionReport(order, FIX_EXEC_TYPE_CANCELED, FIX_ORD_STATUS_CANCELED)
+	report.Add(FIX_TAG_ORIG_CL_ORD_ID, origClOrdID)
+	g.send(report)
+}
+
+// updateOrder sends the execution report for a change to an order placed
+// through the gateway. It returns false if the order isn't one of them.
+func (g *FIXGateway) updateOrder(exchange string, detail OrderDetail) bool {
+	for clOrdID, order := range g.state.Orders {
+		if order.Exchange != exchange || order.OrderID != detail.ID {
+			continue
+		}
+
+		switch detail.Status {
+		case ORDER_STATUS_CANCELLED:
+			// Fills before the cancel are reported first.
+			if detail.Filled > order.Filled && detail.Filled < order.Quantity {
+				order = g.fillOrder(order, detail.Filled, detail.Price)
+			}
+			log.Printf("FIX: Order %s cancelled on %s.\n", clOrdID, exchange)
+			delete(g.state.Orders, clOrdID)
+			g.send(g.newExecutionReport(order, FIX_EXEC_TYPE_CANCELED, FIX_ORD_STATUS_CANCELED))
+		case ORDER_STATUS_FILLED:
+			g.fillOrder(order, order.Quantity, detail.Price)
+		default:
+			if detail.Filled > order.Filled {
+				g.fillOrder(order, detail.Filled, detail.Price)
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// fillOrder reports an order filled up to filled, at the average price
+// avgPrice over all its fills. It returns the updated order.
+func (g *FIXGateway) fillOrder(order FIXOrder, filled, avgPrice float64) FIXOrder {
+	if avgPrice <= 0 {
+		avgPrice = order.Price
+	}
+
+	lastQty := filled - order.Filled
+	lastPx := (avgPrice*filled - order.FilledValue) / lastQty
+	if lastPx <= 0 {
+		lastPx = avgPrice
+	}
+	order.Filled = filled
+	order.FilledValue = avgPrice * filled
+	ordStatus := FIX_ORD_STATUS_PARTIALLY_FILLED
+	if filled >= order.Quantity {
+		ordStatus = FIX_ORD_STATUS_FILLED
+		delete(g.state.Orders, order.ClOrdID)
+	} else {
+		g.state.Orders[order.ClOrdID] = order
+	}
+
+	log.Printf("FIX: Order %s filled %f of %f on %s.\n", order.ClOrdID, filled, order.Quantity, order.Exchange)
+	report := g.newExecutionReport(order, FIX_EXEC_TYPE_TRADE, ordStatus)
+	report.AddFloat(FIX_TAG_LAST_QTY, lastQty)
+	report.AddFloat(FIX_TAG_LAST_PX, lastPx)
+	g.send(report)
+	return order
+}
+
+// pollOrders fetches the open orders of exchanges with orders placed through
+// the gateway, and how those which are no longer open closed, in the
+// background. Paper orders are left to the feed, which reports them as they
+// change.
+func (g *FIXGateway) pollOrders(ctx context.Context) {
+	if g.polling {
+		return
+	}
+
+	polls := make(map[string][]FIXOrder)
+	for _, order := range g.state.Orders {
+		if IsPaperTrading(order.Exchange) {
+			continue
+		}
+		polls[order.Exchange] = append(polls[order.Exchange], order)
+	}
+	if len(polls) == 0 {
+		return
+	}
+
+	g.polling = true
+	go func() {
+		results := []fixPollResult{}
+		for exchange, orders := range polls {
+			result := fixPollResult{Exchange: exchange}
+			result.Open, result.Err = getFIXOpenOrders(exchange)
+			if result.Err == nil {
+				result.Closed = getFIXClosedOrders(exchange, orders, result.Open)
+			}
+			results = append(results, result)
+		}
+
+		select {
+		case g.polled <- results:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+func getFIXOpenOrders(exchange string) ([]OrderDetail, error) {
+	manager, _, err := getFIXOrderManager(exchange)
+	if err != nil {
+		return nil, err
+	}
+	return manager.GetOpenOrderDetails()
+}
+
+// getFIXClosedOrders works out from the account's fills how the orders which
+// are not among open closed.
+func getFIXClosedOrders(exchange string, orders []FIXOrder, open []OrderDetail) []OrderDetail {
+	ids := make(map[string]bool)
+	for _, x := range open {
+		ids[x.ID] = true
+	}
+
+	exch, _, err := getFIXExchange(exchange)
+	if err != nil {
+		return nil
+	}
+
+	closed := []OrderDetail{}
+	for _, x := range orders {
+		if ids[x.OrderID] {
+			continue
+		}
+		order := OrderDetail{ID: x.OrderID, Pair: x.Pair, Side: x.Side, Price: x.Price, Amount: x.Quantity, Filled: x.Filled}
+		closed = append(closed, GetClosedOrderDetail(exch, order, x.Placed))
+	}
+	return closed
+}
+
+// handlePollResults reports orders which have filled or closed.
+func (g *FIXGateway) handlePollResults(results []fixPollResult) {
+	for _, result := range results {
+		if result.Err != nil {
+			log.Printf("FIX: Unable to fetch %s open orders. Error: %s\n", result.Exchange, result.Err)
+			continue
+		}
+
+		for _, x := range result.Open {
+			x.Status = ORDER_STATUS_OPEN
+			g.updateOrder(result.Exchange, x)
+		}
+		for _, x := range result.Closed {
+			g.updateOrder(result.Exchange, x)
+		}
+	}
+}
+
+func (g *FIXGateway) handleFeedUpdate(update FeedUpdate) {
+	switch update.Channel {
+	case FEED_CHANNEL_ORDERS:
+		if !g.updateOrder(update.Exchange, update.Data.(OrderDetail)) && g.submitting > 0 {
+			g.unmatched = append(g.unmatched, update)
+		}
+	case FEED_CHANNEL_BOOK:
+		for id, subscriptions := range g.marketData {
+			for _, x := range subscriptions {
+				if (x.Bids || x.Offers) && x.Exchange == update.Exchange && x.Pair == update.Pair {
+					g.sendBook(id, x, update.Data.(Orderbook))
+				}
+			}
+		}
+	case FEED_CHANNEL_TRADES:
+		for id, subscriptions := range g.marketData {
+			for _, x := range subscriptions {
+				if x.Trades && x.Exchange == update.Exchange && x.Pair == update.Pair {
+					g.sendTrades(id, x, update.Data.([]TradeRecord))
+				}
+			}
+		}
+	}
+}
+
+func (g *FIXGateway) rejectMarketData(id, reason, text string) {
+	log.Printf("FIX: Market data request %s rejected. %s\n", id, text)
+	reject := NewFIXMessage(FIX_MSG_MARKET_DATA_REQUEST_REJECT)
+	reject.Add(FIX_TAG_MD_REQ_ID, id)
+	if reason != "" {
+		reject.Add(FIX_TAG_MD_REQ_REJ_REASON, reason)
+	}
+	reject.Add(FIX_TAG_TEXT, text)
+	g.send(reject)
+}
+
+// handleMarketDataRequest sends a snapshot of the requested books, or
+// subscribes to their books and trades until unsubscribed or disconnected.
+func (g *FIXGateway) handleMarketDataRequest(message FIXMessage, seqNum int) {
+	id, err := message.GetRequired(FIX_TAG_MD_REQ_ID)
+	if err != nil {
+		g.reject(message, seqNum, FIX_TAG_MD_REQ_ID, FIX_SESSION_REJ_REQUIRED_TAG, err.Error())
+		return
+	}
+
+	subscriptionType, _ := message.Get(FIX_TAG_SUBSCRIPTION_REQUEST_TYPE)
+	switch subscriptionType {
+	case FIX_SUBSCRIPTION_UNSUBSCRIBE:
+		if _, ok := g.marketData[id]; !ok {
+			g.rejectMarketData(id, "", fmt.Sprintf(ErrFIXUnknownMDReqID, id))
+			return
+		}
+		g.removeMarketData(id)
+		log.Printf("FIX: Market data request %s unsubscribed.\n", id)
+		return
+	case FIX_SUBSCRIPTION_SNAPSHOT, FIX_SUBSCRIPTION_SUBSCRIBE:
+	default:
+		g.rejectMarketData(id, FIX_MD_REJ_SUBSCRIPTION_TYPE, fmt.Sprintf(ErrFIXTagInvalid, subscriptionType, FIX_TAG_SUBSCRIPTION_REQUEST_TYPE))
+		return
+	}
+
+	if _, ok := g.marketData[id]; ok && subscriptionType == FIX_SUBSCRIPTION_SUBSCRIBE {
+		g.rejectMarketData(id, FIX_MD_REJ_DUPLICATE_ID, fmt.Sprintf(ErrFIXDuplicateMDReqID, id))
+		return
+	}
+
+	template := fixMarketData{}
+	if value, ok := message.Get(FIX_TAG_MARKET_DEPTH); ok {
+		template.Depth, err = message.GetInt(FIX_TAG_MARKET_DEPTH)
+		if err != nil || template.Depth < 0 {
+			g.rejectMarketData(id, FIX_MD_REJ_MARKET_DEPTH, fmt.Sprintf(ErrFIXTagInvalid, value, FIX_TAG_MARKET_DEPTH))
+			return
+		}
+	}
+
+	updateType, _ := message.Get(FIX_TAG_MD_UPDATE_TYPE)
+	switch updateType {
+	case "", FIX_MD_UPDATE_FULL_REFRESH:
+	case FIX_MD_UPDATE_INCREMENTAL:
+		template.Incremental = true
+	default:
+		g.rejectMarketData(id, FIX_MD_REJ_UPDATE_TYPE, fmt.Sprintf(ErrFIXTagInvalid, updateType, FIX_TAG_MD_UPDATE_TYPE))
+		return
+	}
+
+	for _, entry := range message.Group(FIX_TAG_NO_MD_ENTRY_TYPES, FIX_TAG_MD_ENTRY_TYPE) {
+		entryType, _ := entry.Get(FIX_TAG_MD_ENTRY_TYPE)
+		switch entryType {
+		case FIX_MD_ENTRY_BID:
+			template.Bids = true
+		case FIX_MD_ENTRY_OFFER:
+			template.Offers = true
+		case FIX_MD_ENTRY_TRADE:
+			template.Trades = true
+		default:
+			g.rejectMarketData(id, FIX_MD_REJ_ENTRY_TYPE, fmt.Sprintf(ErrFIXTagInvalid, entryType, FIX_TAG_MD_ENTRY_TYPE))
+			return
+		}
+	}
+	if !template.Bids && !template.Offers && !template.Trades {
+		g.rejectMarketData(id, FIX_MD_REJ_ENTRY_TYPE, ErrFIXMDEntryTypesEmpty)
+		return
+	}
+
+	symbols := message.Group(FIX_TAG_NO_RELATED_SYM, FIX_TAG_SYMBOL, FIX_TAG_SECURITY_EXCHANGE)
+	if len(symbols) == 0 {
+		g.rejectMarketData(id, FIX_MD_REJ_UNKNOWN_SYMBOL, ErrFIXRelatedSymEmpty)
+		return
+	}
+
+	subscriptions := []*fixMarketData{}
+	fetchers := []IOrderbookFetcher{}
+	for _, x := range symbols {
+		subscription := template
+		subscription.Symbol, _ = x.Get(FIX_TAG_SYMBOL)
+		name, _ := x.Get(FIX_TAG_SECURITY_EXCHANGE)
+		if subscription.Symbol == "" {
+			g.rejectMarketData(id, FIX_MD_REJ_UNKNOWN_SYMBOL, fmt.Sprintf(ErrFIXTagMissing, FIX_TAG_SYMBOL))
+			return
+		}
+
+		exchange, exch, err := getFIXExchange(name)
+		if err != nil {
+			g.rejectMarketData(id, FIX_MD_REJ_UNKNOWN_SYMBOL, err.Error())
+			return
+		}
+
+		fetcher, ok := exchange.(IOrderbookFetcher)
+		if !ok && (subscription.Bids || subscription.Offers) {
+			g.rejectMarketData(id, FIX_MD_REJ_ENTRY_TYPE, NewExchangeFeatureError(exchange, "orderbooks").Error())
+			return
+		}
+
+		subscription.Exchange = exch.Name
+		subscription.Pair = ParseCLICurrencyPair(exch, subscription.Symbol)
+		subscriptions = append(subscriptions, &subscription)
+		fetchers = append(fetchers, fetcher)
+	}
+
+	if subscriptionType == FIX_SUBSCRIPTION_SNAPSHOT {
+		for i, x := range subscriptions {
+			orderbook := Orderbook{}
+			if x.Bids || x.Offers {
+				orderbook, err = fetchers[i].GetOrderbookDepth(x.Pair)
+				if err != nil {
+					g.rejectMarketData(id, "", err.Error())
+					return
+				}
+			}
+			x.Incremental = false
+			g.sendBook(id, x, orderbook)
+		}
+		return
+	}
+
+	g.marketData[id] = subscriptions
+	log.Printf("FIX: Market data request %s subscribed to %d symbol(s).\n", id, len(subscriptions))
+	for i, x := range subscriptions {
+		if !x.Bids && !x.Offers {
+			continue
+		}
+
+		last := SubscribeFeedBook(x.Exchange, x.Pair, fetchers[i])
+		if last != nil {
+			g.sendBook(id, x, *last)
+		}
+	}
+}
+
+func (g *FIXGateway) removeMarketData(id string) {
+	for _, x := range g.marketData[id] {
+		if x.Bids || x.Offers {
+			ReleaseFeedBook(x.Exchange, x.Pair)
+		}
+	}
+	delete(g.marketData, id)
+}
+
+// sendBook sends a book to a subscription, as a snapshot the first time and
+// for full refresh subscriptions, and otherwise as the levels which changed
+// since the last book sent.
+func (g *FIXGateway) sendBook(id string, x *fixMarketData, orderbook Orderbook) {
+	if x.Depth > 0 {
+		orderbook = TrimOrderbook(orderbook, x.Depth)
+	}
+	if !x.Offers {
+		orderbook.Asks = nil
+	}
+	if !x.Bids {
+		orderbook.Bids = nil
+	}
+
+	if x.last != nil && reflect.DeepEqual(*x.last, orderbook) {
+		return
+	}
+
+	if x.last == nil || !x.Incremental {
+		message := NewFIXMessage(FIX_MSG_MARKET_DATA_SNAPSHOT)
+		message.Add(FIX_TAG_MD_REQ_ID, id)
+		message.Add(FIX_TAG_SYMBOL, x.Symbol)
+		message.Add(FIX_TAG_SECURITY_EXCHANGE, x.Exchange)
+		message.AddInt(FIX_TAG_NO_MD_ENTRIES, len(orderbook.Bids)+len(orderbook.Asks))
+		for _, side := range []struct {
+			entryType string
+			items     []OrderbookItem
+		}{{FIX_MD_ENTRY_BID, orderbook.Bids}, {FIX_MD_ENTRY_OFFER, orderbook.Asks}} {
+			for _, item := range side.items {
+				message.Add(FIX_TAG_MD_ENTRY_TYPE, side.entryType)
+				message.AddFloat(FIX_TAG_MD_ENTRY_PX, item.Price)
+				message.AddFloat(FIX_TAG_MD_ENTRY_SIZE, item.Amount)
+			}
+		}
+		g.send(message)
+	} else {
+		entries := FIXMessage{}
+		count := addFIXBookChanges(&entries, x, FIX_MD_ENTRY_BID, x.last.Bids, orderbook.Bids)
+		count += addFIXBookChanges(&entries, x, FIX_MD_ENTRY_OFFER, x.last.Asks, orderbook.Asks)
+		g.sendIncrementalRefresh(id, count, entries)
+	}
+	x.last = &orderbook
+}
+
+func (g *FIXGateway) sendTrades(id string, x *fixMarketData, trades []TradeRecord) {
+	entries := FIXMessage{}
+	for _, trade := range trades {
+		addFIXMarketDataEntry(&entries, x, FIX_MD_ACTION_NEW, FIX_MD_ENTRY_TRADE, trade.Price, trade.Amount)
+	}
+	g.sendIncrementalRefresh(id, len(trades), entries)
+}
+
+func (g *FIXGateway) sendIncrementalRefresh(id string, count int, entries FIXMessage) {
+	if count == 0 {
+		return
+	}
+
+	message := NewFIXMessage(FIX_MSG_MARKET_DATA_INCREMENTAL_REFRESH)
+	message.Add(FIX_TAG_MD_REQ_ID, id)
+	message.AddInt(FIX_TAG_NO_MD_ENTRIES, count)
+	g.send(append(message, entries...))
+}
+
+func addFIXMarketDataEntry(entries *FIXMessage, x *fixMarketData, action, entryType string, price, amount float64) {
+	entries.Add(FIX_TAG_MD_UPDATE_ACTION, action)
+	entries.Add(FIX_TAG_MD_ENTRY_TYPE, entryType)
+	entries.Add(FIX_TAG_SYMBOL, x.Symbol)
+	entries.Add(FIX_TAG_SECURITY_EXCHANGE, x.Exchange)
+	entries.AddFloat(FIX_TAG_MD_ENTRY_PX, price)
+	entries.AddFloat(FIX_TAG_MD_ENTRY_SIZE, amount)
+}
+
+// addFIXBookChanges adds an entry for every price level of one side of a
+// book which was removed, changed or added, in that order, and returns how
+// many it added.
+func addFIXBookChanges(entries *FIXMessage, x *fixMarketData, entryType string, previous, current []OrderbookItem) int {
+	amounts := make(map[float64]float64)
+	for _, item := range current {
+		amounts[item.Price] = item.Amount
+	}
+
+	count := 0
+	previousAmounts := make(map[float64]float64)
+	for _, item := range previous {
+		previousAmounts[item.Price] = item.Amount
+		if _, ok := amounts[item.Price]; !ok {
+			addFIXMarketDataEntry(entries, x, FIX_MD_ACTION_DELETE, entryType, item.Price, item.Amount)
+			count++
+		}
+	}
+
+	for _, action := range []string{FIX_MD_ACTION_CHANGE, FIX_MD_ACTION_NEW} {
+		for _, item := range current {
+			amount, ok := previousAmounts[item.Price]
+			if (action == FIX_MD_ACTION_CHANGE && ok && amount != item.Amount) || (action == FIX_MD_ACTION_NEW && !ok) {
+				addFIXMarketDataEntry(entries, x, action, entryType, item.Price, item.Amount)
+				count++
+			}
+		}
+	}
+	return count
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// newFIXTestGateway returns a gateway logged on over an in-memory connection,
+// and the messages it sends.
+func newFIXTestGateway(t *testing.T) (*FIXGateway, chan FIXMessage) {
+	t.Helper()
+	g, err := NewFIXGateway(FIXConfig{SenderCompID: "GCT", TargetCompID: "CLIENT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	g.conn = server
+	g.loggedOn = true
+
+	sent := make(chan FIXMessage, 10)
+	go func() {
+		reader := bufio.NewReader(client)
+		for {
+			message, err := ReadFIXMessage(reader)
+			if err != nil {
+				return
+			}
+			sent <- message
+		}
+	}()
+	return g, sent
+}
+
+func newFIXTestOrder(clOrdID string) FIXMessage {
+	message := NewFIXMessage(FIX_MSG_NEW_ORDER_SINGLE)
+	message.Add(FIX_TAG_CL_ORD_ID, clOrdID)
+	message.Add(FIX_TAG_SYMBOL, "BTCUSD")
+	message.Add(FIX_TAG_SECURITY_EXCHANGE, "Bitstamp")
+	message.Add(FIX_TAG_SIDE, FIX_SIDE_BUY)
+	message.Add(FIX_TAG_ORD_TYPE, FIX_ORD_TYPE_LIMIT)
+	message.AddFloat(FIX_TAG_ORDER_QTY, 1)
+	message.AddFloat(FIX_TAG_PRICE, 100)
+	return message
+}
+
+func readFIXExecType(t *testing.T, sent chan FIXMessage) (string, string) {
+	t.Helper()
+	select {
+	case message := <-sent:
+		execType, _ := message.Get(FIX_TAG_EXEC_TYPE)
+		text, _ := message.Get(FIX_TAG_TEXT)
+		return execType, text
+	case <-time.After(time.Second * 5):
+		t.Fatal("No execution report sent")
+	}
+	return "", ""
+}
+
+func TestFIXGatewayClOrdIDs(t *testing.T) {
+	setupTestBot(t)
+	g, sent := newFIXTestGateway(t)
+	ctx := context.Background()
+
+	submit := func(clOrdID string) {
+		g.handleNewOrderSingle(ctx, newFIXTestOrder(clOrdID), 1)
+		select {
+		case result := <-g.submitted:
+			g.handleSubmitResult(result)
+		case <-time.After(time.Second * 5):
+			t.Fatal("Order not submitted")
+		}
+
+		if execType, text := readFIXExecType(t, sent); execType != FIX_EXEC_TYPE_NEW {
+			t.Fatalf("%s: ExecType = %s %s, want New", clOrdID, execType, text)
+		}
+	}
+
+	rejected := func(name, clOrdID string) {
+		g.handleNewOrderSingle(ctx, newFIXTestOrder(clOrdID), 1)
+		execType, text := readFIXExecType(t, sent)
+		if execType != FIX_EXEC_TYPE_REJECTED || text != fmt.Sprintf(ErrFIXDuplicateClOrdID, clOrdID) {
+			t.Errorf("%s: ExecType = %s %s, want a duplicate rejection", name, execType, text)
+		}
+		if g.submitting != 0 {
+			t.Errorf("%s: order was submitted", name)
+		}
+	}
+
+	submit("1")
+	rejected("open order", "1")
+
+	cancel := NewFIXMessage(FIX_MSG_ORDER_CANCEL_REQUEST)
+	cancel.Add(FIX_TAG_CL_ORD_ID, "2")
+	cancel.Add(FIX_TAG_ORIG_CL_ORD_ID, "1")
+	g.handleOrderCancelRequest(cancel, 1)
+	if execType, text := readFIXExecType(t, sent); execType != FIX_EXEC_TYPE_CANCELED {
+		t.Fatalf("ExecType = %s %s, want Canceled", execType, text)
+	}
+	rejected("cancelled order", "1")
+
+	restored, restoredSent := newFIXTestGateway(t)
+	restored.handleNewOrderSingle(ctx, newFIXTestOrder("1"), 1)
+	if execType, _ := readFIXExecType(t, restoredSent); execType != FIX_EXEC_TYPE_REJECTED {
+		t.Errorf("restored session: ExecType = %s, want Rejected", execType)
+	}
+
+	g.state.SessionDay = "2000-01-01"
+	submit("1")
+}
+
+func TestFIXGatewaySessionErrors(t *testing.T) {
+	setupTestBot(t)
+	g, sent := newFIXTestGateway(t)
+	g.send(NewFIXMessage(FIX_MSG_HEARTBEAT))
+	<-sent
+
+	tests := []struct {
+		name       string
+		begin, end int
+	}{
+		{"zero begin", 0, 0},
+		{"begin after end", 3, 2},
+		{"begin after the last sent", 5, 0},
+	}
+	for _, x := range tests {
+		request := NewFIXMessage(FIX_MSG_RESEND_REQUEST)
+		request.AddInt(FIX_TAG_BEGIN_SEQ_NO, x.begin)
+		request.AddInt(FIX_TAG_END_SEQ_NO, x.end)
+		g.handleResendRequest(request, 7)
+
+		select {
+		case message := <-sent:
+			refSeqNum, _ := message.GetInt(FIX_TAG_REF_SEQ_NUM)
+			if message.Type() != FIX_MSG_REJECT || refSeqNum != 7 {
+				t.Errorf("%s: sent %v, want a Reject", x.name, message)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("%s: nothing sent", x.name)
+		}
+	}
+
+	// A garbled message is asked for again once.
+	expected := g.state.NextTargetSeqNum
+	g.handleGarbled(fixCheckSumError{"000", 1})
+	g.handleGarbled(fixCheckSumError{"000", 1})
+	select {
+	case message := <-sent:
+		begin, _ := message.GetInt(FIX_TAG_BEGIN_SEQ_NO)
+		if message.Type() != FIX_MSG_RESEND_REQUEST || begin != expected {
+			t.Errorf("sent %v, want a ResendRequest from %d", message, expected)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("No ResendRequest sent")
+	}
+	select {
+	case message := <-sent:
+		t.Errorf("sent %v for a second garbled message", message)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestFIXGatewayFills(t *testing.T) {
+	setupTestBot(t)
+	g, sent := newFIXTestGateway(t)
+	g.state.Orders["1"] = FIXOrder{ClOrdID: "1", OrderID: "100", Exchange: "Bitstamp", Symbol: "BTCUSD", Side: ORDER_SIDE_BUY, Quantity: 2, Price: 105}
+
+	report := func() FIXMessage {
+		t.Helper()
+		select {
+		case message := <-sent:
+			return message
+		case <-time.After(time.Second * 5):
+			t.Fatal("No execution report sent")
+		}
+		return nil
+	}
+
+	g.updateOrder("Bitstamp", OrderDetail{ID: "100", Status: ORDER_STATUS_OPEN, Filled: 0.5, Price: 100})
+	message := report()
+	lastPx, _ := message.GetFloat(FIX_TAG_LAST_PX)
+	avgPx, _ := message.GetFloat(FIX_TAG_AVG_PX)
+	if lastPx != 100 || avgPx != 100 {
+		t.Errorf("partial fill LastPx %f AvgPx %f, want 100", lastPx, avgPx)
+	}
+
+	// A cancelled order reports its last fills, then the cancel.
+	g.updateOrder("Bitstamp", OrderDetail{ID: "100", Status: ORDER_STATUS_CANCELLED, Filled: 1.5, Price: 102})
+	message = report()
+	lastQty, _ := message.GetFloat(FIX_TAG_LAST_QTY)
+	lastPx, _ = message.GetFloat(FIX_TAG_LAST_PX)
+	avgPx, _ = message.GetFloat(FIX_TAG_AVG_PX)
+	if execType, _ := message.Get(FIX_TAG_EXEC_TYPE); execType != FIX_EXEC_TYPE_TRADE || lastQty != 1 || lastPx != 103 || avgPx != 102 {
+		t.Errorf("fill before cancel = %v, want 1 at 103 averaging 102", message)
+	}
+
+	message = report()
+	cumQty, _ := message.GetFloat(FIX_TAG_CUM_QTY)
+	if ordStatus, _ := message.Get(FIX_TAG_ORD_STATUS); ordStatus != FIX_ORD_STATUS_CANCELED || cumQty != 1.5 {
+		t.Errorf("cancel = %v, want Canceled with 1.5 filled", message)
+	}
+	if len(g.state.Orders) != 0 {
+		t.Errorf("orders = %+v, want none", g.state.Orders)
+	}
+}
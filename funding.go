@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FUNDING_DEFAULT_MATCH_WINDOW = time.Hour * 72
+	FUNDING_DEFAULT_TOLERANCE    = 1 // percent
+	FUNDING_MATCH_SKEW           = time.Hour
+	FUNDING_FLAG_TRANSFER        = "TRANSFER"
+	FUNDING_FLAG_PENDING         = "PENDING"
+	FUNDING_FLAG_UNMATCHED       = "UNMATCHED"
+	FUNDING_FLAG_CANCELLED       = "CANCELLED"
+)
+
+// FundingRecord is a funding ledger movement flagged by reconciliation.
+// Counterpart is set to the exchange and ID of the other side of a transfer
+// between our own accounts, which may still be pending.
+type FundingRecord struct {
+	FundingMovement
+	Flag        string
+	Counterpart string
+}
+
+type FundingReconciliation struct {
+	Start     time.Time
+	End       time.Time
+	Transfers int
+	Pending   int
+	Unmatched int
+	// External is the net amount of each currency deposited from outside our
+	// exchange accounts less the amount withdrawn to outside them. Transfers
+	// between our own accounts are left out.
+	External map[string]float64
+	Fees     map[string]float64
+	Records  []FundingRecord
+}
+
+func GetFundingHistoryFetcher(exchange IBotExchange) (IFundingHistoryFetcher, error) {
+	fetcher, ok := exchange.(IFundingHistoryFetcher)
+	if !ok {
+		return nil, NewExchangeFeatureError(exchange, "funding history")
+	}
+	return fetcher, nil
+}
+
+// ImportFundingHistory fetches an exchange's deposits and withdrawals within
+// [start, end] into the funding ledger and returns how many were found.
+func ImportFundingHistory(storage *Storage, exchange IBotExchange, start, end time.Time) (int, error) {
+	fetcher, err := GetFundingHistoryFetcher(exchange)
+	if err != nil {
+		return 0, err
+	}
+
+	movements, err := fetcher.GetFundingHistory(start, end)
+	if err != nil {
+		return 0, err
+	}
+	return len(movements), storage.UpdateFundingMovements(exchange.GetName(), start, end, movements)
+}
+
+// ReconcileFunding matches withdrawals from one exchange with deposits to
+// another so that transfers between our own accounts aren't counted as money
+// in or out. Movements with the same TxID are matched first. Otherwise a
+// deposit of the same currency made within window of the withdrawal matches
+// when its amount is between the withdrawal amount less fees and the
+// withdrawal amount, give or take tolerance percent. Movements should be in
+// time order.
+func ReconcileFunding(movements []FundingMovement, window time.Duration, tolerance float64) FundingReconciliation {
+	result := FundingReconciliation{
+		External: make(map[string]float64),
+		Fees:     make(map[string]float64),
+		Records:  []FundingRecord{},
+	}
+	if len(movements) > 0 {
+		result.Start = movements[0].Timestamp
+		result.End = movements[len(movements)-1].Timestamp
+	}
+
+	counterparts := make([]int, len(movements))
+	for i := range counterparts {
+		counterparts[i] = -1
+	}
+
+	matches := func(w, d FundingMovement, byTxID bool) bool {
+		if w.Exchange == d.Exchange || GetCommonCurrencyName(w.Currency) != GetCommonCurrencyName(d.Currency) {
+			return false
+		}
+
+		if byTxID {
+			return w.TxID != "" && w.TxID == d.TxID
+		}
+
+		if d.Timestamp.Before(w.Timestamp.Add(-FUNDING_MATCH_SKEW)) || d.Timestamp.After(w.Timestamp.Add(window)) {
+			return false
+		}
+		return d.Amount >= (w.Amount-w.Fee)*(1-tolerance/100) && d.Amount <= w.Amount*(1+tolerance/100)
+	}
+
+	for _, byTxID := range []bool{true, false} {
+		for i, w := range movements {
+			if w.Type != FUNDING_WITHDRAWAL || w.Status == FUNDING_STATUS_CANCELLED || counterparts[i] >= 0 {
+				continue
+			}
+
+			for j, d := range movements {
+				if d.Type != FUNDING_DEPOSIT || d.Status == FUNDING_STATUS_CANCELLED || counterparts[j] >= 0 {
+					continue
+				}
+
+				if matches(w, d, byTxID) {
+					counterparts[i], counterparts[j] = j, i
+					result.Transfers++
+					break
+				}
+			}
+		}
+	}
+
+	for i, x := range movements {
+		record := FundingRecord{FundingMovement: x}
+		if counterparts[i] >= 0 {
+			other := movements[counterparts[i]]
+			record.Counterpart = other.Exchange + "/" + other.ID
+		}
+
+		switch {
+		case x.Status == FUNDING_STATUS_CANCELLED:
+			record.Flag = FUNDING_FLAG_CANCELLED
+		case x.Status == FUNDING_STATUS_PENDING:
+			record.Flag = FUNDING_FLAG_PENDING
+			result.Pending++
+		case record.Counterpart != "":
+			record.Flag = FUNDING_FLAG_TRANSFER
+		default:
+			record.Flag = FUNDING_FLAG_UNMATCHED
+			result.Unmatched++
+		}
+
+		if x.Status == FUNDING_STATUS_COMPLETE {
+			currency := GetCommonCurrencyName(x.Currency)
+			if x.Fee > 0 {
+				result.Fees[currency] += x.Fee
+			}
+			if record.Counterpart == "" {
+				if x.Type == FUNDING_DEPOSIT {
+					result.External[currency] += x.Amount
+				} else {
+					result.External[currency] -= x.Amount
+				}
+			}
+		}
+		result.Records = append(result.Records, record)
+	}
+	return result
+}
+
+// Export writes the reconciliation to path as JSON, or its records as CSV when
+// path ends in .csv.
+func (r FundingReconciliation) Export(path string) error {
+	if strings.ToLower(filepath.Ext(path)) != ".csv" {
+		data, err := json.MarshalIndent(r, "", " ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, data, 0644)
+	}
+
+	rows := [][]string{{"Timestamp", "Exchange", "ID", "Type", "Status", "Currency", "Amount", "Fee", "Address", "TxID", "Flag", "Counterpart"}}
+	for _, x := range r.Records {
+		rows = append(rows, []string{
+			x.Timestamp.Format(time.RFC3339),
+			x.Exchange,
+			x.ID,
+			x.Type,
+			x.Status,
+			x.Currency,
+			strconv.FormatFloat(x.Amount, 'f', -1, 64),
+			strconv.FormatFloat(x.Fee, 'f', -1, 64),
+			x.Address,
+			x.TxID,
+			x.Flag,
+			x.Counterpart,
+		})
+	}
+	return WriteCSVFile(path, rows)
+}
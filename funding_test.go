@@ -0,0 +1,90 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReconcileFunding(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hours int) time.Time { return start.Add(time.Hour * time.Duration(hours)) }
+	movements := []FundingMovement{
+		// Matched by TxID, however far apart.
+		{Exchange: "Bitstamp", ID: "w1", Type: FUNDING_WITHDRAWAL, Status: FUNDING_STATUS_COMPLETE, Timestamp: at(0), Currency: "BTC", Amount: 1, Fee: 0.001, TxID: "tx1"},
+		// Matched by amount within the window, under Kraken's name for BTC.
+		{Exchange: "Bitfinex", ID: "w2", Type: FUNDING_WITHDRAWAL, Status: FUNDING_STATUS_COMPLETE, Timestamp: at(1), Currency: "BTC", Amount: 2, Fee: 0.01},
+		{Exchange: "Kraken", ID: "d2", Type: FUNDING_DEPOSIT, Status: FUNDING_STATUS_COMPLETE, Timestamp: at(2), Currency: "XBT", Amount: 1.99},
+		// A deposit from outside.
+		{Exchange: "Kraken", ID: "d3", Type: FUNDING_DEPOSIT, Status: FUNDING_STATUS_COMPLETE, Timestamp: at(3), Currency: "USD", Amount: 500},
+		// Too small to be the other side of w5.
+		{Exchange: "Coinbase", ID: "d4", Type: FUNDING_DEPOSIT, Status: FUNDING_STATUS_COMPLETE, Timestamp: at(4), Currency: "LTC", Amount: 5},
+		{Exchange: "Bitstamp", ID: "w5", Type: FUNDING_WITHDRAWAL, Status: FUNDING_STATUS_COMPLETE, Timestamp: at(4), Currency: "LTC", Amount: 10, Fee: 0.1},
+		{Exchange: "Bitstamp", ID: "w6", Type: FUNDING_WITHDRAWAL, Status: FUNDING_STATUS_PENDING, Timestamp: at(5), Currency: "ETH", Amount: 3},
+		{Exchange: "Bitstamp", ID: "w7", Type: FUNDING_WITHDRAWAL, Status: FUNDING_STATUS_CANCELLED, Timestamp: at(6), Currency: "ETH", Amount: 3},
+		// Past the window of w1, but matched by its TxID.
+		{Exchange: "Coinbase", ID: "d1", Type: FUNDING_DEPOSIT, Status: FUNDING_STATUS_COMPLETE, Timestamp: at(100), Currency: "BTC", Amount: 0.999, TxID: "tx1"},
+	}
+
+	result := ReconcileFunding(movements, FUNDING_DEFAULT_MATCH_WINDOW, FUNDING_DEFAULT_TOLERANCE)
+	if result.Transfers != 2 || result.Pending != 1 || result.Unmatched != 3 {
+		t.Errorf("reconciliation found %d transfers, %d pending and %d unmatched, want 2, 1 and 3", result.Transfers, result.Pending, result.Unmatched)
+	}
+
+	want := map[string]struct{ flag, counterpart string }{
+		"w1": {FUNDING_FLAG_TRANSFER, "Coinbase/d1"},
+		"d1": {FUNDING_FLAG_TRANSFER, "Bitstamp/w1"},
+		"w2": {FUNDING_FLAG_TRANSFER, "Kraken/d2"},
+		"d2": {FUNDING_FLAG_TRANSFER, "Bitfinex/w2"},
+		"d3": {FUNDING_FLAG_UNMATCHED, ""},
+		"d4": {FUNDING_FLAG_UNMATCHED, ""},
+		"w5": {FUNDING_FLAG_UNMATCHED, ""},
+		"w6": {FUNDING_FLAG_PENDING, ""},
+		"w7": {FUNDING_FLAG_CANCELLED, ""},
+	}
+	for _, x := range result.Records {
+		if w := want[x.ID]; x.Flag != w.flag || x.Counterpart != w.counterpart {
+			t.Errorf("%s flagged %s with counterpart %q, want %s and %q", x.ID, x.Flag, x.Counterpart, w.flag, w.counterpart)
+		}
+	}
+
+	// Only completed movements to or from outside count.
+	if len(result.External) != 2 || result.External["USD"] != 500 || result.External["LTC"] != -5 {
+		t.Errorf("external = %v, want 500 USD in and 5 LTC out", result.External)
+	}
+	if result.Fees["BTC"] != 0.011 || result.Fees["LTC"] != 0.1 {
+		t.Errorf("fees = %v, want 0.011 BTC and 0.1 LTC", result.Fees)
+	}
+}
+
+func TestUpdateFundingMovements(t *testing.T) {
+	storage, err := OpenStorage(filepath.Join(t.TempDir(), "storage.db"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour * 24)
+	pending := FundingMovement{Exchange: "Bitstamp", ID: "1", Type: FUNDING_DEPOSIT, Status: FUNDING_STATUS_PENDING, Timestamp: start.Add(time.Hour), Currency: "BTC", Amount: 1}
+	dropped := FundingMovement{Exchange: "Bitstamp", ID: "2", Type: FUNDING_DEPOSIT, Status: FUNDING_STATUS_PENDING, Timestamp: start.Add(time.Hour * 2), Currency: "BTC", Amount: 2}
+	err = storage.UpdateFundingMovements("Bitstamp", start, end, []FundingMovement{pending, dropped})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The completed deposit keeps the time it was first seen, and the pending
+	// one no longer reported is removed.
+	completed := pending
+	completed.Status = FUNDING_STATUS_COMPLETE
+	completed.Timestamp = start.Add(time.Hour * 5)
+	err = storage.UpdateFundingMovements("Bitstamp", start, end, []FundingMovement{completed})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movements, err := storage.GetFundingMovements(start, end)
+	if err != nil || len(movements) != 1 || movements[0].Status != FUNDING_STATUS_COMPLETE || !movements[0].Timestamp.Equal(pending.Timestamp) {
+		t.Errorf("movements = %+v %v, want the completed deposit at its first time", movements, err)
+	}
+}
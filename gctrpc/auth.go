@@ -0,0 +1,27 @@
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative gctrpc.proto
+
+package gctrpc
+
+import (
+	"context"
+	"encoding/base64"
+)
+
+// BasicAuth sends the bot's GRPC Username and Password with every call. Pass
+// it to grpc.WithPerRPCCredentials when dialling the bot.
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+// GetRequestMetadata returns the authorization header for each call.
+func (b BasicAuth) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	credentials := base64.StdEncoding.EncodeToString([]byte(b.Username + ":" + b.Password))
+	return map[string]string{"authorization": "Basic " + credentials}, nil
+}
+
+// RequireTransportSecurity returns false as the bot serves gRPC without TLS,
+// by default on localhost only.
+func (b BasicAuth) RequireTransportSecurity() bool {
+	return false
+}
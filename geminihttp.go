@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -26,6 +28,7 @@ const (
 	GEMINI_MYTRADES             = "mytrades"
 	GEMINI_BALANCES             = "balances"
 	GEMINI_HEARTBEAT            = "heartbeat"
+	GEMINI_MAX_TRADE_HISTORY    = 500
 )
 
 type Gemini struct {
@@ -83,13 +86,13 @@ type GeminiOrderResult struct {
 }
 
 type GeminiTradeHistory struct {
-	Price         float64 `json:"price"`
-	Amount        float64 `json:"amount"`
+	Price         float64 `json:"price,string"`
+	Amount        float64 `json:"amount,string"`
 	Timestamp     int64   `json:"timestamp"`
 	TimestampMS   int64   `json:"timestampms"`
 	Type          string  `json:"type"`
 	FeeCurrency   string  `json:"fee_currency"`
-	FeeAmount     float64 `json:"fee_amount"`
+	FeeAmount     float64 `json:"fee_amount,string"`
 	TID           int64   `json:"tid"`
 	OrderID       int64   `json:"order_id"`
 	ClientOrderID string  `json:"client_order_id"`
@@ -106,7 +109,7 @@ func (g *Gemini) SetDefaults() {
 	g.Enabled = true
 	g.Verbose = false
 	g.Websocket = false
-	g.RESTPollingDelay = 10
+	g.RESTPollingDelay = 10 * time.Second
 }
 
 func (g *Gemini) GetName() string {
@@ -121,14 +124,34 @@ func (g *Gemini) IsEnabled() bool {
 	return g.Enabled
 }
 
+func (g *Gemini) Setup(exch Exchanges) {
+	if !exch.Enabled {
+		g.SetEnabled(false)
+	} else {
+		g.SetEnabled(true)
+		g.AuthenticatedAPISupport = exch.AuthenticatedAPISupport
+		g.SetAPIKeys(exch.APIKey, exch.APISecret)
+		g.RESTPollingDelay = exch.RESTPollingDelay.Duration
+		g.Verbose = exch.Verbose
+		g.Websocket = exch.Websocket
+		g.BaseCurrencies = exch.BaseCurrencies
+		g.AvailablePairs = CurrencyPairsToStrings(exch.AvailablePairs)
+		g.EnabledPairs = CurrencyPairsToStrings(exch.EnabledPairs)
+	}
+}
+
+func (g *Gemini) GetRequiredCredentials() []string {
+	return []string{CREDENTIAL_API_KEY, CREDENTIAL_API_SECRET}
+}
+
 func (g *Gemini) SetAPIKeys(apiKey, apiSecret string) {
 	g.APIKey = apiKey
 	g.APISecret = apiSecret
 }
 
-func (g *Gemini) Run() {
+func (g *Gemini) Run(ctx context.Context) {
 	if g.Verbose {
-		log.Printf("%s polling delay: %ds.\n", g.GetName(), g.RESTPollingDelay)
+		log.Printf("%s polling delay: %s.\n", g.GetName(), g.RESTPollingDelay)
 		log.Printf("%s %d currencies enabled: %s.\n", g.GetName(), len(g.EnabledPairs), g.EnabledPairs)
 	}
 
@@ -144,13 +167,13 @@ func (g *Gemini) Run() {
 				log.Println(err)
 			} else {
 				log.Printf("%s Updating available pairs. Difference: %s.\n", g.Name, diff)
-				exch.AvailablePairs = JoinStrings(exchangeProducts, ",")
+				exch.AvailablePairs = ParseCurrencyPairs(exchangeProducts, exch.BaseCurrencies)
 				UpdateExchangeConfig(exch)
 			}
 		}
 	}
 
-	for g.Enabled {
+	for g.Enabled && ctx.Err() == nil {
 		/* Ticker has not been implemented yet
 		for _, x := range g.EnabledPairs {
 			currency := x
@@ -162,7 +185,7 @@ func (g *Gemini) Run() {
 			}()
 		}
 		*/
-		time.Sleep(time.Second * g.RESTPollingDelay)
+		SleepContext(ctx, g.RESTPollingDelay)
 	}
 }
 
@@ -239,6 +262,78 @@ func (g *Gemini) CancelOrders(sessions bool) ([]GeminiOrderResult, error) {
 	return response, nil
 }
 
+func (g *Gemini) CancelAllOpenOrders() error {
+	_, err := g.CancelOrders(false)
+	return err
+}
+
+func (g *Gemini) GetOrderbookDepth(pair CurrencyPair) (Orderbook, error) {
+	orderbook, err := g.GetOrderbook(StringToLower(pair.String()), nil)
+	if err != nil {
+		return Orderbook{}, err
+	}
+
+	result := Orderbook{Pair: pair}
+	for _, x := range orderbook.Bids {
+		result.Bids = append(result.Bids, OrderbookItem{x.Price, x.Quantity})
+	}
+	for _, x := range orderbook.Asks {
+		result.Asks = append(result.Asks, OrderbookItem{x.Price, x.Quantity})
+	}
+	return result, nil
+}
+
+func (g *Gemini) GetAccountBalances() ([]AccountBalance, error) {
+	balances, err := g.GetBalances()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []AccountBalance{}
+	for _, x := range balances {
+		result = append(result, AccountBalance{StringToUpper(x.Currency), x.Amount, x.Available, x.Amount - x.Available})
+	}
+	return result, nil
+}
+
+func (g *Gemini) GetOpenOrderDetails() ([]OrderDetail, error) {
+	orders, err := g.GetOrders()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []OrderDetail{}
+	for _, x := range orders {
+		result = append(result, OrderDetail{
+			ID:     strconv.FormatInt(x.OrderID, 10),
+			Pair:   ParseCurrencyPair(StringToUpper(x.Symbol), g.BaseCurrencies),
+			Side:   StringToUpper(x.Side),
+			Price:  x.Price,
+			Amount: x.OriginalAmount,
+			Filled: x.ExecutedAmount,
+			Status: "open",
+		})
+	}
+	return result, nil
+}
+
+func (g *Gemini) SubmitOrder(pair CurrencyPair, side string, amount, price float64) (string, error) {
+	orderID, err := g.NewOrder(StringToLower(pair.String()), amount, price, StringToLower(side), "exchange limit")
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(orderID, 10), nil
+}
+
+func (g *Gemini) CancelOrderByID(pair CurrencyPair, orderID string) error {
+	id, err := strconv.ParseInt(orderID, 10, 64)
+	if err != nil {
+		return err
+	}
+	_, err = g.CancelOrder(id)
+	return err
+}
+
 func (g *Gemini) GetOrderStatus(orderID int64) (GeminiOrder, error) {
 	request := make(map[string]interface{})
 	request["order_id"] = orderID
@@ -260,11 +355,15 @@ func (g *Gemini) GetOrders() ([]GeminiOrder, error) {
 	return response, nil
 }
 
-func (g *Gemini) GetTradeHistory(symbol string, timestamp int64) ([]GeminiTradeHistory, error) {
+func (g *Gemini) GetTradeHistory(symbol string, timestamp int64, limit int) ([]GeminiTradeHistory, error) {
 	request := make(map[string]interface{})
 	request["symbol"] = symbol
 	request["timestamp"] = timestamp
 
+	if limit > 0 {
+		request["limit_trades"] = limit
+	}
+
 	response := []GeminiTradeHistory{}
 	err := g.SendAuthenticatedHTTPRequest("POST", GEMINI_MYTRADES, request, &response)
 	if err != nil {
@@ -273,6 +372,39 @@ func (g *Gemini) GetTradeHistory(symbol string, timestamp int64) ([]GeminiTradeH
 	return response, nil
 }
 
+// GetAccountFills returns fills of pair from start, at most
+// GEMINI_MAX_TRADE_HISTORY of them, that are before end.
+func (g *Gemini) GetAccountFills(pair CurrencyPair, start, end time.Time) ([]AccountFill, error) {
+	trades, err := g.GetTradeHistory(StringToLower(pair.String()), start.Unix(), GEMINI_MAX_TRADE_HISTORY)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []AccountFill{}
+	for _, x := range trades {
+		timestamp := time.Unix(0, x.TimestampMS*int64(time.Millisecond))
+		if timestamp.Before(start) || !timestamp.Before(end) {
+			continue
+		}
+		result = append(result, AccountFill{
+			Exchange:    g.GetName(),
+			ID:          strconv.FormatInt(x.TID, 10),
+			OrderID:     strconv.FormatInt(x.OrderID, 10),
+			Timestamp:   timestamp,
+			Pair:        pair,
+			Side:        StringToUpper(x.Type),
+			Price:       x.Price,
+			Amount:      x.Amount,
+			Fee:         x.FeeAmount,
+			FeeCurrency: StringToUpper(x.FeeCurrency),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+	return result, nil
+}
+
 func (g *Gemini) GetBalances() ([]GeminiBalance, error) {
 	response := []GeminiBalance{}
 	err := g.SendAuthenticatedHTTPRequest("POST", GEMINI_BALANCES, nil, &response)
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/url"
@@ -48,7 +49,7 @@ func (h *HUOBI) SetDefaults() {
 	h.Fee = 0
 	h.Verbose = false
 	h.Websocket = false
-	h.RESTPollingDelay = 10
+	h.RESTPollingDelay = 10 * time.Second
 }
 
 func (h *HUOBI) GetName() string {
@@ -63,6 +64,26 @@ func (h *HUOBI) IsEnabled() bool {
 	return h.Enabled
 }
 
+func (h *HUOBI) Setup(exch Exchanges) {
+	if !exch.Enabled {
+		h.SetEnabled(false)
+	} else {
+		h.SetEnabled(true)
+		h.AuthenticatedAPISupport = exch.AuthenticatedAPISupport
+		h.SetAPIKeys(exch.APIKey, exch.APISecret)
+		h.RESTPollingDelay = exch.RESTPollingDelay.Duration
+		h.Verbose = exch.Verbose
+		h.Websocket = exch.Websocket
+		h.BaseCurrencies = exch.BaseCurrencies
+		h.AvailablePairs = CurrencyPairsToStrings(exch.AvailablePairs)
+		h.EnabledPairs = CurrencyPairsToStrings(exch.EnabledPairs)
+	}
+}
+
+func (h *HUOBI) GetRequiredCredentials() []string {
+	return []string{CREDENTIAL_API_KEY, CREDENTIAL_API_SECRET}
+}
+
 func (h *HUOBI) SetAPIKeys(apiKey, apiSecret string) {
 	h.AccessKey = apiKey
 	h.SecretKey = apiSecret
@@ -72,18 +93,18 @@ func (h *HUOBI) GetFee() float64 {
 	return h.Fee
 }
 
-func (h *HUOBI) Run() {
+func (h *HUOBI) Run(ctx context.Context) {
 	if h.Verbose {
 		log.Printf("%s Websocket: %s (url: %s).\n", h.GetName(), IsEnabled(h.Websocket), HUOBI_SOCKETIO_ADDRESS)
-		log.Printf("%s polling delay: %ds.\n", h.GetName(), h.RESTPollingDelay)
+		log.Printf("%s polling delay: %s.\n", h.GetName(), h.RESTPollingDelay)
 		log.Printf("%s %d currencies enabled: %s.\n", h.GetName(), len(h.EnabledPairs), h.EnabledPairs)
 	}
 
 	if h.Websocket {
-		go h.WebsocketClient()
+		StartRoutine(func() { h.WebsocketClient(ctx) })
 	}
 
-	for h.Enabled {
+	for h.Enabled && ctx.Err() == nil {
 		for _, x := range h.EnabledPairs {
 			currency := StringToLower(x[0:3])
 			go func() {
@@ -96,7 +117,7 @@ func (h *HUOBI) Run() {
 				AddExchangeInfo(h.GetName(), StringToUpper(currency[0:3]), "USD", HuobiLastUSD, ticker.Vol)
 			}()
 		}
-		time.Sleep(time.Second * h.RESTPollingDelay)
+		SleepContext(ctx, h.RESTPollingDelay)
 	}
 }
 
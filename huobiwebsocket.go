@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"github.com/thrasher-/socketio"
 	"log"
+	"strconv"
+	"time"
 )
 
 const (
@@ -60,13 +63,14 @@ type HuobiWebsocketTrade struct {
 }
 
 type HuobiWebsocketTradeDetail struct {
-	SymbolID string                `json:"symbolId"`
-	TradeID  []int64               `json:"tradeId"`
-	Price    []float64             `json:"price"`
-	Time     []int64               `json:"time"`
-	Amount   []float64             `json:"amount"`
-	TopBids  []HuobiWebsocketTrade `json:"topBids"`
-	TopAsks  []HuobiWebsocketTrade `json:"topAsks"`
+	SymbolID  string                `json:"symbolId"`
+	TradeID   []int64               `json:"tradeId"`
+	Price     []float64             `json:"price"`
+	Time      []int64               `json:"time"`
+	Amount    []float64             `json:"amount"`
+	Direction []int                 `json:"direction"`
+	TopBids   []HuobiWebsocketTrade `json:"topBids"`
+	TopAsks   []HuobiWebsocketTrade `json:"topAsks"`
 }
 
 type HuobiWebsocketMarketOverview struct {
@@ -159,32 +163,68 @@ func (h *HUOBI) BuildHuobiWebsocketParamsList(objectName, currency, pushType, pe
 }
 
 func (h *HUOBI) OnConnect(output chan socketio.Message) {
+	SetWebsocketConnected(h.GetName(), true)
+
 	if h.Verbose {
 		log.Printf("%s Connected to Websocket.", h.GetName())
 	}
 
 	for _, x := range h.EnabledPairs {
 		currency := StringToLower(x)
-		msg := h.BuildHuobiWebsocketRequestExtra(HUOBI_SOCKET_REQ_SUBSCRIBE, 100, h.BuildHuobiWebsocketParamsList(HUOBI_SOCKET_MARKET_OVERVIEW, currency, "pushLong", "", "", "", "", ""))
-		result, err := JSONEncode(msg)
-		if err != nil {
-			log.Println(err)
+		for _, y := range []string{HUOBI_SOCKET_MARKET_OVERVIEW, HUOBI_SOCKET_TRADE_DETAIL} {
+			msg := h.BuildHuobiWebsocketRequestExtra(HUOBI_SOCKET_REQ_SUBSCRIBE, 100, h.BuildHuobiWebsocketParamsList(y, currency, "pushLong", "", "", "", "", ""))
+			result, err := JSONEncode(msg)
+			if err != nil {
+				log.Println(err)
+			}
+			output <- socketio.CreateMessageEvent("request", string(result), nil, HuobiSocket.Version)
 		}
-		output <- socketio.CreateMessageEvent("request", string(result), nil, HuobiSocket.Version)
 	}
 }
 
 func (h *HUOBI) OnDisconnect(output chan socketio.Message) {
 	log.Printf("%s Disconnected from websocket server.. Reconnecting.\n", h.GetName())
-	h.WebsocketClient()
+	SetWebsocketConnected(h.GetName(), false)
 }
 
 func (h *HUOBI) OnError() {
 	log.Printf("%s Error with Websocket connection.. Reconnecting.\n", h.GetName())
-	h.WebsocketClient()
 }
 
 func (h *HUOBI) OnMessage(message []byte, output chan socketio.Message) {
+	type Response struct {
+		MsgType string                    `json:"msgType"`
+		Payload HuobiWebsocketTradeDetail `json:"payload"`
+	}
+
+	response := Response{}
+	err := JSONDecode(message, &response)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if response.MsgType == HUOBI_SOCKET_TRADE_DETAIL {
+		h.ProcessWebsocketTradeDetail(response.Payload)
+	}
+}
+
+// ProcessWebsocketTradeDetail converts the parallel arrays of a trade detail
+// push into trades. A direction of 2 is a sell.
+func (h *HUOBI) ProcessWebsocketTradeDetail(detail HuobiWebsocketTradeDetail) {
+	trades := []TradeRecord{}
+	for i := range detail.TradeID {
+		if i >= len(detail.Price) || i >= len(detail.Time) || i >= len(detail.Amount) {
+			break
+		}
+
+		side := ORDER_SIDE_BUY
+		if i < len(detail.Direction) && detail.Direction[i] == 2 {
+			side = ORDER_SIDE_SELL
+		}
+		trades = append(trades, TradeRecord{time.Unix(detail.Time[i], 0), strconv.FormatInt(detail.TradeID[i], 10), detail.Price[i], detail.Amount[i], side})
+	}
+	ProcessTrades(h.GetName(), ParseCurrencyPair(StringToUpper(detail.SymbolID), h.BaseCurrencies), trades)
 }
 
 func (h *HUOBI) OnRequest(message []byte, output chan socketio.Message) {
@@ -195,7 +235,9 @@ func (h *HUOBI) OnRequest(message []byte, output chan socketio.Message) {
 	}
 }
 
-func (h *HUOBI) WebsocketClient() {
+func (h *HUOBI) WebsocketClient(ctx context.Context) {
+	defer SetWebsocketConnected(h.GetName(), false)
+
 	events := make(map[string]func(message []byte, output chan socketio.Message))
 	events["request"] = h.OnRequest
 	events["message"] = h.OnMessage
@@ -208,8 +250,23 @@ func (h *HUOBI) WebsocketClient() {
 		OnDisconnect: h.OnDisconnect,
 	}
 
-	for h.Enabled && h.Websocket {
-		err := socketio.ConnectToSocket(HUOBI_SOCKETIO_ADDRESS, HuobiSocket)
+	for h.Enabled && h.Websocket && ctx.Err() == nil {
+		// ConnectToSocket blocks until disconnected and cannot be cancelled,
+		// so wait on it in the background and return once ctx is done. The
+		// connection is left to the server to close, ignoring its events.
+		result := make(chan error, 1)
+		socket := IgnoreSocketIOOnDone(ctx, HuobiSocket)
+		go func() {
+			result <- socketio.ConnectToSocket(HUOBI_SOCKETIO_ADDRESS, socket)
+		}()
+
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case err = <-result:
+		}
+
 		if err != nil {
 			log.Printf("%s Unable to connect to Websocket. Err: %s\n", err)
 			continue
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	INDICATOR_WARMUP_CANDLES = 500
+)
+
+// indicatorFeed keeps one indicator up to date with finished live candles.
+type indicatorFeed struct {
+	exchange  string
+	pair      CurrencyPair
+	spec      IndicatorSpec
+	indicator Indicator
+	last      time.Time
+}
+
+func (f *indicatorFeed) update(candle Candle) {
+	if !candle.Timestamp.After(f.last) {
+		return
+	}
+	f.indicator.Update(candle)
+	f.last = candle.Timestamp
+}
+
+var indicatorFeeds = struct {
+	sync.Mutex
+	feeds map[string]*indicatorFeed
+}{feeds: make(map[string]*indicatorFeed)}
+
+// GetIndicatorValue returns the current value of an indicator on a pair. The
+// indicator is created on first use, warmed up from stored candles and then
+// updated by IndicatorRoutine. It returns false until the indicator is ready.
+func GetIndicatorValue(exchange string, pair CurrencyPair, spec IndicatorSpec) (float64, bool) {
+	indicatorFeeds.Lock()
+	defer indicatorFeeds.Unlock()
+
+	key := GetCandleSeriesName(exchange, pair, spec.Interval) + "/" + spec.String()
+	feed, ok := indicatorFeeds.feeds[key]
+	if !ok {
+		indicator, err := spec.NewIndicator()
+		if err != nil {
+			return 0, false
+		}
+
+		feed = &indicatorFeed{exchange: exchange, pair: pair, spec: spec, indicator: indicator}
+		if bot.storage != nil {
+			end := time.Now()
+			candles, err := bot.storage.GetCandles(exchange, pair, spec.Interval, end.Add(-spec.Interval*INDICATOR_WARMUP_CANDLES), end)
+			if err == nil {
+				for _, x := range candles {
+					feed.update(x)
+				}
+			}
+		}
+		indicatorFeeds.feeds[key] = feed
+	}
+
+	if !feed.indicator.Ready() {
+		return 0, false
+	}
+	return feed.spec.GetIndicatorOutput(feed.indicator), true
+}
+
+// IndicatorRoutine feeds finished live candles to every indicator in use
+// until ctx is cancelled.
+func IndicatorRoutine(ctx context.Context) {
+	candles := bot.candles.Subscribe()
+	defer bot.candles.Unsubscribe(candles)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-candles:
+			indicatorFeeds.Lock()
+			for _, feed := range indicatorFeeds.feeds {
+				if feed.exchange == event.Exchange && feed.pair == event.Pair && feed.spec.Interval == event.Interval {
+					feed.update(event.Candle)
+				}
+			}
+			indicatorFeeds.Unlock()
+		}
+	}
+}
@@ -0,0 +1,632 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	INDICATOR_SMA                   = "SMA"
+	INDICATOR_EMA                   = "EMA"
+	INDICATOR_RSI                   = "RSI"
+	INDICATOR_MACD                  = "MACD"
+	INDICATOR_BOLLINGER             = "BB"
+	INDICATOR_ATR                   = "ATR"
+	INDICATOR_VWAP                  = "VWAP"
+	INDICATOR_OBV                   = "OBV"
+	INDICATOR_STOCHASTIC            = "STOCH"
+	INDICATOR_DEFAULT_INTERVAL      = time.Hour
+	ErrIndicatorUnknown             = "Unknown indicator %s."
+	ErrIndicatorInvalid             = "Invalid indicator %s. Use NAME(args).OUTPUT@interval, e.g. RSI(14)@1h."
+	ErrIndicatorArgumentCount       = "Indicator %s takes at most %d argument(s)."
+	ErrIndicatorPeriodInvalid       = "Indicator %s period must be a positive whole number."
+	ErrIndicatorOutputUnknown       = "Indicator %s has no output %s."
+	ErrIndicatorOutputNotSupported  = "Indicator %s has a single output."
+	ErrIndicatorIntervalNotPositive = "Indicator interval %s must be positive."
+)
+
+// Indicator is updated with each finished candle in time order. Value is the
+// indicator's main output and is only meaningful once Ready returns true.
+type Indicator interface {
+	Update(candle Candle)
+	Ready() bool
+	Value() float64
+}
+
+// IIndicatorOutputs is implemented by indicators with more than one output,
+// such as the MACD signal line or the upper Bollinger band.
+type IIndicatorOutputs interface {
+	Output(name string) (float64, bool)
+}
+
+// BatchIndicator runs an indicator over a historical series and returns its
+// value after each candle. Values before the indicator is ready are NaN.
+func BatchIndicator(indicator Indicator, candles []Candle) []float64 {
+	result := make([]float64, len(candles))
+	for i, x := range candles {
+		indicator.Update(x)
+		result[i] = math.NaN()
+		if indicator.Ready() {
+			result[i] = indicator.Value()
+		}
+	}
+	return result
+}
+
+// window keeps the last size values added to it.
+type window struct {
+	values []float64
+	next   int
+	full   bool
+}
+
+func newWindow(size int) *window {
+	return &window{values: make([]float64, size)}
+}
+
+// add stores value and returns the value it replaced, or zero.
+func (w *window) add(value float64) float64 {
+	old := w.values[w.next]
+	w.values[w.next] = value
+	w.next++
+	if w.next == len(w.values) {
+		w.next = 0
+		w.full = true
+	}
+	return old
+}
+
+func (w *window) count() int {
+	if w.full {
+		return len(w.values)
+	}
+	return w.next
+}
+
+func (w *window) min() float64 {
+	result := math.Inf(1)
+	for i := 0; i < w.count(); i++ {
+		result = math.Min(result, w.values[i])
+	}
+	return result
+}
+
+func (w *window) max() float64 {
+	result := math.Inf(-1)
+	for i := 0; i < w.count(); i++ {
+		result = math.Max(result, w.values[i])
+	}
+	return result
+}
+
+// SMA is the simple moving average of closing prices.
+type SMA struct {
+	window *window
+	sum    float64
+}
+
+func NewSMA(period int) *SMA {
+	return &SMA{window: newWindow(period)}
+}
+
+func (s *SMA) Update(candle Candle) {
+	s.add(candle.Close)
+}
+
+func (s *SMA) add(value float64) {
+	s.sum += value - s.window.add(value)
+}
+
+func (s *SMA) Ready() bool {
+	return s.window.full
+}
+
+func (s *SMA) Value() float64 {
+	return s.sum / float64(s.window.count())
+}
+
+// EMA is the exponential moving average of closing prices, seeded with the
+// simple average of the first period closes.
+type EMA struct {
+	period int
+	count  int
+	value  float64
+}
+
+func NewEMA(period int) *EMA {
+	return &EMA{period: period}
+}
+
+func (e *EMA) Update(candle Candle) {
+	e.add(candle.Close)
+}
+
+func (e *EMA) add(value float64) {
+	e.count++
+	if e.count <= e.period {
+		e.value += (value - e.value) / float64(e.count)
+		return
+	}
+	e.value += (value - e.value) * 2 / float64(e.period+1)
+}
+
+func (e *EMA) Ready() bool {
+	return e.count >= e.period
+}
+
+func (e *EMA) Value() float64 {
+	return e.value
+}
+
+// wilder is Wilder's smoothed average, used by RSI and ATR.
+type wilder struct {
+	period int
+	count  int
+	value  float64
+}
+
+func (w *wilder) add(value float64) {
+	w.count++
+	if w.count <= w.period {
+		w.value += (value - w.value) / float64(w.count)
+		return
+	}
+	w.value = (w.value*float64(w.period-1) + value) / float64(w.period)
+}
+
+// RSI is the relative strength index using Wilder's smoothing.
+type RSI struct {
+	gain      wilder
+	loss      wilder
+	lastClose float64
+	started   bool
+}
+
+func NewRSI(period int) *RSI {
+	return &RSI{gain: wilder{period: period}, loss: wilder{period: period}}
+}
+
+func (r *RSI) Update(candle Candle) {
+	if r.started {
+		change := candle.Close - r.lastClose
+		r.gain.add(math.Max(change, 0))
+		r.loss.add(math.Max(-change, 0))
+	}
+	r.lastClose = candle.Close
+	r.started = true
+}
+
+func (r *RSI) Ready() bool {
+	return r.gain.count >= r.gain.period
+}
+
+func (r *RSI) Value() float64 {
+	if r.loss.value == 0 {
+		if r.gain.value == 0 {
+			return 50
+		}
+		return 100
+	}
+	return 100 - 100/(1+r.gain.value/r.loss.value)
+}
+
+// MACD is the difference between a fast and slow EMA of closing prices, with
+// a signal line EMA of that difference.
+type MACD struct {
+	fast   *EMA
+	slow   *EMA
+	signal *EMA
+	macd   float64
+}
+
+func NewMACD(fast, slow, signal int) *MACD {
+	return &MACD{fast: NewEMA(fast), slow: NewEMA(slow), signal: NewEMA(signal)}
+}
+
+func (m *MACD) Update(candle Candle) {
+	m.fast.Update(candle)
+	m.slow.Update(candle)
+	if m.fast.Ready() && m.slow.Ready() {
+		m.macd = m.fast.Value() - m.slow.Value()
+		m.signal.add(m.macd)
+	}
+}
+
+func (m *MACD) Ready() bool {
+	return m.signal.Ready()
+}
+
+func (m *MACD) Value() float64 {
+	return m.macd
+}
+
+// Output returns MACD, SIGNAL or HIST.
+func (m *MACD) Output(name string) (float64, bool) {
+	switch name {
+	case "MACD":
+		return m.macd, true
+	case "SIGNAL":
+		return m.signal.Value(), true
+	case "HIST":
+		return m.macd - m.signal.Value(), true
+	}
+	return 0, false
+}
+
+// Bollinger bands are a simple moving average with bands a number of
+// standard deviations above and below it.
+type Bollinger struct {
+	sma        *SMA
+	deviations float64
+}
+
+func NewBollinger(period int, deviations float64) *Bollinger {
+	return &Bollinger{NewSMA(period), deviations}
+}
+
+func (b *Bollinger) Update(candle Candle) {
+	b.sma.Update(candle)
+}
+
+func (b *Bollinger) Ready() bool {
+	return b.sma.Ready()
+}
+
+func (b *Bollinger) Value() float64 {
+	return b.sma.Value()
+}
+
+func (b *Bollinger) StandardDeviation() float64 {
+	mean := b.sma.Value()
+	variance := 0.0
+	count := b.sma.window.count()
+	for i := 0; i < count; i++ {
+		variance += math.Pow(b.sma.window.values[i]-mean, 2)
+	}
+	return math.Sqrt(variance / float64(count))
+}
+
+// Output returns MIDDLE, UPPER or LOWER.
+func (b *Bollinger) Output(name string) (float64, bool) {
+	switch name {
+	case "MIDDLE":
+		return b.sma.Value(), true
+	case "UPPER":
+		return b.sma.Value() + b.deviations*b.StandardDeviation(), true
+	case "LOWER":
+		return b.sma.Value() - b.deviations*b.StandardDeviation(), true
+	}
+	return 0, false
+}
+
+// ATR is the average true range using Wilder's smoothing.
+type ATR struct {
+	average   wilder
+	lastClose float64
+	started   bool
+}
+
+func NewATR(period int) *ATR {
+	return &ATR{average: wilder{period: period}}
+}
+
+func (a *ATR) Update(candle Candle) {
+	trueRange := candle.High - candle.Low
+	if a.started {
+		trueRange = math.Max(trueRange, math.Abs(candle.High-a.lastClose))
+		trueRange = math.Max(trueRange, math.Abs(candle.Low-a.lastClose))
+	}
+	a.average.add(trueRange)
+	a.lastClose = candle.Close
+	a.started = true
+}
+
+func (a *ATR) Ready() bool {
+	return a.average.count >= a.average.period
+}
+
+func (a *ATR) Value() float64 {
+	return a.average.value
+}
+
+// VWAP is the volume weighted average of each candle's typical price over
+// the last period candles, or over every candle when period is zero.
+type VWAP struct {
+	priceVolume *window
+	volume      *window
+	totalPV     float64
+	totalVolume float64
+	count       int
+}
+
+func NewVWAP(period int) *VWAP {
+	if period == 0 {
+		return &VWAP{}
+	}
+	return &VWAP{priceVolume: newWindow(period), volume: newWindow(period)}
+}
+
+func (v *VWAP) Update(candle Candle) {
+	typical := (candle.High + candle.Low + candle.Close) / 3
+	pv := typical * candle.Volume
+	v.totalPV += pv
+	v.totalVolume += candle.Volume
+	if v.priceVolume != nil {
+		v.totalPV -= v.priceVolume.add(pv)
+		v.totalVolume -= v.volume.add(candle.Volume)
+	}
+	v.count++
+}
+
+func (v *VWAP) Ready() bool {
+	if v.priceVolume != nil {
+		return v.priceVolume.full && v.totalVolume > 0
+	}
+	return v.count > 0 && v.totalVolume > 0
+}
+
+func (v *VWAP) Value() float64 {
+	return v.totalPV / v.totalVolume
+}
+
+// OBV is on balance volume, starting from zero at the first candle.
+type OBV struct {
+	value     float64
+	lastClose float64
+	started   bool
+}
+
+func NewOBV() *OBV {
+	return &OBV{}
+}
+
+func (o *OBV) Update(candle Candle) {
+	if o.started {
+		if candle.Close > o.lastClose {
+			o.value += candle.Volume
+		} else if candle.Close < o.lastClose {
+			o.value -= candle.Volume
+		}
+	}
+	o.lastClose = candle.Close
+	o.started = true
+}
+
+func (o *OBV) Ready() bool {
+	return o.started
+}
+
+func (o *OBV) Value() float64 {
+	return o.value
+}
+
+// Stochastic is the stochastic oscillator. %K is where the close sits in the
+// high-low range of the last period candles and %D is its simple average.
+type Stochastic struct {
+	highs *window
+	lows  *window
+	d     *SMA
+	k     float64
+}
+
+func NewStochastic(period, smoothing int) *Stochastic {
+	return &Stochastic{highs: newWindow(period), lows: newWindow(period), d: NewSMA(smoothing)}
+}
+
+func (s *Stochastic) Update(candle Candle) {
+	s.highs.add(candle.High)
+	s.lows.add(candle.Low)
+	if !s.highs.full {
+		return
+	}
+
+	high, low := s.highs.max(), s.lows.min()
+	s.k = 50
+	if high > low {
+		s.k = (candle.Close - low) / (high - low) * 100
+	}
+	s.d.add(s.k)
+}
+
+func (s *Stochastic) Ready() bool {
+	return s.d.Ready()
+}
+
+func (s *Stochastic) Value() float64 {
+	return s.k
+}
+
+// Output returns K or D.
+func (s *Stochastic) Output(name string) (float64, bool) {
+	switch name {
+	case "K":
+		return s.k, true
+	case "D":
+		return s.d.Value(), true
+	}
+	return 0, false
+}
+
+// IndicatorSpec describes an indicator written as NAME(args).OUTPUT@interval,
+// e.g. RSI(14), MACD(12,26,9).SIGNAL@4h or BB(20,2).LOWER@15m. Arguments,
+// output and interval are optional.
+type IndicatorSpec struct {
+	Name     string
+	Args     []float64
+	Output   string
+	Interval time.Duration
+}
+
+var indicatorSpecRegexp = regexp.MustCompile(`^([A-Z]+)(?:\(([0-9., ]*)\))?(?:\.([A-Z]+))?$`)
+
+// indicatorDefaults holds the default arguments of each indicator.
+var indicatorDefaults = map[string][]float64{
+	INDICATOR_SMA:        {20},
+	INDICATOR_EMA:        {20},
+	INDICATOR_RSI:        {14},
+	INDICATOR_MACD:       {12, 26, 9},
+	INDICATOR_BOLLINGER:  {20, 2},
+	INDICATOR_ATR:        {14},
+	INDICATOR_VWAP:       {0},
+	INDICATOR_OBV:        {},
+	INDICATOR_STOCHASTIC: {14, 3},
+}
+
+func ParseIndicatorSpec(value string) (IndicatorSpec, error) {
+	parts := SplitStrings(value, "@")
+	if len(parts) > 2 {
+		return IndicatorSpec{}, fmt.Errorf(ErrIndicatorInvalid, value)
+	}
+
+	match := indicatorSpecRegexp.FindStringSubmatch(StringToUpper(parts[0]))
+	if match == nil {
+		return IndicatorSpec{}, fmt.Errorf(ErrIndicatorInvalid, value)
+	}
+
+	spec := IndicatorSpec{Name: match[1], Output: match[3], Interval: INDICATOR_DEFAULT_INTERVAL}
+	defaults, ok := indicatorDefaults[spec.Name]
+	if !ok {
+		return IndicatorSpec{}, fmt.Errorf(ErrIndicatorUnknown, spec.Name)
+	}
+
+	spec.Args = append([]float64{}, defaults...)
+	if match[2] != "" {
+		args := SplitStrings(match[2], ",")
+		if len(args) > len(defaults) {
+			return IndicatorSpec{}, fmt.Errorf(ErrIndicatorArgumentCount, spec.Name, len(defaults))
+		}
+
+		for i, x := range args {
+			arg, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+			if err != nil {
+				return IndicatorSpec{}, fmt.Errorf(ErrIndicatorInvalid, value)
+			}
+			spec.Args[i] = arg
+		}
+	}
+
+	if len(parts) == 2 {
+		interval, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return IndicatorSpec{}, fmt.Errorf(ErrIndicatorInvalid, value)
+		}
+		if interval <= 0 {
+			return IndicatorSpec{}, fmt.Errorf(ErrIndicatorIntervalNotPositive, interval)
+		}
+		spec.Interval = interval
+	}
+
+	// Build once to check the periods and output name.
+	_, err := spec.NewIndicator()
+	if err != nil {
+		return IndicatorSpec{}, err
+	}
+	return spec, nil
+}
+
+func (s IndicatorSpec) String() string {
+	args := []string{}
+	for _, x := range s.Args {
+		args = append(args, strconv.FormatFloat(x, 'f', -1, 64))
+	}
+
+	result := s.Name
+	if len(args) > 0 {
+		result += "(" + JoinStrings(args, ",") + ")"
+	}
+	if s.Output != "" {
+		result += "." + s.Output
+	}
+	return result + "@" + s.Interval.String()
+}
+
+// NewIndicator returns a new indicator with the spec's arguments.
+func (s IndicatorSpec) NewIndicator() (Indicator, error) {
+	periods := []int{}
+	for i, x := range s.Args {
+		// The Bollinger deviation multiplier is the only argument that is not
+		// a period.
+		if s.Name == INDICATOR_BOLLINGER && i == 1 {
+			continue
+		}
+
+		minimum := 1.0
+		if s.Name == INDICATOR_VWAP {
+			minimum = 0
+		}
+		if x < minimum || x != math.Trunc(x) {
+			return nil, fmt.Errorf(ErrIndicatorPeriodInvalid, s.Name)
+		}
+		periods = append(periods, int(x))
+	}
+
+	var indicator Indicator
+	switch s.Name {
+	case INDICATOR_SMA:
+		indicator = NewSMA(periods[0])
+	case INDICATOR_EMA:
+		indicator = NewEMA(periods[0])
+	case INDICATOR_RSI:
+		indicator = NewRSI(periods[0])
+	case INDICATOR_MACD:
+		indicator = NewMACD(periods[0], periods[1], periods[2])
+	case INDICATOR_BOLLINGER:
+		indicator = NewBollinger(periods[0], s.Args[1])
+	case INDICATOR_ATR:
+		indicator = NewATR(periods[0])
+	case INDICATOR_VWAP:
+		indicator = NewVWAP(periods[0])
+	case INDICATOR_OBV:
+		indicator = NewOBV()
+	case INDICATOR_STOCHASTIC:
+		indicator = NewStochastic(periods[0], periods[1])
+	default:
+		return nil, fmt.Errorf(ErrIndicatorUnknown, s.Name)
+	}
+
+	if s.Output != "" {
+		outputs, ok := indicator.(IIndicatorOutputs)
+		if !ok {
+			return nil, fmt.Errorf(ErrIndicatorOutputNotSupported, s.Name)
+		}
+		if _, ok := outputs.Output(s.Output); !ok {
+			return nil, fmt.Errorf(ErrIndicatorOutputUnknown, s.Name, s.Output)
+		}
+	}
+	return indicator, nil
+}
+
+// GetIndicatorOutput returns the spec's output of indicator, or its main value
+// when no output is given.
+func (s IndicatorSpec) GetIndicatorOutput(indicator Indicator) float64 {
+	if s.Output != "" {
+		if outputs, ok := indicator.(IIndicatorOutputs); ok {
+			value, _ := outputs.Output(s.Output)
+			return value
+		}
+	}
+	return indicator.Value()
+}
+
+// Batch runs the spec's indicator over a historical series, returning its
+// output after each candle or NaN while the indicator is not yet ready.
+func (s IndicatorSpec) Batch(candles []Candle) ([]float64, error) {
+	indicator, err := s.NewIndicator()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]float64, len(candles))
+	for i, x := range candles {
+		indicator.Update(x)
+		result[i] = math.NaN()
+		if indicator.Ready() {
+			result[i] = s.GetIndicatorOutput(indicator)
+		}
+	}
+	return result, nil
+}
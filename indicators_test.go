@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// newTestCandle returns a candle with the given high, low, close and volume.
+func newTestCandle(high, low, last, volume float64) Candle {
+	return Candle{High: high, Low: low, Close: last, Volume: volume}
+}
+
+func newTestCloses(closes ...float64) []Candle {
+	result := []Candle{}
+	for _, x := range closes {
+		result = append(result, newTestCandle(x, x, x, 1))
+	}
+	return result
+}
+
+func TestIndicators(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		spec    string
+		candles []Candle
+		want    []float64
+	}{
+		{"SMA(3)", newTestCloses(1, 2, 3, 4, 5), []float64{nan, nan, 2, 3, 4}},
+		{"EMA(3)", newTestCloses(1, 2, 3, 4, 5), []float64{nan, nan, 2, 3, 4}},
+		{"RSI(2)", newTestCloses(1, 2, 3, 2), []float64{nan, nan, 100, 50}},
+		{"RSI(2)", newTestCloses(1, 1, 1), []float64{nan, nan, 50}},
+		{"MACD(2,3,2)", newTestCloses(1, 2, 3, 4, 5), []float64{nan, nan, nan, 0.5, 0.5}},
+		{"MACD(2,3,2).HIST", newTestCloses(1, 2, 3, 4, 5), []float64{nan, nan, nan, 0, 0}},
+		{"BB(3,2).UPPER", newTestCloses(1, 2, 3), []float64{nan, nan, 2 + 2*math.Sqrt(2.0/3)}},
+		{"BB(3,2).LOWER", newTestCloses(1, 2, 3), []float64{nan, nan, 2 - 2*math.Sqrt(2.0/3)}},
+		{"ATR(2)", []Candle{newTestCandle(2, 1, 1.5, 1), newTestCandle(3, 2, 2.5, 1), newTestCandle(2.5, 1, 2, 1)}, []float64{nan, 1.25, 1.375}},
+		{"VWAP", []Candle{newTestCandle(10, 10, 10, 1), newTestCandle(20, 20, 20, 3)}, []float64{10, 17.5}},
+		{"VWAP(1)", []Candle{newTestCandle(10, 10, 10, 1), newTestCandle(20, 20, 20, 3)}, []float64{10, 20}},
+		{"OBV", []Candle{newTestCandle(1, 1, 1, 1), newTestCandle(2, 2, 2, 2), newTestCandle(2, 2, 2, 3), newTestCandle(1, 1, 1, 4)}, []float64{0, 2, 2, -2}},
+		{"STOCH(2,1)", []Candle{newTestCandle(10, 0, 5, 1), newTestCandle(20, 10, 15, 1)}, []float64{nan, 75}},
+	}
+
+	for _, x := range tests {
+		spec, err := ParseIndicatorSpec(x.spec)
+		if err != nil {
+			t.Errorf("%s: %s", x.spec, err)
+			continue
+		}
+
+		values, err := spec.Batch(x.candles)
+		if err != nil {
+			t.Errorf("%s: %s", x.spec, err)
+			continue
+		}
+
+		for i, value := range values {
+			if math.IsNaN(x.want[i]) != math.IsNaN(value) || math.Abs(value-x.want[i]) > 1e-9 {
+				t.Errorf("%s: values = %v, want %v", x.spec, values, x.want)
+				break
+			}
+		}
+	}
+}
+
+func TestParseIndicatorSpec(t *testing.T) {
+	spec, err := ParseIndicatorSpec("macd(12, 26,9).signal@4h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.String() != "MACD(12,26,9).SIGNAL@4h0m0s" || spec.Interval != time.Hour*4 {
+		t.Errorf("spec = %s, want MACD(12,26,9).SIGNAL@4h0m0s", spec)
+	}
+
+	spec, err = ParseIndicatorSpec("BB")
+	if err != nil || spec.String() != "BB(20,2)@1h0m0s" {
+		t.Errorf("spec = %s %v, want the defaults", spec, err)
+	}
+
+	for _, x := range []string{"SMA(0)", "SMA(1.5)", "SMA(1,2)", "FOO(1)", "RSI.K", "MACD.FOO", "SMA@-1h", "SMA@1x", "SMA@1h@1h", "SMA("} {
+		if _, err := ParseIndicatorSpec(x); err == nil {
+			t.Errorf("ParseIndicatorSpec(%s) succeeded", x)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net/url"
 	"strconv"
@@ -55,7 +57,7 @@ func (i *ItBit) SetDefaults() {
 	i.TakerFee = 0.50
 	i.Verbose = false
 	i.Websocket = false
-	i.RESTPollingDelay = 10
+	i.RESTPollingDelay = 10 * time.Second
 }
 
 func (i *ItBit) GetName() string {
@@ -70,6 +72,26 @@ func (i *ItBit) IsEnabled() bool {
 	return i.Enabled
 }
 
+func (i *ItBit) Setup(exch Exchanges) {
+	if !exch.Enabled {
+		i.SetEnabled(false)
+	} else {
+		i.SetEnabled(true)
+		i.AuthenticatedAPISupport = exch.AuthenticatedAPISupport
+		i.SetAPIKeys(exch.APIKey, exch.APISecret, exch.ClientID)
+		i.RESTPollingDelay = exch.RESTPollingDelay.Duration
+		i.Verbose = exch.Verbose
+		i.Websocket = exch.Websocket
+		i.BaseCurrencies = exch.BaseCurrencies
+		i.AvailablePairs = CurrencyPairsToStrings(exch.AvailablePairs)
+		i.EnabledPairs = CurrencyPairsToStrings(exch.EnabledPairs)
+	}
+}
+
+func (i *ItBit) GetRequiredCredentials() []string {
+	return []string{CREDENTIAL_API_KEY, CREDENTIAL_API_SECRET, CREDENTIAL_CLIENT_ID}
+}
+
 func (i *ItBit) SetAPIKeys(apiKey, apiSecret, userID string) {
 	i.ClientKey = apiKey
 	i.APISecret = apiSecret
@@ -84,13 +106,13 @@ func (i *ItBit) GetFee(maker bool) float64 {
 	}
 }
 
-func (i *ItBit) Run() {
+func (i *ItBit) Run(ctx context.Context) {
 	if i.Verbose {
-		log.Printf("%s polling delay: %ds.\n", i.GetName(), i.RESTPollingDelay)
+		log.Printf("%s polling delay: %s.\n", i.GetName(), i.RESTPollingDelay)
 		log.Printf("%s %d currencies enabled: %s.\n", i.GetName(), len(i.EnabledPairs), i.EnabledPairs)
 	}
 
-	for i.Enabled {
+	for i.Enabled && ctx.Err() == nil {
 		for _, x := range i.EnabledPairs {
 			currency := x
 			go func() {
@@ -99,7 +121,7 @@ func (i *ItBit) Run() {
 				AddExchangeInfo(i.GetName(), currency[0:3], currency[3:], ticker.LastPrice, ticker.Volume24h)
 			}()
 		}
-		time.Sleep(time.Second * i.RESTPollingDelay)
+		SleepContext(ctx, i.RESTPollingDelay)
 	}
 }
 
@@ -114,6 +136,30 @@ func (i *ItBit) GetTicker(currency string) ItBitTicker {
 	return itbitTicker
 }
 
+func (i *ItBit) GetTickerPrice(pair CurrencyPair) (TickerPrice, error) {
+	ticker := i.GetTicker(pair.String())
+	if ticker.Pair == "" {
+		return TickerPrice{}, fmt.Errorf(ErrExchangePairNotSupported, i.GetName(), pair)
+	}
+	return TickerPrice{pair.Base, pair.Quote, ticker.LastPrice, ticker.High24h, ticker.Low24h, ticker.Bid, ticker.Ask, ticker.Volume24h}, nil
+}
+
+func (i *ItBit) GetOrderbookDepth(pair CurrencyPair) (Orderbook, error) {
+	orderbook, err := i.GetOrderbook(pair.String())
+	if err != nil {
+		return Orderbook{}, err
+	}
+
+	result := Orderbook{Pair: pair}
+	for _, x := range orderbook.Bids {
+		result.Bids = append(result.Bids, OrderbookItem{x.Price, x.Quantitiy})
+	}
+	for _, x := range orderbook.Asks {
+		result.Asks = append(result.Asks, OrderbookItem{x.Price, x.Quantitiy})
+	}
+	return result, nil
+}
+
 type ItbitOrderbookEntry struct {
 	Quantitiy float64 `json:"quantity,string"`
 	Price     float64 `json:"price,string"`
@@ -147,33 +193,48 @@ func (i *ItBit) GetTradeHistory(currency, timestamp string) bool {
 	return true
 }
 
-func (i *ItBit) GetWallets(params url.Values) {
+type ItBitWalletBalance struct {
+	Currency         string  `json:"currency"`
+	AvailableBalance float64 `json:"availableBalance,string"`
+	TotalBalance     float64 `json:"totalBalance,string"`
+}
+
+type ItBitWallet struct {
+	ID       string               `json:"id"`
+	UserID   string               `json:"userId"`
+	Name     string               `json:"name"`
+	Balances []ItBitWalletBalance `json:"balances"`
+}
+
+func (i *ItBit) GetWallets(params url.Values) ([]ItBitWallet, error) {
 	params.Set("userId", i.UserID)
 	path := "/wallets?" + params.Encode()
 
-	err := i.SendAuthenticatedHTTPRequest("GET", path, nil)
-
+	response := []ItBitWallet{}
+	err := i.SendAuthenticatedHTTPRequest("GET", path, nil, &response)
 	if err != nil {
-		log.Println(err)
+		return nil, err
 	}
+	return response, nil
 }
 
-func (i *ItBit) CreateWallet(walletName string) {
+func (i *ItBit) CreateWallet(walletName string) (ItBitWallet, error) {
 	path := "/wallets"
 	params := make(map[string]interface{})
 	params["userId"] = i.UserID
 	params["name"] = walletName
 
-	err := i.SendAuthenticatedHTTPRequest("POST", path, params)
-
+	response := ItBitWallet{}
+	err := i.SendAuthenticatedHTTPRequest("POST", path, params, &response)
 	if err != nil {
-		log.Println(err)
+		return response, err
 	}
+	return response, nil
 }
 
 func (i *ItBit) GetWallet(walletID string) {
 	path := "/wallets/" + walletID
-	err := i.SendAuthenticatedHTTPRequest("GET", path, nil)
+	err := i.SendAuthenticatedHTTPRequest("GET", path, nil, nil)
 
 	if err != nil {
 		log.Println(err)
@@ -182,7 +243,7 @@ func (i *ItBit) GetWallet(walletID string) {
 
 func (i *ItBit) GetWalletBalance(walletID, currency string) {
 	path := "/wallets/ " + walletID + "/balances/" + currency
-	err := i.SendAuthenticatedHTTPRequest("GET", path, nil)
+	err := i.SendAuthenticatedHTTPRequest("GET", path, nil, nil)
 
 	if err != nil {
 		log.Println(err)
@@ -191,7 +252,7 @@ func (i *ItBit) GetWalletBalance(walletID, currency string) {
 
 func (i *ItBit) GetWalletTrades(walletID string, params url.Values) {
 	path := EncodeURLValues("/wallets/"+walletID+"/trades", params)
-	err := i.SendAuthenticatedHTTPRequest("GET", path, nil)
+	err := i.SendAuthenticatedHTTPRequest("GET", path, nil, nil)
 
 	if err != nil {
 		log.Println(err)
@@ -200,7 +261,7 @@ func (i *ItBit) GetWalletTrades(walletID string, params url.Values) {
 
 func (i *ItBit) GetWalletOrders(walletID string, params url.Values) {
 	path := EncodeURLValues("/wallets/"+walletID+"/orders", params)
-	err := i.SendAuthenticatedHTTPRequest("GET", path, nil)
+	err := i.SendAuthenticatedHTTPRequest("GET", path, nil, nil)
 
 	if err != nil {
 		log.Println(err)
@@ -221,7 +282,7 @@ func (i *ItBit) PlaceWalletOrder(walletID, side, orderType, currency string, amo
 		params["clientOrderIdentifier"] = clientRef
 	}
 
-	err := i.SendAuthenticatedHTTPRequest("POST", path, params)
+	err := i.SendAuthenticatedHTTPRequest("POST", path, params, nil)
 
 	if err != nil {
 		log.Println(err)
@@ -230,7 +291,7 @@ func (i *ItBit) PlaceWalletOrder(walletID, side, orderType, currency string, amo
 
 func (i *ItBit) GetWalletOrder(walletID, orderID string) {
 	path := "/wallets/" + walletID + "/orders/" + orderID
-	err := i.SendAuthenticatedHTTPRequest("GET", path, nil)
+	err := i.SendAuthenticatedHTTPRequest("GET", path, nil, nil)
 
 	if err != nil {
 		log.Println(err)
@@ -239,25 +300,23 @@ func (i *ItBit) GetWalletOrder(walletID, orderID string) {
 
 func (i *ItBit) CancelWalletOrder(walletID, orderID string) {
 	path := "/wallets/" + walletID + "/orders/" + orderID
-	err := i.SendAuthenticatedHTTPRequest("DELETE", path, nil)
+	err := i.SendAuthenticatedHTTPRequest("DELETE", path, nil, nil)
 
 	if err != nil {
 		log.Println(err)
 	}
 }
 
-func (i *ItBit) PlaceWithdrawalRequest(walletID, currency, address string, amount float64) {
+// placeWithdrawalRequest is not used by RequestWithdrawal, as the response is
+// discarded and a failed withdrawal can't be told from a successful one.
+func (i *ItBit) placeWithdrawalRequest(walletID, currency, address string, amount float64) error {
 	path := "/wallets/" + walletID + "/cryptocurrency_withdrawals"
 	params := make(map[string]interface{})
 	params["currency"] = currency
 	params["amount"] = amount
 	params["address"] = address
 
-	err := i.SendAuthenticatedHTTPRequest("POST", path, params)
-
-	if err != nil {
-		log.Println(err)
-	}
+	return i.SendAuthenticatedHTTPRequest("POST", path, params, nil)
 }
 
 func (i *ItBit) GetDepositAddress(walletID, currency string) {
@@ -265,14 +324,14 @@ func (i *ItBit) GetDepositAddress(walletID, currency string) {
 	params := make(map[string]interface{})
 	params["currency"] = currency
 
-	err := i.SendAuthenticatedHTTPRequest("POST", path, params)
+	err := i.SendAuthenticatedHTTPRequest("POST", path, params, nil)
 
 	if err != nil {
 		log.Println(err)
 	}
 }
 
-func (i *ItBit) WalletTransfer(walletID, sourceWallet, destWallet string, amount float64, currency string) {
+func (i *ItBit) WalletTransfer(walletID, sourceWallet, destWallet string, amount float64, currency string) error {
 	path := "/wallets/" + walletID + "/wallet_transfers"
 	params := make(map[string]interface{})
 	params["sourceWalletId"] = sourceWallet
@@ -280,14 +339,54 @@ func (i *ItBit) WalletTransfer(walletID, sourceWallet, destWallet string, amount
 	params["amount"] = strconv.FormatFloat(amount, 'f', -1, 64)
 	params["currencyCode"] = currency
 
-	err := i.SendAuthenticatedHTTPRequest("POST", path, params)
+	response := make(map[string]interface{})
+	return i.SendAuthenticatedHTTPRequest("POST", path, params, &response)
+}
 
+// ListWallets returns each of the user's itBit wallets and its balances.
+func (i *ItBit) ListWallets() ([]Wallet, error) {
+	wallets, err := i.GetWallets(url.Values{})
 	if err != nil {
-		log.Println(err)
+		return nil, err
+	}
+
+	result := []Wallet{}
+	for _, x := range wallets {
+		wallet := Wallet{Exchange: i.GetName(), ID: x.ID, Name: x.Name, Balances: []AccountBalance{}}
+		for _, y := range x.Balances {
+			wallet.Balances = append(wallet.Balances, AccountBalance{y.Currency, y.TotalBalance, y.AvailableBalance, y.TotalBalance - y.AvailableBalance})
+		}
+		result = append(result, wallet)
+	}
+	return result, nil
+}
+
+// TransferBetweenWallets takes BTC as well as itBit's own XBT, as wallets are
+// listed with common currency names.
+func (i *ItBit) TransferBetweenWallets(currency string, amount float64, from, to string) error {
+	if currency == "BTC" {
+		currency = "XBT"
 	}
+	return i.WalletTransfer(from, from, to, amount, currency)
 }
 
-func (i *ItBit) SendAuthenticatedHTTPRequest(method string, path string, params map[string]interface{}) (err error) {
+// CreateNewWallet opens a wallet which holds every currency.
+func (i *ItBit) CreateNewWallet(name, currency string) (string, error) {
+	wallet, err := i.CreateWallet(name)
+	if err != nil {
+		return "", err
+	}
+	return wallet.ID, nil
+}
+
+type ItBitErrorResponse struct {
+	Code        int    `json:"code"`
+	Description string `json:"description"`
+}
+
+// SendAuthenticatedHTTPRequest decodes the response into result unless it is
+// nil, in which case the response is discarded.
+func (i *ItBit) SendAuthenticatedHTTPRequest(method string, path string, params map[string]interface{}, result interface{}) (err error) {
 	timestamp := strconv.FormatInt(time.Now().UnixNano(), 10)[0:13]
 	nonce, err := strconv.Atoi(timestamp)
 
@@ -337,9 +436,26 @@ func (i *ItBit) SendAuthenticatedHTTPRequest(method string, path string, params
 	headers["Content-Type"] = "application/json"
 
 	resp, err := SendHTTPRequest(method, url, headers, bytes.NewBuffer([]byte(PayloadJson)))
+	if err != nil {
+		return err
+	}
 
 	if i.Verbose {
 		log.Printf("Recieved raw: \n%s\n", resp)
 	}
+
+	if result == nil {
+		return nil
+	}
+
+	errResponse := ItBitErrorResponse{}
+	if JSONDecode([]byte(resp), &errResponse) == nil && errResponse.Description != "" {
+		return errors.New(errResponse.Description)
+	}
+
+	err = JSONDecode([]byte(resp), result)
+	if err != nil {
+		return errors.New("Unable to JSON Unmarshal response.")
+	}
 	return nil
 }
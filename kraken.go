@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -48,7 +52,6 @@ type Kraken struct {
 	BaseCurrencies          []string
 	AvailablePairs          []string
 	EnabledPairs            []string
-	Ticker                  map[string]KrakenTicker
 }
 
 func (k *Kraken) SetDefaults() {
@@ -58,8 +61,7 @@ func (k *Kraken) SetDefaults() {
 	k.CryptoFee = 0.10
 	k.Verbose = false
 	k.Websocket = false
-	k.RESTPollingDelay = 10
-	k.Ticker = make(map[string]KrakenTicker)
+	k.RESTPollingDelay = 10 * time.Second
 }
 
 func (k *Kraken) GetName() string {
@@ -74,6 +76,26 @@ func (k *Kraken) IsEnabled() bool {
 	return k.Enabled
 }
 
+func (k *Kraken) Setup(exch Exchanges) {
+	if !exch.Enabled {
+		k.SetEnabled(false)
+	} else {
+		k.SetEnabled(true)
+		k.AuthenticatedAPISupport = exch.AuthenticatedAPISupport
+		k.SetAPIKeys(exch.APIKey, exch.APISecret)
+		k.RESTPollingDelay = exch.RESTPollingDelay.Duration
+		k.Verbose = exch.Verbose
+		k.Websocket = exch.Websocket
+		k.BaseCurrencies = exch.BaseCurrencies
+		k.AvailablePairs = CurrencyPairsToStrings(exch.AvailablePairs)
+		k.EnabledPairs = CurrencyPairsToStrings(exch.EnabledPairs)
+	}
+}
+
+func (k *Kraken) GetRequiredCredentials() []string {
+	return []string{CREDENTIAL_API_KEY, CREDENTIAL_API_SECRET}
+}
+
 func (k *Kraken) SetAPIKeys(apiKey, apiSecret string) {
 	k.ClientKey = apiKey
 	k.APISecret = apiSecret
@@ -87,24 +109,24 @@ func (k *Kraken) GetFee(cryptoTrade bool) float64 {
 	}
 }
 
-func (k *Kraken) Run() {
+func (k *Kraken) Run(ctx context.Context) {
 	if k.Verbose {
-		log.Printf("%s polling delay: %ds.\n", k.GetName(), k.RESTPollingDelay)
+		log.Printf("%s polling delay: %s.\n", k.GetName(), k.RESTPollingDelay)
 		log.Printf("%s %d currencies enabled: %s.\n", k.GetName(), len(k.EnabledPairs), k.EnabledPairs)
 	}
 
-	for k.Enabled {
-		err := k.GetTicker(JoinStrings(k.EnabledPairs, ","))
+	for k.Enabled && ctx.Err() == nil {
+		tickers, err := k.GetTicker(JoinStrings(k.EnabledPairs, ","))
 		if err != nil {
 			log.Println(err)
 		} else {
 			for _, x := range k.EnabledPairs {
-				ticker := k.Ticker[x]
+				ticker := tickers[x]
 				log.Printf("Kraken %s Last %f High %f Low %f Volume %f\n", x, ticker.Last, ticker.High, ticker.Low, ticker.Volume)
 				AddExchangeInfo(k.GetName(), x[0:3], x[3:], ticker.Last, ticker.Volume)
 			}
 		}
-		time.Sleep(time.Second * k.RESTPollingDelay)
+		SleepContext(ctx, k.RESTPollingDelay)
 	}
 }
 
@@ -171,7 +193,9 @@ type KrakenTickerResponse struct {
 	Open   string   `json:"o"`
 }
 
-func (k *Kraken) GetTicker(symbol string) error {
+// GetTicker returns the tickers of the comma separated pairs in symbol, keyed
+// by pair.
+func (k *Kraken) GetTicker(symbol string) (map[string]KrakenTicker, error) {
 	values := url.Values{}
 	values.Set("pair", symbol)
 
@@ -185,13 +209,14 @@ func (k *Kraken) GetTicker(symbol string) error {
 	err := SendHTTPGetRequest(path, true, &resp)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if len(resp.Error) > 0 {
-		return errors.New(fmt.Sprintf("Kraken error: %s", resp.Error))
+		return nil, errors.New(fmt.Sprintf("Kraken error: %s", resp.Error))
 	}
 
+	tickers := make(map[string]KrakenTicker)
 	for x, y := range resp.Data {
 		x = x[1:4] + x[5:]
 		ticker := KrakenTicker{}
@@ -204,41 +229,146 @@ func (k *Kraken) GetTicker(symbol string) error {
 		ticker.Low, _ = strconv.ParseFloat(y.Low[1], 64)
 		ticker.High, _ = strconv.ParseFloat(y.High[1], 64)
 		ticker.Open, _ = strconv.ParseFloat(y.Open, 64)
-		k.Ticker[x] = ticker
+		tickers[x] = ticker
 	}
-	return nil
+	return tickers, nil
 }
 
-func (k *Kraken) GetOHLC(symbol string) error {
+type KrakenOHLC struct {
+	Time   int64
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	VWAP   float64
+	Volume float64
+	Count  int64
+}
+
+// GetOHLC returns candles of interval minutes after since, a unix time. Kraken
+// only keeps the most recent 720 candles of each interval, so older data
+// cannot be retrieved this way.
+func (k *Kraken) GetOHLC(symbol string, interval int, since int64) ([]KrakenOHLC, error) {
 	values := url.Values{}
 	values.Set("pair", symbol)
+	values.Set("interval", strconv.Itoa(interval))
 
-	var result interface{}
+	if since > 0 {
+		values.Set("since", strconv.FormatInt(since, 10))
+	}
+
+	type Response struct {
+		Error []interface{}          `json:"error"`
+		Data  map[string]interface{} `json:"result"`
+	}
+
+	resp := Response{}
 	path := fmt.Sprintf("%s/%s/public/%s?%s", KRAKEN_API_URL, KRAKEN_API_VERSION, KRAKEN_OHLC, values.Encode())
-	err := SendHTTPGetRequest(path, true, &result)
+	err := SendHTTPGetRequest(path, true, &resp)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	log.Println(result)
-	return nil
+	if len(resp.Error) > 0 {
+		return nil, errors.New(fmt.Sprintf("Kraken error: %s", resp.Error))
+	}
+
+	result := []KrakenOHLC{}
+	for x, y := range resp.Data {
+		if x == "last" {
+			continue
+		}
+
+		candles, ok := y.([]interface{})
+		if !ok {
+			continue
+		}
+
+		for _, candle := range candles {
+			data, ok := candle.([]interface{})
+			if !ok || len(data) < 8 {
+				continue
+			}
+
+			ohlc := KrakenOHLC{}
+			if timestamp, ok := data[0].(float64); ok {
+				ohlc.Time = int64(timestamp)
+			}
+			if count, ok := data[7].(float64); ok {
+				ohlc.Count = int64(count)
+			}
+
+			fields := []*float64{&ohlc.Open, &ohlc.High, &ohlc.Low, &ohlc.Close, &ohlc.VWAP, &ohlc.Volume}
+			for i, field := range fields {
+				value, _ := data[i+1].(string)
+				*field, err = strconv.ParseFloat(value, 64)
+				if err != nil {
+					return nil, err
+				}
+			}
+			result = append(result, ohlc)
+		}
+	}
+	return result, nil
 }
 
-func (k *Kraken) GetDepth(symbol string) error {
+func (k *Kraken) GetHistoricCandles(pair CurrencyPair, start, end time.Time, interval time.Duration) ([]Candle, error) {
+	minutes := int(interval / time.Minute)
+	switch minutes {
+	case 1, 5, 15, 30, 60, 240, 1440, 10080, 21600:
+		if interval%time.Minute == 0 {
+			break
+		}
+		fallthrough
+	default:
+		return nil, NewCandleIntervalError(k.GetName(), interval)
+	}
+
+	// since is exclusive, so step back one second to include the start candle.
+	ohlc, err := k.GetOHLC(pair.Base+pair.Quote, minutes, start.Unix()-1)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Candle{}
+	for _, x := range ohlc {
+		result = append(result, Candle{time.Unix(x.Time, 0), x.Open, x.High, x.Low, x.Close, x.Volume})
+	}
+	SortCandles(result)
+	return result, nil
+}
+
+type KrakenDepth struct {
+	Asks [][]interface{} `json:"asks"`
+	Bids [][]interface{} `json:"bids"`
+}
+
+func (k *Kraken) GetDepth(symbol string) (KrakenDepth, error) {
 	values := url.Values{}
 	values.Set("pair", symbol)
 
-	var result interface{}
+	type Response struct {
+		Error []interface{}          `json:"error"`
+		Data  map[string]KrakenDepth `json:"result"`
+	}
+
+	resp := Response{}
 	path := fmt.Sprintf("%s/%s/public/%s?%s", KRAKEN_API_URL, KRAKEN_API_VERSION, KRAKEN_DEPTH, values.Encode())
-	err := SendHTTPGetRequest(path, true, &result)
+	err := SendHTTPGetRequest(path, true, &resp)
 
 	if err != nil {
-		return err
+		return KrakenDepth{}, err
 	}
 
-	log.Println(result)
-	return nil
+	if len(resp.Error) > 0 {
+		return KrakenDepth{}, errors.New(fmt.Sprintf("Kraken error: %s", resp.Error))
+	}
+
+	for _, x := range resp.Data {
+		return x, nil
+	}
+	return KrakenDepth{}, nil
 }
 
 func (k *Kraken) GetTrades(symbol string) error {
@@ -271,15 +401,21 @@ func (k *Kraken) GetSpread(symbol string) {
 	}
 }
 
-func (k *Kraken) GetBalance() {
-	result, err := k.SendAuthenticatedHTTPRequest(KRAKEN_BALANCE, url.Values{})
+// GetBalance returns the balance of each asset, keyed by Kraken's asset name
+// such as XXBT or ZUSD.
+func (k *Kraken) GetBalance() (map[string]float64, error) {
+	result := make(map[string]string)
+	err := k.SendAuthenticatedRequest(KRAKEN_BALANCE, url.Values{}, &result)
 
 	if err != nil {
-		log.Println(err)
-		return
+		return nil, err
 	}
 
-	log.Println(result)
+	balances := make(map[string]float64)
+	for x, y := range result {
+		balances[x], _ = strconv.ParseFloat(y, 64)
+	}
+	return balances, nil
 }
 
 func (k *Kraken) GetTradeBalance(symbol, asset string) {
@@ -303,7 +439,20 @@ func (k *Kraken) GetTradeBalance(symbol, asset string) {
 	log.Println(result)
 }
 
-func (k *Kraken) GetOpenOrders(showTrades bool, userref int64) {
+type KrakenOrder struct {
+	Status      string `json:"status"`
+	Description struct {
+		Pair      string  `json:"pair"`
+		Type      string  `json:"type"`
+		OrderType string  `json:"ordertype"`
+		Price     float64 `json:"price,string"`
+	} `json:"descr"`
+	Volume         float64 `json:"vol,string"`
+	VolumeExecuted float64 `json:"vol_exec,string"`
+}
+
+// GetOpenOrders returns open orders keyed by transaction ID.
+func (k *Kraken) GetOpenOrders(showTrades bool, userref int64) (map[string]KrakenOrder, error) {
 	values := url.Values{}
 
 	if showTrades {
@@ -314,14 +463,16 @@ func (k *Kraken) GetOpenOrders(showTrades bool, userref int64) {
 		values.Set("userref", strconv.FormatInt(userref, 10))
 	}
 
-	result, err := k.SendAuthenticatedHTTPRequest(KRAKEN_OPEN_ORDERS, values)
+	result := struct {
+		Open map[string]KrakenOrder `json:"open"`
+	}{}
+	err := k.SendAuthenticatedRequest(KRAKEN_OPEN_ORDERS, values, &result)
 
 	if err != nil {
-		log.Println(err)
-		return
+		return nil, err
 	}
 
-	log.Println(result)
+	return result.Open, nil
 }
 
 func (k *Kraken) GetClosedOrders(showTrades bool, userref, start, end, offset int64, closetime string) {
@@ -386,7 +537,21 @@ func (k *Kraken) QueryOrdersInfo(showTrades bool, userref, txid int64) {
 	log.Println(result)
 }
 
-func (k *Kraken) GetTradesHistory(tradeType string, showRelatedTrades bool, start, end, offset int64) {
+type KrakenTrade struct {
+	OrderID   string  `json:"ordertxid"`
+	Pair      string  `json:"pair"`
+	Time      float64 `json:"time"`
+	Type      string  `json:"type"`
+	OrderType string  `json:"ordertype"`
+	Price     float64 `json:"price,string"`
+	Cost      float64 `json:"cost,string"`
+	Fee       float64 `json:"fee,string"`
+	Volume    float64 `json:"vol,string"`
+}
+
+// GetTradesHistory returns a page of trades, newest first, keyed by trade ID
+// along with the total number of trades matching the query.
+func (k *Kraken) GetTradesHistory(tradeType string, showRelatedTrades bool, start, end, offset int64) (map[string]KrakenTrade, int, error) {
 	values := url.Values{}
 
 	if len(tradeType) > 0 {
@@ -406,17 +571,62 @@ func (k *Kraken) GetTradesHistory(tradeType string, showRelatedTrades bool, star
 	}
 
 	if offset != 0 {
-		values.Set("offset", strconv.FormatInt(offset, 10))
+		values.Set("ofs", strconv.FormatInt(offset, 10))
 	}
 
-	result, err := k.SendAuthenticatedHTTPRequest(KRAKEN_TRADES_HISTORY, values)
+	result := struct {
+		Trades map[string]KrakenTrade `json:"trades"`
+		Count  int                    `json:"count"`
+	}{}
+	err := k.SendAuthenticatedRequest(KRAKEN_TRADES_HISTORY, values, &result)
 
 	if err != nil {
-		log.Println(err)
-		return
+		return nil, 0, err
 	}
 
-	log.Println(result)
+	return result.Trades, result.Count, nil
+}
+
+// GetAccountFills returns the fills of pair within [start, end). The history
+// covers every pair, so it is paged through in full and filtered. Fees are
+// charged in the quote currency.
+func (k *Kraken) GetAccountFills(pair CurrencyPair, start, end time.Time) ([]AccountFill, error) {
+	result := []AccountFill{}
+	for offset := 0; ; {
+		trades, count, err := k.GetTradesHistory("", false, start.Unix(), end.Unix(), int64(offset))
+		if err != nil {
+			return nil, err
+		}
+
+		for id, x := range trades {
+			timestamp := time.Unix(0, int64(x.Time*float64(time.Second)))
+			if krakenPair(x.Pair, k.BaseCurrencies) != pair || timestamp.Before(start) || !timestamp.Before(end) {
+				continue
+			}
+			result = append(result, AccountFill{
+				Exchange:    k.GetName(),
+				ID:          id,
+				OrderID:     x.OrderID,
+				Timestamp:   timestamp,
+				Pair:        pair,
+				Side:        StringToUpper(x.Type),
+				Price:       x.Price,
+				Amount:      x.Volume,
+				Fee:         x.Fee,
+				FeeCurrency: pair.Quote,
+			})
+		}
+
+		offset += len(trades)
+		if len(trades) == 0 || offset >= count {
+			break
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+	return result, nil
 }
 
 func (k *Kraken) QueryTrades(txid int64, showRelatedTrades bool) {
@@ -455,7 +665,20 @@ func (k *Kraken) OpenPositions(txid int64, showPL bool) {
 	log.Println(result)
 }
 
-func (k *Kraken) GetLedgers(symbol, asset, ledgerType string, start, end, offset int64) {
+type KrakenLedger struct {
+	RefID   string  `json:"refid"`
+	Time    float64 `json:"time"`
+	Type    string  `json:"type"`
+	Class   string  `json:"aclass"`
+	Asset   string  `json:"asset"`
+	Amount  float64 `json:"amount,string"`
+	Fee     float64 `json:"fee,string"`
+	Balance float64 `json:"balance,string"`
+}
+
+// GetLedgers returns a page of ledger entries, newest first, keyed by ledger
+// ID along with the total number of entries matching the query.
+func (k *Kraken) GetLedgers(symbol, asset, ledgerType string, start, end, offset int64) (map[string]KrakenLedger, int, error) {
 	values := url.Values{}
 
 	if len(symbol) > 0 {
@@ -479,17 +702,57 @@ func (k *Kraken) GetLedgers(symbol, asset, ledgerType string, start, end, offset
 	}
 
 	if offset != 0 {
-		values.Set("offset", strconv.FormatInt(offset, 10))
+		values.Set("ofs", strconv.FormatInt(offset, 10))
 	}
 
-	result, err := k.SendAuthenticatedHTTPRequest(KRAKEN_LEDGERS, values)
+	result := struct {
+		Ledger map[string]KrakenLedger `json:"ledger"`
+		Count  int                     `json:"count"`
+	}{}
+	err := k.SendAuthenticatedRequest(KRAKEN_LEDGERS, values, &result)
 
 	if err != nil {
-		log.Println(err)
-		return
+		return nil, 0, err
 	}
 
-	log.Println(result)
+	return result.Ledger, result.Count, nil
+}
+
+// GetFundingHistory returns the deposits and withdrawals in the ledger. Kraken
+// only adds them to the ledger once they complete.
+func (k *Kraken) GetFundingHistory(start, end time.Time) ([]FundingMovement, error) {
+	result := []FundingMovement{}
+	for _, ledgerType := range []string{"deposit", "withdrawal"} {
+		for offset := 0; ; {
+			entries, count, err := k.GetLedgers("", "", ledgerType, start.Unix(), end.Unix(), int64(offset))
+			if err != nil {
+				return nil, err
+			}
+
+			for id, x := range entries {
+				timestamp := time.Unix(0, int64(x.Time*float64(time.Second)))
+				if timestamp.Before(start) || timestamp.After(end) {
+					continue
+				}
+				result = append(result, FundingMovement{
+					Exchange:  k.GetName(),
+					ID:        id,
+					Type:      StringToUpper(ledgerType),
+					Status:    FUNDING_STATUS_COMPLETE,
+					Timestamp: timestamp,
+					Currency:  krakenCurrency(x.Asset),
+					Amount:    math.Abs(x.Amount),
+					Fee:       x.Fee,
+				})
+			}
+
+			offset += len(entries)
+			if len(entries) == 0 || offset >= count {
+				break
+			}
+		}
+	}
+	return result, nil
 }
 
 func (k *Kraken) QueryLedgers(id string) {
@@ -520,39 +783,74 @@ func (k *Kraken) GetTradeVolume(symbol string) {
 	log.Println(result)
 }
 
-func (k *Kraken) AddOrder(symbol, side, orderType string, price, price2, volume, leverage, position float64) {
+// AddOrder places an order and returns its transaction IDs. price2,
+// leverage and position are only sent when set.
+func (k *Kraken) AddOrder(symbol, side, orderType string, price, price2, volume, leverage, position float64) ([]string, error) {
 	values := url.Values{}
-	values.Set("pairs", symbol)
+	values.Set("pair", symbol)
 	values.Set("type", side)
 	values.Set("ordertype", orderType)
 	values.Set("price", strconv.FormatFloat(price, 'f', -1, 64))
-	values.Set("price2", strconv.FormatFloat(price, 'f', -1, 64))
 	values.Set("volume", strconv.FormatFloat(volume, 'f', -1, 64))
-	values.Set("leverage", strconv.FormatFloat(leverage, 'f', -1, 64))
-	values.Set("position", strconv.FormatFloat(position, 'f', -1, 64))
 
-	result, err := k.SendAuthenticatedHTTPRequest(KRAKEN_ORDER_PLACE, values)
+	if price2 != 0 {
+		values.Set("price2", strconv.FormatFloat(price2, 'f', -1, 64))
+	}
+
+	if leverage != 0 {
+		values.Set("leverage", strconv.FormatFloat(leverage, 'f', -1, 64))
+	}
+
+	if position != 0 {
+		values.Set("position", strconv.FormatFloat(position, 'f', -1, 64))
+	}
+
+	result := struct {
+		TransactionIDs []string `json:"txid"`
+	}{}
+	err := k.SendAuthenticatedRequest(KRAKEN_ORDER_PLACE, values, &result)
 
 	if err != nil {
-		log.Println(err)
-		return
+		return nil, err
 	}
 
-	log.Println(result)
+	return result.TransactionIDs, nil
 }
 
-func (k *Kraken) CancelOrder(orderID int64) {
+func (k *Kraken) CancelOrder(orderID string) error {
 	values := url.Values{}
-	values.Set("txid", strconv.FormatInt(orderID, 10))
+	values.Set("txid", orderID)
 
-	result, err := k.SendAuthenticatedHTTPRequest(KRAKEN_ORDER_CANCEL, values)
+	var result interface{}
+	return k.SendAuthenticatedRequest(KRAKEN_ORDER_CANCEL, values, &result)
+}
+
+// SendAuthenticatedRequest sends a private API request and decodes its result,
+// returning any errors Kraken reports.
+func (k *Kraken) SendAuthenticatedRequest(method string, values url.Values, result interface{}) error {
+	resp, err := k.SendAuthenticatedHTTPRequest(method, values)
 
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
 
-	log.Println(result)
+	type Response struct {
+		Error  []interface{}   `json:"error"`
+		Result json.RawMessage `json:"result"`
+	}
+
+	response := Response{}
+	err = JSONDecode([]byte(resp.(string)), &response)
+
+	if err != nil {
+		return err
+	}
+
+	if len(response.Error) > 0 {
+		return errors.New(fmt.Sprintf("Kraken error: %s", response.Error))
+	}
+
+	return JSONDecode(response.Result, result)
 }
 
 func (k *Kraken) SendAuthenticatedHTTPRequest(method string, values url.Values) (interface{}, error) {
@@ -587,3 +885,103 @@ func (k *Kraken) SendAuthenticatedHTTPRequest(method string, values url.Values)
 
 	return resp, nil
 }
+
+// krakenCurrency converts a Kraken asset name such as XXBT or ZUSD to the
+// currency name used in pairs.
+func krakenCurrency(asset string) string {
+	if len(asset) == 4 && (asset[0] == 'X' || asset[0] == 'Z') {
+		return asset[1:]
+	}
+	return asset
+}
+
+// krakenPair converts a Kraken pair name such as XXBTZUSD or XBTUSD to a
+// currency pair.
+func krakenPair(pair string, quotes []string) CurrencyPair {
+	if len(pair) == 8 && (pair[0] == 'X' || pair[0] == 'Z') && (pair[4] == 'X' || pair[4] == 'Z') {
+		return NewCurrencyPair(krakenCurrency(pair[:4]), krakenCurrency(pair[4:]))
+	}
+	return ParseCurrencyPair(StringToUpper(pair), quotes)
+}
+
+func (k *Kraken) GetTickerPrice(pair CurrencyPair) (TickerPrice, error) {
+	tickers, err := k.GetTicker(pair.String())
+	if err != nil {
+		return TickerPrice{}, err
+	}
+
+	ticker, ok := tickers[pair.String()]
+	if !ok {
+		return TickerPrice{}, fmt.Errorf(ErrExchangePairNotSupported, k.GetName(), pair)
+	}
+	return TickerPrice{pair.Base, pair.Quote, ticker.Last, ticker.High, ticker.Low, ticker.Bid, ticker.Ask, ticker.Volume}, nil
+}
+
+func (k *Kraken) GetOrderbookDepth(pair CurrencyPair) (Orderbook, error) {
+	depth, err := k.GetDepth(pair.String())
+	if err != nil {
+		return Orderbook{}, err
+	}
+
+	convert := func(entries [][]interface{}) []OrderbookItem {
+		items := []OrderbookItem{}
+		for _, x := range entries {
+			if len(x) < 2 {
+				continue
+			}
+			price, _ := strconv.ParseFloat(fmt.Sprint(x[0]), 64)
+			amount, _ := strconv.ParseFloat(fmt.Sprint(x[1]), 64)
+			items = append(items, OrderbookItem{price, amount})
+		}
+		return items
+	}
+	return Orderbook{pair, convert(depth.Bids), convert(depth.Asks)}, nil
+}
+
+// GetAccountBalances returns each asset's balance. Kraken doesn't report funds
+// held by open orders, so the whole balance is shown as available.
+func (k *Kraken) GetAccountBalances() ([]AccountBalance, error) {
+	balances, err := k.GetBalance()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []AccountBalance{}
+	for x, y := range balances {
+		result = append(result, AccountBalance{krakenCurrency(x), y, y, 0})
+	}
+	return result, nil
+}
+
+func (k *Kraken) GetOpenOrderDetails() ([]OrderDetail, error) {
+	orders, err := k.GetOpenOrders(false, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []OrderDetail{}
+	for id, x := range orders {
+		result = append(result, OrderDetail{
+			ID:     id,
+			Pair:   ParseCurrencyPair(StringToUpper(x.Description.Pair), k.BaseCurrencies),
+			Side:   StringToUpper(x.Description.Type),
+			Price:  x.Description.Price,
+			Amount: x.Volume,
+			Filled: x.VolumeExecuted,
+			Status: ORDER_STATUS_OPEN,
+		})
+	}
+	return result, nil
+}
+
+func (k *Kraken) SubmitOrder(pair CurrencyPair, side string, amount, price float64) (string, error) {
+	ids, err := k.AddOrder(pair.String(), StringToLower(side), "limit", price, 0, amount, 0, 0)
+	if err != nil {
+		return "", err
+	}
+	return JoinStrings(ids, ","), nil
+}
+
+func (k *Kraken) CancelOrderByID(pair CurrencyPair, orderID string) error {
+	return k.CancelOrder(orderID)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"log"
 	"net/url"
@@ -64,7 +65,7 @@ func (l *LakeBTC) SetDefaults() {
 	l.MakerFee = 0.15
 	l.Verbose = false
 	l.Websocket = false
-	l.RESTPollingDelay = 10
+	l.RESTPollingDelay = 10 * time.Second
 }
 
 func (l *LakeBTC) GetName() string {
@@ -79,6 +80,26 @@ func (l *LakeBTC) IsEnabled() bool {
 	return l.Enabled
 }
 
+func (l *LakeBTC) Setup(exch Exchanges) {
+	if !exch.Enabled {
+		l.SetEnabled(false)
+	} else {
+		l.SetEnabled(true)
+		l.AuthenticatedAPISupport = exch.AuthenticatedAPISupport
+		l.SetAPIKeys(exch.APIKey, exch.APISecret)
+		l.RESTPollingDelay = exch.RESTPollingDelay.Duration
+		l.Verbose = exch.Verbose
+		l.Websocket = exch.Websocket
+		l.BaseCurrencies = exch.BaseCurrencies
+		l.AvailablePairs = CurrencyPairsToStrings(exch.AvailablePairs)
+		l.EnabledPairs = CurrencyPairsToStrings(exch.EnabledPairs)
+	}
+}
+
+func (l *LakeBTC) GetRequiredCredentials() []string {
+	return []string{CREDENTIAL_API_KEY, CREDENTIAL_API_SECRET}
+}
+
 func (l *LakeBTC) SetAPIKeys(apiKey, apiSecret string) {
 	l.Email = apiKey
 	l.APISecret = apiSecret
@@ -92,18 +113,18 @@ func (l *LakeBTC) GetFee(maker bool) float64 {
 	}
 }
 
-func (l *LakeBTC) Run() {
+func (l *LakeBTC) Run(ctx context.Context) {
 	if l.Verbose {
 		log.Printf("%s Websocket: %s. (url: %s).\n", l.GetName(), IsEnabled(l.Websocket), LAKEBTC_WEBSOCKET_URL)
-		log.Printf("%s polling delay: %ds.\n", l.GetName(), l.RESTPollingDelay)
+		log.Printf("%s polling delay: %s.\n", l.GetName(), l.RESTPollingDelay)
 		log.Printf("%s %d currencies enabled: %s.\n", l.GetName(), len(l.EnabledPairs), l.EnabledPairs)
 	}
 
 	if l.Websocket {
-		go l.WebsocketClient()
+		StartRoutine(func() { l.WebsocketClient(ctx) })
 	}
 
-	for l.Enabled {
+	for l.Enabled && ctx.Err() == nil {
 		ticker := l.GetTicker()
 		for _, x := range l.EnabledPairs {
 			if x == "BTCUSD" {
@@ -114,7 +135,7 @@ func (l *LakeBTC) Run() {
 				AddExchangeInfo(l.GetName(), x[0:3], x[3:], ticker.CNY.Last, ticker.CNY.Volume)
 			}
 		}
-		time.Sleep(time.Second * l.RESTPollingDelay)
+		SleepContext(ctx, l.RESTPollingDelay)
 	}
 }
 
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"log"
@@ -41,8 +42,10 @@ func WSRailsPong(id string, conn *websocket.Conn) {
 	}
 }
 
-func (l *LakeBTC) WebsocketClient() {
-	for l.Enabled && l.Websocket {
+func (l *LakeBTC) WebsocketClient(ctx context.Context) {
+	defer SetWebsocketConnected(l.GetName(), false)
+
+	for l.Enabled && l.Websocket && ctx.Err() == nil {
 		var Dialer websocket.Dialer
 		conn, _, err := Dialer.Dial(LAKEBTC_WEBSOCKET_URL, http.Header{})
 
@@ -51,7 +54,10 @@ func (l *LakeBTC) WebsocketClient() {
 			continue
 		}
 
+		stopClose := CloseOnDone(ctx, conn)
+
 		log.Printf("%s Connected to Websocket.\n", l.GetName())
+		SetWebsocketConnected(l.GetName(), true)
 
 		for l.Enabled && l.Websocket {
 			msgType, resp, err := conn.ReadMessage()
@@ -114,7 +120,9 @@ func (l *LakeBTC) WebsocketClient() {
 				}
 			}
 		}
+		stopClose()
 		conn.Close()
 		log.Printf("%s Websocket client disconnected.\n", l.GetName())
+		SetWebsocketConnected(l.GetName(), false)
 	}
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -43,7 +44,7 @@ func (l *LocalBitcoins) SetDefaults() {
 	l.Verbose = false
 	l.Verbose = false
 	l.Websocket = false
-	l.RESTPollingDelay = 10
+	l.RESTPollingDelay = 10 * time.Second
 }
 
 func (l *LocalBitcoins) GetName() string {
@@ -58,6 +59,26 @@ func (l *LocalBitcoins) IsEnabled() bool {
 	return l.Enabled
 }
 
+func (l *LocalBitcoins) Setup(exch Exchanges) {
+	if !exch.Enabled {
+		l.SetEnabled(false)
+	} else {
+		l.SetEnabled(true)
+		l.AuthenticatedAPISupport = exch.AuthenticatedAPISupport
+		l.SetAPIKeys(exch.APIKey, exch.APISecret)
+		l.RESTPollingDelay = exch.RESTPollingDelay.Duration
+		l.Verbose = exch.Verbose
+		l.Websocket = exch.Websocket
+		l.BaseCurrencies = exch.BaseCurrencies
+		l.AvailablePairs = CurrencyPairsToStrings(exch.AvailablePairs)
+		l.EnabledPairs = CurrencyPairsToStrings(exch.EnabledPairs)
+	}
+}
+
+func (l *LocalBitcoins) GetRequiredCredentials() []string {
+	return []string{CREDENTIAL_API_KEY, CREDENTIAL_API_SECRET}
+}
+
 func (l *LocalBitcoins) GetFee(maker bool) float64 {
 	if maker {
 		return l.MakerFee
@@ -66,13 +87,13 @@ func (l *LocalBitcoins) GetFee(maker bool) float64 {
 	}
 }
 
-func (l *LocalBitcoins) Run() {
+func (l *LocalBitcoins) Run(ctx context.Context) {
 	if l.Verbose {
-		log.Printf("%s polling delay: %ds.\n", l.GetName(), l.RESTPollingDelay)
+		log.Printf("%s polling delay: %s.\n", l.GetName(), l.RESTPollingDelay)
 		log.Printf("%s %d currencies enabled: %s.\n", l.GetName(), len(l.EnabledPairs), l.EnabledPairs)
 	}
 
-	for l.Enabled {
+	for l.Enabled && ctx.Err() == nil {
 		ticker, err := l.GetTicker()
 
 		if err != nil {
@@ -86,7 +107,7 @@ func (l *LocalBitcoins) Run() {
 			AddExchangeInfo(l.GetName(), x[0:3], x[3:], ticker[currency].Rates.Last, ticker[currency].VolumeBTC)
 		}
 	sleep:
-		time.Sleep(time.Second * l.RESTPollingDelay)
+		SleepContext(ctx, l.RESTPollingDelay)
 	}
 }
 
@@ -326,7 +347,8 @@ func (l *LocalBitcoins) GetWalletBalance() (LocalBitcoinsWalletBalanceInfo, erro
 	return resp.Data, nil
 }
 
-func (l *LocalBitcoins) WalletSend(address string, amount float64, pin int) (bool, error) {
+// walletSend sends bitcoin from the wallet.
+func (l *LocalBitcoins) walletSend(address string, amount float64, pin int) (bool, error) {
 	values := url.Values{}
 	values.Set("address", address)
 	values.Set("amount", strconv.FormatFloat(amount, 'f', -1, 64))
@@ -356,6 +378,36 @@ func (l *LocalBitcoins) WalletSend(address string, amount float64, pin int) (boo
 	return true, nil
 }
 
+// withdraw sends bitcoin without a PIN, so the API key must not require one.
+// LocalBitcoins doesn't return an ID.
+func (l *LocalBitcoins) withdraw(currency, address string, amount float64) (string, error) {
+	if currency != "BTC" {
+		return "", fmt.Errorf(ErrWithdrawalCurrencyNotSupported, l.GetName(), currency)
+	}
+
+	_, err := l.walletSend(address, amount, 0)
+	return "", err
+}
+
+// GetAccountBalances returns the wallet's bitcoin balance. Funds which aren't
+// yet sendable are shown as held.
+func (l *LocalBitcoins) GetAccountBalances() ([]AccountBalance, error) {
+	info, err := l.GetWalletBalance()
+	if err != nil {
+		return nil, err
+	}
+
+	total := info.Total
+	return []AccountBalance{{"BTC", total.Balance, total.Sendable, total.Balance - total.Sendable}}, nil
+}
+
+func (l *LocalBitcoins) GetCryptoDepositAddress(currency string) (string, error) {
+	if currency != "BTC" {
+		return "", fmt.Errorf(ErrDepositCurrencyNotSupported, l.GetName(), currency)
+	}
+	return l.GetWalletAddress()
+}
+
 func (l *LocalBitcoins) GetWalletAddress() (string, error) {
 	type response struct {
 		Data struct {
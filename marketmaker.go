@@ -0,0 +1,271 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	STRATEGY_MARKET_MAKER = "marketmaker"
+)
+
+func init() {
+	RegisterStrategy(STRATEGY_MARKET_MAKER, func() Strategy { return &MarketMaker{} })
+}
+
+// MarketMaker quotes bids and asks around the order book mid. Quotes are
+// shifted away from the side the strategy holds too much of, so inventory
+// drifts back towards where it started, and are replaced whenever the mid
+// moves by more than the requote threshold or an order fills.
+//
+// Params, with percentages of the mid:
+//
+//	spread (0.2)        distance between the innermost bid and ask
+//	levels (1)          quotes on each side
+//	level_spacing (0.1) distance between levels
+//	amount (0.01)       size of each quote
+//	max_inventory (1)   largest position, in base currency, either side of
+//	                    the starting inventory. Asks are also limited to
+//	                    the base currency held.
+//	skew (0.1)          how far quotes shift at max_inventory
+//	requote (0.05)      mid move which triggers new quotes
+//	max_daily_loss (0)  loss in quote currency since midnight UTC at which
+//	                    quoting stops for the day; 0 disables the limit
+//	price_decimals (2)  decimals prices are rounded to
+//
+// Set Orderbook in the strategy's config to quote off the order book. Without
+// it, quotes are based on the ticker's bid and ask.
+type MarketMaker struct {
+	BaseStrategy
+	spread        float64
+	levels        int
+	levelSpacing  float64
+	amount        float64
+	maxInventory  float64
+	skew          float64
+	requote       float64
+	maxDailyLoss  float64
+	priceDecimals int
+
+	target     float64
+	mid        float64
+	book       bool
+	quotedMid  float64
+	inventory  float64
+	stale      bool
+	bids       []string
+	asks       []string
+	day        time.Time
+	dayEquity  float64
+	lossHalted bool
+}
+
+func (m *MarketMaker) OnStart(s *StrategyContext) error {
+	var err error
+	floats := []struct {
+		name         string
+		value        *float64
+		defaultValue float64
+	}{
+		{"spread", &m.spread, 0.2},
+		{"level_spacing", &m.levelSpacing, 0.1},
+		{"amount", &m.amount, 0.01},
+		{"max_inventory", &m.maxInventory, 1},
+		{"skew", &m.skew, 0.1},
+		{"requote", &m.requote, 0.05},
+		{"max_daily_loss", &m.maxDailyLoss, 0},
+	}
+	for _, x := range floats {
+		*x.value, err = s.GetFloatParam(x.name, x.defaultValue)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.levels, err = s.GetIntParam("levels", 1)
+	if err != nil {
+		return err
+	}
+
+	m.priceDecimals, err = s.GetIntParam("price_decimals", 2)
+	if err != nil {
+		return err
+	}
+
+	balance, err := s.GetBalance(s.Pair.Base)
+	if err != nil {
+		return err
+	}
+	m.target = balance.Total
+	m.inventory = balance.Total
+	return nil
+}
+
+func (m *MarketMaker) OnBook(s *StrategyContext, orderbook Orderbook) {
+	if len(orderbook.Bids) == 0 || len(orderbook.Asks) == 0 {
+		return
+	}
+	m.book = true
+	m.update(s, (orderbook.Bids[0].Price+orderbook.Asks[0].Price)/2)
+}
+
+func (m *MarketMaker) OnTicker(s *StrategyContext, ticker TickerPrice) {
+	if m.book || ticker.Bid <= 0 || ticker.Ask <= 0 {
+		return
+	}
+	m.update(s, (ticker.Bid+ticker.Ask)/2)
+}
+
+func (m *MarketMaker) OnOrderUpdate(s *StrategyContext, order OrderDetail) {
+	if order.Status == ORDER_STATUS_OPEN {
+		return
+	}
+
+	m.bids = removeString(m.bids, order.ID)
+	m.asks = removeString(m.asks, order.ID)
+	if order.Filled > 0 {
+		m.stale = true
+	}
+}
+
+func (m *MarketMaker) OnStop(s *StrategyContext) {
+	m.cancelAll(s)
+}
+
+func removeString(list []string, value string) []string {
+	result := []string{}
+	for _, x := range list {
+		if x != value {
+			result = append(result, x)
+		}
+	}
+	return result
+}
+
+func (m *MarketMaker) cancelAll(s *StrategyContext) {
+	for _, x := range append(m.bids, m.asks...) {
+		err := s.Cancel(x)
+		if err != nil {
+			s.Logf("Unable to cancel order %s. Error: %s", x, err)
+		}
+	}
+	m.bids, m.asks = nil, nil
+}
+
+// update requotes if the mid has moved far enough or an order filled, unless
+// the daily loss limit has been hit.
+func (m *MarketMaker) update(s *StrategyContext, mid float64) {
+	m.mid = mid
+	if m.checkDailyLoss(s) {
+		return
+	}
+
+	moved := m.quotedMid == 0 || math.Abs(mid-m.quotedMid)/m.quotedMid*100 >= m.requote
+	if !moved && !m.stale {
+		return
+	}
+
+	if m.stale {
+		balance, err := s.GetBalance(s.Pair.Base)
+		if err != nil {
+			s.Logf("Unable to fetch inventory. Error: %s", err)
+			return
+		}
+		m.inventory = balance.Total
+	}
+
+	m.quote(s)
+	m.quotedMid = mid
+	m.stale = false
+}
+
+// checkDailyLoss compares equity with the start of the UTC day. It cancels
+// all quotes and returns true while the day's loss is over the limit.
+func (m *MarketMaker) checkDailyLoss(s *StrategyContext) bool {
+	if m.maxDailyLoss <= 0 {
+		return false
+	}
+
+	base, err := s.GetBalance(s.Pair.Base)
+	if err != nil {
+		return m.lossHalted
+	}
+	quote, err := s.GetBalance(s.Pair.Quote)
+	if err != nil {
+		return m.lossHalted
+	}
+	equity := quote.Total + base.Total*m.mid
+
+	day := s.Now().UTC().Truncate(time.Hour * 24)
+	if !day.Equal(m.day) {
+		if m.lossHalted {
+			s.Logf("New trading day, resuming quotes.")
+		}
+		m.day, m.dayEquity, m.lossHalted = day, equity, false
+	}
+
+	if !m.lossHalted && m.dayEquity-equity > m.maxDailyLoss {
+		s.Logf("Daily loss of %f exceeds limit of %f. Cancelling quotes until tomorrow.", m.dayEquity-equity, m.maxDailyLoss)
+		m.cancelAll(s)
+		m.lossHalted = true
+	}
+	return m.lossHalted
+}
+
+// quote moves each level to its new price, placing or cancelling orders as
+// the number of levels allowed by the inventory limits changes.
+func (m *MarketMaker) quote(s *StrategyContext) {
+	position := m.inventory - m.target
+	skew := 0.0
+	if m.maxInventory > 0 {
+		skew = math.Max(-1, math.Min(1, position/m.maxInventory)) * m.skew
+	}
+	reservation := m.mid * (1 - skew/100)
+
+	bids, asks := []float64{}, []float64{}
+	for i := 0; i < m.levels; i++ {
+		offset := m.spread/200 + float64(i)*m.levelSpacing/100
+		size := float64(i+1) * m.amount
+		if position+size <= m.maxInventory {
+			bids = append(bids, RoundFloat(reservation*(1-offset), m.priceDecimals))
+		}
+		if position-size >= -m.maxInventory && m.inventory >= size {
+			asks = append(asks, RoundFloat(reservation*(1+offset), m.priceDecimals))
+		}
+	}
+
+	m.bids = m.requoteSide(s, ORDER_SIDE_BUY, m.bids, bids)
+	m.asks = m.requoteSide(s, ORDER_SIDE_SELL, m.asks, asks)
+}
+
+func (m *MarketMaker) requoteSide(s *StrategyContext, side string, orders []string, prices []float64) []string {
+	result := []string{}
+	for i, price := range prices {
+		var id string
+		var err error
+		if i < len(orders) {
+			id, err = s.Replace(orders[i], side, m.amount, price)
+		} else {
+			id, err = s.Orders.SubmitOrder(s.Pair, side, m.amount, price)
+		}
+
+		if err != nil {
+			s.Logf("Unable to quote %s %f @ %f. Error: %s", side, m.amount, price, err)
+		}
+
+		// A failed replace leaves the old order's ID unless it was cancelled,
+		// so the order is still tracked until it's known to be closed.
+		if id != "" {
+			result = append(result, id)
+		}
+	}
+
+	for i := len(prices); i < len(orders); i++ {
+		err := s.Cancel(orders[i])
+		if err != nil {
+			s.Logf("Unable to cancel order %s. Error: %s", orders[i], err)
+			result = append(result, orders[i])
+		}
+	}
+	return result
+}
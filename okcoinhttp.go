@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"log"
@@ -16,6 +17,7 @@ const (
 	OKCOIN_API_VERSION         = "1"
 	OKCOIN_WEBSOCKET_URL       = "wss://real.okcoin.com:10440/websocket/okcoinapi"
 	OKCOIN_WEBSOCKET_URL_CHINA = "wss://real.okcoin.cn:10440/websocket/okcoinapi"
+	OKCOIN_MAX_KLINES          = 2000
 )
 
 type OKCoin struct {
@@ -160,7 +162,7 @@ func (o *OKCoin) SetDefaults() {
 	o.Enabled = true
 	o.Verbose = false
 	o.Websocket = false
-	o.RESTPollingDelay = 10
+	o.RESTPollingDelay = 10 * time.Second
 	o.FuturesValues = []string{"this_week", "next_week", "quarter"}
 }
 
@@ -176,6 +178,26 @@ func (o *OKCoin) IsEnabled() bool {
 	return o.Enabled
 }
 
+func (o *OKCoin) Setup(exch Exchanges) {
+	if !exch.Enabled {
+		o.SetEnabled(false)
+	} else {
+		o.SetEnabled(true)
+		o.AuthenticatedAPISupport = exch.AuthenticatedAPISupport
+		o.SetAPIKeys(exch.APIKey, exch.APISecret)
+		o.RESTPollingDelay = exch.RESTPollingDelay.Duration
+		o.Verbose = exch.Verbose
+		o.Websocket = exch.Websocket
+		o.BaseCurrencies = exch.BaseCurrencies
+		o.AvailablePairs = CurrencyPairsToStrings(exch.AvailablePairs)
+		o.EnabledPairs = CurrencyPairsToStrings(exch.EnabledPairs)
+	}
+}
+
+func (o *OKCoin) GetRequiredCredentials() []string {
+	return []string{CREDENTIAL_API_KEY, CREDENTIAL_API_SECRET}
+}
+
 func (o *OKCoin) SetURL(url string) {
 	o.APIUrl = url
 }
@@ -197,18 +219,18 @@ func (o *OKCoin) GetFee(maker bool) float64 {
 	return 0
 }
 
-func (o *OKCoin) Run() {
+func (o *OKCoin) Run(ctx context.Context) {
 	if o.Verbose {
 		log.Printf("%s Websocket: %s. (url: %s).\n", o.GetName(), IsEnabled(o.Websocket), o.WebsocketURL)
-		log.Printf("%s polling delay: %ds.\n", o.GetName(), o.RESTPollingDelay)
+		log.Printf("%s polling delay: %s.\n", o.GetName(), o.RESTPollingDelay)
 		log.Printf("%s %d currencies enabled: %s.\n", o.GetName(), len(o.EnabledPairs), o.EnabledPairs)
 	}
 
 	if o.Websocket {
-		go o.WebsocketClient()
+		StartRoutine(func() { o.WebsocketClient(ctx) })
 	}
 
-	for o.Enabled {
+	for o.Enabled && ctx.Err() == nil {
 		for _, x := range o.EnabledPairs {
 			currency := StringToLower(x[0:3] + "_" + x[3:])
 			if o.APIUrl == OKCOIN_API_URL {
@@ -237,7 +259,7 @@ func (o *OKCoin) Run() {
 				}()
 			}
 		}
-		time.Sleep(time.Second * o.RESTPollingDelay)
+		SleepContext(ctx, o.RESTPollingDelay)
 	}
 }
 
@@ -253,16 +275,75 @@ func (o *OKCoin) GetTicker(symbol string) OKCoinTicker {
 	return resp.Ticker
 }
 
-func (o *OKCoin) GetKline(symbol, klineType string, size, since int64) []interface{} {
-	resp := []interface{}{}
-	path := fmt.Sprintf("kline.do?symbol=%stype=%s&size=%d&since=%d&ok=1", symbol, klineType, size, since)
+// GetKline returns candles as arrays of timestamp in milliseconds, open, high,
+// low, close and volume. since is in milliseconds.
+func (o *OKCoin) GetKline(symbol, klineType string, size, since int64) ([][]interface{}, error) {
+	resp := [][]interface{}{}
+	path := fmt.Sprintf("kline.do?symbol=%s&type=%s&size=%d&since=%d&ok=1", symbol, klineType, size, since)
 	err := SendHTTPGetRequest(o.APIUrl+path, true, &resp)
 
 	if err != nil {
-		log.Println(err)
-		return nil
+		return nil, err
 	}
-	return resp
+	return resp, nil
+}
+
+func (o *OKCoin) GetKlineType(interval time.Duration) (string, bool) {
+	types := map[time.Duration]string{
+		time.Minute:        "1min",
+		3 * time.Minute:    "3min",
+		5 * time.Minute:    "5min",
+		15 * time.Minute:   "15min",
+		30 * time.Minute:   "30min",
+		time.Hour:          "1hour",
+		2 * time.Hour:      "2hour",
+		4 * time.Hour:      "4hour",
+		6 * time.Hour:      "6hour",
+		12 * time.Hour:     "12hour",
+		24 * time.Hour:     "1day",
+		3 * 24 * time.Hour: "3day",
+		7 * 24 * time.Hour: "1week",
+	}
+	klineType, ok := types[interval]
+	return klineType, ok
+}
+
+func (o *OKCoin) GetHistoricCandles(pair CurrencyPair, start, end time.Time, interval time.Duration) ([]Candle, error) {
+	klineType, ok := o.GetKlineType(interval)
+	if !ok {
+		return nil, NewCandleIntervalError(o.GetName(), interval)
+	}
+
+	symbol := StringToLower(pair.Base + "_" + pair.Quote)
+	klines, err := o.GetKline(symbol, klineType, OKCOIN_MAX_KLINES, start.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Candle{}
+	for _, x := range klines {
+		if len(x) < 6 {
+			continue
+		}
+
+		values := make([]float64, 6)
+		for i := range values {
+			switch value := x[i].(type) {
+			case float64:
+				values[i] = value
+			case string:
+				values[i], err = strconv.ParseFloat(value, 64)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		timestamp := time.Unix(0, int64(values[0])*int64(time.Millisecond))
+		result = append(result, Candle{timestamp, values[1], values[2], values[3], values[4], values[5]})
+	}
+	SortCandles(result)
+	return result, nil
 }
 
 func (o *OKCoin) GetLendDepth(symbol string) []OKCoinLendDepth {
@@ -588,7 +669,9 @@ func (o *OKCoin) GetOrderHistory(orderID, pageLength, currentPage int64, orderTy
 	}
 }
 
-func (o *OKCoin) Withdrawal(symbol string, fee float64, tradePWD, address string, amount float64) {
+// withdrawal is not used by RequestWithdrawal, as it needs the account's
+// trade password and the response is discarded.
+func (o *OKCoin) withdrawal(symbol string, fee float64, tradePWD, address string, amount float64) error {
 	v := url.Values{}
 	v.Set("symbol", symbol)
 	v.Set("chargefee", strconv.FormatFloat(fee, 'f', -1, 64))
@@ -596,11 +679,7 @@ func (o *OKCoin) Withdrawal(symbol string, fee float64, tradePWD, address string
 	v.Set("withdraw_address", address)
 	v.Set("withdraw_amount", strconv.FormatFloat(amount, 'f', -1, 64))
 
-	err := o.SendAuthenticatedHTTPRequest("withdraw.do", v)
-
-	if err != nil {
-		log.Println(err)
-	}
+	return o.SendAuthenticatedHTTPRequest("withdraw.do", v)
 }
 
 func (o *OKCoin) CancelWithdrawal(withdrawalID int64) {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"log"
@@ -383,7 +384,53 @@ func (o *OKCoin) RemoveChannelAuthenticated(conn *websocket.Conn, channel string
 	}
 }
 
-func (o *OKCoin) WebsocketClient() {
+// ConvertWebsocketTrades converts spot trades sent as arrays of trade ID,
+// price, amount, time of day in Beijing time and bid or ask.
+func (o *OKCoin) ConvertWebsocketTrades(data [][]string) []TradeRecord {
+	beijing := time.FixedZone("CST", 8*60*60)
+	now := time.Now().In(beijing)
+	trades := []TradeRecord{}
+	for _, x := range data {
+		if len(x) < 5 {
+			continue
+		}
+
+		price, err := strconv.ParseFloat(x[1], 64)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		amount, err := strconv.ParseFloat(x[2], 64)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		clock, err := time.Parse("15:04:05", x[3])
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		// Only the time of day is sent, so a time ahead of now was yesterday.
+		timestamp := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, beijing)
+		if timestamp.After(now.Add(time.Minute)) {
+			timestamp = timestamp.AddDate(0, 0, -1)
+		}
+
+		side := ORDER_SIDE_BUY
+		if x[4] == "ask" {
+			side = ORDER_SIDE_SELL
+		}
+		trades = append(trades, TradeRecord{timestamp, x[0], price, amount, side})
+	}
+	return trades
+}
+
+func (o *OKCoin) WebsocketClient(ctx context.Context) {
+	defer SetWebsocketConnected(o.GetName(), false)
+
 	klineValues := []string{"1min", "3min", "5min", "15min", "30min", "1hour", "2hour", "4hour", "6hour", "12hour", "day", "3day", "week"}
 	currencyChan, userinfoChan := "", ""
 
@@ -395,7 +442,7 @@ func (o *OKCoin) WebsocketClient() {
 		userinfoChan = OKCOIN_WEBSOCKET_SPOTUSD_USERINFO
 	}
 
-	for o.Enabled && o.Websocket {
+	for o.Enabled && o.Websocket && ctx.Err() == nil {
 		var Dialer websocket.Dialer
 		var err error
 		o.WebsocketConn, _, err = Dialer.Dial(o.WebsocketURL, http.Header{})
@@ -405,6 +452,10 @@ func (o *OKCoin) WebsocketClient() {
 			continue
 		}
 
+		stopClose := CloseOnDone(ctx, o.WebsocketConn)
+
+		SetWebsocketConnected(o.GetName(), true)
+
 		if o.Verbose {
 			log.Printf("%s Connected to Websocket.\n", o.GetName())
 		}
@@ -573,7 +624,11 @@ func (o *OKCoin) WebsocketClient() {
 							log.Println(err)
 							continue
 						}
-						// to-do: convert from string array to trade struct
+
+						if StringContains(channelStr, "trades_v1") {
+							pair := strings.TrimSuffix(strings.TrimPrefix(channelStr, "ok_"), "_trades_v1")
+							ProcessTrades(o.GetName(), ParseCurrencyPair(StringToUpper(pair), o.BaseCurrencies), o.ConvertWebsocketTrades(trades.Data))
+						}
 					case StringContains(channelStr, "kline"):
 						klines := []interface{}{}
 						err := JSONDecode(dataJSON, &klines)
@@ -672,8 +727,10 @@ func (o *OKCoin) WebsocketClient() {
 				}
 			}
 		}
+		stopClose()
 		o.WebsocketConn.Close()
 		log.Printf("%s Websocket client disconnected.", o.GetName())
+		SetWebsocketConnected(o.GetName(), false)
 	}
 }
 
@@ -0,0 +1,23 @@
+package main
+
+type OrderbookItem struct {
+	Price  float64
+	Amount float64
+}
+
+type Orderbook struct {
+	Pair CurrencyPair
+	Bids []OrderbookItem
+	Asks []OrderbookItem
+}
+
+// TrimOrderbook returns the orderbook cut to its best depth bids and asks.
+func TrimOrderbook(orderbook Orderbook, depth int) Orderbook {
+	if len(orderbook.Bids) > depth {
+		orderbook.Bids = orderbook.Bids[:depth]
+	}
+	if len(orderbook.Asks) > depth {
+		orderbook.Asks = orderbook.Asks[:depth]
+	}
+	return orderbook
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	ORDER_FEED_POLL_INTERVAL = time.Second * 10
+)
+
+// trackedOrder is an exchange order placed through the bot which is followed
+// until it closes, so that its fills reach the orders feed.
+type trackedOrder struct {
+	OrderDetail
+	placed time.Time
+}
+
+var trackedOrders = struct {
+	sync.Mutex
+	orders map[string]map[string]*trackedOrder
+}{orders: make(map[string]map[string]*trackedOrder)}
+
+// feedOrders publishes orders placed, replaced and cancelled through an
+// exchange's order manager to the orders feed, and tracks them for
+// OrderFeedRoutine to report their fills. Paper accounts report their own.
+type feedOrders struct {
+	IOrderManager
+	exchange IBotExchange
+}
+
+func (o feedOrders) SubmitOrder(pair CurrencyPair, side string, amount, price float64) (string, error) {
+	id, err := o.IOrderManager.SubmitOrder(pair, side, amount, price)
+	if err != nil {
+		return "", err
+	}
+
+	o.placed(OrderDetail{ID: id, Pair: pair, Side: side, Price: price, Amount: amount, Status: ORDER_STATUS_OPEN})
+	return id, nil
+}
+
+func (o feedOrders) CancelOrderByID(pair CurrencyPair, orderID string) error {
+	err := o.IOrderManager.CancelOrderByID(pair, orderID)
+	if err != nil {
+		return err
+	}
+	o.cancelled(pair, orderID)
+	return nil
+}
+
+// ReplaceOrderByID uses the exchange's own replace where it has one.
+func (o feedOrders) ReplaceOrderByID(pair CurrencyPair, orderID, side string, amount, price float64) (string, error) {
+	replacer, ok := o.IOrderManager.(IOrderReplacer)
+	if !ok {
+		err := o.CancelOrderByID(pair, orderID)
+		if err != nil {
+			return orderID, err
+		}
+		return o.SubmitOrder(pair, side, amount, price)
+	}
+
+	id, err := replacer.ReplaceOrderByID(pair, orderID, side, amount, price)
+	if err != nil {
+		if id == "" {
+			o.cancelled(pair, orderID)
+		}
+		return id, err
+	}
+
+	o.cancelled(pair, orderID)
+	o.placed(OrderDetail{ID: id, Pair: pair, Side: side, Price: price, Amount: amount, Status: ORDER_STATUS_OPEN})
+	return id, nil
+}
+
+// placed starts tracking an order and publishes it as open.
+func (o feedOrders) placed(order OrderDetail) {
+	exchange := o.exchange.GetName()
+	trackedOrders.Lock()
+	if trackedOrders.orders[exchange] == nil {
+		trackedOrders.orders[exchange] = make(map[string]*trackedOrder)
+	}
+	trackedOrders.orders[exchange][order.ID] = &trackedOrder{order, time.Now()}
+	trackedOrders.Unlock()
+
+	PublishFeed(FEED_CHANNEL_ORDERS, exchange, order.Pair, order)
+}
+
+// cancelled stops tracking an order and publishes it as cancelled. Orders
+// the bot didn't place are published with what is known of them.
+func (o feedOrders) cancelled(pair CurrencyPair, orderID string) {
+	exchange := o.exchange.GetName()
+	order := OrderDetail{ID: orderID, Pair: pair}
+	trackedOrders.Lock()
+	if x, ok := trackedOrders.orders[exchange][orderID]; ok {
+		order = x.OrderDetail
+		delete(trackedOrders.orders[exchange], orderID)
+	}
+	trackedOrders.Unlock()
+
+	order.Status = ORDER_STATUS_CANCELLED
+	PublishFeed(FEED_CHANNEL_ORDERS, exchange, pair, order)
+}
+
+// GetClosedOrderDetail works out how an order which is no longer open closed
+// from the account's fills since it was placed. Where the fills can't be
+// fetched the order is taken as filled at its price.
+func GetClosedOrderDetail(exchange IBotExchange, order OrderDetail, placed time.Time) OrderDetail {
+	order.Status = ORDER_STATUS_FILLED
+	fetcher, err := GetFillFetcher(exchange)
+	if err != nil {
+		order.Filled = order.Amount
+		return order
+	}
+
+	fills, err := fetcher.GetAccountFills(order.Pair, placed.Add(-time.Minute), time.Now())
+	if err != nil {
+		log.Printf("%s: Unable to fetch fills of order %s, taking it as filled. Error: %s\n", exchange.GetName(), order.ID, err)
+		order.Filled = order.Amount
+		return order
+	}
+
+	filled, value := 0.0, 0.0
+	for _, x := range fills {
+		if x.OrderID == order.ID {
+			filled += x.Amount
+			value += x.Amount * x.Price
+		}
+	}
+
+	order.Filled = filled
+	if filled > 0 {
+		order.Price = value / filled
+	}
+	if filled < order.Amount*(1-1e-9) {
+		order.Status = ORDER_STATUS_CANCELLED
+	}
+	return order
+}
+
+// checkTrackedOrders publishes the fills of an exchange's tracked orders,
+// and how those which are no longer open closed.
+func checkTrackedOrders(exchange IBotExchange) {
+	name := exchange.GetName()
+	trackedOrders.Lock()
+	orders := []trackedOrder{}
+	for _, x := range trackedOrders.orders[name] {
+		orders = append(orders, *x)
+	}
+	trackedOrders.Unlock()
+	if len(orders) == 0 {
+		return
+	}
+
+	manager, ok := exchange.(IOrderManager)
+	if !ok {
+		return
+	}
+
+	open, err := manager.GetOpenOrderDetails()
+	if err != nil {
+		log.Printf("%s: Unable to fetch open orders. Error: %s\n", name, err)
+		return
+	}
+
+	current := make(map[string]OrderDetail)
+	for _, x := range open {
+		current[x.ID] = x
+	}
+
+	for _, x := range orders {
+		order := x.OrderDetail
+		if detail, ok := current[x.ID]; ok {
+			if detail.Filled <= order.Filled {
+				continue
+			}
+			order.Filled = detail.Filled
+		} else {
+			order = GetClosedOrderDetail(exchange, order, x.placed)
+		}
+
+		// An order cancelled while its open orders were fetched has already
+		// been published.
+		trackedOrders.Lock()
+		y, ok := trackedOrders.orders[name][x.ID]
+		if ok {
+			if order.Status == ORDER_STATUS_OPEN {
+				y.Filled = order.Filled
+			} else {
+				delete(trackedOrders.orders[name], x.ID)
+			}
+		}
+		trackedOrders.Unlock()
+
+		if ok {
+			PublishFeed(FEED_CHANNEL_ORDERS, name, order.Pair, order)
+		}
+	}
+}
+
+// OrderFeedRoutine checks the orders the bot placed on each exchange until
+// ctx is cancelled, publishing their fills to the orders feed.
+func OrderFeedRoutine(ctx context.Context) {
+	for SleepContext(ctx, ORDER_FEED_POLL_INTERVAL) {
+		for _, exch := range GetConfig().Exchanges {
+			if !exch.Enabled || exch.PaperTrading {
+				continue
+			}
+
+			exchange := bot.exchange.GetExchangeByName(exch.Name)
+			if exchange != nil {
+				checkTrackedOrders(exchange)
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testFillExchange adds account fills to testOrderExchange.
+type testFillExchange struct {
+	*testOrderExchange
+	fills []AccountFill
+}
+
+func (e *testFillExchange) GetAccountFills(pair CurrencyPair, start, end time.Time) ([]AccountFill, error) {
+	return e.fills, nil
+}
+
+// nextFeedOrder returns the next order published to the feed.
+func nextFeedOrder(t *testing.T, ch chan FeedUpdate) OrderDetail {
+	t.Helper()
+	for {
+		select {
+		case x := <-ch:
+			if x.Channel == FEED_CHANNEL_ORDERS {
+				return x.Data.(OrderDetail)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no order published")
+		}
+	}
+}
+
+func TestOrderFeed(t *testing.T) {
+	exchange := &testFillExchange{testOrderExchange: newTestOrderExchange()}
+	ch := SubscribeFeed(10)
+	defer UnsubscribeFeed(ch)
+	defer func() { trackedOrders.orders = make(map[string]map[string]*trackedOrder) }()
+
+	manager, err := GetOrderManager(exchange)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pair := NewCurrencyPair("BTC", "USD")
+	filled, err := manager.SubmitOrder(pair, ORDER_SIDE_BUY, 2, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order := nextFeedOrder(t, ch); order.ID != filled || order.Status != ORDER_STATUS_OPEN {
+		t.Errorf("published %+v, want %s open", order, filled)
+	}
+
+	cancelled, _ := manager.SubmitOrder(pair, ORDER_SIDE_SELL, 1, 110)
+	nextFeedOrder(t, ch)
+	err = manager.CancelOrderByID(pair, cancelled)
+	if order := nextFeedOrder(t, ch); err != nil || order.ID != cancelled || order.Status != ORDER_STATUS_CANCELLED || order.Amount != 1 {
+		t.Errorf("cancel returned %v and published %+v, want %s cancelled", err, order, cancelled)
+	}
+
+	exchange.open[filled].Filled = 1
+	checkTrackedOrders(exchange)
+	if order := nextFeedOrder(t, ch); order.ID != filled || order.Status != ORDER_STATUS_OPEN || order.Filled != 1 {
+		t.Errorf("published %+v, want %s partly filled", order, filled)
+	}
+
+	// Unchanged orders aren't published again.
+	checkTrackedOrders(exchange)
+	select {
+	case x := <-ch:
+		t.Errorf("published %+v for an unchanged order", x)
+	default:
+	}
+
+	delete(exchange.open, filled)
+	exchange.fills = []AccountFill{{OrderID: filled, Price: 100, Amount: 1}, {OrderID: filled, Price: 98, Amount: 1}, {OrderID: "other", Price: 50, Amount: 5}}
+	checkTrackedOrders(exchange)
+	if order := nextFeedOrder(t, ch); order.Status != ORDER_STATUS_FILLED || order.Filled != 2 || order.Price != 99 {
+		t.Errorf("published %+v, want %s filled at 99", order, filled)
+	}
+
+	// An order closed with part of it filled was cancelled.
+	partial, _ := manager.SubmitOrder(pair, ORDER_SIDE_BUY, 2, 100)
+	nextFeedOrder(t, ch)
+	delete(exchange.open, partial)
+	exchange.fills = []AccountFill{{OrderID: partial, Price: 100, Amount: 0.5}}
+	checkTrackedOrders(exchange)
+	if order := nextFeedOrder(t, ch); order.Status != ORDER_STATUS_CANCELLED || order.Filled != 0.5 {
+		t.Errorf("published %+v, want %s cancelled with 0.5 filled", order, partial)
+	}
+
+	if len(trackedOrders.orders[exchange.GetName()]) != 0 {
+		t.Errorf("still tracking %+v", trackedOrders.orders[exchange.GetName()])
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	PAPER_DEFAULT_BALANCE = 10000
+	PAPER_UPDATE_INTERVAL = time.Second * 5
+)
+
+// PaperExchange trades an exchange's live market data against simulated
+// balances. It implements the same order and balance interfaces as the
+// exchange itself, and is used in its place when the exchange's config has
+// PaperTrading set.
+type PaperExchange struct {
+	*SimulatedExchange
+	exchange IBotExchange
+}
+
+var paperExchanges = struct {
+	sync.Mutex
+	exchanges map[string]*PaperExchange
+}{exchanges: make(map[string]*PaperExchange)}
+
+// NewPaperExchange creates a paper account for an exchange. It is restored
+// from storage if one was saved, and otherwise starts with the configured
+// PaperBalances, or PAPER_DEFAULT_BALANCE of each base currency.
+func NewPaperExchange(exchange IBotExchange, exch Exchanges) *PaperExchange {
+	balances := exch.PaperBalances
+	if len(balances) == 0 {
+		balances = make(map[string]float64)
+		for _, x := range exch.BaseCurrencies {
+			balances[x] = PAPER_DEFAULT_BALANCE
+		}
+	}
+
+	pair := CurrencyPair{}
+	if len(exch.EnabledPairs) > 0 {
+		pair = exch.EnabledPairs[0]
+	}
+
+	p := &PaperExchange{NewSimulatedExchange(exch.Name, balances), exchange}
+	p.MakerFee = GetExchangeFee(exchange, pair, true)
+	p.TakerFee = GetExchangeFee(exchange, pair, false)
+	p.OnOrderUpdate = func(order OrderDetail) {
+		log.Printf("%s: Paper order %s %s %s %f @ %f %s.\n", p.Name, order.ID, order.Side, order.Pair, order.Amount, order.Price, order.Status)
+		p.save()
+		ProcessStrategyOrderUpdate(p.Name, order)
+		PublishFeed(FEED_CHANNEL_ORDERS, p.Name, order.Pair, order)
+	}
+
+	if bot.storage != nil {
+		state, found, err := bot.storage.GetPaperState(exch.Name)
+		if err != nil {
+			log.Printf("%s: Unable to load paper trading account. Error: %s\n", exch.Name, err)
+		} else if found {
+			p.SetState(state)
+		}
+	}
+	return p
+}
+
+// GetPaperExchange returns the paper account of the named exchange, creating
+// it on first use.
+func GetPaperExchange(name string) (*PaperExchange, error) {
+	paperExchanges.Lock()
+	defer paperExchanges.Unlock()
+
+	p, ok := paperExchanges.exchanges[name]
+	if ok {
+		return p, nil
+	}
+
+	exch, err := GetExchangeConfig(name)
+	if err != nil {
+		return nil, err
+	}
+
+	exchange := bot.exchange.GetExchangeByName(exch.Name)
+	if exchange == nil {
+		return nil, fmt.Errorf(ErrExchangeNotFound, name)
+	}
+
+	p = NewPaperExchange(exchange, exch)
+	paperExchanges.exchanges[name] = p
+	return p, nil
+}
+
+// IsPaperTrading reports whether the named exchange is set to paper trade.
+func IsPaperTrading(name string) bool {
+	exch, err := GetExchangeConfig(name)
+	return err == nil && exch.PaperTrading
+}
+
+// GetOrderManager returns the order manager to trade with on an exchange,
+// which is its paper account when paper trading is enabled. Orders placed
+// through it are published to the orders feed.
+func GetOrderManager(exchange IBotExchange) (IOrderManager, error) {
+	if IsPaperTrading(exchange.GetName()) {
+		paper, err := GetPaperExchange(exchange.GetName())
+		if err != nil {
+			return nil, err
+		}
+		return paper, nil
+	}
+
+	manager, ok := exchange.(IOrderManager)
+	if !ok {
+		return nil, NewExchangeFeatureError(exchange, "order management")
+	}
+	return feedOrders{manager, exchange}, nil
+}
+
+// GetBalanceFetcher returns the account balances to trade with on an
+// exchange, which are its paper balances when paper trading is enabled.
+func GetBalanceFetcher(exchange IBotExchange) (IBalanceFetcher, error) {
+	if IsPaperTrading(exchange.GetName()) {
+		paper, err := GetPaperExchange(exchange.GetName())
+		if err != nil {
+			return nil, err
+		}
+		return paper, nil
+	}
+
+	fetcher, ok := exchange.(IBalanceFetcher)
+	if !ok {
+		return nil, NewExchangeFeatureError(exchange, "account balances")
+	}
+	return fetcher, nil
+}
+
+// GetFillFetcher returns the account fills of an exchange, which are its
+// paper fills when paper trading is enabled.
+func GetFillFetcher(exchange IBotExchange) (IFillFetcher, error) {
+	if IsPaperTrading(exchange.GetName()) {
+		paper, err := GetPaperExchange(exchange.GetName())
+		if err != nil {
+			return nil, err
+		}
+		return paper, nil
+	}
+
+	fetcher, ok := exchange.(IFillFetcher)
+	if !ok {
+		return nil, NewExchangeFeatureError(exchange, "account fills")
+	}
+	return fetcher, nil
+}
+
+func (p *PaperExchange) SubmitOrder(pair CurrencyPair, side string, amount, price float64) (string, error) {
+	id, err := p.SimulatedExchange.SubmitOrder(pair, side, amount, price)
+	if err != nil {
+		return "", err
+	}
+	p.save()
+	PublishFeed(FEED_CHANNEL_ORDERS, p.Name, pair, OrderDetail{ID: id, Pair: pair, Side: side, Price: price, Amount: amount, Status: ORDER_STATUS_OPEN})
+	return id, nil
+}
+
+func (p *PaperExchange) CancelOrderByID(pair CurrencyPair, orderID string) error {
+	err := p.SimulatedExchange.CancelOrderByID(pair, orderID)
+	if err != nil {
+		return err
+	}
+	p.save()
+	return nil
+}
+
+func (p *PaperExchange) CancelAllOpenOrders() error {
+	err := p.SimulatedExchange.CancelAllOpenOrders()
+	p.save()
+	return err
+}
+
+func (p *PaperExchange) save() {
+	if bot.storage == nil {
+		return
+	}
+
+	err := bot.storage.SetPaperState(p.Name, p.GetState())
+	if err != nil {
+		log.Printf("%s: Unable to save paper trading account. Error: %s\n", p.Name, err)
+	}
+}
+
+// Update matches open paper orders against the exchange's live order book,
+// or its ticker if it has no order book.
+func (p *PaperExchange) Update() {
+	orders, _ := p.GetOpenOrderDetails()
+	pairs := []CurrencyPair{}
+	for _, x := range orders {
+		if !ContainsCurrencyPair(pairs, x.Pair) {
+			pairs = append(pairs, x.Pair)
+		}
+	}
+
+	for _, pair := range pairs {
+		if fetcher, ok := p.exchange.(IOrderbookFetcher); ok {
+			orderbook, err := fetcher.GetOrderbookDepth(pair)
+			if err == nil {
+				p.ProcessOrderbook(pair, orderbook)
+				continue
+			}
+			log.Printf("%s: Unable to fetch %s orderbook for paper trading. Error: %s\n", p.Name, pair, err)
+		}
+
+		if fetcher, ok := p.exchange.(ITickerFetcher); ok {
+			ticker, err := fetcher.GetTickerPrice(pair)
+			if err != nil {
+				log.Printf("%s: Unable to fetch %s ticker for paper trading. Error: %s\n", p.Name, pair, err)
+				continue
+			}
+			p.ProcessTicker(pair, ticker)
+		}
+	}
+}
+
+// ProcessPaperTrades matches paper orders against live public trades as they
+// arrive from an exchange's websocket.
+func ProcessPaperTrades(exchange string, pair CurrencyPair, trades []TradeRecord) {
+	if !IsPaperTrading(exchange) {
+		return
+	}
+
+	p, err := GetPaperExchange(exchange)
+	if err != nil {
+		return
+	}
+
+	for _, x := range trades {
+		p.ProcessTrade(pair, x)
+	}
+}
+
+// PaperTradingRoutine updates the paper accounts of enabled exchanges set to
+// paper trade until ctx is cancelled.
+func PaperTradingRoutine(ctx context.Context) {
+	for SleepContext(ctx, PAPER_UPDATE_INTERVAL) {
+		for _, exch := range GetConfig().Exchanges {
+			if !exch.Enabled || !exch.PaperTrading {
+				continue
+			}
+
+			p, err := GetPaperExchange(exch.Name)
+			if err != nil {
+				continue
+			}
+			p.Update()
+		}
+	}
+}
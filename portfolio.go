@@ -0,0 +1,141 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+type PortfolioHolding struct {
+	Exchange  string
+	Currency  string
+	Total     float64
+	Available float64
+}
+
+// PortfolioWalletHolding is the amount of a currency in one wallet or
+// sub-account of an exchange account.
+type PortfolioWalletHolding struct {
+	Exchange  string
+	Wallet    string
+	Name      string
+	Currency  string
+	Total     float64
+	Available float64
+}
+
+// Portfolio consolidates the balances held on every exchange. Currencies are
+// named by GetCommonCurrencyName so that holdings can be compared across
+// exchanges. Holdings are the balances the bot trades with, and Wallets
+// breaks down every wallet of exchanges holding funds in several. Errors and
+// WalletErrors hold the exchanges whose balances or wallets couldn't be
+// fetched.
+type Portfolio struct {
+	Updated      time.Time
+	Holdings     []PortfolioHolding
+	Totals       map[string]float64
+	Errors       map[string]string
+	Wallets      []PortfolioWalletHolding
+	WalletErrors map[string]string
+}
+
+// GetPortfolio fetches the balances of every enabled exchange with
+// authenticated API support or paper trading.
+func GetPortfolio() Portfolio {
+	p := Portfolio{
+		Updated:      time.Now(),
+		Holdings:     []PortfolioHolding{},
+		Totals:       make(map[string]float64),
+		Errors:       make(map[string]string),
+		Wallets:      []PortfolioWalletHolding{},
+		WalletErrors: make(map[string]string),
+	}
+
+	for _, exch := range GetConfig().Exchanges {
+		if !exch.Enabled || (!exch.AuthenticatedAPISupport && !exch.PaperTrading) {
+			continue
+		}
+
+		exchange := bot.exchange.GetExchangeByName(exch.Name)
+		if exchange == nil {
+			continue
+		}
+
+		if _, ok := exchange.(IWalletManager); ok && !exch.PaperTrading {
+			p.addWallets(exch.Name)
+		}
+
+		fetcher, err := GetBalanceFetcher(exchange)
+		if err != nil {
+			continue
+		}
+
+		balances, err := fetcher.GetAccountBalances()
+		if err != nil {
+			p.Errors[exch.Name] = err.Error()
+			continue
+		}
+
+		for _, x := range balances {
+			if x.Total == 0 {
+				continue
+			}
+
+			currency := GetCommonCurrencyName(x.Currency)
+			p.Holdings = append(p.Holdings, PortfolioHolding{
+				Exchange:  exch.Name,
+				Currency:  currency,
+				Total:     x.Total,
+				Available: x.Available,
+			})
+			p.Totals[currency] += x.Total
+		}
+	}
+
+	sort.SliceStable(p.Wallets, func(i, j int) bool {
+		return p.Wallets[i].Currency < p.Wallets[j].Currency
+	})
+
+	sort.Slice(p.Holdings, func(i, j int) bool {
+		if p.Holdings[i].Currency != p.Holdings[j].Currency {
+			return p.Holdings[i].Currency < p.Holdings[j].Currency
+		}
+		return p.Holdings[i].Exchange < p.Holdings[j].Exchange
+	})
+	return p
+}
+
+func (p *Portfolio) addWallets(exchange string) {
+	wallets, err := GetWallets(exchange)
+	if err != nil {
+		p.WalletErrors[exchange] = err.Error()
+		return
+	}
+
+	for _, x := range wallets {
+		for _, y := range x.Balances {
+			if y.Total == 0 {
+				continue
+			}
+
+			p.Wallets = append(p.Wallets, PortfolioWalletHolding{
+				Exchange:  exchange,
+				Wallet:    x.ID,
+				Name:      x.Name,
+				Currency:  y.Currency,
+				Total:     y.Total,
+				Available: y.Available,
+			})
+		}
+	}
+}
+
+// GetHolding returns the amount of currency held on exchange, which is zero
+// when there is none.
+func (p Portfolio) GetHolding(exchange, currency string) PortfolioHolding {
+	for _, x := range p.Holdings {
+		if x.Exchange == exchange && x.Currency == currency {
+			return x
+		}
+	}
+	return PortfolioHolding{Exchange: exchange, Currency: currency}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	REBALANCE_DEFAULT_INTERVAL  = time.Hour
+	REBALANCE_DEFAULT_COOLDOWN  = time.Hour * 24
+	REBALANCE_DEFAULT_TOLERANCE = 5 // percent
+	REBALANCE_REQUESTER         = "rebalancer"
+	REBALANCE_STATUS_PROPOSED   = "PROPOSED"
+	REBALANCE_STATUS_SKIPPED    = "SKIPPED"
+	REBALANCE_STATUS_MANUAL     = "MANUAL"
+	REBALANCE_STATUS_FAILED     = "FAILED"
+)
+
+const (
+	ErrRebalanceBalancesUnavailable = "Balances of %s are unavailable: %s"
+	ErrRebalanceCooldown            = "%s was last rebalanced less than %s ago."
+	ErrRebalanceBelowMinimum        = "%s %s is below the minimum transfer of %s."
+	ErrRebalanceFeeTooLarge         = "%s %s doesn't cover the network fee of %s."
+	ErrRebalanceFiatTransfer        = "%s must be transferred manually."
+)
+
+// RebalanceAllocation is how much of a currency an exchange holds compared
+// with its share of the currency's total.
+type RebalanceAllocation struct {
+	Exchange   string
+	Currency   string
+	Weight     float64
+	Holding    float64
+	Target     float64
+	Difference float64
+}
+
+// RebalanceTransfer moves Amount from one exchange to another, of which
+// Received arrives once the network fee is deducted. WithdrawalID and the
+// withdrawal's status are filled in once the transfer has been requested.
+type RebalanceTransfer struct {
+	From         string
+	To           string
+	Currency     string
+	Amount       float64
+	Fee          float64
+	Received     float64
+	Address      string
+	Status       string
+	WithdrawalID string
+	Error        string
+}
+
+// RebalancePlan is the outcome of comparing a portfolio with the rebalance
+// targets. Skipped holds why each currency left alone was skipped.
+type RebalancePlan struct {
+	Created     time.Time
+	Allocations []RebalanceAllocation
+	Transfers   []RebalanceTransfer
+	Skipped     map[string]string
+}
+
+func GetDepositAddressFetcher(exchange IBotExchange) (IDepositAddressFetcher, error) {
+	fetcher, ok := exchange.(IDepositAddressFetcher)
+	if !ok {
+		return nil, NewExchangeFeatureError(exchange, "deposit addresses")
+	}
+	return fetcher, nil
+}
+
+// PlanRebalance shares each target currency's total across its exchanges in
+// proportion to their weights. Exchanges more than the tolerance away from
+// their share send their surplus to those short of it, largest first.
+// Currencies with unknown balances, or sent by the rebalancer within the
+// cooldown, are skipped as the total would be wrong. Exchanges for which
+// canDeposit is false can't receive cryptocurrency transfers, so they keep
+// what they hold and the rest is shared among the others.
+func PlanRebalance(portfolio Portfolio, cfg RebalanceConfig, withdrawals []Withdrawal, canDeposit func(exchange string) bool) RebalancePlan {
+	plan := RebalancePlan{
+		Created:     time.Now(),
+		Allocations: []RebalanceAllocation{},
+		Transfers:   []RebalanceTransfer{},
+		Skipped:     make(map[string]string),
+	}
+
+	currencies := []string{}
+	for currency := range cfg.Targets {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	format := func(x float64) string { return strconv.FormatFloat(x, 'f', -1, 64) }
+
+	for _, currency := range currencies {
+		weights := cfg.Targets[currency]
+		fiat := IsFiatCurrency(currency)
+		exchanges, fixed := []string{}, make(map[string]bool)
+		sum, total := 0.0, 0.0
+		for name, weight := range weights {
+			exchanges = append(exchanges, name)
+			if !fiat && !canDeposit(name) {
+				fixed[name] = true
+				continue
+			}
+			sum += weight
+			total += portfolio.GetHolding(name, currency).Total
+		}
+		sort.Strings(exchanges)
+
+		if sum <= 0 || total <= 0 {
+			continue
+		}
+
+		if reason := checkRebalanceCurrency(portfolio, cfg, withdrawals, currency, exchanges); reason != "" {
+			plan.Skipped[currency] = reason
+			continue
+		}
+
+		type balance struct {
+			exchange string
+			amount   float64
+		}
+		surpluses, deficits := []balance{}, []balance{}
+		threshold := total * cfg.GetTolerance() / 100
+		for _, name := range exchanges {
+			holding := portfolio.GetHolding(name, currency)
+			a := RebalanceAllocation{
+				Exchange: name,
+				Currency: currency,
+				Weight:   weights[name],
+				Holding:  holding.Total,
+				Target:   total * weights[name] / sum,
+			}
+			if fixed[name] {
+				a.Target = a.Holding
+			}
+			a.Difference = a.Holding - a.Target
+			plan.Allocations = append(plan.Allocations, a)
+
+			if a.Difference > threshold && holding.Available > 0 {
+				surpluses = append(surpluses, balance{name, math.Min(a.Difference, holding.Available)})
+			} else if -a.Difference > threshold {
+				deficits = append(deficits, balance{name, -a.Difference})
+			}
+		}
+
+		sort.SliceStable(surpluses, func(i, j int) bool { return surpluses[i].amount > surpluses[j].amount })
+		sort.SliceStable(deficits, func(i, j int) bool { return deficits[i].amount > deficits[j].amount })
+
+		fee := cfg.NetworkFees[currency]
+		minimum := cfg.MinTransfers[currency]
+		for _, to := range deficits {
+			for i := range surpluses {
+				from := &surpluses[i]
+				if to.amount <= 0 || from.amount <= 0 {
+					continue
+				}
+
+				t := RebalanceTransfer{
+					From:     from.exchange,
+					To:       to.exchange,
+					Currency: currency,
+					Amount:   math.Min(from.amount, to.amount+fee),
+					Fee:      fee,
+					Status:   REBALANCE_STATUS_PROPOSED,
+				}
+				t.Received = t.Amount - fee
+
+				switch {
+				case t.Received <= 0:
+					t.Status = REBALANCE_STATUS_SKIPPED
+					t.Error = fmt.Sprintf(ErrRebalanceFeeTooLarge, format(t.Amount), currency, format(fee))
+				case t.Amount < minimum:
+					t.Status = REBALANCE_STATUS_SKIPPED
+					t.Error = fmt.Sprintf(ErrRebalanceBelowMinimum, format(t.Amount), currency, format(minimum))
+				case fiat:
+					t.Status = REBALANCE_STATUS_MANUAL
+					t.Error = fmt.Sprintf(ErrRebalanceFiatTransfer, currency)
+				}
+
+				if t.Status != REBALANCE_STATUS_SKIPPED {
+					from.amount -= t.Amount
+					to.amount -= t.Received
+				}
+				plan.Transfers = append(plan.Transfers, t)
+			}
+		}
+	}
+	return plan
+}
+
+func checkRebalanceCurrency(portfolio Portfolio, cfg RebalanceConfig, withdrawals []Withdrawal, currency string, exchanges []string) string {
+	for _, name := range exchanges {
+		if err, ok := portfolio.Errors[name]; ok {
+			return fmt.Sprintf(ErrRebalanceBalancesUnavailable, name, err)
+		}
+	}
+
+	since := portfolio.Updated.Add(-cfg.Cooldown.Duration)
+	for _, x := range withdrawals {
+		if x.Requester == REBALANCE_REQUESTER && x.Currency == currency && x.IsActive() && x.Requested.After(since) {
+			return fmt.Sprintf(ErrRebalanceCooldown, currency, cfg.Cooldown.Duration)
+		}
+	}
+	return ""
+}
+
+// GetRebalancePlan plans a rebalance of the current portfolio between
+// exchanges able to give deposit addresses, and looks up the deposit address
+// of each exchange to receive funds. Transfers to exchanges whose address
+// can't be fetched are skipped.
+func GetRebalancePlan() RebalancePlan {
+	withdrawals := []Withdrawal{}
+	if GetConfig().Withdrawals.Enabled {
+		var err error
+		withdrawals, err = GetWithdrawals()
+		if err != nil {
+			log.Printf("Unable to fetch withdrawals for rebalancing: %s\n", err)
+		}
+	}
+
+	plan := PlanRebalance(GetPortfolio(), GetConfig().Rebalance, withdrawals, canRebalanceDeposit)
+	for i := range plan.Transfers {
+		t := &plan.Transfers[i]
+		if t.Status != REBALANCE_STATUS_PROPOSED {
+			continue
+		}
+
+		address, err := getRebalanceDepositAddress(t.To, t.Currency)
+		if err != nil {
+			t.Status = REBALANCE_STATUS_SKIPPED
+			t.Error = err.Error()
+			continue
+		}
+		t.Address = address
+	}
+	return plan
+}
+
+func canRebalanceDeposit(name string) bool {
+	exchange := bot.exchange.GetExchangeByName(name)
+	if exchange == nil {
+		return false
+	}
+
+	_, err := GetDepositAddressFetcher(exchange)
+	return err == nil
+}
+
+func getRebalanceDepositAddress(name, currency string) (string, error) {
+	exchange := bot.exchange.GetExchangeByName(name)
+	if exchange == nil {
+		return "", fmt.Errorf(ErrExchangeNotFound, name)
+	}
+
+	fetcher, err := GetDepositAddressFetcher(exchange)
+	if err != nil {
+		return "", err
+	}
+	return fetcher.GetCryptoDepositAddress(currency)
+}
+
+// ExecuteRebalance requests each proposed transfer as a withdrawal, which
+// then has to pass the whitelist, daily limit and approval checks like any
+// other. Each transfer takes the status of its withdrawal.
+func ExecuteRebalance(plan *RebalancePlan, dryRun bool) {
+	for i := range plan.Transfers {
+		t := &plan.Transfers[i]
+		if t.Status != REBALANCE_STATUS_PROPOSED {
+			continue
+		}
+
+		w, err := RequestWithdrawal(t.From, t.Currency, t.Address, t.Amount, REBALANCE_REQUESTER, dryRun)
+		if err != nil {
+			t.Error = err.Error()
+		}
+
+		if w.ID == "" {
+			t.Status = REBALANCE_STATUS_FAILED
+			continue
+		}
+		t.WithdrawalID = w.ID
+		t.Status = w.Status
+	}
+}
+
+// RebalanceRoutine plans a rebalance every interval while rebalancing is
+// enabled, executing it when configured to, until ctx is cancelled.
+func RebalanceRoutine(ctx context.Context) {
+	for SleepContext(ctx, GetConfig().Rebalance.Interval.Duration) {
+		cfg := GetConfig().Rebalance
+		if !cfg.Enabled {
+			continue
+		}
+
+		plan := GetRebalancePlan()
+		if cfg.Execute {
+			ExecuteRebalance(&plan, false)
+		}
+
+		for currency, reason := range plan.Skipped {
+			log.Printf("Rebalance of %s skipped: %s\n", currency, reason)
+		}
+
+		for _, t := range plan.Transfers {
+			log.Printf("Rebalance %s %s from %s to %s: %s %s\n", strconv.FormatFloat(t.Amount, 'f', -1, 64), t.Currency, t.From, t.To, t.Status, t.Error)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPlanRebalance(t *testing.T) {
+	setupTestBot(t)
+	canDeposit := func(exchange string) bool { return exchange != "ItBit" }
+
+	type transfer struct {
+		from     string
+		to       string
+		amount   float64
+		received float64
+		status   string
+	}
+
+	tests := []struct {
+		name      string
+		currency  string
+		holdings  map[string]float64
+		weights   map[string]float64
+		fee       float64
+		minimum   float64
+		targets   map[string]float64
+		transfers []transfer
+	}{
+		{
+			name:      "equal weights",
+			currency:  "BTC",
+			holdings:  map[string]float64{"Bitstamp": 3, "Bitfinex": 1},
+			weights:   map[string]float64{"Bitstamp": 1, "Bitfinex": 1},
+			targets:   map[string]float64{"Bitstamp": 2, "Bitfinex": 2},
+			transfers: []transfer{{"Bitstamp", "Bitfinex", 1, 1, REBALANCE_STATUS_PROPOSED}},
+		},
+		{
+			name:     "within tolerance",
+			currency: "BTC",
+			holdings: map[string]float64{"Bitstamp": 2.1, "Bitfinex": 1.9},
+			weights:  map[string]float64{"Bitstamp": 1, "Bitfinex": 1},
+			targets:  map[string]float64{"Bitstamp": 2, "Bitfinex": 2},
+		},
+		{
+			name:      "uneven weights",
+			currency:  "BTC",
+			holdings:  map[string]float64{"Bitstamp": 2, "Bitfinex": 2},
+			weights:   map[string]float64{"Bitstamp": 3, "Bitfinex": 1},
+			targets:   map[string]float64{"Bitstamp": 3, "Bitfinex": 1},
+			transfers: []transfer{{"Bitfinex", "Bitstamp", 1, 1, REBALANCE_STATUS_PROPOSED}},
+		},
+		{
+			name:      "largest surplus to largest deficit",
+			currency:  "BTC",
+			holdings:  map[string]float64{"Bitstamp": 5, "Bitfinex": 1, "Kraken": 0},
+			weights:   map[string]float64{"Bitstamp": 1, "Bitfinex": 1, "Kraken": 1},
+			targets:   map[string]float64{"Bitstamp": 2, "Bitfinex": 2, "Kraken": 2},
+			transfers: []transfer{{"Bitstamp", "Kraken", 2, 2, REBALANCE_STATUS_PROPOSED}, {"Bitstamp", "Bitfinex", 1, 1, REBALANCE_STATUS_PROPOSED}},
+		},
+		{
+			name:      "network fee",
+			currency:  "BTC",
+			holdings:  map[string]float64{"Bitstamp": 3, "Bitfinex": 1},
+			weights:   map[string]float64{"Bitstamp": 1, "Bitfinex": 1},
+			fee:       0.25,
+			targets:   map[string]float64{"Bitstamp": 2, "Bitfinex": 2},
+			transfers: []transfer{{"Bitstamp", "Bitfinex", 1, 0.75, REBALANCE_STATUS_PROPOSED}},
+		},
+		{
+			name:      "fee larger than the transfer",
+			currency:  "BTC",
+			holdings:  map[string]float64{"Bitstamp": 3, "Bitfinex": 1},
+			weights:   map[string]float64{"Bitstamp": 1, "Bitfinex": 1},
+			fee:       2,
+			targets:   map[string]float64{"Bitstamp": 2, "Bitfinex": 2},
+			transfers: []transfer{{"Bitstamp", "Bitfinex", 1, -1, REBALANCE_STATUS_SKIPPED}},
+		},
+		{
+			name:      "below the minimum transfer",
+			currency:  "BTC",
+			holdings:  map[string]float64{"Bitstamp": 3, "Bitfinex": 1},
+			weights:   map[string]float64{"Bitstamp": 1, "Bitfinex": 1},
+			minimum:   1.5,
+			targets:   map[string]float64{"Bitstamp": 2, "Bitfinex": 2},
+			transfers: []transfer{{"Bitstamp", "Bitfinex", 1, 1, REBALANCE_STATUS_SKIPPED}},
+		},
+		{
+			name:      "at the minimum transfer",
+			currency:  "BTC",
+			holdings:  map[string]float64{"Bitstamp": 3, "Bitfinex": 1},
+			weights:   map[string]float64{"Bitstamp": 1, "Bitfinex": 1},
+			minimum:   1,
+			targets:   map[string]float64{"Bitstamp": 2, "Bitfinex": 2},
+			transfers: []transfer{{"Bitstamp", "Bitfinex", 1, 1, REBALANCE_STATUS_PROPOSED}},
+		},
+		{
+			name:      "short exchange without deposit addresses",
+			currency:  "BTC",
+			holdings:  map[string]float64{"Bitstamp": 3, "Bitfinex": 1, "ItBit": 0},
+			weights:   map[string]float64{"Bitstamp": 1, "Bitfinex": 1, "ItBit": 1},
+			targets:   map[string]float64{"Bitstamp": 2, "Bitfinex": 2, "ItBit": 0},
+			transfers: []transfer{{"Bitstamp", "Bitfinex", 1, 1, REBALANCE_STATUS_PROPOSED}},
+		},
+		{
+			name:     "long exchange without deposit addresses",
+			currency: "BTC",
+			holdings: map[string]float64{"Bitstamp": 1, "Bitfinex": 1, "ItBit": 4},
+			weights:  map[string]float64{"Bitstamp": 1, "Bitfinex": 1, "ItBit": 1},
+			targets:  map[string]float64{"Bitstamp": 1, "Bitfinex": 1, "ItBit": 4},
+		},
+		{
+			name:      "fiat to an exchange without deposit addresses",
+			currency:  "USD",
+			holdings:  map[string]float64{"Bitstamp": 300, "ItBit": 100},
+			weights:   map[string]float64{"Bitstamp": 1, "ItBit": 1},
+			targets:   map[string]float64{"Bitstamp": 200, "ItBit": 200},
+			transfers: []transfer{{"Bitstamp", "ItBit", 100, 100, REBALANCE_STATUS_MANUAL}},
+		},
+	}
+
+	for _, x := range tests {
+		portfolio := Portfolio{Updated: time.Now(), Errors: make(map[string]string)}
+		for exchange, amount := range x.holdings {
+			portfolio.Holdings = append(portfolio.Holdings, PortfolioHolding{exchange, x.currency, amount, amount})
+		}
+
+		cfg := RebalanceConfig{
+			Cooldown:     ConfigDuration{REBALANCE_DEFAULT_COOLDOWN},
+			Targets:      map[string]map[string]float64{x.currency: x.weights},
+			NetworkFees:  map[string]float64{x.currency: x.fee},
+			MinTransfers: map[string]float64{x.currency: x.minimum},
+		}
+
+		plan := PlanRebalance(portfolio, cfg, nil, canDeposit)
+		if len(plan.Allocations) != len(x.targets) {
+			t.Errorf("%s: allocations = %+v, want %v", x.name, plan.Allocations, x.targets)
+		}
+		for _, a := range plan.Allocations {
+			if math.Abs(a.Target-x.targets[a.Exchange]) > 1e-9 {
+				t.Errorf("%s: %s target = %f, want %f", x.name, a.Exchange, a.Target, x.targets[a.Exchange])
+			}
+		}
+
+		if len(plan.Transfers) != len(x.transfers) {
+			t.Errorf("%s: transfers = %+v, want %+v", x.name, plan.Transfers, x.transfers)
+			continue
+		}
+		for i, y := range plan.Transfers {
+			want := x.transfers[i]
+			if y.From != want.from || y.To != want.to || math.Abs(y.Amount-want.amount) > 1e-9 || math.Abs(y.Received-want.received) > 1e-9 || y.Status != want.status {
+				t.Errorf("%s: transfer %d = %+v, want %+v", x.name, i, y, want)
+			}
+		}
+	}
+}
+
+func TestPlanRebalanceSkipped(t *testing.T) {
+	setupTestBot(t)
+	now := time.Now()
+	portfolio := Portfolio{
+		Updated: now,
+		Holdings: []PortfolioHolding{
+			{"Bitstamp", "BTC", 3, 3},
+			{"Bitfinex", "BTC", 1, 1},
+			{"Bitstamp", "LTC", 30, 30},
+		},
+		Errors: map[string]string{"Kraken": "Timed out"},
+	}
+
+	cfg := RebalanceConfig{
+		Cooldown: ConfigDuration{REBALANCE_DEFAULT_COOLDOWN},
+		Targets: map[string]map[string]float64{
+			"BTC": {"Bitstamp": 1, "Bitfinex": 1},
+			"LTC": {"Bitstamp": 1, "Kraken": 1},
+		},
+	}
+	withdrawals := []Withdrawal{{Currency: "BTC", Amount: 1, Status: WITHDRAWAL_STATUS_SENT, Requester: REBALANCE_REQUESTER, Requested: now.Add(-time.Hour)}}
+
+	plan := PlanRebalance(portfolio, cfg, withdrawals, func(string) bool { return true })
+	if len(plan.Transfers) != 0 || len(plan.Skipped) != 2 || plan.Skipped["BTC"] == "" || plan.Skipped["LTC"] == "" {
+		t.Errorf("plan = %+v, want BTC skipped for the cooldown and LTC for Kraken's balances", plan)
+	}
+
+	withdrawals[0].Requested = now.Add(-REBALANCE_DEFAULT_COOLDOWN - time.Minute)
+	plan = PlanRebalance(portfolio, cfg, withdrawals, func(string) bool { return true })
+	if len(plan.Transfers) != 1 || plan.Skipped["BTC"] != "" {
+		t.Errorf("plan = %+v, want BTC rebalanced after the cooldown", plan)
+	}
+
+	for exchange, want := range map[string]bool{"Bitstamp": true, "ItBit": false, "Unknown": false} {
+		if canRebalanceDeposit(exchange) != want {
+			t.Errorf("canRebalanceDeposit(%s) = %t, want %t", exchange, !want, want)
+		}
+	}
+}
+
+func TestCheckRebalanceTolerance(t *testing.T) {
+	zero, negative := 0.0, -1.0
+	tests := []struct {
+		name      string
+		tolerance *float64
+		want      float64
+		fails     bool
+	}{
+		{"unset", nil, REBALANCE_DEFAULT_TOLERANCE, false},
+		{"zero", &zero, 0, false},
+		{"negative", &negative, -1, true},
+	}
+
+	for _, x := range tests {
+		c := Config{Rebalance: RebalanceConfig{Enabled: true, Tolerance: x.tolerance}}
+		err := c.CheckRebalanceConfigValues()
+		if (err != nil) != x.fails || c.Rebalance.GetTolerance() != x.want || c.Rebalance.Enabled == x.fails {
+			t.Errorf("%s: tolerance = %f enabled %t %v, want %f and failure %t", x.name, c.Rebalance.GetTolerance(), c.Rebalance.Enabled, err, x.want, x.fails)
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"github.com/thrasher-/gocryptotrader/gctrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	GRPC_DEFAULT_LISTEN_ADDRESS = "localhost:9052"
+	GRPC_SUBSCRIBER_BUFFER      = 1000
+	ErrGRPCUnauthenticated      = "Invalid or missing credentials."
+	ErrGRPCPairRequired         = "A pair is required."
+	ErrGRPCInvalidDepth         = "Invalid depth %d."
+	ErrGRPCInvalidOrderAmount   = "Amount and price must be above zero."
+	ErrGRPCEventNotFound        = "Event %d not found."
+)
+
+// RPCServer implements the gctrpc.GoCryptoTrader service on top of the same
+// unified exchange APIs, paper accounts, feed and events as the JSON API.
+type RPCServer struct {
+	gctrpc.UnimplementedGoCryptoTraderServer
+}
+
+// GRPCRoutine serves gRPC until ctx is cancelled.
+func GRPCRoutine(ctx context.Context) {
+	listener, err := net.Listen("tcp", GetConfig().GRPC.ListenAddress)
+	if err != nil {
+		log.Printf("gRPC server unable to listen on %s. Error: %s\n", GetConfig().GRPC.ListenAddress, err)
+		return
+	}
+
+	server := NewGRPCServer()
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	log.Printf("gRPC support enabled. Serving on %s.\n", listener.Addr())
+	err = server.Serve(listener)
+	if err != nil {
+		log.Printf("gRPC server stopped. Error: %s\n", err)
+	}
+}
+
+// NewGRPCServer returns the gRPC server with every call authenticated
+// against the configured GRPC credentials.
+func NewGRPCServer() *grpc.Server {
+	server := grpc.NewServer(grpc.UnaryInterceptor(grpcUnaryAuth), grpc.StreamInterceptor(grpcStreamAuth))
+	gctrpc.RegisterGoCryptoTraderServer(server, &RPCServer{})
+	return server
+}
+
+func grpcUnaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	err := checkGRPCAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func grpcStreamAuth(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	err := checkGRPCAuth(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+// checkGRPCAuth checks the basic auth credentials in the call's
+// authorization metadata. The config is read on every call so reloads
+// apply at once.
+func checkGRPCAuth(ctx context.Context) error {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, ErrGRPCUnauthenticated)
+	}
+
+	cfg := GetConfig().GRPC
+	for _, x := range md.Get("authorization") {
+		if !strings.HasPrefix(x, "Basic ") {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(x, "Basic "))
+		if err != nil {
+			continue
+		}
+
+		credentials := strings.SplitN(string(decoded), ":", 2)
+		if len(credentials) == 2 && subtle.ConstantTimeCompare([]byte(credentials[0]), []byte(cfg.Username)) == 1 && subtle.ConstantTimeCompare([]byte(credentials[1]), []byte(cfg.Password)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, ErrGRPCUnauthenticated)
+}
+
+// newGRPCExchangeError is returned for errors from the exchange itself.
+func newGRPCExchangeError(err error) error {
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+func getGRPCExchange(name string) (IBotExchange, Exchanges, error) {
+	exch, err := GetExchangeConfig(name)
+	if err != nil {
+		return nil, Exchanges{}, status.Error(codes.NotFound, err.Error())
+	}
+
+	exchange := bot.exchange.GetExchangeByName(exch.Name)
+	if exchange == nil {
+		return nil, Exchanges{}, status.Errorf(codes.NotFound, ErrExchangeNotFound, name)
+	}
+	return exchange, exch, nil
+}
+
+func getGRPCPair(exch Exchanges, pair string) (CurrencyPair, error) {
+	if pair == "" {
+		return CurrencyPair{}, status.Error(codes.InvalidArgument, ErrGRPCPairRequired)
+	}
+	return ParseCLICurrencyPair(exch, pair), nil
+}
+
+func getGRPCOrderManager(name string) (IOrderManager, Exchanges, error) {
+	exchange, exch, err := getGRPCExchange(name)
+	if err != nil {
+		return nil, Exchanges{}, err
+	}
+
+	manager, err := GetOrderManager(exchange)
+	if err != nil {
+		return nil, Exchanges{}, status.Error(codes.Unimplemented, err.Error())
+	}
+	return manager, exch, nil
+}
+
+func newGRPCCurrencyPair(pair CurrencyPair) *gctrpc.CurrencyPair {
+	return &gctrpc.CurrencyPair{Base: pair.Base, Quote: pair.Quote}
+}
+
+func newGRPCEvent(event Event) *gctrpc.Event {
+	return &gctrpc.Event{
+		Id:             int64(event.ID),
+		Exchange:       event.Exchange,
+		Item:           event.Item,
+		Condition:      event.Condition,
+		CryptoCurrency: event.CryptoCurrency,
+		FiatCurrency:   event.FiatCurrency,
+		Action:         event.Action,
+		Executed:       event.Executed,
+	}
+}
+
+func (s *RPCServer) GetTicker(ctx context.Context, req *gctrpc.GetTickerRequest) (*gctrpc.Ticker, error) {
+	exchange, exch, err := getGRPCExchange(req.Exchange)
+	if err != nil {
+		return nil, err
+	}
+
+	fetcher, ok := exchange.(ITickerFetcher)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, NewExchangeFeatureError(exchange, "tickers").Error())
+	}
+
+	pair, err := getGRPCPair(exch, req.Pair)
+	if err != nil {
+		return nil, err
+	}
+
+	ticker, err := fetcher.GetTickerPrice(pair)
+	if err != nil {
+		return nil, newGRPCExchangeError(err)
+	}
+
+	return &gctrpc.Ticker{
+		Exchange:  exch.Name,
+		Pair:      newGRPCCurrencyPair(pair),
+		Last:      ticker.Last,
+		High:      ticker.High,
+		Low:       ticker.Low,
+		Bid:       ticker.Bid,
+		Ask:       ticker.Ask,
+		Volume:    ticker.Volume,
+		Timestamp: time.Now().UnixNano(),
+	}, nil
+}
+
+func (s *RPCServer) GetOrderbook(ctx context.Context, req *gctrpc.GetOrderbookRequest) (*gctrpc.Orderbook, error) {
+	exchange, exch, err := getGRPCExchange(req.Exchange)
+	if err != nil {
+		return nil, err
+	}
+
+	fetcher, ok := exchange.(IOrderbookFetcher)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, NewExchangeFeatureError(exchange, "orderbooks").Error())
+	}
+
+	pair, err := getGRPCPair(exch, req.Pair)
+	if err != nil {
+		return nil, err
+	}
+
+	depth := int(req.Depth)
+	if depth < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, ErrGRPCInvalidDepth, depth)
+	}
+	if depth == 0 {
+		depth = CLI_DEFAULT_ORDERBOOK_SIZE
+	}
+
+	orderbook, err := fetcher.GetOrderbookDepth(pair)
+	if err != nil {
+		return nil, newGRPCExchangeError(err)
+	}
+	orderbook = TrimOrderbook(orderbook, depth)
+
+	result := &gctrpc.Orderbook{Exchange: exch.Name, Pair: newGRPCCurrencyPair(pair)}
+	for _, x := range orderbook.Bids {
+		result.Bids = append(result.Bids, &gctrpc.OrderbookItem{Price: x.Price, Amount: x.Amount})
+	}
+	for _, x := range orderbook.Asks {
+		result.Asks = append(result.Asks, &gctrpc.OrderbookItem{Price: x.Price, Amount: x.Amount})
+	}
+	return result, nil
+}
+
+// subscribeGRPCFeed calls send with every feed update on the channel which
+// matches the request, until the client goes away or the bot shuts down.
+func subscribeGRPCFeed(ctx context.Context, channel string, req *gctrpc.SubscribeRequest, send func(FeedUpdate) error) error {
+	if req.Exchange != "" {
+		_, _, err := getGRPCExchange(req.Exchange)
+		if err != nil {
+			return err
+		}
+	}
+
+	pair := StringToUpper(req.Pair)
+	for _, separator := range []string{"-", "/", "_"} {
+		pair = strings.Replace(pair, separator, "", -1)
+	}
+
+	updates := SubscribeFeed(GRPC_SUBSCRIBER_BUFFER)
+	defer UnsubscribeFeed(updates)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-bot.ctx.Done():
+			return nil
+		case update := <-updates:
+			if update.Channel != channel || (req.Exchange != "" && update.Exchange != req.Exchange) || (pair != "" && update.Pair.String() != pair) {
+				continue
+			}
+
+			err := send(update)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// SubscribeTickers streams the tickers the bot receives. Only Last and
+// Volume are set.
+func (s *RPCServer) SubscribeTickers(req *gctrpc.SubscribeRequest, stream gctrpc.GoCryptoTrader_SubscribeTickersServer) error {
+	return subscribeGRPCFeed(stream.Context(), FEED_CHANNEL_TICKER, req, func(update FeedUpdate) error {
+		ticker, ok := update.Data.(TickerSample)
+		if !ok {
+			return nil
+		}
+		return stream.Send(&gctrpc.Ticker{
+			Exchange:  update.Exchange,
+			Pair:      newGRPCCurrencyPair(update.Pair),
+			Last:      ticker.Last,
+			Volume:    ticker.Volume,
+			Timestamp: ticker.Timestamp.UnixNano(),
+		})
+	})
+}
+
+func (s *RPCServer) SubscribeTrades(req *gctrpc.SubscribeRequest, stream gctrpc.GoCryptoTrader_SubscribeTradesServer) error {
+	return subscribeGRPCFeed(stream.Context(), FEED_CHANNEL_TRADES, req, func(update FeedUpdate) error {
+		trades, ok := update.Data.([]TradeRecord)
+		if !ok {
+			return nil
+		}
+		for _, x := range trades {
+			err := stream.Send(&gctrpc.Trade{
+				Exchange:  update.Exchange,
+				Pair:      newGRPCCurrencyPair(update.Pair),
+				Id:        x.ID,
+				Price:     x.Price,
+				Amount:    x.Amount,
+				Side:      x.Side,
+				Timestamp: x.Timestamp.UnixNano(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *RPCServer) SubmitOrder(ctx context.Context, req *gctrpc.SubmitOrderRequest) (*gctrpc.SubmitOrderResponse, error) {
+	manager, exch, err := getGRPCOrderManager(req.Exchange)
+	if err != nil {
+		return nil, err
+	}
+
+	pair, err := getGRPCPair(exch, req.Pair)
+	if err != nil {
+		return nil, err
+	}
+
+	side := StringToUpper(req.Side)
+	err = IsValidOrderSide(side)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if req.Amount <= 0 || req.Price <= 0 {
+		return nil, status.Error(codes.InvalidArgument, ErrGRPCInvalidOrderAmount)
+	}
+
+	orderID, err := manager.SubmitOrder(pair, side, req.Amount, req.Price)
+	if err != nil {
+		return nil, newGRPCExchangeError(err)
+	}
+
+	log.Printf("gRPC: Order %s placed on %s.\n", orderID, exch.Name)
+	return &gctrpc.SubmitOrderResponse{Id: orderID}, nil
+}
+
+func (s *RPCServer) CancelOrder(ctx context.Context, req *gctrpc.CancelOrderRequest) (*gctrpc.CancelOrderResponse, error) {
+	manager, exch, err := getGRPCOrderManager(req.Exchange)
+	if err != nil {
+		return nil, err
+	}
+
+	pair, err := getGRPCPair(exch, req.Pair)
+	if err != nil {
+		return nil, err
+	}
+
+	err = manager.CancelOrderByID(pair, req.Id)
+	if err != nil {
+		return nil, newGRPCExchangeError(err)
+	}
+
+	log.Printf("gRPC: Order %s cancelled on %s.\n", req.Id, exch.Name)
+	return &gctrpc.CancelOrderResponse{}, nil
+}
+
+func (s *RPCServer) GetOrders(ctx context.Context, req *gctrpc.GetOrdersRequest) (*gctrpc.GetOrdersResponse, error) {
+	manager, _, err := getGRPCOrderManager(req.Exchange)
+	if err != nil {
+		return nil, err
+	}
+
+	orders, err := manager.GetOpenOrderDetails()
+	if err != nil {
+		return nil, newGRPCExchangeError(err)
+	}
+
+	result := &gctrpc.GetOrdersResponse{}
+	for _, x := range orders {
+		result.Orders = append(result.Orders, &gctrpc.Order{
+			Id:     x.ID,
+			Pair:   newGRPCCurrencyPair(x.Pair),
+			Side:   x.Side,
+			Price:  x.Price,
+			Amount: x.Amount,
+			Filled: x.Filled,
+			Status: x.Status,
+		})
+	}
+	return result, nil
+}
+
+func (s *RPCServer) GetBalances(ctx context.Context, req *gctrpc.GetBalancesRequest) (*gctrpc.GetBalancesResponse, error) {
+	exchange, _, err := getGRPCExchange(req.Exchange)
+	if err != nil {
+		return nil, err
+	}
+
+	fetcher, err := GetBalanceFetcher(exchange)
+	if err != nil {
+		return nil, status.Error(codes.Unimplemented, err.Error())
+	}
+
+	balances, err := fetcher.GetAccountBalances()
+	if err != nil {
+		return nil, newGRPCExchangeError(err)
+	}
+
+	result := &gctrpc.GetBalancesResponse{}
+	for _, x := range balances {
+		result.Balances = append(result.Balances, &gctrpc.Balance{Currency: x.Currency, Total: x.Total, Available: x.Available, Hold: x.Hold})
+	}
+	return result, nil
+}
+
+func (s *RPCServer) AddEvent(ctx context.Context, req *gctrpc.AddEventRequest) (*gctrpc.Event, error) {
+	id, err := AddEvent(req.Exchange, req.Item, req.Condition, StringToUpper(req.CryptoCurrency), StringToUpper(req.FiatCurrency), req.Action)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err = SaveEvents()
+	if err != nil {
+		log.Printf("gRPC: Unable to save events to %s. Error: %s\n", EventsFile, err)
+	}
+
+	for _, x := range GetEvents() {
+		if x.ID == id {
+			return newGRPCEvent(x), nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, ErrGRPCEventNotFound, id)
+}
+
+func (s *RPCServer) RemoveEvent(ctx context.Context, req *gctrpc.RemoveEventRequest) (*gctrpc.RemoveEventResponse, error) {
+	if !RemoveEvent(int(req.Id)) {
+		return nil, status.Errorf(codes.NotFound, ErrGRPCEventNotFound, req.Id)
+	}
+
+	err := SaveEvents()
+	if err != nil {
+		log.Printf("gRPC: Unable to save events to %s. Error: %s\n", EventsFile, err)
+	}
+	return &gctrpc.RemoveEventResponse{}, nil
+}
+
+func (s *RPCServer) ListEvents(ctx context.Context, req *gctrpc.ListEventsRequest) (*gctrpc.ListEventsResponse, error) {
+	result := &gctrpc.ListEventsResponse{}
+	for _, x := range GetEvents() {
+		result.Events = append(result.Events, newGRPCEvent(x))
+	}
+	return result, nil
+}
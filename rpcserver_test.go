@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/thrasher-/gocryptotrader/gctrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newGRPCTestClient serves NewGRPCServer over an in-memory connection for
+// the length of the test. The bot's GRPC login is user and pass.
+func newGRPCTestClient(t *testing.T) gctrpc.GoCryptoTraderClient {
+	t.Helper()
+	setupTestBot(t)
+
+	// Streams end once the bot shuts down, which setupTestBot has already
+	// done to keep exchanges from starting
+	ctx, cancel := context.WithCancel(context.Background())
+	bot.ctx, bot.cancel = ctx, cancel
+	t.Cleanup(cancel)
+
+	cfg := GetConfig()
+	cfg.GRPC = GRPCConfig{Username: "user", Password: "pass"}
+	SetConfig(cfg)
+
+	listener := bufconn.Listen(1 << 20)
+	server := NewGRPCServer()
+	go server.Serve(listener)
+	t.Cleanup(server.GracefulStop)
+
+	dialer := func(ctx context.Context, address string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}
+	conn, err := grpc.Dial("bufconn", grpc.WithContextDialer(dialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return gctrpc.NewGoCryptoTraderClient(conn)
+}
+
+var grpcTestCredentials = []struct {
+	name string
+	opts []grpc.CallOption
+	code codes.Code
+}{
+	{"missing credentials", nil, codes.Unauthenticated},
+	{"wrong password", []grpc.CallOption{grpc.PerRPCCredentials(gctrpc.BasicAuth{Username: "user", Password: "wrong"})}, codes.Unauthenticated},
+	{"wrong username", []grpc.CallOption{grpc.PerRPCCredentials(gctrpc.BasicAuth{Username: "admin", Password: "pass"})}, codes.Unauthenticated},
+	{"correct credentials", []grpc.CallOption{grpc.PerRPCCredentials(gctrpc.BasicAuth{Username: "user", Password: "pass"})}, codes.OK},
+}
+
+func TestGRPCUnaryAuth(t *testing.T) {
+	client := newGRPCTestClient(t)
+
+	for _, x := range grpcTestCredentials {
+		_, err := client.ListEvents(context.Background(), &gctrpc.ListEventsRequest{}, x.opts...)
+		if status.Code(err) != x.code {
+			t.Errorf("%s: ListEvents returned %v, want %s", x.name, err, x.code)
+		}
+	}
+}
+
+func TestGRPCStreamAuth(t *testing.T) {
+	client := newGRPCTestClient(t)
+	pair := CurrencyPair{Base: "BTC", Quote: "USD"}
+
+	for _, x := range grpcTestCredentials {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		stream, err := client.SubscribeTickers(ctx, &gctrpc.SubscribeRequest{Exchange: "Bitstamp", Pair: "BTCUSD"}, x.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The subscription starts once the server has run the interceptor,
+		// so publish until the ticker is received or the stream fails
+		received := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case <-received:
+					return
+				case <-time.After(time.Millisecond * 10):
+					PublishFeed(FEED_CHANNEL_TICKER, "Bitstamp", pair, TickerSample{time.Now(), 500, 10})
+				}
+			}
+		}()
+
+		ticker, err := stream.Recv()
+		close(received)
+		cancel()
+
+		if status.Code(err) != x.code {
+			t.Errorf("%s: SubscribeTickers returned %v, want %s", x.name, err, x.code)
+			continue
+		}
+
+		if err == nil && (ticker.Exchange != "Bitstamp" || ticker.Last != 500 || ticker.Pair.Base != "BTC") {
+			t.Errorf("%s: ticker = %+v, want Bitstamp BTCUSD at 500", x.name, ticker)
+		}
+	}
+}
+
+func TestGRPCOrders(t *testing.T) {
+	client := newGRPCTestClient(t)
+	ctx := context.Background()
+	auth := grpc.PerRPCCredentials(gctrpc.BasicAuth{Username: "user", Password: "pass"})
+
+	_, err := client.SubmitOrder(ctx, &gctrpc.SubmitOrderRequest{Exchange: "Bitstamp", Pair: "BTCUSD", Side: "hold", Amount: 1, Price: 500}, auth)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("SubmitOrder with an invalid side returned %v, want %s", err, codes.InvalidArgument)
+	}
+
+	_, err = client.SubmitOrder(ctx, &gctrpc.SubmitOrderRequest{Exchange: "Unknown", Pair: "BTCUSD", Side: "buy", Amount: 1, Price: 500}, auth)
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("SubmitOrder on an unknown exchange returned %v, want %s", err, codes.NotFound)
+	}
+
+	order, err := client.SubmitOrder(ctx, &gctrpc.SubmitOrderRequest{Exchange: "Bitstamp", Pair: "BTCUSD", Side: "buy", Amount: 1, Price: 500}, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orders, err := client.GetOrders(ctx, &gctrpc.GetOrdersRequest{Exchange: "Bitstamp"}, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(orders.Orders) != 1 || orders.Orders[0].Id != order.Id || orders.Orders[0].Side != ORDER_SIDE_BUY || orders.Orders[0].Price != 500 {
+		t.Errorf("orders = %+v, want the buy order %s", orders.Orders, order.Id)
+	}
+
+	_, err = client.CancelOrder(ctx, &gctrpc.CancelOrderRequest{Exchange: "Bitstamp", Pair: "BTCUSD", Id: order.Id}, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orders, err = client.GetOrders(ctx, &gctrpc.GetOrdersRequest{Exchange: "Bitstamp"}, auth)
+	if err != nil || len(orders.Orders) != 0 {
+		t.Errorf("orders = %+v %v, want none", orders, err)
+	}
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ORDER_STATUS_OPEN              = "open"
+	ORDER_STATUS_FILLED            = "filled"
+	ORDER_STATUS_CANCELLED         = "cancelled"
+	ErrSimulatedInsufficientFunds  = "Insufficient %s balance: %f available, %f required."
+	ErrSimulatedOrderNotFound      = "Order %s not found."
+	ErrSimulatedOrderAmountInvalid = "Order amount and price must be greater than zero."
+)
+
+// SimulatedFill records one order fill by the simulated matching engine.
+type SimulatedFill struct {
+	Timestamp time.Time
+	OrderID   string
+	Pair      CurrencyPair
+	Side      string
+	Price     float64
+	Amount    float64
+	Fee       float64
+	Maker     bool
+}
+
+type simulatedOrder struct {
+	OrderDetail
+	activeAt time.Time
+	cancelAt time.Time
+	// resting is set once the order was found not to be marketable on
+	// arrival. From then on it can only fill as a maker at its limit price.
+	resting bool
+}
+
+// SimulatedExchange is a matching engine over simulated balances. It
+// implements the same order and balance interfaces as real exchanges and is
+// driven by market data passed to its Process methods. Orders fill in full.
+// An order that crosses the market when it arrives fills as a taker at the
+// market price plus slippage, capped at its limit; otherwise it rests and
+// fills as a maker at its limit price once the market trades through it.
+type SimulatedExchange struct {
+	sync.Mutex
+	Name     string
+	MakerFee float64 // percent
+	TakerFee float64 // percent
+	Slippage float64 // percent
+	Latency  time.Duration
+	Clock    func() time.Time
+	// OnOrderUpdate, if set, is called with each filled or cancelled order.
+	OnOrderUpdate func(order OrderDetail)
+
+	balances map[string]*AccountBalance
+	orders   map[string]*simulatedOrder
+	fills    []SimulatedFill
+	nextID   int64
+	updates  []OrderDetail
+}
+
+func NewSimulatedExchange(name string, balances map[string]float64) *SimulatedExchange {
+	s := &SimulatedExchange{
+		Name:     name,
+		balances: make(map[string]*AccountBalance),
+		orders:   make(map[string]*simulatedOrder),
+		Clock:    time.Now,
+	}
+	for currency, amount := range balances {
+		s.balance(currency).Total = amount
+		s.balance(currency).Available = amount
+	}
+	return s
+}
+
+func (s *SimulatedExchange) GetName() string {
+	return s.Name
+}
+
+func (s *SimulatedExchange) balance(currency string) *AccountBalance {
+	currency = StringToUpper(currency)
+	balance, ok := s.balances[currency]
+	if !ok {
+		balance = &AccountBalance{Currency: currency}
+		s.balances[currency] = balance
+	}
+	return balance
+}
+
+func (s *SimulatedExchange) GetAccountBalances() ([]AccountBalance, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	result := []AccountBalance{}
+	for _, x := range s.balances {
+		result = append(result, *x)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Currency < result[j].Currency
+	})
+	return result, nil
+}
+
+func (s *SimulatedExchange) GetOpenOrderDetails() ([]OrderDetail, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	result := []OrderDetail{}
+	for _, x := range s.orders {
+		result = append(result, x.OrderDetail)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return simulatedOrderIDLess(result[i].ID, result[j].ID)
+	})
+	return result, nil
+}
+
+func simulatedOrderIDLess(a, b string) bool {
+	x, _ := strconv.ParseInt(a, 10, 64)
+	y, _ := strconv.ParseInt(b, 10, 64)
+	return x < y
+}
+
+// GetFills returns every fill so far in the order they happened.
+func (s *SimulatedExchange) GetFills() []SimulatedFill {
+	s.Lock()
+	defer s.Unlock()
+	return append([]SimulatedFill{}, s.fills...)
+}
+
+// GetAccountFills returns the fills of pair within [start, end). Fees are
+// charged in the quote currency.
+func (s *SimulatedExchange) GetAccountFills(pair CurrencyPair, start, end time.Time) ([]AccountFill, error) {
+	result := []AccountFill{}
+	for i, x := range s.GetFills() {
+		if x.Pair != pair || x.Timestamp.Before(start) || !x.Timestamp.Before(end) {
+			continue
+		}
+		result = append(result, AccountFill{
+			Exchange:    s.Name,
+			ID:          strconv.Itoa(i + 1),
+			OrderID:     x.OrderID,
+			Timestamp:   x.Timestamp,
+			Pair:        x.Pair,
+			Side:        x.Side,
+			Price:       x.Price,
+			Amount:      x.Amount,
+			Fee:         x.Fee,
+			FeeCurrency: x.Pair.Quote,
+		})
+	}
+	return result, nil
+}
+
+// SubmitOrder reserves the funds for a limit order, which becomes active
+// after the configured latency.
+func (s *SimulatedExchange) SubmitOrder(pair CurrencyPair, side string, amount, price float64) (string, error) {
+	err := IsValidOrderSide(side)
+	if err != nil {
+		return "", err
+	}
+
+	if amount <= 0 || price <= 0 {
+		return "", errors.New(ErrSimulatedOrderAmountInvalid)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	currency, required := pair.Base, amount
+	if side == ORDER_SIDE_BUY {
+		currency, required = pair.Quote, amount*price*(1+math.Max(s.MakerFee, s.TakerFee)/100)
+	}
+
+	balance := s.balance(currency)
+	if balance.Available < required {
+		return "", fmt.Errorf(ErrSimulatedInsufficientFunds, currency, balance.Available, required)
+	}
+	balance.Available -= required
+	balance.Hold += required
+
+	s.nextID++
+	id := strconv.FormatInt(s.nextID, 10)
+	s.orders[id] = &simulatedOrder{
+		OrderDetail: OrderDetail{ID: id, Pair: pair, Side: side, Price: price, Amount: amount, Status: ORDER_STATUS_OPEN},
+		activeAt:    s.Clock().Add(s.Latency),
+	}
+	return id, nil
+}
+
+// CancelOrderByID cancels an order once the configured latency has passed.
+// An order may still fill in the meantime.
+func (s *SimulatedExchange) CancelOrderByID(pair CurrencyPair, orderID string) error {
+	s.Lock()
+	defer s.unlockAndNotify()
+
+	order, ok := s.orders[orderID]
+	if !ok {
+		return fmt.Errorf(ErrSimulatedOrderNotFound, orderID)
+	}
+
+	if s.Latency <= 0 {
+		s.cancel(order)
+		return nil
+	}
+
+	if order.cancelAt.IsZero() {
+		order.cancelAt = s.Clock().Add(s.Latency)
+	}
+	return nil
+}
+
+func (s *SimulatedExchange) CancelAllOpenOrders() error {
+	orders, _ := s.GetOpenOrderDetails()
+	for _, x := range orders {
+		err := s.CancelOrderByID(x.Pair, x.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SimulatedExchange) cancel(order *simulatedOrder) {
+	s.release(order)
+	order.Status = ORDER_STATUS_CANCELLED
+	delete(s.orders, order.ID)
+	s.notify(order.OrderDetail)
+}
+
+// release returns an order's reserved funds to the available balance.
+func (s *SimulatedExchange) release(order *simulatedOrder) {
+	currency, reserved := order.Pair.Base, order.Amount
+	if order.Side == ORDER_SIDE_BUY {
+		currency, reserved = order.Pair.Quote, order.Amount*order.Price*(1+math.Max(s.MakerFee, s.TakerFee)/100)
+	}
+
+	balance := s.balance(currency)
+	balance.Hold -= reserved
+	balance.Available += reserved
+}
+
+func (s *SimulatedExchange) fill(order *simulatedOrder, price float64, timestamp time.Time, maker bool) {
+	s.release(order)
+
+	feeRate := s.TakerFee
+	if maker {
+		feeRate = s.MakerFee
+	}
+
+	value := price * order.Amount
+	fee := value * feeRate / 100
+	base, quote := s.balance(order.Pair.Base), s.balance(order.Pair.Quote)
+	if order.Side == ORDER_SIDE_BUY {
+		base.Total += order.Amount
+		base.Available += order.Amount
+		quote.Total -= value + fee
+		quote.Available -= value + fee
+	} else {
+		base.Total -= order.Amount
+		base.Available -= order.Amount
+		quote.Total += value - fee
+		quote.Available += value - fee
+	}
+
+	order.Filled = order.Amount
+	order.Status = ORDER_STATUS_FILLED
+	delete(s.orders, order.ID)
+	s.fills = append(s.fills, SimulatedFill{timestamp, order.ID, order.Pair, order.Side, price, order.Amount, fee, maker})
+	s.notify(order.OrderDetail)
+}
+
+// notify queues an order update, which is passed to OnOrderUpdate by
+// unlockAndNotify once matching has finished.
+func (s *SimulatedExchange) notify(order OrderDetail) {
+	if s.OnOrderUpdate != nil {
+		s.updates = append(s.updates, order)
+	}
+}
+
+// unlockAndNotify releases the lock, then calls OnOrderUpdate with each
+// queued update so the callback can place new orders.
+func (s *SimulatedExchange) unlockAndNotify() {
+	updates := s.updates
+	s.updates = nil
+	s.Unlock()
+
+	for _, x := range updates {
+		s.OnOrderUpdate(x)
+	}
+}
+
+// pending returns the orders on pair that are active at timestamp, oldest
+// first, after applying cancellations due by then.
+func (s *SimulatedExchange) pending(pair CurrencyPair, timestamp time.Time) []*simulatedOrder {
+	result := []*simulatedOrder{}
+	for _, x := range s.orders {
+		if x.Pair != pair {
+			continue
+		}
+
+		if !x.cancelAt.IsZero() && !x.cancelAt.After(timestamp) {
+			s.cancel(x)
+			continue
+		}
+
+		if x.activeAt.After(timestamp) {
+			continue
+		}
+		result = append(result, x)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return simulatedOrderIDLess(result[i].ID, result[j].ID)
+	})
+	return result
+}
+
+// match fills an order given the best price it can trade at on arrival and
+// the most favourable price the market reached while it was active.
+func (s *SimulatedExchange) match(order *simulatedOrder, arrival, extreme float64, timestamp time.Time) {
+	if order.Status != ORDER_STATUS_OPEN {
+		return
+	}
+
+	if !order.resting {
+		if order.Side == ORDER_SIDE_BUY && order.Price >= arrival {
+			s.fill(order, math.Min(order.Price, arrival*(1+s.Slippage/100)), timestamp, false)
+			return
+		}
+		if order.Side == ORDER_SIDE_SELL && order.Price <= arrival {
+			s.fill(order, math.Max(order.Price, arrival*(1-s.Slippage/100)), timestamp, false)
+			return
+		}
+		order.resting = true
+	}
+
+	if order.Side == ORDER_SIDE_BUY && extreme <= order.Price ||
+		order.Side == ORDER_SIDE_SELL && extreme >= order.Price {
+		s.fill(order, order.Price, timestamp, true)
+	}
+}
+
+// ProcessTrade matches active orders against a public trade.
+func (s *SimulatedExchange) ProcessTrade(pair CurrencyPair, trade TradeRecord) {
+	s.Lock()
+	defer s.unlockAndNotify()
+
+	for _, x := range s.pending(pair, trade.Timestamp) {
+		s.match(x, trade.Price, trade.Price, trade.Timestamp)
+	}
+}
+
+// ProcessCandle matches orders active before the candle's end against it.
+// Orders arriving during the candle are taken to arrive at its open.
+func (s *SimulatedExchange) ProcessCandle(pair CurrencyPair, candle Candle, interval time.Duration) {
+	s.Lock()
+	defer s.unlockAndNotify()
+
+	end := candle.Timestamp.Add(interval)
+	for _, x := range s.pending(pair, end.Add(-time.Nanosecond)) {
+		extreme := candle.Low
+		if x.Side == ORDER_SIDE_SELL {
+			extreme = candle.High
+		}
+		s.match(x, candle.Open, extreme, end)
+	}
+}
+
+// ProcessTicker matches active orders against a ticker. Buys trade at the ask
+// and sells at the bid, or at the last price if the ticker has no bid or ask.
+func (s *SimulatedExchange) ProcessTicker(pair CurrencyPair, ticker TickerPrice) {
+	s.Lock()
+	defer s.unlockAndNotify()
+
+	timestamp := s.Clock()
+	for _, x := range s.pending(pair, timestamp) {
+		price := ticker.Ask
+		if x.Side == ORDER_SIDE_SELL {
+			price = ticker.Bid
+		}
+		if price <= 0 {
+			price = ticker.Last
+		}
+		if price <= 0 {
+			continue
+		}
+		s.match(x, price, price, timestamp)
+	}
+}
+
+// ProcessOrderbook matches active orders against an order book. A marketable
+// order takes the average price of walking the book for its amount. Resting
+// orders fill once the best opposite price reaches them.
+func (s *SimulatedExchange) ProcessOrderbook(pair CurrencyPair, orderbook Orderbook) {
+	s.Lock()
+	defer s.unlockAndNotify()
+
+	timestamp := s.Clock()
+	for _, x := range s.pending(pair, timestamp) {
+		levels := orderbook.Asks
+		if x.Side == ORDER_SIDE_SELL {
+			levels = orderbook.Bids
+		}
+		if len(levels) == 0 {
+			continue
+		}
+		s.match(x, GetOrderbookFillPrice(levels, x.Amount), levels[0].Price, timestamp)
+	}
+}
+
+// GetOrderbookFillPrice returns the average price of filling amount from the
+// best levels of one side of a book. Any amount beyond the book's depth is
+// priced at its last level.
+func GetOrderbookFillPrice(levels []OrderbookItem, amount float64) float64 {
+	remaining, value := amount, 0.0
+	for _, x := range levels {
+		size := math.Min(remaining, x.Amount)
+		value += size * x.Price
+		remaining -= size
+		if remaining <= 0 {
+			break
+		}
+	}
+	value += remaining * levels[len(levels)-1].Price
+	return value / amount
+}
+
+// SimulatedState is the part of a SimulatedExchange saved between runs.
+type SimulatedState struct {
+	Balances []AccountBalance
+	Orders   []OrderDetail
+	Fills    []SimulatedFill
+	NextID   int64
+}
+
+func (s *SimulatedExchange) GetState() SimulatedState {
+	balances, _ := s.GetAccountBalances()
+	orders, _ := s.GetOpenOrderDetails()
+
+	s.Lock()
+	defer s.Unlock()
+	return SimulatedState{balances, orders, append([]SimulatedFill{}, s.fills...), s.nextID}
+}
+
+// SetState replaces balances, orders and fills with a saved state. Restored
+// orders are active immediately.
+func (s *SimulatedExchange) SetState(state SimulatedState) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.balances = make(map[string]*AccountBalance)
+	for _, x := range state.Balances {
+		balance := x
+		s.balances[StringToUpper(x.Currency)] = &balance
+	}
+
+	s.orders = make(map[string]*simulatedOrder)
+	for _, x := range state.Orders {
+		s.orders[x.ID] = &simulatedOrder{OrderDetail: x}
+	}
+	s.fills = state.Fills
+	s.nextID = state.NextID
+}
+
+// GetEquity values every balance in the quote currency using prices, a map
+// of currency to its price in quote.
+func (s *SimulatedExchange) GetEquity(quote string, prices map[string]float64) float64 {
+	s.Lock()
+	defer s.Unlock()
+
+	equity := 0.0
+	for currency, x := range s.balances {
+		if currency == StringToUpper(quote) {
+			equity += x.Total
+			continue
+		}
+		equity += x.Total * prices[currency]
+	}
+	return equity
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSimulatedExchangeOrderUpdates(t *testing.T) {
+	pair := CurrencyPair{Base: "BTC", Quote: "USD"}
+	s := NewSimulatedExchange("Simulated", map[string]float64{"USD": 1000})
+
+	updates := []OrderDetail{}
+	s.OnOrderUpdate = func(order OrderDetail) {
+		updates = append(updates, order)
+		if order.Status != ORDER_STATUS_FILLED || order.Side != ORDER_SIDE_BUY {
+			return
+		}
+
+		// The lock must be released for the callback to trade
+		_, err := s.SubmitOrder(pair, ORDER_SIDE_SELL, order.Amount, 110)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	for _, price := range []float64{100, 101} {
+		_, err := s.SubmitOrder(pair, ORDER_SIDE_BUY, 1, price)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.ProcessTrade(pair, TradeRecord{Timestamp: time.Now(), Price: 100})
+	if len(updates) != 2 || updates[0].ID != "1" || updates[1].ID != "2" {
+		t.Fatalf("updates = %+v, want orders 1 and 2 filled", updates)
+	}
+
+	orders, _ := s.GetOpenOrderDetails()
+	if len(orders) != 2 || orders[0].Side != ORDER_SIDE_SELL || orders[1].Side != ORDER_SIDE_SELL {
+		t.Errorf("open orders = %+v, want the two sells placed by the callback", orders)
+	}
+
+	err := s.CancelOrderByID(pair, orders[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(updates) != 3 || updates[2].Status != ORDER_STATUS_CANCELLED {
+		t.Errorf("updates = %+v, want the sell cancelled", updates)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	STRATEGY_SMA_CROSS = "smacross"
+)
+
+func init() {
+	RegisterStrategy(STRATEGY_SMA_CROSS, func() Strategy { return &SMACross{} })
+}
+
+// SMACross is an example strategy. It buys when the fast moving average
+// crosses above the slow one and sells its position when it crosses back.
+//
+// Params: fast (10), slow (30), amount (0.01) and interval (1h).
+type SMACross struct {
+	BaseStrategy
+	fast     string
+	slow     string
+	amount   float64
+	position float64
+	above    int // 1 fast above slow, -1 below, 0 unknown
+}
+
+func (c *SMACross) OnStart(s *StrategyContext) error {
+	fast, err := s.GetIntParam("fast", 10)
+	if err != nil {
+		return err
+	}
+
+	slow, err := s.GetIntParam("slow", 30)
+	if err != nil {
+		return err
+	}
+
+	c.amount, err = s.GetFloatParam("amount", 0.01)
+	if err != nil {
+		return err
+	}
+
+	interval, err := time.ParseDuration(s.GetParam("interval", "1h"))
+	if err != nil {
+		return fmt.Errorf(ErrStrategyParamInvalid, "interval", s.GetParam("interval", ""))
+	}
+
+	c.fast = IndicatorSpec{Name: INDICATOR_SMA, Args: []float64{float64(fast)}, Interval: interval}.String()
+	c.slow = IndicatorSpec{Name: INDICATOR_SMA, Args: []float64{float64(slow)}, Interval: interval}.String()
+	return nil
+}
+
+func (c *SMACross) OnTicker(s *StrategyContext, ticker TickerPrice) {
+	fast, ok := s.GetIndicator(c.fast)
+	if !ok {
+		return
+	}
+
+	slow, ok := s.GetIndicator(c.slow)
+	if !ok {
+		return
+	}
+
+	above := -1
+	if fast > slow {
+		above = 1
+	}
+
+	previous := c.above
+	c.above = above
+	if previous == 0 || previous == above {
+		return
+	}
+
+	// Marketable limit orders, priced through the spread.
+	if above == 1 && c.position == 0 {
+		_, err := s.Buy(c.amount, ticker.Ask*1.01)
+		if err != nil {
+			s.Logf("Buy failed: %s", err)
+		}
+	} else if above == -1 && c.position > 0 {
+		_, err := s.Sell(c.position, ticker.Bid*0.99)
+		if err != nil {
+			s.Logf("Sell failed: %s", err)
+		}
+	}
+}
+
+func (c *SMACross) OnOrderUpdate(s *StrategyContext, order OrderDetail) {
+	if order.Side == ORDER_SIDE_BUY {
+		c.position += order.Filled
+	} else {
+		c.position -= order.Filled
+	}
+}
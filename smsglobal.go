@@ -15,7 +15,7 @@ const (
 
 func GetEnabledSMSContacts() int {
 	counter := 0
-	for _, contact := range bot.config.SMS.Contacts {
+	for _, contact := range GetConfig().SMS.Contacts {
 		if contact.Enabled {
 			counter++
 		}
@@ -24,7 +24,7 @@ func GetEnabledSMSContacts() int {
 }
 
 func SMSSendToAll(message string) {
-	for _, contact := range bot.config.SMS.Contacts {
+	for _, contact := range GetConfig().SMS.Contacts {
 		if contact.Enabled {
 			err := SMSNotify(contact.Number, message)
 			if err != nil {
@@ -35,7 +35,7 @@ func SMSSendToAll(message string) {
 }
 
 func SMSGetNumberByName(name string) string {
-	for _, contact := range bot.config.SMS.Contacts {
+	for _, contact := range GetConfig().SMS.Contacts {
 		if contact.Name == name {
 			return contact.Number
 		}
@@ -44,11 +44,12 @@ func SMSGetNumberByName(name string) string {
 }
 
 func SMSNotify(to, message string) error {
+	cfg := GetConfig()
 	values := url.Values{}
 	values.Set("action", "sendsms")
-	values.Set("user", bot.config.SMS.Username)
-	values.Set("password", bot.config.SMS.Password)
-	values.Set("from", bot.config.Name)
+	values.Set("user", cfg.SMS.Username)
+	values.Set("password", cfg.SMS.Password)
+	values.Set("from", cfg.Name)
 	values.Set("to", to)
 	values.Set("text", message)
 
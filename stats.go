@@ -2,6 +2,8 @@ package main
 
 import (
 	"sort"
+	"sync"
+	"time"
 )
 
 type ExchangeInfo struct {
@@ -14,6 +16,21 @@ type ExchangeInfo struct {
 
 var ExchInfo []ExchangeInfo
 
+// exchInfoMutex guards ExchInfo, which every exchange's Run loop updates.
+var exchInfoMutex sync.Mutex
+
+// LatestTicker is the most recent ticker price seen for an exchange's pair.
+type LatestTicker struct {
+	Exchange string
+	Pair     CurrencyPair
+	TickerSample
+}
+
+var latestTickers = struct {
+	sync.Mutex
+	tickers map[string]LatestTicker
+}{tickers: make(map[string]LatestTicker)}
+
 type ByPrice []ExchangeInfo
 
 func (this ByPrice) Len() int {
@@ -43,9 +60,17 @@ func (this ByVolume) Swap(i, j int) {
 }
 
 func AddExchangeInfo(exchange, crypto, fiat string, price, volume float64) {
+	StoreTicker(exchange, crypto, fiat, price, volume)
+	ProcessConditionalPrice(exchange, NewCurrencyPair(crypto, fiat), price)
+	SetLatestTicker(exchange, NewCurrencyPair(crypto, fiat), price, volume)
+	PublishFeed(FEED_CHANNEL_TICKER, exchange, NewCurrencyPair(crypto, fiat), TickerSample{time.Now(), price, volume})
+
 	if !IsFiatCurrency(fiat) {
 		return
 	}
+
+	exchInfoMutex.Lock()
+	defer exchInfoMutex.Unlock()
 	if len(ExchInfo) == 0 {
 		AppendExchangeInfo(exchange, crypto, fiat, price, volume)
 	} else {
@@ -77,10 +102,42 @@ func ExchangeInfoAlreadyExists(exchange, crypto, fiat string, price, volume floa
 	return false
 }
 
+// GetExchangeInfo returns a copy of ExchInfo.
+func GetExchangeInfo() []ExchangeInfo {
+	exchInfoMutex.Lock()
+	defer exchInfoMutex.Unlock()
+	return append([]ExchangeInfo{}, ExchInfo...)
+}
+
+func SetLatestTicker(exchange string, pair CurrencyPair, price, volume float64) {
+	latestTickers.Lock()
+	defer latestTickers.Unlock()
+	latestTickers.tickers[exchange+" "+pair.String()] = LatestTicker{exchange, pair, TickerSample{time.Now(), price, volume}}
+}
+
+// GetLatestTickers returns the latest ticker of every exchange and pair,
+// sorted by exchange and pair.
+func GetLatestTickers() []LatestTicker {
+	latestTickers.Lock()
+	defer latestTickers.Unlock()
+
+	result := []LatestTicker{}
+	for _, x := range latestTickers.tickers {
+		result = append(result, x)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Exchange != result[j].Exchange {
+			return result[i].Exchange < result[j].Exchange
+		}
+		return result[i].Pair.String() < result[j].Pair.String()
+	})
+	return result
+}
+
 func SortExchangesByVolume(crypto, fiat string, reverse bool) []ExchangeInfo {
 	info := []ExchangeInfo{}
 
-	for _, x := range ExchInfo {
+	for _, x := range GetExchangeInfo() {
 		if x.CryptoCurrency == crypto && x.FiatCurrency == fiat {
 			info = append(info, x)
 		}
@@ -97,7 +154,7 @@ func SortExchangesByVolume(crypto, fiat string, reverse bool) []ExchangeInfo {
 func SortExchangesByPrice(crypto, fiat string, reverse bool) []ExchangeInfo {
 	info := []ExchangeInfo{}
 
-	for _, x := range ExchInfo {
+	for _, x := range GetExchangeInfo() {
 		if x.CryptoCurrency == crypto && x.FiatCurrency == fiat {
 			info = append(info, x)
 		}
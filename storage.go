@@ -0,0 +1,893 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	STORAGE_DEFAULT_PATH                 = "gocryptotrader.db"
+	STORAGE_DEFAULT_SNAPSHOT_INTERVAL    = time.Minute
+	STORAGE_DEFAULT_ORDERBOOK_DEPTH      = 10
+	STORAGE_DEFAULT_MAINTENANCE_INTERVAL = time.Hour
+	STORAGE_OPEN_TIMEOUT                 = time.Second
+	STORAGE_BUCKET_TICKERS               = "tickers"
+	STORAGE_BUCKET_TRADES                = "trades"
+	STORAGE_BUCKET_ORDERBOOKS            = "orderbooks"
+	STORAGE_BUCKET_CANDLES               = "candles"
+	STORAGE_BUCKET_DOWNLOADS             = "downloads"
+	STORAGE_BUCKET_PAPER                 = "paper"
+	STORAGE_BUCKET_CONDITIONAL           = "conditional"
+	STORAGE_BUCKET_FIX                   = "fix"
+	STORAGE_BUCKET_FILLS                 = "fills"
+	STORAGE_BUCKET_FUNDING               = "funding"
+	STORAGE_BUCKET_WITHDRAWALS           = "withdrawals"
+	STORAGE_FIX_STATE_KEY                = "state"
+	STORAGE_FIX_MESSAGES_BUCKET          = "messages"
+	ErrStorageUnknownBucket              = "Unknown storage bucket %s."
+	ErrStorageInUse                      = "Storage %s is in use by another process. Stop the bot first."
+)
+
+// Storage keeps ticker samples, public trades and order book snapshots in a
+// bolt database. Each kind of record has its own top level bucket holding a
+// bucket per exchange and pair, e.g. tickers -> Bitfinex/BTCUSD. Keys start
+// with the big endian UnixNano timestamp of the record so cursors iterate in
+// time order.
+type Storage struct {
+	db *bolt.DB
+}
+
+type TickerSample struct {
+	Timestamp time.Time
+	Last      float64
+	Volume    float64
+}
+
+type TradeRecord struct {
+	Timestamp time.Time
+	ID        string
+	Price     float64
+	Amount    float64
+	Side      string
+}
+
+type OrderbookSnapshot struct {
+	Timestamp time.Time
+	Bids      []OrderbookItem
+	Asks      []OrderbookItem
+}
+
+// OpenStorage opens the database at path, creating it if needed. bolt locks
+// the file while it is open, even read only, so the command line can't open it
+// while the bot is running.
+func OpenStorage(path string, readOnly bool) (*Storage, error) {
+	if readOnly {
+		// bolt would otherwise create an empty file it cannot initialise
+		_, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: STORAGE_OPEN_TIMEOUT, ReadOnly: readOnly})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf(ErrStorageInUse, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !readOnly {
+		err = db.Update(func(tx *bolt.Tx) error {
+			for _, x := range []string{STORAGE_BUCKET_TICKERS, STORAGE_BUCKET_TRADES, STORAGE_BUCKET_ORDERBOOKS, STORAGE_BUCKET_CANDLES, STORAGE_BUCKET_DOWNLOADS, STORAGE_BUCKET_PAPER, STORAGE_BUCKET_CONDITIONAL, STORAGE_BUCKET_FIX, STORAGE_BUCKET_FILLS, STORAGE_BUCKET_FUNDING, STORAGE_BUCKET_WITHDRAWALS} {
+				_, err := tx.CreateBucketIfNotExists([]byte(x))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &Storage{db}, nil
+}
+
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+func GetStorageSeriesName(exchange string, pair CurrencyPair) string {
+	return exchange + "/" + pair.String()
+}
+
+func encodeStorageTimestamp(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
+
+func decodeStorageTimestamp(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key[:8])))
+}
+
+// put stores value under key, which must start with the record timestamp.
+// When sequence is set a sequence number is appended to keep records with
+// equal timestamps apart. Otherwise writing the same key again replaces the
+// record, which is how trades and candles are deduplicated.
+func (s *Storage) put(bucket, series string, key []byte, sequence bool, value interface{}) error {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket([]byte(bucket)).CreateBucketIfNotExists([]byte(series))
+		if err != nil {
+			return err
+		}
+
+		if sequence {
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			key = append(key, encodeStorageTimestamp(time.Unix(0, int64(seq)))...)
+		}
+		return b.Put(key, payload)
+	})
+}
+
+// query calls f with each record in the series timestamped within
+// [start, end].
+func (s *Storage) query(bucket, series string, start, end time.Time, f func(value []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(bucket))
+		if root == nil {
+			return nil
+		}
+
+		b := root.Bucket([]byte(series))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		endKey := encodeStorageTimestamp(end)
+		for k, v := c.Seek(encodeStorageTimestamp(start)); k != nil && bytes.Compare(k[:8], endKey) <= 0; k, v = c.Next() {
+			err := f(v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Storage) AddTicker(exchange string, pair CurrencyPair, sample TickerSample) error {
+	return s.put(STORAGE_BUCKET_TICKERS, GetStorageSeriesName(exchange, pair), encodeStorageTimestamp(sample.Timestamp), true, sample)
+}
+
+func (s *Storage) AddTrades(exchange string, pair CurrencyPair, trades []TradeRecord) error {
+	for _, x := range trades {
+		key := append(encodeStorageTimestamp(x.Timestamp), []byte(x.ID)...)
+		err := s.put(STORAGE_BUCKET_TRADES, GetStorageSeriesName(exchange, pair), key, x.ID == "", x)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Storage) AddOrderbook(exchange string, pair CurrencyPair, snapshot OrderbookSnapshot) error {
+	return s.put(STORAGE_BUCKET_ORDERBOOKS, GetStorageSeriesName(exchange, pair), encodeStorageTimestamp(snapshot.Timestamp), true, snapshot)
+}
+
+func GetCandleSeriesName(exchange string, pair CurrencyPair, interval time.Duration) string {
+	return GetStorageSeriesName(exchange, pair) + "/" + interval.String()
+}
+
+func (s *Storage) AddCandles(exchange string, pair CurrencyPair, interval time.Duration, candles []Candle) error {
+	for _, x := range candles {
+		err := s.put(STORAGE_BUCKET_CANDLES, GetCandleSeriesName(exchange, pair, interval), encodeStorageTimestamp(x.Timestamp), false, x)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Storage) GetCandles(exchange string, pair CurrencyPair, interval time.Duration, start, end time.Time) ([]Candle, error) {
+	result := []Candle{}
+	err := s.query(STORAGE_BUCKET_CANDLES, GetCandleSeriesName(exchange, pair, interval), start, end, func(value []byte) error {
+		candle := Candle{}
+		err := json.Unmarshal(value, &candle)
+		result = append(result, candle)
+		return err
+	})
+	return result, err
+}
+
+// AddFills stores account fills in the trade ledger. Fills are keyed by ID, so
+// importing the same fill again replaces it.
+func (s *Storage) AddFills(fills []AccountFill) error {
+	for _, x := range fills {
+		key := append(encodeStorageTimestamp(x.Timestamp), []byte(x.ID)...)
+		err := s.put(STORAGE_BUCKET_FILLS, GetStorageSeriesName(x.Exchange, x.Pair), key, x.ID == "", x)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetFills returns the ledger's fills on every exchange and pair timestamped
+// within [start, end], in time order.
+func (s *Storage) GetFills(start, end time.Time) ([]AccountFill, error) {
+	series := []string{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(STORAGE_BUCKET_FILLS))
+		if root == nil {
+			return nil
+		}
+		return root.ForEach(func(k, v []byte) error {
+			series = append(series, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []AccountFill{}
+	for _, x := range series {
+		err := s.query(STORAGE_BUCKET_FILLS, x, start, end, func(value []byte) error {
+			fill := AccountFill{}
+			err := json.Unmarshal(value, &fill)
+			result = append(result, fill)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+	return result, nil
+}
+
+// UpdateFundingMovements stores an exchange's deposits and withdrawals found
+// within [start, end] in the funding ledger. Movements are keyed by ID, so a
+// movement imported again has its status updated but keeps the timestamp it
+// was first seen with. Pending movements within the period which the exchange
+// no longer reports are removed, as they have either completed under another
+// ID or been dropped.
+func (s *Storage) UpdateFundingMovements(exchange string, start, end time.Time, movements []FundingMovement) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket([]byte(STORAGE_BUCKET_FUNDING)).CreateBucketIfNotExists([]byte(exchange))
+		if err != nil {
+			return err
+		}
+
+		existing := make(map[string]FundingMovement)
+		stale := [][]byte{}
+		err = b.ForEach(func(k, v []byte) error {
+			movement := FundingMovement{}
+			err := json.Unmarshal(v, &movement)
+			if err != nil {
+				return err
+			}
+
+			existing[movement.ID] = movement
+			if movement.Status == FUNDING_STATUS_PENDING && !movement.Timestamp.Before(start) && !movement.Timestamp.After(end) {
+				stale = append(stale, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = deleteStorageKeys(b, stale)
+		if err != nil {
+			return err
+		}
+
+		for _, x := range movements {
+			if old, ok := existing[x.ID]; ok && old.Timestamp.Before(x.Timestamp) {
+				x.Timestamp = old.Timestamp
+			}
+
+			payload, err := json.Marshal(x)
+			if err != nil {
+				return err
+			}
+
+			err = b.Put([]byte(x.ID), payload)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetFundingMovements returns the funding ledger's movements on every exchange
+// timestamped within [start, end], in time order.
+func (s *Storage) GetFundingMovements(start, end time.Time) ([]FundingMovement, error) {
+	result := []FundingMovement{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(STORAGE_BUCKET_FUNDING))
+		if root == nil {
+			return nil
+		}
+
+		return root.ForEach(func(exchange, v []byte) error {
+			b := root.Bucket(exchange)
+			if b == nil {
+				return nil
+			}
+
+			return b.ForEach(func(k, v []byte) error {
+				movement := FundingMovement{}
+				err := json.Unmarshal(v, &movement)
+				if err != nil {
+					return err
+				}
+
+				if !movement.Timestamp.Before(start) && !movement.Timestamp.After(end) {
+					result = append(result, movement)
+				}
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+	return result, nil
+}
+
+// GetStoredPrice returns the latest price of pair on any exchange at or before
+// t and no older than maxAge. Stored candle closes, trades and ticker samples
+// are searched. It returns false if none is found.
+func (s *Storage) GetStoredPrice(pair CurrencyPair, t time.Time, maxAge time.Duration) (float64, bool, error) {
+	price := 0.0
+	latest := t.Add(-maxAge)
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{STORAGE_BUCKET_CANDLES, STORAGE_BUCKET_TRADES, STORAGE_BUCKET_TICKERS} {
+			root := tx.Bucket([]byte(bucket))
+			if root == nil {
+				continue
+			}
+
+			err := root.ForEach(func(name, v []byte) error {
+				series := SplitStrings(string(name), "/")
+				if len(series) < 2 || series[1] != pair.String() || root.Bucket(name) == nil {
+					return nil
+				}
+
+				c := root.Bucket(name).Cursor()
+				k, value := c.Seek(encodeStorageTimestamp(t.Add(1)))
+				if k == nil {
+					k, value = c.Last()
+				} else {
+					k, value = c.Prev()
+				}
+				if k == nil || decodeStorageTimestamp(k).Before(latest) {
+					return nil
+				}
+
+				var err error
+				switch bucket {
+				case STORAGE_BUCKET_CANDLES:
+					candle := Candle{}
+					err = json.Unmarshal(value, &candle)
+					price = candle.Close
+				case STORAGE_BUCKET_TRADES:
+					trade := TradeRecord{}
+					err = json.Unmarshal(value, &trade)
+					price = trade.Price
+				default:
+					sample := TickerSample{}
+					err = json.Unmarshal(value, &sample)
+					price = sample.Last
+				}
+				if err != nil {
+					return err
+				}
+
+				latest = decodeStorageTimestamp(k)
+				found = true
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return price, found, err
+}
+
+// GetDownloadProgress loads the saved state of the download named name into
+// progress. It returns false if there is none.
+func (s *Storage) GetDownloadProgress(name string, progress interface{}) (bool, error) {
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket([]byte(STORAGE_BUCKET_DOWNLOADS)).Get([]byte(name))
+		if value == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(value, progress)
+	})
+	return found, err
+}
+
+func (s *Storage) SetDownloadProgress(name string, progress interface{}) error {
+	payload, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(STORAGE_BUCKET_DOWNLOADS)).Put([]byte(name), payload)
+	})
+}
+
+// GetPaperState loads the saved paper trading account of an exchange. It
+// returns false if there is none.
+func (s *Storage) GetPaperState(exchange string) (SimulatedState, bool, error) {
+	state := SimulatedState{}
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(STORAGE_BUCKET_PAPER))
+		if bucket == nil {
+			return nil
+		}
+
+		value := bucket.Get([]byte(exchange))
+		if value == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(value, &state)
+	})
+	return state, found, err
+}
+
+func (s *Storage) SetPaperState(exchange string, state SimulatedState) error {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(STORAGE_BUCKET_PAPER)).Put([]byte(exchange), payload)
+	})
+}
+
+// GetConditionalOrders loads every saved conditional order.
+func (s *Storage) GetConditionalOrders() ([]ConditionalOrder, error) {
+	result := []ConditionalOrder{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(STORAGE_BUCKET_CONDITIONAL))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(key, value []byte) error {
+			order := ConditionalOrder{}
+			err := json.Unmarshal(value, &order)
+			result = append(result, order)
+			return err
+		})
+	})
+	return result, err
+}
+
+func (s *Storage) SetConditionalOrder(order ConditionalOrder) error {
+	payload, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(STORAGE_BUCKET_CONDITIONAL)).Put([]byte(order.ID), payload)
+	})
+}
+
+// GetWithdrawals loads every withdrawal in the order they were requested.
+func (s *Storage) GetWithdrawals() ([]Withdrawal, error) {
+	result := []Withdrawal{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(STORAGE_BUCKET_WITHDRAWALS))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(key, value []byte) error {
+			withdrawal := Withdrawal{}
+			err := json.Unmarshal(value, &withdrawal)
+			result = append(result, withdrawal)
+			return err
+		})
+	})
+	return result, err
+}
+
+// SetWithdrawal saves a withdrawal, giving it the next ID if it has none.
+// IDs are numbered in the order withdrawals are requested.
+func (s *Storage) SetWithdrawal(withdrawal *Withdrawal) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(STORAGE_BUCKET_WITHDRAWALS))
+		if withdrawal.ID == "" {
+			id, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			withdrawal.ID = strconv.FormatUint(id, 10)
+		}
+
+		payload, err := json.Marshal(withdrawal)
+		if err != nil {
+			return err
+		}
+
+		id, err := strconv.ParseUint(withdrawal.ID, 10, 64)
+		if err != nil {
+			return err
+		}
+
+		// Keys are big endian so withdrawals are iterated in ID order.
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, id)
+		return bucket.Put(key, payload)
+	})
+}
+
+// GetFIXSession loads the saved state of a FIX session. It returns false if
+// there is none.
+func (s *Storage) GetFIXSession(session string) (FIXSessionState, bool, error) {
+	state := FIXSessionState{}
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(STORAGE_BUCKET_FIX)).Bucket([]byte(session))
+		if bucket == nil {
+			return nil
+		}
+
+		value := bucket.Get([]byte(STORAGE_FIX_STATE_KEY))
+		if value == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(value, &state)
+	})
+	return state, found, err
+}
+
+// SetFIXSession saves the state of a FIX session. If message is set it is
+// kept as the session's sent message seqNum, for resending.
+func (s *Storage) SetFIXSession(session string, state FIXSessionState, seqNum int, message []byte) error {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket([]byte(STORAGE_BUCKET_FIX)).CreateBucketIfNotExists([]byte(session))
+		if err != nil {
+			return err
+		}
+
+		if message != nil {
+			messages, err := bucket.CreateBucketIfNotExists([]byte(STORAGE_FIX_MESSAGES_BUCKET))
+			if err != nil {
+				return err
+			}
+
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(seqNum))
+			err = messages.Put(key, message)
+			if err != nil {
+				return err
+			}
+		}
+		return bucket.Put([]byte(STORAGE_FIX_STATE_KEY), payload)
+	})
+}
+
+// GetFIXMessages returns the kept sent messages of a FIX session from begin
+// to end inclusive, by sequence number.
+func (s *Storage) GetFIXMessages(session string, begin, end int) (map[int][]byte, error) {
+	result := make(map[int][]byte)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(STORAGE_BUCKET_FIX)).Bucket([]byte(session))
+		if bucket == nil {
+			return nil
+		}
+
+		messages := bucket.Bucket([]byte(STORAGE_FIX_MESSAGES_BUCKET))
+		if messages == nil {
+			return nil
+		}
+
+		min := make([]byte, 8)
+		binary.BigEndian.PutUint64(min, uint64(begin))
+		c := messages.Cursor()
+		for key, value := c.Seek(min); key != nil && int(binary.BigEndian.Uint64(key)) <= end; key, value = c.Next() {
+			result[int(binary.BigEndian.Uint64(key))] = append([]byte{}, value...)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// DeleteFIXMessages drops the kept sent messages of a FIX session, once its
+// sequence numbers have been reset.
+func (s *Storage) DeleteFIXMessages(session string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(STORAGE_BUCKET_FIX)).Bucket([]byte(session))
+		if bucket == nil || bucket.Bucket([]byte(STORAGE_FIX_MESSAGES_BUCKET)) == nil {
+			return nil
+		}
+		return bucket.DeleteBucket([]byte(STORAGE_FIX_MESSAGES_BUCKET))
+	})
+}
+
+func (s *Storage) GetTickers(exchange string, pair CurrencyPair, start, end time.Time) ([]TickerSample, error) {
+	result := []TickerSample{}
+	err := s.query(STORAGE_BUCKET_TICKERS, GetStorageSeriesName(exchange, pair), start, end, func(value []byte) error {
+		sample := TickerSample{}
+		err := json.Unmarshal(value, &sample)
+		result = append(result, sample)
+		return err
+	})
+	return result, err
+}
+
+func (s *Storage) GetTrades(exchange string, pair CurrencyPair, start, end time.Time) ([]TradeRecord, error) {
+	result := []TradeRecord{}
+	err := s.query(STORAGE_BUCKET_TRADES, GetStorageSeriesName(exchange, pair), start, end, func(value []byte) error {
+		trade := TradeRecord{}
+		err := json.Unmarshal(value, &trade)
+		result = append(result, trade)
+		return err
+	})
+	return result, err
+}
+
+func (s *Storage) GetOrderbooks(exchange string, pair CurrencyPair, start, end time.Time) ([]OrderbookSnapshot, error) {
+	result := []OrderbookSnapshot{}
+	err := s.query(STORAGE_BUCKET_ORDERBOOKS, GetStorageSeriesName(exchange, pair), start, end, func(value []byte) error {
+		snapshot := OrderbookSnapshot{}
+		err := json.Unmarshal(value, &snapshot)
+		result = append(result, snapshot)
+		return err
+	})
+	return result, err
+}
+
+// DeleteBefore removes every record in bucket older than before and returns
+// the number removed.
+func (s *Storage) DeleteBefore(bucket string, before time.Time) (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(bucket))
+		if root == nil {
+			return fmt.Errorf(ErrStorageUnknownBucket, bucket)
+		}
+
+		beforeKey := encodeStorageTimestamp(before)
+		return root.ForEach(func(series, _ []byte) error {
+			b := root.Bucket(series)
+			keys := [][]byte{}
+			c := b.Cursor()
+			for k, _ := c.First(); k != nil && bytes.Compare(k[:8], beforeKey) < 0; k, _ = c.Next() {
+				keys = append(keys, append([]byte{}, k...))
+			}
+			removed += len(keys)
+			return deleteStorageKeys(b, keys)
+		})
+	})
+	return removed, err
+}
+
+// DownsampleTickers thins out ticker samples older than before so that at
+// most one sample, the last, is kept per interval. Running it again over the
+// same range removes nothing further.
+func (s *Storage) DownsampleTickers(before time.Time, interval time.Duration) (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(STORAGE_BUCKET_TICKERS))
+		beforeKey := encodeStorageTimestamp(before)
+
+		return root.ForEach(func(series, _ []byte) error {
+			b := root.Bucket(series)
+			keys := [][]byte{}
+			c := b.Cursor()
+			var previous []byte
+			for k, _ := c.First(); k != nil && bytes.Compare(k[:8], beforeKey) < 0; k, _ = c.Next() {
+				if previous != nil && decodeStorageTimestamp(previous).Truncate(interval).Equal(decodeStorageTimestamp(k).Truncate(interval)) {
+					keys = append(keys, append([]byte{}, previous...))
+				}
+				previous = k
+			}
+			removed += len(keys)
+			return deleteStorageKeys(b, keys)
+		})
+	})
+	return removed, err
+}
+
+// Keys are deleted after iterating, as deleting through a bolt cursor can
+// skip the following key.
+func deleteStorageKeys(b *bolt.Bucket, keys [][]byte) error {
+	for _, x := range keys {
+		err := b.Delete(x)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunStorageMaintenance applies the configured retention and downsampling
+// policies.
+func (s *Storage) RunStorageMaintenance(cfg StorageConfig) {
+	now := time.Now()
+	policies := []struct {
+		bucket    string
+		retention time.Duration
+	}{
+		{STORAGE_BUCKET_TICKERS, cfg.TickerRetention.Duration},
+		{STORAGE_BUCKET_TRADES, cfg.TradeRetention.Duration},
+		{STORAGE_BUCKET_ORDERBOOKS, cfg.OrderbookRetention.Duration},
+	}
+
+	for _, x := range policies {
+		if x.retention <= 0 {
+			continue
+		}
+
+		removed, err := s.DeleteBefore(x.bucket, now.Add(-x.retention))
+		if err != nil {
+			log.Printf("Unable to apply %s retention. Error: %s\n", x.bucket, err)
+			continue
+		}
+		if removed > 0 {
+			log.Printf("Storage: Removed %d %s record(s) older than %s.\n", removed, x.bucket, x.retention)
+		}
+	}
+
+	if cfg.DownsampleAfter.Duration > 0 && cfg.DownsampleInterval.Duration > 0 {
+		removed, err := s.DownsampleTickers(now.Add(-cfg.DownsampleAfter.Duration), cfg.DownsampleInterval.Duration)
+		if err != nil {
+			log.Printf("Unable to downsample tickers. Error: %s\n", err)
+		} else if removed > 0 {
+			log.Printf("Storage: Downsampled %d ticker sample(s).\n", removed)
+		}
+	}
+}
+
+// StoreTicker records a ticker sample if storage is enabled.
+func StoreTicker(exchange, crypto, fiat string, price, volume float64) {
+	if bot.storage == nil {
+		return
+	}
+
+	err := bot.storage.AddTicker(exchange, NewCurrencyPair(crypto, fiat), TickerSample{time.Now(), price, volume})
+	if err != nil {
+		log.Printf("%s: Unable to store ticker. Error: %s\n", exchange, err)
+	}
+}
+
+// StoreTrades records public trades if storage is enabled.
+func StoreTrades(exchange string, pair CurrencyPair, trades []TradeRecord) {
+	if bot.storage == nil || len(trades) == 0 {
+		return
+	}
+
+	err := bot.storage.AddTrades(exchange, pair, trades)
+	if err != nil {
+		log.Printf("%s: Unable to store trades. Error: %s\n", exchange, err)
+	}
+}
+
+// StoreCandles records finished candles if storage is enabled.
+func StoreCandles(exchange string, pair CurrencyPair, interval time.Duration, candles []Candle) {
+	if bot.storage == nil || len(candles) == 0 {
+		return
+	}
+
+	err := bot.storage.AddCandles(exchange, pair, interval, candles)
+	if err != nil {
+		log.Printf("%s: Unable to store candles. Error: %s\n", exchange, err)
+	}
+}
+
+// SnapshotOrderbooks stores the top of the order book for every enabled pair
+// on each enabled exchange able to fetch order books.
+func SnapshotOrderbooks(depth int) {
+	for _, exch := range GetConfig().Exchanges {
+		if !exch.Enabled {
+			continue
+		}
+
+		fetcher, ok := bot.exchange.GetExchangeByName(exch.Name).(IOrderbookFetcher)
+		if !ok {
+			continue
+		}
+
+		for _, pair := range exch.EnabledPairs {
+			orderbook, err := fetcher.GetOrderbookDepth(pair)
+			if err != nil {
+				log.Printf("%s: Unable to snapshot %s orderbook. Error: %s\n", exch.Name, pair, err)
+				continue
+			}
+
+			orderbook = TrimOrderbook(orderbook, depth)
+			err = bot.storage.AddOrderbook(exch.Name, pair, OrderbookSnapshot{time.Now(), orderbook.Bids, orderbook.Asks})
+			if err != nil {
+				log.Printf("%s: Unable to store %s orderbook. Error: %s\n", exch.Name, pair, err)
+			}
+		}
+	}
+}
+
+// StorageRoutine takes periodic order book snapshots and applies retention
+// policies until ctx is cancelled.
+func StorageRoutine(ctx context.Context) {
+	cfg := GetConfig().Storage
+	snapshotInterval := cfg.OrderbookSnapshotInterval.Duration
+	if snapshotInterval <= 0 {
+		snapshotInterval = STORAGE_DEFAULT_SNAPSHOT_INTERVAL
+	}
+
+	maintenanceInterval := cfg.MaintenanceInterval.Duration
+	if maintenanceInterval <= 0 {
+		maintenanceInterval = STORAGE_DEFAULT_MAINTENANCE_INTERVAL
+	}
+
+	depth := cfg.OrderbookDepth
+	if depth <= 0 {
+		depth = STORAGE_DEFAULT_ORDERBOOK_DEPTH
+	}
+
+	snapshots := time.NewTicker(snapshotInterval)
+	defer snapshots.Stop()
+	maintenance := time.NewTicker(maintenanceInterval)
+	defer maintenance.Stop()
+
+	bot.storage.RunStorageMaintenance(cfg)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-snapshots.C:
+			SnapshotOrderbooks(depth)
+		case <-maintenance.C:
+			bot.storage.RunStorageMaintenance(cfg)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	storage, err := OpenStorage(filepath.Join(t.TempDir(), "storage.db"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+func TestStorageRangeQuery(t *testing.T) {
+	storage := newTestStorage(t)
+	pair := NewCurrencyPair("BTC", "USD")
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Added out of order, with two samples sharing a timestamp.
+	for _, x := range []int{3, 0, 1, 2, 2, 4} {
+		err := storage.AddTicker("Bitstamp", pair, TickerSample{Timestamp: start.Add(time.Minute * time.Duration(x)), Last: float64(x)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	storage.AddTicker("Bitfinex", pair, TickerSample{Timestamp: start.Add(time.Minute * 2), Last: 100})
+	storage.AddTicker("Bitstamp", NewCurrencyPair("LTC", "USD"), TickerSample{Timestamp: start.Add(time.Minute * 2), Last: 200})
+
+	tickers, err := storage.GetTickers("Bitstamp", pair, start.Add(time.Minute), start.Add(time.Minute*3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []float64{1, 2, 2, 3}
+	if len(tickers) != len(want) {
+		t.Fatalf("tickers = %+v, want %v", tickers, want)
+	}
+	for i, x := range tickers {
+		if x.Last != want[i] {
+			t.Errorf("ticker %d = %f, want %f", i, x.Last, want[i])
+		}
+	}
+
+	tickers, err = storage.GetTickers("Kraken", pair, start, start.Add(time.Hour))
+	if err != nil || len(tickers) != 0 {
+		t.Errorf("tickers of an exchange without any = %+v %v", tickers, err)
+	}
+
+	// Trades are kept once however often they are added.
+	trades := []TradeRecord{{Timestamp: start, ID: "1", Price: 10}, {Timestamp: start, ID: "2", Price: 11}}
+	storage.AddTrades("Bitstamp", pair, trades)
+	storage.AddTrades("Bitstamp", pair, trades[1:])
+	stored, err := storage.GetTrades("Bitstamp", pair, start, start)
+	if err != nil || len(stored) != 2 || stored[0].ID != "1" || stored[1].ID != "2" {
+		t.Errorf("trades = %+v %v, want 1 and 2", stored, err)
+	}
+}
+
+func TestStorageMaintenance(t *testing.T) {
+	storage := newTestStorage(t)
+	pair := NewCurrencyPair("BTC", "USD")
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		storage.AddTicker("Bitstamp", pair, TickerSample{Timestamp: start.Add(time.Minute * time.Duration(i*20)), Last: float64(i)})
+	}
+
+	// Samples before 1:20 are thinned to the last of each hour.
+	removed, err := storage.DownsampleTickers(start.Add(time.Minute*80), time.Hour)
+	if err != nil || removed != 2 {
+		t.Fatalf("DownsampleTickers removed %d %v, want 2", removed, err)
+	}
+
+	removed, err = storage.DownsampleTickers(start.Add(time.Minute*80), time.Hour)
+	if err != nil || removed != 0 {
+		t.Errorf("downsampling again removed %d %v, want none", removed, err)
+	}
+
+	removed, err = storage.DeleteBefore(STORAGE_BUCKET_TICKERS, start.Add(time.Hour))
+	if err != nil || removed != 1 {
+		t.Errorf("DeleteBefore removed %d %v, want 1", removed, err)
+	}
+
+	tickers, _ := storage.GetTickers("Bitstamp", pair, start, start.Add(time.Hour*2))
+	want := []float64{3, 4, 5}
+	if len(tickers) != len(want) {
+		t.Fatalf("tickers = %+v, want %v", tickers, want)
+	}
+	for i, x := range tickers {
+		if x.Last != want[i] {
+			t.Errorf("ticker %d = %f, want %f", i, x.Last, want[i])
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ErrStrategyUnknown      = "Unknown strategy %s."
+	ErrStrategyParamInvalid = "Strategy parameter %s has invalid value %s."
+)
+
+// Strategy is implemented by trading logic. The same strategy runs live,
+// paper traded or backtested; only the StrategyContext it is given differs.
+// Hooks are called from a single goroutine, so strategies don't need locking.
+type Strategy interface {
+	OnStart(s *StrategyContext) error
+	OnTicker(s *StrategyContext, ticker TickerPrice)
+	OnTrade(s *StrategyContext, trade TradeRecord)
+	OnBook(s *StrategyContext, orderbook Orderbook)
+	OnOrderUpdate(s *StrategyContext, order OrderDetail)
+	OnTimer(s *StrategyContext, now time.Time)
+	OnStop(s *StrategyContext)
+}
+
+// BaseStrategy implements every hook as a no-op. Strategies embed it and
+// override the hooks they need.
+type BaseStrategy struct{}
+
+func (b BaseStrategy) OnStart(s *StrategyContext) error                    { return nil }
+func (b BaseStrategy) OnTicker(s *StrategyContext, ticker TickerPrice)     {}
+func (b BaseStrategy) OnTrade(s *StrategyContext, trade TradeRecord)       {}
+func (b BaseStrategy) OnBook(s *StrategyContext, orderbook Orderbook)      {}
+func (b BaseStrategy) OnOrderUpdate(s *StrategyContext, order OrderDetail) {}
+func (b BaseStrategy) OnTimer(s *StrategyContext, now time.Time)           {}
+func (b BaseStrategy) OnStop(s *StrategyContext)                           {}
+
+// IIndicatorSource supplies indicator values to strategies. Live strategies
+// read the indicators fed by live candles, backtests those fed by replayed
+// candles.
+type IIndicatorSource interface {
+	GetIndicator(exchange string, pair CurrencyPair, spec IndicatorSpec) (float64, bool)
+}
+
+// StrategyContext is a strategy's view of the exchange and pair it trades.
+type StrategyContext struct {
+	Name       string
+	Exchange   string
+	Pair       CurrencyPair
+	Params     map[string]string
+	Orders     IOrderManager
+	Balances   IBalanceFetcher
+	Indicators IIndicatorSource
+	Clock      func() time.Time
+}
+
+func (s *StrategyContext) Now() time.Time {
+	if s.Clock == nil {
+		return time.Now()
+	}
+	return s.Clock()
+}
+
+func (s *StrategyContext) Logf(format string, args ...interface{}) {
+	log.Printf("Strategy %s (%s %s): %s\n", s.Name, s.Exchange, s.Pair, fmt.Sprintf(format, args...))
+}
+
+// Buy places a limit buy order for the strategy's pair.
+func (s *StrategyContext) Buy(amount, price float64) (string, error) {
+	return s.Orders.SubmitOrder(s.Pair, ORDER_SIDE_BUY, amount, price)
+}
+
+// Sell places a limit sell order for the strategy's pair.
+func (s *StrategyContext) Sell(amount, price float64) (string, error) {
+	return s.Orders.SubmitOrder(s.Pair, ORDER_SIDE_SELL, amount, price)
+}
+
+func (s *StrategyContext) Cancel(orderID string) error {
+	return s.Orders.CancelOrderByID(s.Pair, orderID)
+}
+
+// Replace moves an order to a new amount and price, in one call where the
+// exchange supports it. It returns the new order's ID. On failure the ID is
+// orderID, as it may still be open, unless it is known to have been cancelled.
+func (s *StrategyContext) Replace(orderID, side string, amount, price float64) (string, error) {
+	if replacer, ok := s.Orders.(IOrderReplacer); ok {
+		return replacer.ReplaceOrderByID(s.Pair, orderID, side, amount, price)
+	}
+
+	err := s.Orders.CancelOrderByID(s.Pair, orderID)
+	if err != nil {
+		return orderID, err
+	}
+	return s.Orders.SubmitOrder(s.Pair, side, amount, price)
+}
+
+// GetOpenOrders returns the open orders on the strategy's pair.
+func (s *StrategyContext) GetOpenOrders() ([]OrderDetail, error) {
+	orders, err := s.Orders.GetOpenOrderDetails()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []OrderDetail{}
+	for _, x := range orders {
+		if x.Pair == s.Pair {
+			result = append(result, x)
+		}
+	}
+	return result, nil
+}
+
+// GetBalance returns the balance of a currency, or an empty balance if the
+// account holds none.
+func (s *StrategyContext) GetBalance(currency string) (AccountBalance, error) {
+	balances, err := s.Balances.GetAccountBalances()
+	if err != nil {
+		return AccountBalance{}, err
+	}
+
+	for _, x := range balances {
+		if StringToUpper(x.Currency) == StringToUpper(currency) {
+			return x, nil
+		}
+	}
+	return AccountBalance{Currency: currency}, nil
+}
+
+// GetIndicator returns an indicator such as RSI(14)@1h on the strategy's
+// pair. It returns false until the indicator has seen enough candles.
+func (s *StrategyContext) GetIndicator(item string) (float64, bool) {
+	if s.Indicators == nil {
+		return 0, false
+	}
+
+	spec, err := ParseIndicatorSpec(item)
+	if err != nil {
+		s.Logf("%s", err)
+		return 0, false
+	}
+	return s.Indicators.GetIndicator(s.Exchange, s.Pair, spec)
+}
+
+func (s *StrategyContext) GetParam(name, defaultValue string) string {
+	value, ok := s.Params[name]
+	if !ok {
+		return defaultValue
+	}
+	return value
+}
+
+func (s *StrategyContext) GetFloatParam(name string, defaultValue float64) (float64, error) {
+	value, ok := s.Params[name]
+	if !ok {
+		return defaultValue, nil
+	}
+
+	result, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf(ErrStrategyParamInvalid, name, value)
+	}
+	return result, nil
+}
+
+func (s *StrategyContext) GetIntParam(name string, defaultValue int) (int, error) {
+	value, ok := s.Params[name]
+	if !ok {
+		return defaultValue, nil
+	}
+
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf(ErrStrategyParamInvalid, name, value)
+	}
+	return result, nil
+}
+
+// liveIndicators reads indicators fed by the live candle builder.
+type liveIndicators struct{}
+
+func (l liveIndicators) GetIndicator(exchange string, pair CurrencyPair, spec IndicatorSpec) (float64, bool) {
+	return GetIndicatorValue(exchange, pair, spec)
+}
+
+var strategies = struct {
+	sync.Mutex
+	factories map[string]func() Strategy
+}{factories: make(map[string]func() Strategy)}
+
+// RegisterStrategy makes a strategy available by name. Built-in strategies
+// register themselves from init.
+func RegisterStrategy(name string, factory func() Strategy) {
+	strategies.Lock()
+	defer strategies.Unlock()
+	strategies.factories[name] = factory
+}
+
+func NewStrategy(name string) (Strategy, error) {
+	strategies.Lock()
+	defer strategies.Unlock()
+
+	factory, ok := strategies.factories[name]
+	if !ok {
+		return nil, fmt.Errorf(ErrStrategyUnknown, name)
+	}
+	return factory(), nil
+}
+
+func GetStrategyNames() []string {
+	strategies.Lock()
+	defer strategies.Unlock()
+
+	names := []string{}
+	for x := range strategies.factories {
+		names = append(names, x)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const (
+	STRATEGY_DEFAULT_TIMER_INTERVAL = time.Minute
+	STRATEGY_DEFAULT_POLL_INTERVAL  = time.Second * 10
+	STRATEGY_EVENT_BUFFER           = 1000
+	WarningStrategyEventDropped     = "WARNING -- Strategy %s: Event queue full, dropping event."
+	ErrStrategyPanic                = "Strategy %s panicked and was stopped: %v\n%s"
+)
+
+// StrategyRunner runs a strategy in its own goroutine. Market data, order
+// updates and timer ticks are queued to that goroutine, so hooks are never
+// called concurrently, and a panicking strategy only stops itself.
+type StrategyRunner struct {
+	Config   StrategyConfig
+	Paper    bool
+	strategy Strategy
+	context  *StrategyContext
+	exchange IBotExchange
+	events   chan func()
+	cancel   context.CancelFunc
+	done     chan struct{}
+	// orders holds the open orders the strategy placed, and cancelled those
+	// it asked to cancel. Only the runner goroutine uses them.
+	orders    map[string]OrderDetail
+	cancelled map[string]bool
+}
+
+// strategyOrders records the orders a strategy places so that the runner can
+// report their updates back to it.
+type strategyOrders struct {
+	IOrderManager
+	runner *StrategyRunner
+}
+
+func (o strategyOrders) SubmitOrder(pair CurrencyPair, side string, amount, price float64) (string, error) {
+	id, err := o.IOrderManager.SubmitOrder(pair, side, amount, price)
+	if err != nil {
+		return "", err
+	}
+	o.runner.orders[id] = OrderDetail{ID: id, Pair: pair, Side: side, Price: price, Amount: amount, Status: ORDER_STATUS_OPEN}
+	return id, nil
+}
+
+func (o strategyOrders) CancelOrderByID(pair CurrencyPair, orderID string) error {
+	err := o.IOrderManager.CancelOrderByID(pair, orderID)
+	if err != nil {
+		return err
+	}
+	o.runner.cancelled[orderID] = true
+	return nil
+}
+
+// ReplaceOrderByID uses the exchange's own replace where it has one, so the
+// runner always sees replacements whichever way they are made.
+func (o strategyOrders) ReplaceOrderByID(pair CurrencyPair, orderID, side string, amount, price float64) (string, error) {
+	replacer, ok := o.IOrderManager.(IOrderReplacer)
+	if !ok {
+		err := o.CancelOrderByID(pair, orderID)
+		if err != nil {
+			return orderID, err
+		}
+		return o.SubmitOrder(pair, side, amount, price)
+	}
+
+	id, err := replacer.ReplaceOrderByID(pair, orderID, side, amount, price)
+	if err != nil {
+		return id, err
+	}
+	o.runner.cancelled[orderID] = true
+	o.runner.orders[id] = OrderDetail{ID: id, Pair: pair, Side: side, Price: price, Amount: amount, Status: ORDER_STATUS_OPEN}
+	return id, nil
+}
+
+var strategyRunners = struct {
+	sync.Mutex
+	runners map[string]*StrategyRunner
+}{runners: make(map[string]*StrategyRunner)}
+
+// NewStrategyRunner creates a strategy trading through the exchange's order
+// manager, or its paper account when the exchange is set to paper trade.
+func NewStrategyRunner(cfg StrategyConfig) (*StrategyRunner, error) {
+	strategy, err := NewStrategy(cfg.Strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	exchange := bot.exchange.GetExchangeByName(cfg.Exchange)
+	if exchange == nil {
+		return nil, fmt.Errorf(ErrExchangeNotFound, cfg.Exchange)
+	}
+
+	orders, err := GetOrderManager(exchange)
+	if err != nil {
+		return nil, err
+	}
+
+	balances, err := GetBalanceFetcher(exchange)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &StrategyRunner{
+		Config:    cfg,
+		Paper:     IsPaperTrading(cfg.Exchange),
+		strategy:  strategy,
+		exchange:  exchange,
+		events:    make(chan func(), STRATEGY_EVENT_BUFFER),
+		done:      make(chan struct{}),
+		orders:    make(map[string]OrderDetail),
+		cancelled: make(map[string]bool),
+	}
+	r.context = &StrategyContext{
+		Name:       cfg.Name,
+		Exchange:   cfg.Exchange,
+		Pair:       cfg.Pair,
+		Params:     cfg.Params,
+		Orders:     strategyOrders{orders, r},
+		Balances:   balances,
+		Indicators: liveIndicators{},
+	}
+	return r, nil
+}
+
+// post queues a hook call to the strategy goroutine, dropping it if the
+// strategy has fallen too far behind.
+func (r *StrategyRunner) post(f func()) {
+	select {
+	case r.events <- f:
+	default:
+		log.Printf(WarningStrategyEventDropped, r.Config.Name)
+	}
+}
+
+// call runs a hook, returning false if it panicked.
+func (r *StrategyRunner) call(f func()) (ok bool) {
+	defer func() {
+		if x := recover(); x != nil {
+			log.Printf(ErrStrategyPanic, r.Config.Name, x, debug.Stack())
+			ok = false
+		}
+	}()
+	f()
+	return true
+}
+
+// Run calls OnStart, then feeds the strategy until ctx is cancelled or a hook
+// panics, and finally calls OnStop.
+func (r *StrategyRunner) Run(ctx context.Context) {
+	defer close(r.done)
+
+	var err error
+	if !r.call(func() { err = r.strategy.OnStart(r.context) }) {
+		return
+	}
+	if err != nil {
+		r.context.Logf("Unable to start. Error: %s", err)
+		return
+	}
+	r.context.Logf("Started (paper trading: %s).", IsEnabled(r.Paper))
+
+	interval := r.Config.TimerInterval.Duration
+	if interval <= 0 {
+		interval = STRATEGY_DEFAULT_TIMER_INTERVAL
+	}
+	timer := time.NewTicker(interval)
+	defer timer.Stop()
+
+	delay := STRATEGY_DEFAULT_POLL_INTERVAL
+	exch, err := GetExchangeConfig(r.Config.Exchange)
+	if err == nil && exch.RESTPollingDelay.Duration > 0 {
+		delay = exch.RESTPollingDelay.Duration
+	}
+	poll := time.NewTicker(delay)
+	defer poll.Stop()
+
+	ok := r.call(r.poll)
+	for ok {
+		select {
+		case <-ctx.Done():
+			r.call(func() { r.strategy.OnStop(r.context) })
+			r.context.Logf("Stopped.")
+			return
+		case f := <-r.events:
+			ok = r.call(f)
+		case now := <-timer.C:
+			ok = r.call(func() { r.strategy.OnTimer(r.context, now) })
+		case <-poll.C:
+			ok = r.call(r.poll)
+		}
+	}
+	r.call(func() { r.strategy.OnStop(r.context) })
+}
+
+// poll fetches the ticker, and the order book if the strategy wants it, and
+// checks the strategy's live orders for fills.
+func (r *StrategyRunner) poll() {
+	if fetcher, ok := r.exchange.(ITickerFetcher); ok {
+		ticker, err := fetcher.GetTickerPrice(r.Config.Pair)
+		if err != nil {
+			r.context.Logf("Unable to fetch ticker. Error: %s", err)
+		} else {
+			r.strategy.OnTicker(r.context, ticker)
+		}
+	}
+
+	if fetcher, ok := r.exchange.(IOrderbookFetcher); ok && r.Config.Orderbook {
+		orderbook, err := fetcher.GetOrderbookDepth(r.Config.Pair)
+		if err != nil {
+			r.context.Logf("Unable to fetch orderbook. Error: %s", err)
+		} else {
+			r.strategy.OnBook(r.context, orderbook)
+		}
+	}
+
+	// Paper accounts report order updates themselves.
+	if !r.Paper {
+		r.checkOrders()
+	}
+}
+
+// checkOrders reports live orders which are no longer open. Exchanges don't
+// say why an order closed, so it is taken as filled unless the strategy
+// cancelled it.
+func (r *StrategyRunner) checkOrders() {
+	if len(r.orders) == 0 {
+		return
+	}
+
+	open, err := r.context.GetOpenOrders()
+	if err != nil {
+		r.context.Logf("Unable to fetch open orders. Error: %s", err)
+		return
+	}
+
+	ids := make(map[string]bool)
+	for _, x := range open {
+		ids[x.ID] = true
+	}
+
+	for id, order := range r.orders {
+		if ids[id] {
+			continue
+		}
+
+		order.Status = ORDER_STATUS_FILLED
+		order.Filled = order.Amount
+		if r.cancelled[id] {
+			order.Status = ORDER_STATUS_CANCELLED
+			order.Filled = 0
+		}
+		r.orderUpdate(order)
+	}
+}
+
+// orderUpdate passes an update on one of the strategy's orders to it.
+func (r *StrategyRunner) orderUpdate(order OrderDetail) {
+	if _, ok := r.orders[order.ID]; !ok {
+		return
+	}
+
+	if order.Status != ORDER_STATUS_OPEN {
+		delete(r.orders, order.ID)
+		delete(r.cancelled, order.ID)
+	}
+	r.strategy.OnOrderUpdate(r.context, order)
+}
+
+// StartStrategy runs a configured strategy until ctx is cancelled or it is
+// stopped with StopStrategy.
+func StartStrategy(ctx context.Context, cfg StrategyConfig) error {
+	r, err := NewStrategyRunner(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, r.cancel = context.WithCancel(ctx)
+	strategyRunners.Lock()
+	strategyRunners.runners[cfg.Name] = r
+	strategyRunners.Unlock()
+
+	StartRoutine(func() {
+		r.Run(ctx)
+		strategyRunners.Lock()
+		if strategyRunners.runners[cfg.Name] == r {
+			delete(strategyRunners.runners, cfg.Name)
+		}
+		strategyRunners.Unlock()
+	})
+	return nil
+}
+
+// StopStrategy stops the named strategy and returns a channel which is closed
+// once it has stopped.
+func StopStrategy(name string) <-chan struct{} {
+	strategyRunners.Lock()
+	r, ok := strategyRunners.runners[name]
+	strategyRunners.Unlock()
+
+	if !ok {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+
+	r.cancel()
+	return r.done
+}
+
+// StartStrategies starts every enabled strategy in the config.
+func StartStrategies(ctx context.Context) {
+	if len(GetConfig().Strategies) > 0 && bot.candles == nil {
+		log.Println("Candle support disabled. Strategy indicators will not be available.")
+	}
+
+	for _, x := range GetConfig().Strategies {
+		if !x.Enabled {
+			continue
+		}
+
+		err := StartStrategy(ctx, x)
+		if err != nil {
+			log.Printf("Strategy %s: Unable to start. Error: %s\n", x.Name, err)
+		}
+	}
+}
+
+// forEachStrategy calls f with every running strategy on an exchange pair.
+func forEachStrategy(exchange string, pair CurrencyPair, f func(r *StrategyRunner)) {
+	strategyRunners.Lock()
+	defer strategyRunners.Unlock()
+
+	for _, x := range strategyRunners.runners {
+		if x.Config.Exchange == exchange && (pair.IsEmpty() || x.Config.Pair == pair) {
+			f(x)
+		}
+	}
+}
+
+// ProcessStrategyTrades passes live public trades to strategies on the pair.
+func ProcessStrategyTrades(exchange string, pair CurrencyPair, trades []TradeRecord) {
+	forEachStrategy(exchange, pair, func(r *StrategyRunner) {
+		r.post(func() {
+			for _, x := range trades {
+				r.strategy.OnTrade(r.context, x)
+			}
+		})
+	})
+}
+
+// ProcessStrategyOrderUpdate passes a paper order update to the strategy
+// which placed the order.
+func ProcessStrategyOrderUpdate(exchange string, order OrderDetail) {
+	forEachStrategy(exchange, CurrencyPair{}, func(r *StrategyRunner) {
+		r.post(func() { r.orderUpdate(order) })
+	})
+}
+
+// ApplyStrategyConfigChanges restarts strategies whose config changed, or
+// whose exchange was switched between paper and live trading, and starts or
+// stops strategies added to or removed from the config.
+func ApplyStrategyConfigChanges(oldConfig, newConfig Config) {
+	changed := func(x StrategyConfig, config Config) bool {
+		for _, y := range config.Strategies {
+			if y.Name != x.Name {
+				continue
+			}
+
+			oldExch, _ := oldConfig.GetExchangeConfig(x.Exchange)
+			newExch, _ := newConfig.GetExchangeConfig(x.Exchange)
+			return !reflect.DeepEqual(x, y) || oldExch.PaperTrading != newExch.PaperTrading
+		}
+		return true
+	}
+
+	for _, x := range oldConfig.Strategies {
+		if x.Enabled && changed(x, newConfig) {
+			log.Printf("Strategy %s: Config changed. Stopping strategy.\n", x.Name)
+			<-StopStrategy(x.Name)
+		}
+	}
+
+	for _, x := range newConfig.Strategies {
+		if x.Enabled && changed(x, oldConfig) {
+			log.Printf("Strategy %s: Starting strategy.\n", x.Name)
+			err := StartStrategy(bot.ctx, x)
+			if err != nil {
+				log.Printf("Strategy %s: Unable to start. Error: %s\n", x.Name, err)
+			}
+		}
+	}
+}
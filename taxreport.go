@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TAX_METHOD_FIFO     = "FIFO"
+	TAX_METHOD_LIFO     = "LIFO"
+	TAX_METHOD_AVERAGE  = "AVERAGE"
+	TAX_DEFAULT_FIAT    = "USD"
+	TAX_PRICE_MAX_AGE   = time.Hour * 24
+	TAX_DUST            = 1e-9
+	ErrTaxInvalidMethod = "Invalid cost basis method %s. Use FIFO, LIFO or AVERAGE."
+	ErrTaxPriceNotFound = "No %s price in %s found at %s. Download %s%s candles into storage first."
+)
+
+// TaxFill is a ledger fill valued in the report currency at the time of the
+// trade.
+type TaxFill struct {
+	AccountFill
+	Value    float64
+	FeeValue float64
+}
+
+// TaxDisposal is a sale or exchange of an asset matched against the lot it was
+// acquired in. A disposal spanning several lots is split into one per lot.
+// Average cost disposals, and disposals without a recorded acquisition, have
+// no acquisition time.
+type TaxDisposal struct {
+	Asset     string
+	Amount    float64
+	Acquired  time.Time
+	Disposed  time.Time
+	Proceeds  float64
+	CostBasis float64
+	Gain      float64
+	Exchange  string
+	FillID    string
+}
+
+type TaxReport struct {
+	Method    string
+	Fiat      string
+	Start     time.Time
+	End       time.Time
+	Proceeds  float64
+	CostBasis float64
+	Gain      float64
+	Fees      float64
+	// Unmatched is the amount of each asset disposed of beyond its recorded
+	// acquisitions, which is given a zero cost basis.
+	Unmatched map[string]float64
+	Disposals []TaxDisposal
+	Fills     []TaxFill
+}
+
+type taxLot struct {
+	Timestamp time.Time
+	Amount    float64
+	Cost      float64
+}
+
+// TaxCalculator works out realized gains on ledger fills. Every fill is valued
+// in Fiat at the time it happened. Buying an asset opens a lot at that value
+// plus fees, and selling or exchanging it closes lots first in first out, last
+// in first out or at the average cost of all holdings. Fiat currencies are not
+// tracked as assets.
+type TaxCalculator struct {
+	Method string
+	Fiat   string
+	// Price returns the value of one unit of currency in Fiat at time t.
+	Price func(currency string, t time.Time) (float64, error)
+
+	lots map[string][]taxLot
+}
+
+func NewTaxCalculator(storage *Storage, method, fiat string) (*TaxCalculator, error) {
+	method = StringToUpper(method)
+	if method != TAX_METHOD_FIFO && method != TAX_METHOD_LIFO && method != TAX_METHOD_AVERAGE {
+		return nil, fmt.Errorf(ErrTaxInvalidMethod, method)
+	}
+
+	fiat = StringToUpper(fiat)
+	return &TaxCalculator{
+		Method: method,
+		Fiat:   fiat,
+		Price:  GetStoredFiatPrice(storage, fiat),
+	}, nil
+}
+
+// GetStoredFiatPrice returns a price function for TaxCalculator which looks up
+// a currency's price in fiat from stored market data. This includes other
+// fiat currencies, which aren't converted at the current exchange rate as
+// that would misstate the value of past trades.
+func GetStoredFiatPrice(storage *Storage, fiat string) func(currency string, t time.Time) (float64, error) {
+	return func(currency string, t time.Time) (float64, error) {
+		if currency == fiat {
+			return 1, nil
+		}
+
+		price, found, err := storage.GetStoredPrice(NewCurrencyPair(currency, fiat), t, TAX_PRICE_MAX_AGE)
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			return price, nil
+		}
+		return 0, fmt.Errorf(ErrTaxPriceNotFound, currency, fiat, t.Format(time.RFC3339), currency, fiat)
+	}
+}
+
+// Calculate reports the gains realized within [start, end]. Earlier fills are
+// needed to build up the lots being sold, so fills should cover the account's
+// whole history and be in time order.
+func (t *TaxCalculator) Calculate(fills []AccountFill, start, end time.Time) (TaxReport, error) {
+	t.lots = make(map[string][]taxLot)
+	report := TaxReport{
+		Method:    t.Method,
+		Fiat:      t.Fiat,
+		Start:     start,
+		End:       end,
+		Unmatched: make(map[string]float64),
+		Disposals: []TaxDisposal{},
+		Fills:     []TaxFill{},
+	}
+
+	for _, x := range fills {
+		if x.Timestamp.After(end) {
+			break
+		}
+
+		fill, disposals, err := t.addFill(x)
+		if err != nil {
+			return TaxReport{}, err
+		}
+
+		if x.Timestamp.Before(start) {
+			continue
+		}
+
+		report.Fills = append(report.Fills, fill)
+		report.Fees += fill.FeeValue
+		for _, y := range disposals {
+			report.Disposals = append(report.Disposals, y)
+			report.Proceeds += y.Proceeds
+			report.CostBasis += y.CostBasis
+			report.Gain += y.Gain
+			if y.Acquired.IsZero() && y.CostBasis == 0 {
+				report.Unmatched[y.Asset] += y.Amount
+			}
+		}
+	}
+	return report, nil
+}
+
+// addFill values a fill and applies both of its legs. Fees add to the cost of
+// what was bought and reduce the proceeds of what was sold. A fee paid in a
+// third currency is itself a disposal of that currency.
+func (t *TaxCalculator) addFill(fill AccountFill) (TaxFill, []TaxDisposal, error) {
+	pair := fill.Pair
+	quoteRate, err := t.Price(pair.Quote, fill.Timestamp)
+	if err != nil {
+		return TaxFill{}, nil, err
+	}
+
+	feeCurrency := fill.FeeCurrency
+	if feeCurrency == "" {
+		feeCurrency = pair.Quote
+	}
+
+	value := fill.Price * fill.Amount * quoteRate
+	feeValue := 0.0
+	if fill.Fee > 0 {
+		switch feeCurrency {
+		case pair.Quote:
+			feeValue = fill.Fee * quoteRate
+		case pair.Base:
+			feeValue = fill.Fee * fill.Price * quoteRate
+		default:
+			rate, err := t.Price(feeCurrency, fill.Timestamp)
+			if err != nil {
+				return TaxFill{}, nil, err
+			}
+			feeValue = fill.Fee * rate
+		}
+	}
+
+	base, quote := fill.Amount, fill.Price*fill.Amount
+	disposals := []TaxDisposal{}
+	if fill.Side == ORDER_SIDE_BUY {
+		switch feeCurrency {
+		case pair.Base:
+			t.acquire(pair.Base, base-fill.Fee, value, fill.Timestamp)
+			disposals = append(disposals, t.dispose(pair.Quote, quote, value, fill)...)
+		case pair.Quote:
+			t.acquire(pair.Base, base, value+feeValue, fill.Timestamp)
+			disposals = append(disposals, t.dispose(pair.Quote, quote+fill.Fee, value+feeValue, fill)...)
+		default:
+			t.acquire(pair.Base, base, value+feeValue, fill.Timestamp)
+			disposals = append(disposals, t.dispose(pair.Quote, quote, value, fill)...)
+			disposals = append(disposals, t.dispose(feeCurrency, fill.Fee, feeValue, fill)...)
+		}
+	} else {
+		switch feeCurrency {
+		case pair.Base:
+			disposals = append(disposals, t.dispose(pair.Base, base+fill.Fee, value, fill)...)
+			t.acquire(pair.Quote, quote, value, fill.Timestamp)
+		case pair.Quote:
+			disposals = append(disposals, t.dispose(pair.Base, base, value-feeValue, fill)...)
+			t.acquire(pair.Quote, quote-fill.Fee, value-feeValue, fill.Timestamp)
+		default:
+			disposals = append(disposals, t.dispose(pair.Base, base, value-feeValue, fill)...)
+			disposals = append(disposals, t.dispose(feeCurrency, fill.Fee, feeValue, fill)...)
+			t.acquire(pair.Quote, quote, value, fill.Timestamp)
+		}
+	}
+	return TaxFill{fill, value, feeValue}, disposals, nil
+}
+
+func (t *TaxCalculator) isAsset(currency string) bool {
+	return currency != t.Fiat && !IsFiatCurrency(currency)
+}
+
+func (t *TaxCalculator) acquire(asset string, amount, cost float64, timestamp time.Time) {
+	if !t.isAsset(asset) || amount <= 0 {
+		return
+	}
+
+	lots := t.lots[asset]
+	if t.Method == TAX_METHOD_AVERAGE && len(lots) > 0 {
+		lots[0].Amount += amount
+		lots[0].Cost += cost
+		return
+	}
+	t.lots[asset] = append(lots, taxLot{timestamp, amount, cost})
+}
+
+// dispose closes lots of asset for amount, sharing the proceeds between them.
+// Any amount left once the lots run out has a zero cost basis.
+func (t *TaxCalculator) dispose(asset string, amount, proceeds float64, fill AccountFill) []TaxDisposal {
+	if !t.isAsset(asset) || amount <= 0 {
+		return nil
+	}
+
+	result := []TaxDisposal{}
+	add := func(acquired time.Time, used, cost float64) {
+		share := proceeds * used / amount
+		result = append(result, TaxDisposal{asset, used, acquired, fill.Timestamp, share, cost, share - cost, fill.Exchange, fill.ID})
+	}
+
+	remaining := amount
+	lots := t.lots[asset]
+	for remaining > TAX_DUST && len(lots) > 0 {
+		i := 0
+		if t.Method == TAX_METHOD_LIFO {
+			i = len(lots) - 1
+		}
+
+		lot := &lots[i]
+		used := math.Min(remaining, lot.Amount)
+		cost := lot.Cost * used / lot.Amount
+		acquired := lot.Timestamp
+		if t.Method == TAX_METHOD_AVERAGE {
+			acquired = time.Time{}
+		}
+		add(acquired, used, cost)
+
+		lot.Amount -= used
+		lot.Cost -= cost
+		remaining -= used
+		if lot.Amount <= TAX_DUST {
+			lots = append(lots[:i], lots[i+1:]...)
+		}
+	}
+	t.lots[asset] = lots
+
+	if remaining > TAX_DUST {
+		add(time.Time{}, remaining, 0)
+	}
+	return result
+}
+
+// Export writes the report to path as JSON, or as CSV when path ends in .csv.
+// CSV output is the disposal list, with the valued fills written alongside it
+// to a file ending in _fills.csv.
+func (r TaxReport) Export(path string) error {
+	if strings.ToLower(filepath.Ext(path)) != ".csv" {
+		data, err := json.MarshalIndent(r, "", " ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, data, 0644)
+	}
+
+	rows := [][]string{{"Asset", "Amount", "Acquired", "Disposed", "Proceeds", "CostBasis", "Gain", "Exchange", "FillID"}}
+	for _, x := range r.Disposals {
+		acquired := ""
+		if !x.Acquired.IsZero() {
+			acquired = x.Acquired.Format(time.RFC3339)
+		}
+		rows = append(rows, []string{
+			x.Asset,
+			strconv.FormatFloat(x.Amount, 'f', -1, 64),
+			acquired,
+			x.Disposed.Format(time.RFC3339),
+			strconv.FormatFloat(x.Proceeds, 'f', 2, 64),
+			strconv.FormatFloat(x.CostBasis, 'f', 2, 64),
+			strconv.FormatFloat(x.Gain, 'f', 2, 64),
+			x.Exchange,
+			x.FillID,
+		})
+	}
+
+	err := WriteCSVFile(path, rows)
+	if err != nil {
+		return err
+	}
+
+	rows = [][]string{{"Timestamp", "Exchange", "ID", "OrderID", "Pair", "Side", "Price", "Amount", "Fee", "FeeCurrency", "Value", "FeeValue"}}
+	for _, x := range r.Fills {
+		rows = append(rows, []string{
+			x.Timestamp.Format(time.RFC3339),
+			x.Exchange,
+			x.ID,
+			x.OrderID,
+			x.Pair.String(),
+			x.Side,
+			strconv.FormatFloat(x.Price, 'f', -1, 64),
+			strconv.FormatFloat(x.Amount, 'f', -1, 64),
+			strconv.FormatFloat(x.Fee, 'f', -1, 64),
+			x.FeeCurrency,
+			strconv.FormatFloat(x.Value, 'f', 2, 64),
+			strconv.FormatFloat(x.FeeValue, 'f', 2, 64),
+		})
+	}
+	return WriteCSVFile(strings.TrimSuffix(path, filepath.Ext(path))+"_fills.csv", rows)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTaxReportFiatPrices(t *testing.T) {
+	setupTestBot(t)
+	filled := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	err := bot.storage.AddTicker("Kraken", NewCurrencyPair("EUR", "USD"), TickerSample{filled.Add(-time.Hour), 1.1, 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calculator, err := NewTaxCalculator(bot.storage, TAX_METHOD_FIFO, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		currency string
+		at       time.Time
+		price    float64
+		fails    bool
+	}{
+		{"report fiat", "USD", filled, 1, false},
+		{"stored fiat price", "EUR", filled, 1.1, false},
+		{"stale fiat price", "EUR", filled.Add(TAX_PRICE_MAX_AGE), 0, true},
+		{"fiat price stored only later", "EUR", filled.Add(-time.Hour * 2), 0, true},
+		{"no fiat price", "GBP", filled, 0, true},
+	}
+
+	for _, x := range tests {
+		price, err := calculator.Price(x.currency, x.at)
+		if (err != nil) != x.fails || price != x.price {
+			t.Errorf("%s: Price(%s) = %f %v, want %f and failure %t", x.name, x.currency, price, err, x.price, x.fails)
+		}
+	}
+
+	fills := []AccountFill{
+		{Exchange: "Kraken", ID: "1", Timestamp: filled, Pair: NewCurrencyPair("BTC", "EUR"), Side: ORDER_SIDE_BUY, Price: 2000, Amount: 1},
+		{Exchange: "Kraken", ID: "2", Timestamp: filled.Add(TAX_PRICE_MAX_AGE), Pair: NewCurrencyPair("BTC", "EUR"), Side: ORDER_SIDE_SELL, Price: 2500, Amount: 1},
+	}
+
+	_, err = calculator.Calculate(fills[:1], filled, filled)
+	if err != nil {
+		t.Errorf("Calculate with a stored EUR price returned %s", err)
+	}
+
+	_, err = calculator.Calculate(fills, filled, fills[1].Timestamp)
+	if err == nil {
+		t.Error("Calculate without a stored EUR price at the time of a fill succeeded")
+	}
+}
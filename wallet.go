@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+const (
+	ErrWalletExchangeDisabled    = "Exchange %s is not enabled."
+	ErrWalletNotFound            = "%s has no wallet %s."
+	ErrWalletTransferUnsupported = "%s can only transfer between %s and another wallet."
+	ErrWalletCurrencyMismatch    = "%s wallet %s holds %s, not %s."
+	ErrWalletTransferSame        = "Unable to transfer from a wallet to itself."
+	ErrWalletTransferAmount      = "Transfer amount must be above zero."
+	ErrWalletPaperTrading        = "%s is paper trading and has no wallets."
+)
+
+func GetWalletManager(exchange IBotExchange) (IWalletManager, error) {
+	manager, ok := exchange.(IWalletManager)
+	if !ok {
+		return nil, NewExchangeFeatureError(exchange, "wallets")
+	}
+	return manager, nil
+}
+
+// getWalletExchange returns the named enabled exchange unless it is paper
+// trading, as paper accounts have a single wallet.
+func getWalletExchange(name string) (IBotExchange, error) {
+	exchange := bot.exchange.GetExchangeByName(name)
+	if exchange == nil {
+		return nil, fmt.Errorf(ErrExchangeNotFound, name)
+	}
+
+	if !exchange.IsEnabled() {
+		return nil, fmt.Errorf(ErrWalletExchangeDisabled, name)
+	}
+
+	if IsPaperTrading(name) {
+		return nil, fmt.Errorf(ErrWalletPaperTrading, name)
+	}
+	return exchange, nil
+}
+
+// GetWallets lists the wallets and sub-accounts of an exchange with their
+// balances. Currencies are named by GetCommonCurrencyName.
+func GetWallets(name string) ([]Wallet, error) {
+	exchange, err := getWalletExchange(name)
+	if err != nil {
+		return nil, err
+	}
+
+	manager, err := GetWalletManager(exchange)
+	if err != nil {
+		return nil, err
+	}
+
+	wallets, err := manager.ListWallets()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range wallets {
+		for j := range wallets[i].Balances {
+			x := &wallets[i].Balances[j]
+			x.Currency = GetCommonCurrencyName(x.Currency)
+		}
+	}
+	return wallets, nil
+}
+
+// TransferBetweenWallets moves funds between two wallets or sub-accounts of
+// an exchange account, named by their Wallet IDs. The funds stay with the
+// exchange, so unlike withdrawals no whitelist or limits apply.
+func TransferBetweenWallets(name, currency string, amount float64, from, to string) error {
+	exchange, err := getWalletExchange(name)
+	if err != nil {
+		return err
+	}
+
+	manager, err := GetWalletManager(exchange)
+	if err != nil {
+		return err
+	}
+
+	if amount <= 0 {
+		return errors.New(ErrWalletTransferAmount)
+	}
+
+	if from == to {
+		return errors.New(ErrWalletTransferSame)
+	}
+
+	currency = StringToUpper(currency)
+	err = manager.TransferBetweenWallets(currency, amount, from, to)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s: Transferred %s %s from wallet %s to %s.\n", name, strconv.FormatFloat(amount, 'f', -1, 64), currency, from, to)
+	return nil
+}
+
+// CreateWallet opens a wallet or sub-account and returns its ID. Currency is
+// only used by exchanges holding a single currency per sub-account.
+func CreateWallet(name, walletName, currency string) (string, error) {
+	exchange, err := getWalletExchange(name)
+	if err != nil {
+		return "", err
+	}
+
+	creator, ok := exchange.(IWalletCreator)
+	if !ok {
+		return "", NewExchangeFeatureError(exchange, "creating wallets")
+	}
+	return creator.CreateNewWallet(walletName, StringToUpper(currency))
+}
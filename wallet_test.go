@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// walletTestTransport answers every request with response and keeps the
+// requests made. Exchanges send requests with &http.Client{}, so it stands in
+// for http.DefaultTransport.
+type walletTestTransport struct {
+	response string
+	requests []*http.Request
+	bodies   []string
+}
+
+func (s *walletTestTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	body := []byte{}
+	if r.Body != nil {
+		body, _ = ioutil.ReadAll(r.Body)
+	}
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, string(body))
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader(s.response)),
+		Request:    r,
+	}, nil
+}
+
+func stubWalletTransport(t *testing.T) *walletTestTransport {
+	t.Helper()
+	stub := &walletTestTransport{}
+	transport := http.DefaultTransport
+	http.DefaultTransport = stub
+	t.Cleanup(func() { http.DefaultTransport = transport })
+	return stub
+}
+
+func TestItBitWallets(t *testing.T) {
+	stub := stubWalletTransport(t)
+	i := ItBit{}
+	i.SetDefaults()
+	i.UserID = "user1"
+
+	stub.response = `[{"id":"w1","userId":"user1","name":"Main","balances":[{"currency":"XBT","availableBalance":"1.5","totalBalance":"2"},{"currency":"USD","availableBalance":"100","totalBalance":"100"}]}]`
+	wallets, err := i.ListWallets()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Wallet{{Exchange: i.GetName(), ID: "w1", Name: "Main", Balances: []AccountBalance{{"XBT", 2, 1.5, 0.5}, {"USD", 100, 100, 0}}}}
+	if !reflect.DeepEqual(wallets, want) {
+		t.Errorf("ListWallets = %+v, want %+v", wallets, want)
+	}
+
+	if r := stub.requests[0]; r.Method != "GET" || r.URL.Path != "/v1/wallets" || r.URL.Query().Get("userId") != "user1" {
+		t.Errorf("ListWallets requested %s %s", r.Method, r.URL)
+	}
+
+	stub.response = `{"sourceWalletId":"w1","destinationWalletId":"w2","amount":"0.25","currencyCode":"XBT"}`
+	err = i.TransferBetweenWallets("BTC", 0.25, "w1", "w2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r := stub.requests[1]; r.Method != "POST" || r.URL.Path != "/v1/wallets/w1/wallet_transfers" {
+		t.Errorf("TransferBetweenWallets requested %s %s", r.Method, r.URL)
+	}
+
+	body := make(map[string]interface{})
+	err = json.Unmarshal([]byte(stub.bodies[1]), &body)
+	wantBody := map[string]interface{}{"sourceWalletId": "w1", "destinationWalletId": "w2", "amount": "0.25", "currencyCode": "XBT"}
+	if err != nil || !reflect.DeepEqual(body, wantBody) {
+		t.Errorf("TransferBetweenWallets sent %s, want %v", stub.bodies[1], wantBody)
+	}
+
+	stub.response = `{"code":10002,"description":"Insufficient funds."}`
+	err = i.TransferBetweenWallets("BTC", 100, "w1", "w2")
+	if err == nil || err.Error() != "Insufficient funds." {
+		t.Errorf("TransferBetweenWallets with an error response returned %v", err)
+	}
+
+	_, err = i.ListWallets()
+	if err == nil || err.Error() != "Insufficient funds." {
+		t.Errorf("ListWallets with an error response returned %v", err)
+	}
+
+	stub.response = `{"id":"w3","userId":"user1","name":"Savings","balances":[]}`
+	id, err := i.CreateNewWallet("Savings", "")
+	if err != nil || id != "w3" {
+		t.Errorf("CreateNewWallet = %s %v, want w3", id, err)
+	}
+}
+
+func TestBitfinexWalletTransfer(t *testing.T) {
+	stub := stubWalletTransport(t)
+	b := Bitfinex{}
+	b.SetDefaults()
+
+	stub.response = `[{"status":"success","message":"1.5 USD transfered from Exchange to Deposit"}]`
+	err := b.TransferBetweenWallets("USD", 1.5, BITFINEX_WALLET_EXCHANGE, BITFINEX_WALLET_DEPOSIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := stub.requests[0]
+	if r.Method != "POST" || !strings.HasSuffix(r.URL.Path, "/"+BITFINEX_TRANSFER) {
+		t.Errorf("TransferBetweenWallets requested %s %s", r.Method, r.URL)
+	}
+
+	payload, err := Base64Decode(r.Header.Get("X-BFX-PAYLOAD"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := make(map[string]interface{})
+	err = json.Unmarshal(payload, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]interface{}{"amount": "1.5", "currency": "USD", "walletfrom": BITFINEX_WALLET_EXCHANGE, "walletto": BITFINEX_WALLET_DEPOSIT} {
+		if body[key] != want {
+			t.Errorf("TransferBetweenWallets sent %s = %#v, want %#v", key, body[key], want)
+		}
+	}
+
+	if _, ok := body["walletTo"]; ok {
+		t.Errorf("TransferBetweenWallets sent walletTo in %s", payload)
+	}
+
+	stub.response = `[{"status":"error","message":"Insufficient balance."}]`
+	err = b.TransferBetweenWallets("USD", 1000, BITFINEX_WALLET_EXCHANGE, BITFINEX_WALLET_DEPOSIT)
+	if err == nil || err.Error() != "Insufficient balance." {
+		t.Errorf("TransferBetweenWallets with an error response returned %v", err)
+	}
+
+	err = b.TransferBetweenWallets("USD", 1, BITFINEX_WALLET_EXCHANGE, "margin")
+	if err == nil || len(stub.requests) != 2 {
+		t.Errorf("TransferBetweenWallets to an unknown wallet returned %v after %d requests", err, len(stub.requests))
+	}
+}
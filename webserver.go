@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	WEBSERVER_DEFAULT_LISTEN_ADDRESS = "localhost:9050"
+	WEBSERVER_ACCOUNT_INTERVAL       = time.Second * 30
+	WEBSERVER_REFRESH_SECONDS        = 5
+	WEBSERVER_SHUTDOWN_TIMEOUT       = time.Second * 5
+	WEBSERVER_REALM                  = "gocryptotrader"
+)
+
+type DashboardExchange struct {
+	Name                    string
+	Enabled                 bool
+	AuthenticatedAPISupport bool
+	PaperTrading            bool
+	Websocket               string
+	WebsocketSince          time.Time
+}
+
+// DashboardAccount holds an exchange's open orders and balances as last
+// fetched, or the error fetching them.
+type DashboardAccount struct {
+	Exchange string
+	Orders   []OrderDetail
+	Balances []AccountBalance
+	Error    string
+	Updated  time.Time
+}
+
+type Dashboard struct {
+	Name      string
+	Updated   time.Time
+	Refresh   int
+	Exchanges []DashboardExchange
+	Tickers   []LatestTicker
+	Prices    []ExchangeInfo
+	Events    []Event
+	Accounts  []DashboardAccount
+}
+
+var dashboardAccounts = struct {
+	sync.Mutex
+	accounts map[string]DashboardAccount
+}{accounts: make(map[string]DashboardAccount)}
+
+// WebserverRoutine serves the dashboard until ctx is cancelled.
+func WebserverRoutine(ctx context.Context) {
+	server := &http.Server{Addr: GetConfig().Webserver.ListenAddress, Handler: NewWebserverHandler()}
+
+	StartRoutine(func() { DashboardAccountRoutine(ctx) })
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), WEBSERVER_SHUTDOWN_TIMEOUT)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+		CloseFeedClients()
+	}()
+
+	log.Printf("Webserver support enabled. Serving the dashboard on http://%s/.\n", server.Addr)
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.Printf("Webserver stopped. Error: %s\n", err)
+	}
+}
+
+// NewWebserverHandler returns the dashboard, API and feed routes behind basic
+// auth.
+func NewWebserverHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handleDashboard)
+	mux.HandleFunc("/dashboard.json", handleDashboardJSON)
+	mux.HandleFunc(API_PATH, HandleAPI)
+	mux.HandleFunc(FEED_PATH, handleFeed)
+	return WebserverAuth(mux)
+}
+
+// WebserverAuth checks requests against the configured admin credentials,
+// which are read on every request so config reloads apply at once. Withdrawal
+// approvers may log in too, but only to the API, which limits them further.
+func WebserverAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		cfg := GetConfig()
+		admin := ok && checkWebserverLogin(username, password, cfg.Webserver.AdminUsername, cfg.Webserver.AdminPassword)
+		approver := false
+		if ok && !admin && cfg.Withdrawals.Enabled && cfg.Withdrawals.RequireApproval {
+			for _, x := range cfg.Withdrawals.Approvers {
+				if checkWebserverLogin(username, password, x.Username, x.Password) {
+					approver = true
+				}
+			}
+		}
+
+		if !admin && !approver {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+WEBSERVER_REALM+`"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		if approver && !strings.HasPrefix(r.URL.Path, API_PATH) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func checkWebserverLogin(username, password, expectedUsername, expectedPassword string) bool {
+	return subtle.ConstantTimeCompare([]byte(username), []byte(expectedUsername)) == 1 && subtle.ConstantTimeCompare([]byte(password), []byte(expectedPassword)) == 1
+}
+
+// GetDashboard gathers the dashboard from the bot's in-memory state. Orders
+// and balances come from the last DashboardAccountRoutine update, as fetching
+// them from every exchange on each page load would hit rate limits.
+func GetDashboard() Dashboard {
+	d := Dashboard{
+		Name:    GetConfig().Name,
+		Updated: time.Now(),
+		Refresh: WEBSERVER_REFRESH_SECONDS,
+		Tickers: GetLatestTickers(),
+		Prices:  GetExchangeInfo(),
+		Events:  GetEvents(),
+	}
+
+	for _, exch := range GetConfig().Exchanges {
+		x := DashboardExchange{
+			Name:                    exch.Name,
+			Enabled:                 exch.Enabled,
+			AuthenticatedAPISupport: exch.AuthenticatedAPISupport,
+			PaperTrading:            exch.PaperTrading,
+			Websocket:               "disabled",
+		}
+
+		if exch.Enabled && exch.Websocket {
+			x.Websocket = "connecting"
+			if status, ok := GetWebsocketStatus(exch.Name); ok {
+				x.Websocket, x.WebsocketSince = "disconnected", status.Since
+				if status.Connected {
+					x.Websocket = "connected"
+				}
+			}
+		}
+		d.Exchanges = append(d.Exchanges, x)
+	}
+
+	sort.Slice(d.Prices, func(i, j int) bool {
+		a, b := d.Prices[i], d.Prices[j]
+		if a.CryptoCurrency+a.FiatCurrency != b.CryptoCurrency+b.FiatCurrency {
+			return a.CryptoCurrency+a.FiatCurrency < b.CryptoCurrency+b.FiatCurrency
+		}
+		return a.Price > b.Price
+	})
+
+	dashboardAccounts.Lock()
+	for _, x := range dashboardAccounts.accounts {
+		d.Accounts = append(d.Accounts, x)
+	}
+	dashboardAccounts.Unlock()
+	sort.Slice(d.Accounts, func(i, j int) bool { return d.Accounts[i].Exchange < d.Accounts[j].Exchange })
+	return d
+}
+
+// UpdateDashboardAccounts fetches open orders and balances from every
+// enabled exchange with authenticated API support or paper trading.
+// Exchanges without either unified API are left out.
+func UpdateDashboardAccounts() {
+	accounts := make(map[string]DashboardAccount)
+	for _, exch := range GetConfig().Exchanges {
+		if !exch.Enabled || (!exch.AuthenticatedAPISupport && !exch.PaperTrading) {
+			continue
+		}
+
+		exchange := bot.exchange.GetExchangeByName(exch.Name)
+		if exchange == nil {
+			continue
+		}
+
+		account := DashboardAccount{Exchange: exch.Name, Updated: time.Now()}
+		supported := false
+		if orders, err := GetOrderManager(exchange); err == nil {
+			supported = true
+			account.Orders, err = orders.GetOpenOrderDetails()
+			if err != nil {
+				account.Error = err.Error()
+			}
+		}
+
+		if balances, err := GetBalanceFetcher(exchange); err == nil {
+			supported = true
+			account.Balances, err = balances.GetAccountBalances()
+			if err != nil {
+				account.Error = err.Error()
+			}
+		}
+
+		if supported {
+			accounts[exch.Name] = account
+		}
+	}
+
+	dashboardAccounts.Lock()
+	dashboardAccounts.accounts = accounts
+	dashboardAccounts.Unlock()
+}
+
+// DashboardAccountRoutine keeps the dashboard's orders and balances up to
+// date until ctx is cancelled.
+func DashboardAccountRoutine(ctx context.Context) {
+	UpdateDashboardAccounts()
+	for SleepContext(ctx, WEBSERVER_ACCOUNT_INTERVAL) {
+		UpdateDashboardAccounts()
+	}
+}
+
+func handleDashboardJSON(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(GetDashboard())
+	if err != nil {
+		log.Printf("Webserver: Unable to write dashboard. Error: %s\n", err)
+	}
+}
+
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := dashboardTemplate.Execute(w, GetDashboard())
+	if err != nil {
+		log.Printf("Webserver: Unable to render dashboard. Error: %s\n", err)
+	}
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format("2006-01-02 15:04:05")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>{{.Name}} - GoCryptoTrader</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: left; }
+th { background: #eee; }
+.on { color: #080; } .off { color: #a00; } .error { color: #a00; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<p>Updated {{time .Updated}}. Refreshes every {{.Refresh}} seconds.</p>
+
+<h2>Exchanges</h2>
+<table>
+<tr><th>Exchange</th><th>Status</th><th>Authenticated API</th><th>Paper trading</th><th>Websocket</th></tr>
+{{range .Exchanges}}<tr>
+<td>{{.Name}}</td>
+<td class="{{if .Enabled}}on{{else}}off{{end}}">{{if .Enabled}}enabled{{else}}disabled{{end}}</td>
+<td>{{.AuthenticatedAPISupport}}</td>
+<td>{{.PaperTrading}}</td>
+<td class="{{if eq .Websocket "connected"}}on{{else if eq .Websocket "disconnected"}}off{{end}}">{{.Websocket}} {{time .WebsocketSince}}</td>
+</tr>{{end}}
+</table>
+
+<h2>Tickers</h2>
+<table>
+<tr><th>Exchange</th><th>Pair</th><th>Last</th><th>Volume</th><th>Updated</th></tr>
+{{range .Tickers}}<tr><td>{{.Exchange}}</td><td>{{.Pair}}</td><td>{{.Last}}</td><td>{{.Volume}}</td><td>{{time .Timestamp}}</td></tr>
+{{else}}<tr><td colspan="5">No tickers yet.</td></tr>{{end}}
+</table>
+
+<h2>Prices across exchanges</h2>
+<table>
+<tr><th>Pair</th><th>Exchange</th><th>Price</th><th>Volume</th></tr>
+{{range .Prices}}<tr><td>{{.CryptoCurrency}}{{.FiatCurrency}}</td><td>{{.Exchange}}</td><td>{{.Price}}</td><td>{{.Volume}}</td></tr>
+{{else}}<tr><td colspan="4">No prices yet.</td></tr>{{end}}
+</table>
+
+<h2>Events</h2>
+<table>
+<tr><th>ID</th><th>Event</th><th>Triggered</th></tr>
+{{range .Events}}<tr><td>{{.ID}}</td><td>{{.EventToString}}</td><td class="{{if .Executed}}on{{end}}">{{.Executed}}</td></tr>
+{{else}}<tr><td colspan="3">No events.</td></tr>{{end}}
+</table>
+
+<h2>Open orders</h2>
+<table>
+<tr><th>Exchange</th><th>ID</th><th>Pair</th><th>Side</th><th>Price</th><th>Amount</th><th>Filled</th></tr>
+{{range $account := .Accounts}}{{range .Orders}}<tr><td>{{$account.Exchange}}</td><td>{{.ID}}</td><td>{{.Pair}}</td><td>{{.Side}}</td><td>{{.Price}}</td><td>{{.Amount}}</td><td>{{.Filled}}</td></tr>
+{{end}}{{end}}
+</table>
+
+<h2>Balances</h2>
+<table>
+<tr><th>Exchange</th><th>Currency</th><th>Total</th><th>Available</th><th>Hold</th></tr>
+{{range $account := .Accounts}}{{range .Balances}}{{if .Total}}<tr><td>{{$account.Exchange}}</td><td>{{.Currency}}</td><td>{{.Total}}</td><td>{{.Available}}</td><td>{{.Hold}}</td></tr>
+{{end}}{{end}}{{if .Error}}<tr><td>{{.Exchange}}</td><td colspan="4" class="error">{{.Error}}</td></tr>
+{{end}}{{end}}
+</table>
+</body>
+</html>
+`))
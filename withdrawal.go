@@ -0,0 +1,392 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	WITHDRAWAL_STATUS_PENDING_APPROVAL  = "PENDING_APPROVAL"
+	WITHDRAWAL_STATUS_SENDING           = "SENDING"
+	WITHDRAWAL_STATUS_SENT              = "SENT"
+	WITHDRAWAL_STATUS_DRY_RUN           = "DRY_RUN"
+	WITHDRAWAL_STATUS_REJECTED          = "REJECTED"
+	WITHDRAWAL_STATUS_EXPIRED           = "EXPIRED"
+	WITHDRAWAL_STATUS_FAILED            = "FAILED"
+	WITHDRAWAL_ACTION_REQUESTED         = "REQUESTED"
+	WITHDRAWAL_ACTION_APPROVED          = "APPROVED"
+	WITHDRAWAL_LIMIT_PERIOD             = time.Hour * 24
+	WITHDRAWAL_DEFAULT_APPROVAL_TIMEOUT = time.Hour * 24
+	WITHDRAWAL_USER_SYSTEM              = "system"
+	ErrWithdrawalsDisabled              = "Withdrawals are disabled. Enable them under Withdrawals in the config file."
+	ErrWithdrawalExchangeDisabled       = "Exchange %s is not enabled."
+	ErrWithdrawalAmountInvalid          = "Withdrawal amount must be greater than zero."
+	ErrWithdrawalAddressNotWhitelisted  = "Address %s is not whitelisted for %s."
+	ErrWithdrawalNoDailyLimit           = "No daily withdrawal limit is set for %s."
+	ErrWithdrawalDailyLimitExceeded     = "Withdrawing %s %s would exceed the daily limit of %s, with %s withdrawn or awaiting approval in the last 24 hours."
+	ErrWithdrawalCurrencyNotSupported   = "%s does not support withdrawing %s."
+	ErrWithdrawalNotFound               = "Withdrawal %s not found."
+	ErrWithdrawalNotPending             = "Withdrawal %s is %s, not awaiting approval."
+	ErrWithdrawalSelfApproval           = "Withdrawal %s must be approved by someone other than %s, who requested it."
+	ErrWithdrawalNotApprover            = "%s is not a withdrawal approver."
+	ErrWithdrawalApprovalTimeout        = "Not approved within %s."
+)
+
+// WithdrawalAuditEntry records one step in a withdrawal's life and who took
+// it. Action is REQUESTED, APPROVED or the status the withdrawal moved to.
+type WithdrawalAuditEntry struct {
+	Timestamp time.Time
+	User      string
+	Action    string
+	Detail    string
+}
+
+// Withdrawal is a request to send currency off an exchange, kept in storage
+// along with its audit log whether or not it was allowed. Withdrawals are
+// checked against the whitelist and daily limit when requested and again
+// before being sent. ExchangeID is the exchange's ID for a sent withdrawal
+// where it returns one.
+type Withdrawal struct {
+	ID         string
+	Exchange   string
+	Currency   string
+	Address    string
+	Amount     float64
+	DryRun     bool
+	Status     string
+	Requester  string
+	Approver   string
+	ExchangeID string
+	Error      string
+	Requested  time.Time
+	Audit      []WithdrawalAuditEntry
+}
+
+// withdrawalMutex keeps the daily limit check and the save that follows it
+// from interleaving with another request. It isn't held while an exchange
+// is sending a withdrawal.
+var withdrawalMutex sync.Mutex
+
+// IsActive reports whether the withdrawal counts towards the daily limit.
+// Failed withdrawals count too, as a request which timed out may still have
+// reached the exchange.
+func (w Withdrawal) IsActive() bool {
+	if w.DryRun {
+		return false
+	}
+
+	switch w.Status {
+	case WITHDRAWAL_STATUS_PENDING_APPROVAL, WITHDRAWAL_STATUS_SENDING, WITHDRAWAL_STATUS_SENT, WITHDRAWAL_STATUS_FAILED:
+		return true
+	}
+	return false
+}
+
+func (w *Withdrawal) audit(user, action, detail string) {
+	w.Audit = append(w.Audit, WithdrawalAuditEntry{time.Now(), user, action, detail})
+	message := fmt.Sprintf("Withdrawal %s of %s %s from %s to %s: %s by %s", w.ID, strconv.FormatFloat(w.Amount, 'f', -1, 64), w.Currency, w.Exchange, w.Address, action, user)
+	if detail != "" {
+		message += ". " + detail
+	}
+	log.Println(message)
+}
+
+// finish moves the withdrawal to status, recording err as the reason.
+func (w *Withdrawal) finish(user, status string, err error) {
+	w.Status = status
+	detail := ""
+	if err != nil {
+		w.Error = err.Error()
+		detail = w.Error
+	}
+	w.audit(user, status, detail)
+}
+
+func getWithdrawalStorage() (*Storage, error) {
+	if !GetConfig().Withdrawals.Enabled || bot.storage == nil {
+		return nil, errors.New(ErrWithdrawalsDisabled)
+	}
+	return bot.storage, nil
+}
+
+// GetWithdrawals returns every withdrawal in the order they were requested.
+// Withdrawals left awaiting approval beyond the approval timeout expire.
+func GetWithdrawals() ([]Withdrawal, error) {
+	withdrawalMutex.Lock()
+	defer withdrawalMutex.Unlock()
+
+	storage, err := getWithdrawalStorage()
+	if err != nil {
+		return nil, err
+	}
+	return loadWithdrawals(storage)
+}
+
+func loadWithdrawals(storage *Storage) ([]Withdrawal, error) {
+	withdrawals, err := storage.GetWithdrawals()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := GetConfig().Withdrawals.ApprovalTimeout.Duration
+	for i := range withdrawals {
+		x := &withdrawals[i]
+		if x.Status != WITHDRAWAL_STATUS_PENDING_APPROVAL || time.Since(x.Requested) < timeout {
+			continue
+		}
+
+		x.finish(WITHDRAWAL_USER_SYSTEM, WITHDRAWAL_STATUS_EXPIRED, fmt.Errorf(ErrWithdrawalApprovalTimeout, timeout))
+		err = storage.SetWithdrawal(x)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return withdrawals, nil
+}
+
+// checkWithdrawal checks a withdrawal against the exchange, whitelist and
+// daily limit. Other active withdrawals within the last 24 hours count
+// towards the limit.
+func checkWithdrawal(w Withdrawal, withdrawals []Withdrawal) (IWithdrawer, error) {
+	exchange := bot.exchange.GetExchangeByName(w.Exchange)
+	if exchange == nil {
+		return nil, fmt.Errorf(ErrExchangeNotFound, w.Exchange)
+	}
+
+	if !exchange.IsEnabled() {
+		return nil, fmt.Errorf(ErrWithdrawalExchangeDisabled, w.Exchange)
+	}
+
+	withdrawer, ok := exchange.(IWithdrawer)
+	if !ok {
+		return nil, NewExchangeFeatureError(exchange, "withdrawals")
+	}
+
+	if w.Amount <= 0 {
+		return nil, errors.New(ErrWithdrawalAmountInvalid)
+	}
+
+	cfg := GetConfig().Withdrawals
+	whitelisted := false
+	for _, x := range cfg.Whitelist[w.Currency] {
+		if x == w.Address {
+			whitelisted = true
+		}
+	}
+
+	if !whitelisted {
+		return nil, fmt.Errorf(ErrWithdrawalAddressNotWhitelisted, w.Address, w.Currency)
+	}
+
+	limit, ok := cfg.DailyLimits[w.Currency]
+	if !ok {
+		return nil, fmt.Errorf(ErrWithdrawalNoDailyLimit, w.Currency)
+	}
+
+	used := 0.0
+	since := time.Now().Add(-WITHDRAWAL_LIMIT_PERIOD)
+	for _, x := range withdrawals {
+		if x.ID != w.ID && x.Currency == w.Currency && x.IsActive() && x.Requested.After(since) {
+			used += x.Amount
+		}
+	}
+
+	if !w.DryRun && used+w.Amount > limit {
+		format := func(x float64) string { return strconv.FormatFloat(x, 'f', -1, 64) }
+		return nil, fmt.Errorf(ErrWithdrawalDailyLimitExceeded, format(w.Amount), w.Currency, format(limit), format(used))
+	}
+	return withdrawer, nil
+}
+
+// RequestWithdrawal checks and records a withdrawal, then sends it unless it
+// needs approval. Exchanges only withdraw through IWithdrawer, whose method is
+// unexported, so every withdrawal passes checkWithdrawal here or in
+// ApproveWithdrawal before it is sent. Withdrawals are dry runs when dryRun
+// is set, the config's DryRun is set or the exchange is paper trading. The
+// withdrawal is returned along with the reason when it is rejected or fails.
+func RequestWithdrawal(exchange, currency, address string, amount float64, requester string, dryRun bool) (Withdrawal, error) {
+	withdrawalMutex.Lock()
+	defer withdrawalMutex.Unlock()
+
+	storage, err := getWithdrawalStorage()
+	if err != nil {
+		return Withdrawal{}, err
+	}
+
+	w := Withdrawal{
+		Exchange:  exchange,
+		Currency:  StringToUpper(currency),
+		Address:   address,
+		Amount:    amount,
+		DryRun:    dryRun || GetConfig().Withdrawals.DryRun || IsPaperTrading(exchange),
+		Requester: requester,
+		Requested: time.Now(),
+	}
+
+	withdrawals, err := loadWithdrawals(storage)
+	if err != nil {
+		return Withdrawal{}, err
+	}
+
+	// The withdrawal is saved before it is checked so that rejected requests
+	// get an ID in the audit log.
+	w.Status = WITHDRAWAL_STATUS_PENDING_APPROVAL
+	err = storage.SetWithdrawal(&w)
+	if err != nil {
+		return Withdrawal{}, err
+	}
+
+	detail := ""
+	if w.DryRun {
+		detail = "Dry run"
+	}
+	w.audit(requester, WITHDRAWAL_ACTION_REQUESTED, detail)
+
+	withdrawer, err := checkWithdrawal(w, withdrawals)
+	if err != nil {
+		w.finish(WITHDRAWAL_USER_SYSTEM, WITHDRAWAL_STATUS_REJECTED, err)
+		return w, saveWithdrawal(storage, &w, err)
+	}
+
+	if GetConfig().Withdrawals.RequireApproval {
+		return w, saveWithdrawal(storage, &w, nil)
+	}
+	return w, sendWithdrawal(storage, &w, withdrawer, WITHDRAWAL_USER_SYSTEM)
+}
+
+// ApproveWithdrawal approves a withdrawal awaiting approval, then checks it
+// again and sends it. The approver must be a configured approver other than
+// the person who requested it.
+func ApproveWithdrawal(id, approver string) (Withdrawal, error) {
+	withdrawalMutex.Lock()
+	defer withdrawalMutex.Unlock()
+
+	storage, withdrawals, w, err := getPendingWithdrawal(id)
+	if err != nil {
+		return w, err
+	}
+
+	if !IsWithdrawalApprover(approver) {
+		return w, fmt.Errorf(ErrWithdrawalNotApprover, approver)
+	}
+
+	if approver == w.Requester {
+		return w, fmt.Errorf(ErrWithdrawalSelfApproval, id, approver)
+	}
+
+	w.Approver = approver
+	w.audit(approver, WITHDRAWAL_ACTION_APPROVED, "")
+
+	// The config may have changed since the withdrawal was requested.
+	withdrawer, err := checkWithdrawal(w, withdrawals)
+	if err != nil {
+		w.finish(WITHDRAWAL_USER_SYSTEM, WITHDRAWAL_STATUS_REJECTED, err)
+		return w, saveWithdrawal(storage, &w, err)
+	}
+	return w, sendWithdrawal(storage, &w, withdrawer, approver)
+}
+
+// RejectWithdrawal rejects a withdrawal awaiting approval. Anyone able to
+// approve withdrawals, or the person who requested it, may reject it.
+func RejectWithdrawal(id, user, reason string) (Withdrawal, error) {
+	withdrawalMutex.Lock()
+	defer withdrawalMutex.Unlock()
+
+	storage, _, w, err := getPendingWithdrawal(id)
+	if err != nil {
+		return w, err
+	}
+
+	if !IsWithdrawalApprover(user) && user != w.Requester {
+		return w, fmt.Errorf(ErrWithdrawalNotApprover, user)
+	}
+
+	w.Status = WITHDRAWAL_STATUS_REJECTED
+	w.Error = reason
+	w.audit(user, WITHDRAWAL_STATUS_REJECTED, reason)
+	return w, saveWithdrawal(storage, &w, nil)
+}
+
+func getPendingWithdrawal(id string) (*Storage, []Withdrawal, Withdrawal, error) {
+	storage, err := getWithdrawalStorage()
+	if err != nil {
+		return nil, nil, Withdrawal{}, err
+	}
+
+	withdrawals, err := loadWithdrawals(storage)
+	if err != nil {
+		return nil, nil, Withdrawal{}, err
+	}
+
+	for _, x := range withdrawals {
+		if x.ID != id {
+			continue
+		}
+
+		if x.Status != WITHDRAWAL_STATUS_PENDING_APPROVAL {
+			return nil, nil, Withdrawal{}, fmt.Errorf(ErrWithdrawalNotPending, id, x.Status)
+		}
+		return storage, withdrawals, x, nil
+	}
+	return nil, nil, Withdrawal{}, fmt.Errorf(ErrWithdrawalNotFound, id)
+}
+
+// sendWithdrawal sends a checked withdrawal to the exchange. It is saved as
+// SENDING first, so that if the bot stops mid-request the withdrawal is left
+// for someone to check rather than being sent again. withdrawalMutex must be
+// held, and is released while the exchange is called, as the SENDING
+// withdrawal already counts towards the daily limit and can't be approved or
+// rejected.
+func sendWithdrawal(storage *Storage, w *Withdrawal, withdrawer IWithdrawer, user string) error {
+	if w.DryRun {
+		w.finish(user, WITHDRAWAL_STATUS_DRY_RUN, nil)
+		return saveWithdrawal(storage, w, nil)
+	}
+
+	w.Status = WITHDRAWAL_STATUS_SENDING
+	err := storage.SetWithdrawal(w)
+	if err != nil {
+		return err
+	}
+
+	withdrawalMutex.Unlock()
+	w.ExchangeID, err = withdrawer.withdraw(w.Currency, w.Address, w.Amount)
+	withdrawalMutex.Lock()
+	if err != nil {
+		w.finish(user, WITHDRAWAL_STATUS_FAILED, err)
+		return saveWithdrawal(storage, w, err)
+	}
+
+	detail := ""
+	if w.ExchangeID != "" {
+		detail = "Exchange ID " + w.ExchangeID
+	}
+	w.Status = WITHDRAWAL_STATUS_SENT
+	w.audit(user, WITHDRAWAL_STATUS_SENT, detail)
+	return saveWithdrawal(storage, w, nil)
+}
+
+// saveWithdrawal saves the withdrawal and returns result, or the error saving
+// it if that failed.
+func saveWithdrawal(storage *Storage, w *Withdrawal, result error) error {
+	err := storage.SetWithdrawal(w)
+	if err != nil {
+		log.Printf("Unable to save withdrawal %s. Error: %s\n", w.ID, err)
+		return err
+	}
+	return result
+}
+
+// IsWithdrawalApprover reports whether username is a configured approver.
+func IsWithdrawalApprover(username string) bool {
+	for _, x := range GetConfig().Withdrawals.Approvers {
+		if x.Username == username {
+			return true
+		}
+	}
+	return false
+}
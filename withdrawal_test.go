@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckWithdrawal(t *testing.T) {
+	setupTestBot(t)
+	now := time.Now()
+	sent := Withdrawal{ID: "1", Currency: "BTC", Amount: 0.6, Status: WITHDRAWAL_STATUS_SENT, Requested: now.Add(-time.Hour)}
+
+	tests := []struct {
+		name        string
+		withdrawal  Withdrawal
+		withdrawals []Withdrawal
+		err         string
+	}{
+		{"whitelisted within the limit", Withdrawal{Exchange: "Bitstamp", Currency: "BTC", Address: "1Whitelisted", Amount: 1}, nil, ""},
+		{"address not whitelisted", Withdrawal{Exchange: "Bitstamp", Currency: "BTC", Address: "1Unknown", Amount: 0.1}, nil, fmt.Sprintf(ErrWithdrawalAddressNotWhitelisted, "1Unknown", "BTC")},
+		{"address whitelisted for another currency", Withdrawal{Exchange: "Bitstamp", Currency: "LTC", Address: "1Whitelisted", Amount: 0.1}, nil, fmt.Sprintf(ErrWithdrawalAddressNotWhitelisted, "1Whitelisted", "LTC")},
+		{"zero amount", Withdrawal{Exchange: "Bitstamp", Currency: "BTC", Address: "1Whitelisted"}, nil, ErrWithdrawalAmountInvalid},
+		{"disabled exchange", Withdrawal{Exchange: "Kraken", Currency: "BTC", Address: "1Whitelisted", Amount: 0.1}, nil, fmt.Sprintf(ErrWithdrawalExchangeDisabled, "Kraken")},
+		{"over the limit", Withdrawal{Exchange: "Bitstamp", Currency: "BTC", Address: "1Whitelisted", Amount: 1.5}, nil, fmt.Sprintf(ErrWithdrawalDailyLimitExceeded, "1.5", "BTC", "1", "0")},
+		{"over the limit with one sent", Withdrawal{Exchange: "Bitstamp", Currency: "BTC", Address: "1Whitelisted", Amount: 0.5}, []Withdrawal{sent}, fmt.Sprintf(ErrWithdrawalDailyLimitExceeded, "0.5", "BTC", "1", "0.6")},
+		{"over the limit with one sending", Withdrawal{Exchange: "Bitstamp", Currency: "BTC", Address: "1Whitelisted", Amount: 0.5}, []Withdrawal{{ID: "2", Currency: "BTC", Amount: 0.6, Status: WITHDRAWAL_STATUS_SENDING, Requested: now}}, fmt.Sprintf(ErrWithdrawalDailyLimitExceeded, "0.5", "BTC", "1", "0.6")},
+		{"one sent over 24 hours ago", Withdrawal{Exchange: "Bitstamp", Currency: "BTC", Address: "1Whitelisted", Amount: 0.5}, []Withdrawal{{Currency: "BTC", Amount: 0.6, Status: WITHDRAWAL_STATUS_SENT, Requested: now.Add(-WITHDRAWAL_LIMIT_PERIOD - time.Minute)}}, ""},
+		{"one rejected", Withdrawal{Exchange: "Bitstamp", Currency: "BTC", Address: "1Whitelisted", Amount: 0.5}, []Withdrawal{{Currency: "BTC", Amount: 0.6, Status: WITHDRAWAL_STATUS_REJECTED, Requested: now}}, ""},
+		{"one dry run", Withdrawal{Exchange: "Bitstamp", Currency: "BTC", Address: "1Whitelisted", Amount: 0.5}, []Withdrawal{{Currency: "BTC", Amount: 0.6, Status: WITHDRAWAL_STATUS_DRY_RUN, DryRun: true, Requested: now}}, ""},
+		{"itself when approved", Withdrawal{ID: "1", Exchange: "Bitstamp", Currency: "BTC", Address: "1Whitelisted", Amount: 0.6}, []Withdrawal{sent}, ""},
+		{"dry run over the limit", Withdrawal{Exchange: "Bitstamp", Currency: "BTC", Address: "1Whitelisted", Amount: 1.5, DryRun: true}, nil, ""},
+	}
+
+	for _, x := range tests {
+		_, err := checkWithdrawal(x.withdrawal, x.withdrawals)
+		result := ""
+		if err != nil {
+			result = err.Error()
+		}
+		if result != x.err {
+			t.Errorf("%s: checkWithdrawal returned %q, want %q", x.name, result, x.err)
+		}
+	}
+}
+
+func TestWithdrawalApproval(t *testing.T) {
+	setupTestBot(t)
+
+	w, err := RequestWithdrawal("Bitstamp", "BTC", "1Unknown", 0.5, "approver", false)
+	if err == nil || w.Status != WITHDRAWAL_STATUS_REJECTED {
+		t.Errorf("requesting a withdrawal to an address not whitelisted returned %s %v, want REJECTED", w.Status, err)
+	}
+
+	w, err = RequestWithdrawal("Bitstamp", "BTC", "1Whitelisted", 0.5, "approver", false)
+	if err != nil || w.Status != WITHDRAWAL_STATUS_PENDING_APPROVAL {
+		t.Fatalf("requesting a withdrawal returned %s %v, want PENDING_APPROVAL", w.Status, err)
+	}
+
+	_, err = ApproveWithdrawal(w.ID, "approver")
+	if err == nil || err.Error() != fmt.Sprintf(ErrWithdrawalSelfApproval, w.ID, "approver") {
+		t.Errorf("approving your own withdrawal returned %v", err)
+	}
+
+	_, err = ApproveWithdrawal(w.ID, "admin")
+	if err == nil || err.Error() != fmt.Sprintf(ErrWithdrawalNotApprover, "admin") {
+		t.Errorf("approving as someone who isn't an approver returned %v", err)
+	}
+
+	w, err = RejectWithdrawal(w.ID, "approver", "Changed my mind")
+	if err != nil || w.Status != WITHDRAWAL_STATUS_REJECTED {
+		t.Errorf("rejecting your own withdrawal returned %s %v, want REJECTED", w.Status, err)
+	}
+}
+
+func TestWithdrawalExpiry(t *testing.T) {
+	setupTestBot(t)
+
+	stale := Withdrawal{Exchange: "Bitstamp", Currency: "BTC", Address: "1Whitelisted", Amount: 0.5, DryRun: true, Status: WITHDRAWAL_STATUS_PENDING_APPROVAL, Requester: "admin", Requested: time.Now().Add(-WITHDRAWAL_DEFAULT_APPROVAL_TIMEOUT - time.Minute)}
+	fresh := stale
+	fresh.Requested = time.Now()
+	for _, x := range []*Withdrawal{&stale, &fresh} {
+		err := bot.storage.SetWithdrawal(x)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	withdrawals, err := GetWithdrawals()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(withdrawals) != 2 || withdrawals[0].Status != WITHDRAWAL_STATUS_EXPIRED || withdrawals[1].Status != WITHDRAWAL_STATUS_PENDING_APPROVAL {
+		t.Fatalf("withdrawals = %+v, want the stale one expired", withdrawals)
+	}
+
+	_, err = ApproveWithdrawal(stale.ID, "approver")
+	if err == nil || err.Error() != fmt.Sprintf(ErrWithdrawalNotPending, stale.ID, WITHDRAWAL_STATUS_EXPIRED) {
+		t.Errorf("approving an expired withdrawal returned %v", err)
+	}
+
+	w, err := ApproveWithdrawal(fresh.ID, "approver")
+	if err != nil || w.Status != WITHDRAWAL_STATUS_DRY_RUN {
+		t.Errorf("approving a fresh withdrawal returned %s %v", w.Status, err)
+	}
+}
+
+// testWithdrawer records the status the withdrawal was saved with while it
+// was being sent. Listing withdrawals would block if withdrawalMutex were
+// still held.
+type testWithdrawer struct {
+	status string
+	err    error
+}
+
+func (w *testWithdrawer) withdraw(currency, address string, amount float64) (string, error) {
+	withdrawals, err := GetWithdrawals()
+	if err != nil {
+		return "", err
+	}
+	w.status = withdrawals[len(withdrawals)-1].Status
+	return "X1", w.err
+}
+
+func TestWithdrawalSending(t *testing.T) {
+	setupTestBot(t)
+
+	w := Withdrawal{Exchange: "Bitstamp", Currency: "BTC", Address: "1Whitelisted", Amount: 0.5, Status: WITHDRAWAL_STATUS_PENDING_APPROVAL, Requester: "admin", Requested: time.Now()}
+	withdrawer := &testWithdrawer{err: errors.New("Timed out")}
+	withdrawalMutex.Lock()
+	err := sendWithdrawal(bot.storage, &w, withdrawer, "approver")
+	withdrawalMutex.Unlock()
+	if err == nil || w.Status != WITHDRAWAL_STATUS_FAILED {
+		t.Errorf("sending a failing withdrawal returned %s %v, want FAILED", w.Status, err)
+	}
+
+	if withdrawer.status != WITHDRAWAL_STATUS_SENDING {
+		t.Errorf("withdrawal was %s while being sent, want SENDING", withdrawer.status)
+	}
+
+	// A withdrawal left SENDING by a bot stopping mid-request can't be sent
+	// again, as it may have reached the exchange.
+	w.Status = WITHDRAWAL_STATUS_SENDING
+	err = bot.storage.SetWithdrawal(&w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ApproveWithdrawal(w.ID, "approver")
+	if err == nil || err.Error() != fmt.Sprintf(ErrWithdrawalNotPending, w.ID, WITHDRAWAL_STATUS_SENDING) {
+		t.Errorf("approving a SENDING withdrawal returned %v", err)
+	}
+
+	_, err = RejectWithdrawal(w.ID, "approver", "")
+	if err == nil {
+		t.Error("rejecting a SENDING withdrawal succeeded")
+	}
+
+	withdrawals, err := GetWithdrawals()
+	if err != nil || len(withdrawals) != 1 || withdrawals[0].Status != WITHDRAWAL_STATUS_SENDING {
+		t.Errorf("withdrawals = %+v %v, want it left SENDING", withdrawals, err)
+	}
+}
+
+func TestWithdrawalCLIAPI(t *testing.T) {
+	setupTestBot(t)
+	server := httptest.NewServer(NewWebserverHandler())
+	cfg := GetConfig()
+	cfg.Webserver.Enabled = true
+	cfg.Webserver.ListenAddress = strings.TrimPrefix(server.URL, "http://")
+	SetConfig(cfg)
+
+	called, err := runWithdrawalAPICommand([]string{"request", "Bitstamp", "BTC", "0.5", "1Whitelisted"}, true)
+	if !called || err != nil {
+		t.Fatalf("request returned %t %v, want it sent to the API", called, err)
+	}
+
+	withdrawals, err := GetWithdrawals()
+	if err != nil || len(withdrawals) != 1 || withdrawals[0].Requester != "admin" || !withdrawals[0].DryRun {
+		t.Fatalf("withdrawals = %+v %v, want a dry run requested by admin", withdrawals, err)
+	}
+
+	called, err = runWithdrawalAPICommand([]string{"request", "Bitstamp", "BTC", "0.5", "1Unknown"}, false)
+	if !called || err == nil || !strings.Contains(err.Error(), "1Unknown") {
+		t.Errorf("request to an unknown address returned %t %v, want the API's error", called, err)
+	}
+
+	called, err = runWithdrawalAPICommand([]string{"reject", withdrawals[0].ID, "Wrong amount"}, false)
+	if !called || err != nil {
+		t.Errorf("reject returned %t %v, want it sent to the API", called, err)
+	}
+
+	withdrawals, err = GetWithdrawals()
+	if err != nil || withdrawals[0].Status != WITHDRAWAL_STATUS_REJECTED || withdrawals[0].Error != "Wrong amount" {
+		t.Errorf("withdrawals = %+v %v, want the first rejected", withdrawals, err)
+	}
+
+	server.Close()
+	called, err = runWithdrawalAPICommand([]string{"list"}, false)
+	if called || err != nil {
+		t.Errorf("list with the bot stopped returned %t %v, want it left to the CLI", called, err)
+	}
+}